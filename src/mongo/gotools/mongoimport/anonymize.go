@@ -0,0 +1,153 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AnonymizeMethod is how a FieldAnonymizer replaces the values of a column.
+type AnonymizeMethod int
+
+const (
+	// AnonymizeHash replaces each value with the hex SHA-256 hash of the
+	// value, after the Salt.
+	AnonymizeHash AnonymizeMethod = iota
+
+	// AnonymizeRedact replaces each value with the Redaction.
+	AnonymizeRedact
+
+	// AnonymizeNull replaces each value with null.
+	AnonymizeNull
+)
+
+// defaultRedaction is the Redaction of a "redact" anonymizer that gives none.
+const defaultRedaction = "REDACTED"
+
+// FieldAnonymizer replaces the values of a string column, as they are
+// converted, so that the data they hold is not imported. Hashes depend only
+// on the value and the Salt, so a value is given the same hash in every
+// document, by every decoding goroutine, and documents can still be joined
+// on a hashed field.
+type FieldAnonymizer struct {
+	Method AnonymizeMethod
+
+	// HashLength, if set, truncates hashes to their first HashLength hex
+	// digits, of the 64 there are.
+	HashLength int
+
+	// Salt is hashed before each value.
+	Salt string
+
+	// Redaction is the string that AnonymizeRedact gives each value.
+	Redaction string
+}
+
+// ParseFieldAnonymizer parses an anonymizer of the form "sha256", or
+// "sha256:<length>" to truncate the hashes, "redact", or "redact:<text>" to
+// redact with text other than "REDACTED", or "null". Salt is the salt of any
+// hash.
+func ParseFieldAnonymizer(spec, salt string) (FieldAnonymizer, error) {
+	method, arg := spec, ""
+	hasArg := false
+	if i := strings.Index(spec, ":"); i != -1 {
+		method, arg, hasArg = spec[:i], spec[i+1:], true
+	}
+	switch method {
+	case "sha256":
+		anonymizer := FieldAnonymizer{Method: AnonymizeHash, Salt: salt}
+		if hasArg {
+			length, err := strconv.Atoi(arg)
+			if err != nil || length < 1 || length > 2*sha256.Size {
+				return FieldAnonymizer{}, fmt.Errorf("hash length '%v' must be a number from 1 to %v", arg, 2*sha256.Size)
+			}
+			anonymizer.HashLength = length
+		}
+		return anonymizer, nil
+	case "redact":
+		if !hasArg {
+			arg = defaultRedaction
+		}
+		return FieldAnonymizer{Method: AnonymizeRedact, Redaction: arg}, nil
+	case "null":
+		if hasArg {
+			return FieldAnonymizer{}, fmt.Errorf("'null' takes no argument")
+		}
+		return FieldAnonymizer{Method: AnonymizeNull}, nil
+	}
+	return FieldAnonymizer{}, fmt.Errorf("unknown anonymizer '%v': must be sha256, redact or null", method)
+}
+
+// anonymize returns the value to give the field of a column in place of
+// value.
+func (a *FieldAnonymizer) anonymize(value string) interface{} {
+	switch a.Method {
+	case AnonymizeRedact:
+		return a.Redaction
+	case AnonymizeNull:
+		return nil
+	}
+	sum := sha256.Sum256([]byte(a.Salt + value))
+	hash := hex.EncodeToString(sum[:])
+	if a.HashLength != 0 {
+		hash = hash[:a.HashLength]
+	}
+	return hash
+}
+
+// findAnonymizedColumns checks that each of the AnonymizeFields names a string
+// or auto column, and records the anonymizer of every column.
+func (opts *ConvertOptions) findAnonymizedColumns(colSpecs []ColumnSpec) error {
+	opts.anonymizers = nil
+	if len(opts.AnonymizeFields) == 0 {
+		return nil
+	}
+	opts.anonymizers = make([]*FieldAnonymizer, len(colSpecs))
+	fields := make([]string, 0, len(opts.AnonymizeFields))
+	for field := range opts.AnonymizeFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		index := -1
+		for i, colSpec := range colSpecs {
+			if colSpec.Name == field {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return fmt.Errorf("anonymized field '%v' is not in the header", field)
+		}
+		parser := colSpecs[index].Parser
+		if dp, ok := parser.(*FieldDefaultParser); ok {
+			parser = dp.parser
+		}
+		switch parser.(type) {
+		case *FieldStringParser, *FieldAutoParser:
+		default:
+			return fmt.Errorf("anonymized field '%v' is not a string field", field)
+		}
+		anonymizer := opts.AnonymizeFields[field]
+		opts.anonymizers[index] = &anonymizer
+	}
+	return nil
+}
+
+// anonymizer returns the anonymizer of the column at index, or nil if its
+// values are imported as they are.
+func (opts *ConvertOptions) anonymizer(index int) *FieldAnonymizer {
+	if index < len(opts.anonymizers) {
+		return opts.anonymizers[index]
+	}
+	return nil
+}
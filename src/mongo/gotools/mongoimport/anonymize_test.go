@@ -0,0 +1,138 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseFieldAnonymizer(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Anonymizers should be parsed from their specs", t, func() {
+		valid := []struct {
+			spec       string
+			anonymizer FieldAnonymizer
+		}{
+			{"sha256", FieldAnonymizer{Method: AnonymizeHash, Salt: "pepper"}},
+			{"sha256:12", FieldAnonymizer{Method: AnonymizeHash, HashLength: 12, Salt: "pepper"}},
+			{"redact", FieldAnonymizer{Method: AnonymizeRedact, Redaction: "REDACTED"}},
+			{"redact:xxx", FieldAnonymizer{Method: AnonymizeRedact, Redaction: "xxx"}},
+			{"redact:", FieldAnonymizer{Method: AnonymizeRedact}},
+			{"null", FieldAnonymizer{Method: AnonymizeNull}},
+		}
+		for _, test := range valid {
+			anonymizer, err := ParseFieldAnonymizer(test.spec, "pepper")
+			So(err, ShouldBeNil)
+			So(anonymizer, ShouldResemble, test.anonymizer)
+		}
+		for _, spec := range []string{"", "md5", "sha256:0", "sha256:65", "sha256:x", "null:x"} {
+			_, err := ParseFieldAnonymizer(spec, "")
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestAnonymizeFields(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	// the SHA-256 hash of "a@b.c"
+	emailHash := "d648b243a3e817eaa3309e00e183483f2867baadf522099f0c2121770536b25a"
+	Convey("With columns to anonymize", t, func() {
+		colSpecs := []ColumnSpec{
+			{"email", new(FieldStringParser), pgStop, "string"},
+			{"name", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"phone", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"count", new(FieldInt32Parser), pgStop, "int32"},
+		}
+		hash, _ := ParseFieldAnonymizer("sha256", "")
+		opts := &ConvertOptions{AnonymizeFields: map[string]FieldAnonymizer{
+			"email": hash,
+			"name":  {Method: AnonymizeRedact, Redaction: "x"},
+			"phone": {Method: AnonymizeNull},
+		}}
+		So(opts.selectColumns(colSpecs), ShouldBeNil)
+
+		Convey("their values should be replaced once they are parsed", func() {
+			bsonD, err := tokensToBSON(colSpecs, []string{"a@b.c", "Ann", "5551234", "3"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{
+				{"email", emailHash},
+				{"name", "x"},
+				{"phone", nil},
+				{"count", int32(3)},
+			})
+		})
+		Convey("a numeric value of an auto column should be hashed as it was read", func() {
+			opts.AnonymizeFields["phone"], _ = ParseFieldAnonymizer("sha256:8", "salt")
+			So(opts.selectColumns(colSpecs), ShouldBeNil)
+			bsonD, err := tokensToBSON(colSpecs, []string{"", "", "5551234", "3"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD[2].Value, ShouldEqual, "20411913")
+		})
+		Convey("a string _id should be built from the hashes", func() {
+			opts.IDFields = []string{"email", "count"}
+			opts.IDSeparator = "-"
+			So(opts.selectColumns(colSpecs), ShouldBeNil)
+			bsonD, err := tokensToBSON(colSpecs, []string{"a@b.c", "Ann", "", "3"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD[0], ShouldResemble, bson.DocElem{Name: "_id", Value: emailHash + "-3"})
+		})
+		Convey("each must name a string or auto column in the header", func() {
+			opts.AnonymizeFields = map[string]FieldAnonymizer{"missing": hash}
+			So(opts.selectColumns(colSpecs), ShouldNotBeNil)
+			opts.AnonymizeFields = map[string]FieldAnonymizer{"count": hash}
+			So(opts.selectColumns(colSpecs), ShouldNotBeNil)
+		})
+	})
+	Convey("With a TSV input reader that hashes a column on several decoders", t, func() {
+		var buf bytes.Buffer
+		buf.WriteString("key.string()\n")
+		for i := 0; i < 200; i++ {
+			fmt.Fprintf(&buf, "key%d\n", i%10)
+		}
+		r := NewTSVInputReader(nil, bytes.NewReader(buf.Bytes()), os.Stdout, 4, false)
+		hash, _ := ParseFieldAnonymizer("sha256:16", "pepper")
+		r.AnonymizeFields = map[string]FieldAnonymizer{"key": hash}
+		So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+
+		Convey("each value should be given the same hash in every document", func() {
+			docChan := make(chan bson.D, 200)
+			So(r.StreamDocument(false, docChan), ShouldBeNil)
+			hashes := make(map[string]bool)
+			for doc := range docChan {
+				hashes[doc[0].Value.(string)] = true
+			}
+			So(len(hashes), ShouldEqual, 10)
+			So(hashes["f17b5dee4d23daaf"], ShouldBeTrue)
+		})
+	})
+	Convey("With a TSV input reader that anonymizes a column of a record that fails", t, func() {
+		contents := "email.string()\tcount.int32()\na@b.c\tmany\n"
+		r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+		r.AnonymizeFields = map[string]FieldAnonymizer{"email": {Method: AnonymizeNull}}
+		So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+
+		Convey("the error should not quote the record", func() {
+			err := r.StreamDocument(true, make(chan bson.D, 1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #1 (line 2): ")
+			So(err.Error(), ShouldNotContainSubstring, "a@b.c")
+		})
+		Convey("but the record should be rejected as it was read", func() {
+			var rejects bytes.Buffer
+			r.Rejects = &rejects
+			So(r.StreamDocument(true, make(chan bson.D, 1)), ShouldBeNil)
+			So(rejects.String(), ShouldEqual, "a@b.c\tmany\n")
+		})
+	})
+}
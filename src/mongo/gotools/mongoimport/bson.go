@@ -0,0 +1,163 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BSONInputReader is an implementation of InputReader that reads a stream of
+// length-prefixed BSON documents, such as a collection file from mongodump.
+type BSONInputReader struct {
+	// source is used to read the next raw document from the input source
+	source *db.BSONSource
+
+	// numProcessed is the number of BSON documents read in full, whether handed
+	// over to be converted or skipped by SkipRecords, but not one that fails
+	// to be read, so the one that does is number numProcessed+1; it is
+	// updated atomically, so it can be read while StreamDocument runs
+	numProcessed uint64
+
+	// offset is the byte offset of the next document in the input source
+	offset int64
+
+	// embedded sizeTracker exposes the Size() method to check the number of bytes read so far
+	sizeTracker
+
+	// embedded StreamOptions controls how records that fail to convert are handled
+	StreamOptions
+}
+
+// BSONConverter implements the Converter interface for BSON input.
+type BSONConverter struct {
+	data   []byte
+	index  uint64
+	offset int64
+}
+
+// NewBSONInputReader returns a BSONInputReader configured to read data from
+// the given io.Reader.
+func NewBSONInputReader(in io.Reader, numDecoders int) *BSONInputReader {
+	szCount := newSizeTrackingReader(in)
+	return &BSONInputReader{
+		// each document must have its own buffer, as they are decoded concurrently
+		source:        db.NewBufferlessBSONSource(ioutil.NopCloser(newDecompressingReader(szCount))),
+		sizeTracker:   szCount,
+		StreamOptions: StreamOptions{numDecoders: effectiveNumDecoders(numDecoders)},
+	}
+}
+
+// ReadAndValidateHeader is a no-op for BSON imports; always returns nil.
+func (r *BSONInputReader) ReadAndValidateHeader() error {
+	return nil
+}
+
+// ReadAndValidateTypedHeader is a no-op for BSON imports; always returns nil.
+func (r *BSONInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) error {
+	return nil
+}
+
+// Fields returns nil, as BSON documents have no fixed set of fields.
+func (r *BSONInputReader) Fields() []string {
+	return nil
+}
+
+// Progress returns a snapshot of how far StreamDocument has got. It is safe
+// to call while StreamDocument is running.
+func (r *BSONInputReader) Progress() ReaderProgress {
+	return r.progress(r.Size())
+}
+
+// StreamDocument takes a boolean indicating if the documents should be streamed
+// in read order and a channel on which to stream the documents processed from
+// the underlying reader. Returns a non-nil error if streaming fails.
+func (r *BSONInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	return r.streamTo(ordered, docSink(readDocs))
+}
+
+// streamTo is StreamDocument for the documents that sink takes.
+func (r *BSONInputReader) streamTo(ordered bool, sink documentSink) error {
+	r.beginStream(r.Size)
+	// cancelling on return stops the read loop if decoding fails first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rawChan := make(chan Converter, r.recordBuffer())
+	// buffered so neither goroutine blocks once the other's error is returned
+	bsonErrChan := make(chan error, 2)
+
+	// begin reading from source
+	go func() {
+		for {
+			var rawBytes []byte
+			if !r.stopped() {
+				rawBytes = r.source.LoadNext()
+			}
+			if rawBytes == nil {
+				close(rawChan)
+				if err := r.source.Err(); err != nil {
+					bsonErrChan <- fmt.Errorf("error reading document #%v at byte offset %v: %v",
+						r.numProcessed+1, r.offset, err)
+				} else {
+					bsonErrChan <- nil
+				}
+				return
+			}
+			if rawBytes[len(rawBytes)-1] != 0 {
+				close(rawChan)
+				bsonErrChan <- fmt.Errorf("error reading document #%v at byte offset %v: "+
+					"document of %v bytes is not null-terminated", r.numProcessed+1, r.offset, len(rawBytes))
+				return
+			}
+			if r.skipsRecord(r.numProcessed) {
+				atomic.AddUint64(&r.numProcessed, 1)
+				r.offset += int64(len(rawBytes))
+				continue
+			}
+			if err := r.handOver(ctx, rawChan, r.wrapConverter(BSONConverter{
+				data:   rawBytes,
+				index:  r.numProcessed,
+				offset: r.offset,
+			}, len(rawBytes))); err != nil {
+				close(rawChan)
+				bsonErrChan <- err
+				return
+			}
+			atomic.AddUint64(&r.numProcessed, 1)
+			r.offset += int64(len(rawBytes))
+		}
+	}()
+
+	// begin processing read bytes
+	go func() {
+		bsonErrChan <- r.streamConverted(context.Background(), ordered, rawChan, sink)
+	}()
+
+	return channelQuorumError(bsonErrChan, 2)
+}
+
+// Convert implements the Converter interface for BSON input. It unmarshals a
+// BSONConverter struct into a BSON document.
+func (c BSONConverter) Convert() (bson.D, error) {
+	var document bson.D
+	if err := bson.Unmarshal(c.data, &document); err != nil {
+		return nil, fmt.Errorf("error unmarshaling document #%v at byte offset %v: %v",
+			c.index+1, c.offset, err)
+	}
+	return document, nil
+}
+
+// rawRecord returns the document's raw bytes, including its length prefix.
+func (c BSONConverter) rawRecord() []byte {
+	return c.data
+}
@@ -0,0 +1,104 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// marshalDocs returns the concatenated BSON encodings of docs.
+func marshalDocs(docs ...bson.D) []byte {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			panic(err)
+		}
+		buf.Write(raw)
+	}
+	return buf.Bytes()
+}
+
+func TestBSONStreamDocument(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a BSON input reader", t, func() {
+		docs := []bson.D{
+			{{"_id", 1}, {"b", "x"}},
+			{{"_id", 2}, {"c", bson.D{{"d", 1.5}}}},
+			{{"_id", 3}},
+		}
+		contents := marshalDocs(docs...)
+
+		Convey("documents should be streamed in order and the bytes "+
+			"consumed tracked", func() {
+			r := NewBSONInputReader(bytes.NewReader(contents), 2)
+			docChan := make(chan bson.D, len(docs))
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			for _, doc := range docs {
+				So(<-docChan, ShouldResemble, doc)
+			}
+			So(r.Size(), ShouldEqual, len(contents))
+		})
+
+		Convey("skipped and unsampled documents should not be converted", func() {
+			r := NewBSONInputReader(bytes.NewReader(contents), 1)
+			r.SkipRecords = 1
+			r.SampleEvery = 2
+			docChan := make(chan bson.D, len(docs))
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1)
+			So(<-docChan, ShouldResemble, docs[1])
+			So(r.NumProcessed(), ShouldEqual, 3)
+		})
+
+		Convey("an empty input source should produce no documents", func() {
+			r := NewBSONInputReader(bytes.NewReader(nil), 1)
+			So(r.StreamDocument(true, make(chan bson.D)), ShouldBeNil)
+		})
+
+		Convey("a truncated final document should report its byte offset", func() {
+			r := NewBSONInputReader(bytes.NewReader(contents[:len(contents)-2]), 1)
+			docChan := make(chan bson.D, len(docs))
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "document #3")
+			offset := len(marshalDocs(docs[0], docs[1]))
+			So(err.Error(), ShouldContainSubstring, fmt.Sprintf("byte offset %v", offset))
+		})
+
+		Convey("a corrupt length prefix should report its byte offset", func() {
+			corrupt := append([]byte{}, contents...)
+			first := len(marshalDocs(docs[0]))
+			corrupt[first] = 2
+			corrupt[first+1] = 0
+			corrupt[first+2] = 0
+			corrupt[first+3] = 0
+			r := NewBSONInputReader(bytes.NewReader(corrupt), 1)
+			docChan := make(chan bson.D, len(docs))
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "document #2")
+			So(err.Error(), ShouldContainSubstring, "invalid BSONSize")
+			So(<-docChan, ShouldResemble, docs[0])
+		})
+
+		Convey("a document that is not null-terminated should error out", func() {
+			corrupt := append([]byte{}, contents...)
+			corrupt[len(contents)-1] = 1
+			r := NewBSONInputReader(bytes.NewReader(corrupt), 1)
+			err := r.StreamDocument(true, make(chan bson.D, len(docs)))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "not null-terminated")
+		})
+	})
+}
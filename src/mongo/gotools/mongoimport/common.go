@@ -9,13 +9,22 @@ package mongoimport
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
+	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/db"
@@ -57,6 +66,143 @@ func ParsePG(pg string) (res ParseGrace) {
 	return
 }
 
+// RaggedRowPolicy controls how a record with fewer or more tokens than there
+// are columns is converted.
+type RaggedRowPolicy int
+
+const (
+	// RaggedDefault omits the trailing fields of short rows and collects the
+	// extra tokens of long rows into generated fields.
+	RaggedDefault RaggedRowPolicy = iota
+
+	// RaggedError fails the conversion of the row.
+	RaggedError
+
+	// RaggedPadWithNull sets the missing trailing fields of short rows to null,
+	// or to the default of their column, if it has one.
+	RaggedPadWithNull
+
+	// RaggedPadWithMissing omits the missing trailing fields of short rows.
+	RaggedPadWithMissing
+
+	// RaggedTruncateExtra drops the extra tokens of long rows.
+	RaggedTruncateExtra
+
+	// RaggedCollectExtraIntoField stores each extra token of a long row under
+	// a generated field name, "field<index>", where index is 0-based.
+	RaggedCollectExtraIntoField
+
+	// RaggedPadWithEmpty converts the missing trailing fields of short rows
+	// as if they were empty tokens, so IgnoreBlanks applies to them as it
+	// does to the explicitly empty ones.
+	RaggedPadWithEmpty
+)
+
+var raggedRowPolicyNames = map[RaggedRowPolicy]string{
+	RaggedDefault:               "default",
+	RaggedError:                 "error",
+	RaggedPadWithNull:           "padWithNull",
+	RaggedPadWithMissing:        "padWithMissing",
+	RaggedTruncateExtra:         "truncateExtra",
+	RaggedCollectExtraIntoField: "collectExtraIntoField",
+	RaggedPadWithEmpty:          "padWithEmpty",
+}
+
+func (p RaggedRowPolicy) String() string {
+	if name, ok := raggedRowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("RaggedRowPolicy(%d)", int(p))
+}
+
+// ValidateShortRows ensures the user-provided policy for short rows is one of
+// the allowed values.
+func ValidateShortRows(policy string) (RaggedRowPolicy, error) {
+	switch policy {
+	case "", "padWithMissing":
+		return RaggedPadWithMissing, nil
+	case "padWithNull":
+		return RaggedPadWithNull, nil
+	case "padWithEmpty":
+		return RaggedPadWithEmpty, nil
+	case "error":
+		return RaggedError, nil
+	default:
+		return RaggedDefault, fmt.Errorf("invalid short row policy: %s", policy)
+	}
+}
+
+// ValidateLongRows ensures the user-provided policy for long rows is one of
+// the allowed values.
+func ValidateLongRows(policy string) (RaggedRowPolicy, error) {
+	switch policy {
+	case "", "collectExtraIntoField":
+		return RaggedCollectExtraIntoField, nil
+	case "truncateExtra":
+		return RaggedTruncateExtra, nil
+	case "error":
+		return RaggedError, nil
+	default:
+		return RaggedDefault, fmt.Errorf("invalid long row policy: %s", policy)
+	}
+}
+
+// InvalidUTF8Policy controls how a value that is converted to a string, but
+// is not valid UTF-8, is handled.
+type InvalidUTF8Policy int
+
+const (
+	// InvalidUTF8Error fails the conversion of the record.
+	InvalidUTF8Error InvalidUTF8Policy = iota
+
+	// InvalidUTF8Replace replaces each run of bytes that are not valid UTF-8
+	// with U+FFFD, the replacement character.
+	InvalidUTF8Replace
+
+	// InvalidUTF8Skip drops the record, which is counted as rejected and
+	// written to the Rejects of its stream, if set, whatever its MaxErrors.
+	InvalidUTF8Skip
+)
+
+// ValidateInvalidUTF8Policy ensures the user-provided policy for invalid
+// UTF-8 is one of the allowed values.
+func ValidateInvalidUTF8Policy(policy string) (InvalidUTF8Policy, error) {
+	switch policy {
+	case "", "error":
+		return InvalidUTF8Error, nil
+	case "replace":
+		return InvalidUTF8Replace, nil
+	case "skip":
+		return InvalidUTF8Skip, nil
+	default:
+		return InvalidUTF8Error, fmt.Errorf("invalid UTF-8 policy: %s", policy)
+	}
+}
+
+// MaxNumDecoders is the most decoding goroutines an input reader runs; a
+// reader asked for more runs this many, logging a warning.
+const MaxNumDecoders = 256
+
+// DefaultNumDecoders returns the number of decoding goroutines that an input
+// reader runs when it is asked for zero or fewer: one for each CPU.
+func DefaultNumDecoders() int {
+	return runtime.NumCPU()
+}
+
+// effectiveNumDecoders returns the number of decoding goroutines to run when
+// numDecoders are asked for: DefaultNumDecoders() if it is not positive, and
+// at most MaxNumDecoders.
+func effectiveNumDecoders(numDecoders int) int {
+	if numDecoders <= 0 {
+		return DefaultNumDecoders()
+	}
+	if numDecoders > MaxNumDecoders {
+		log.Logvf(log.Always, "warning: using the maximum of %v decoding workers, not %v", MaxNumDecoders, numDecoders)
+		return MaxNumDecoders
+	}
+	return numDecoders
+}
+
 // Converter is an interface that adds the basic Convert method which returns a
 // valid BSON document that has been converted by the underlying implementation.
 // If conversion fails, err will be set.
@@ -64,283 +210,2853 @@ type Converter interface {
 	Convert() (document bson.D, err error)
 }
 
-// An importWorker reads Converter from the unprocessedDataChan channel and
-// sends processed BSON documents on the processedDocumentChan channel
-type importWorker struct {
-	// unprocessedDataChan is used to stream the input data for a worker to process
-	unprocessedDataChan chan Converter
+// RawConverter is implemented by the Converters that can return their
+// document already marshalled to BSON, as StreamRaw streams it. A nil
+// document, for a record that was dropped, is returned as nil.
+type RawConverter interface {
+	ConvertRaw() (document []byte, err error)
+}
 
-	// used to stream the processed document back to the caller
-	processedDocumentChan chan bson.D
+// rawRecordConverter is implemented by Converters that can return the input
+// they were built from, exactly as it was read.
+type rawRecordConverter interface {
+	Converter
+	rawRecord() []byte
+}
 
-	// used to synchronise all worker goroutines
-	tomb *tomb.Tomb
+// whitespaceRun matches the runs of whitespace replaced by UnderscoreFieldNames.
+var whitespaceRun = regexp.MustCompile(`\s+`)
+
+// maxRecentFailures is the number of conversion failures quoted in the error
+// returned once MaxErrors is exceeded.
+const maxRecentFailures = 5
+
+// StreamOptions controls how StreamDocument handles records that fail to
+// convert. It is embedded in the input readers, so its exported fields can be
+// changed before the first call to StreamDocument.
+type StreamOptions struct {
+	// Rejects, if set, receives the raw input of every record that fails to
+	// convert within the MaxErrors limit, byte for byte including its line
+	// ending. Writes are serialized, so the writer need not be safe for
+	// concurrent use.
+	Rejects io.Writer
+
+	// MaxErrors is the number of records that may fail to convert before
+	// streaming fails; failures within the limit are logged and skipped. Zero
+	// stops at the first failure unless Rejects is set, and a negative value
+	// never stops.
+	MaxErrors int
+
+	// Transform, if set, is applied to every document that converts, before
+	// it is streamed. It is called concurrently by the decoding goroutines,
+	// so it must be safe for concurrent use. A nil document drops the
+	// record, and an error is handled like a conversion failure.
+	Transform func(bson.D) (bson.D, error)
+
+	// MaxDocumentSize is the largest that a document may be, once marshalled
+	// to BSON, for it to be streamed; a larger one fails to convert, in the
+	// FailureSize category, rather than failing to insert. Zero is the
+	// server's maximum, db.MaxBSONSize. A negative size checks nothing, which
+	// spares marshalling each document an extra time, except when streaming
+	// raw, when the server's maximum still applies.
+	MaxDocumentSize int
+
+	// UpsertFields, if set, are the fields that identify each document for
+	// an upsert, in dot notation. Every document streamed must have each of
+	// them, after Transform; a document that lacks one fails to convert.
+	UpsertFields []string
+
+	// TotalBytes is the size of the input, if it is known, as reported by
+	// Progress; zero if it is not.
+	TotalBytes int64
+
+	// SkipRecords is the number of records, after any header, that are read
+	// and discarded without being converted, so that an import can resume
+	// where an earlier one stopped. Skipped records still count towards the
+	// record numbers in errors.
+	SkipRecords uint64
+
+	// Skip is the number of records after the SkipRecords that are also
+	// discarded, so that a range of the input can be imported by an import
+	// that is also resumed. Limit, if set, is the most records that are then
+	// handed over to be converted, after which the reader stops reading, as
+	// if the input had ended. A SplitTSVInputReader does neither, nor sample.
+	Skip  uint64
+	Limit uint64
+
+	// SampleEvery, if more than one, selects only the first of every
+	// SampleEvery records after the SkipRecords and Skip to be converted, and
+	// SampleFraction, if set instead, selects each of them at random with
+	// that probability, from a source seeded with SampleSeed, so that runs
+	// with the same seed select the same records. The records left out are
+	// read but not converted, and count towards the record numbers in errors
+	// and towards NumProcessed; a header is never left out.
+	SampleEvery    uint64
+	SampleFraction float64
+	SampleSeed     int64
+
+	// DocumentSizes records a SizeHistogram of the sizes of the documents
+	// streamed, marshalled to BSON, in the SizeBuckets, or the
+	// DefaultSizeBuckets if they are not set, for Progress and Summary to
+	// report. The size is the one that MaxDocumentSize is checked against,
+	// so documents are not marshalled again to measure them.
+	DocumentSizes bool
+	SizeBuckets   []int
+
+	// DecoderTiming times the decoders converting each record, and the
+	// reader handing records to them, for Summary to report as a
+	// DecoderTiming, with a recommendation of the number of decoders. Unless
+	// it is set, nothing is timed.
+	DecoderTiming bool
+
+	// BatchSize is the number of consecutive records that the CSV and TSV
+	// readers hand to the decoding goroutines at once, which cuts the channel
+	// operations per record when records are short. Documents are still
+	// streamed one at a time, and in input order if ordered; a record that
+	// fails to convert is reported as a BatchRecordError. Zero or one hands
+	// over each record on its own.
+	BatchSize int
+
+	// ReorderWindow is, when streaming in order, the number of records, or
+	// batches of them, that may be converted ahead of the next one to be
+	// streamed, so that a record that is slow to convert does not hold up
+	// the decoders behind it. Once that many are waiting, reading pauses.
+	// Zero picks 16 for each decoder.
+	ReorderWindow int
+
+	// RecordBuffer is the number of records, or batches of them, that may
+	// wait to be taken up by the decoding goroutines. Zero allows one for
+	// each decoder.
+	RecordBuffer int
+
+	// MaxBufferedBytes, if positive, pauses reading while the records read
+	// but not yet converted add up to more than this many bytes of input,
+	// so that a slow consumer can not make the reader buffer without bound.
+	// A record is always read if nothing else is buffered, however long.
+	MaxBufferedBytes int64
+
+	// Metrics, if set, is called with a snapshot of the stream's progress
+	// each time another MetricsInterval documents have been converted, and
+	// once more, with Final set, when streaming ends, whether or not it
+	// failed. It is called on a goroutine of its own, and never concurrently
+	// with itself, so a slow callback does not hold up conversion; snapshots
+	// that fall due while it runs are combined into one. If MetricsInterval
+	// is zero, it is only called at the end.
+	Metrics         func(StreamMetrics)
+	MetricsInterval uint64
+
+	// DocumentsPerSecond and BytesPerSecond, if positive, limit the rate at
+	// which documents are streamed, and at which the input of the records
+	// they were converted from is, by making the decoders wait before
+	// handing each document on, so that reading slows down behind them.
+	// Each allows a burst of a tenth of a second's worth after a pause. Use
+	// SetRateLimit to change them while streaming.
+	DocumentsPerSecond float64
+	BytesPerSecond     float64
+
+	// Dedup drops every document whose key is that of a document streamed
+	// before it, after Transform: the values of DedupFields, in dot
+	// notation, or the whole document if there are none. When streaming in
+	// order, the first occurrence of each key in the input is kept; when not,
+	// the one that finishes converting first is, which need not be the first
+	// in the input, as the decoders run concurrently. Keys are kept as
+	// 128-bit hashes for the rest of the stream, but not across resumed
+	// imports. DedupMaxMemory, if positive, caps the memory that they may
+	// take, at an estimated 64 bytes each, beyond which DedupOverflow says
+	// whether the documents after them fail to convert or the keys go into a
+	// Bloom filter. A document that lacks one of the DedupFields fails to
+	// convert, as do those, in the FailureDedup category.
+	Dedup          bool
+	DedupFields    []string
+	DedupMaxMemory int64
+	DedupOverflow  DedupOverflowPolicy
+
+	// Route, if set, is called with every document that converts, after
+	// Transform, for the route that it takes, such as the collection it is
+	// imported into. StreamRouted streams each document tagged with its
+	// route, and Summary counts the documents streamed on each. Like
+	// Transform, it is called concurrently by the decoding goroutines, and
+	// an error is handled like a conversion failure.
+	Route func(bson.D) (string, error)
+
+	numDropped         uint64
+	numRead            uint64
+	numConverted       uint64
+	numEmpty           uint64
+	numSkipped         uint64
+	numReplaced        uint64
+	numDuplicates      uint64
+	numUnsampled       uint64
+	sampler            *rand.Rand
+	sizesOnce          sync.Once
+	sizes              *sizeRecorder
+	timingOnce         sync.Once
+	timing             *decoderTimer
+	bytesEmitted       int64
+	started            int64
+	finished           int64
+	failureLock        sync.Mutex
+	numFailed          uint64
+	failuresByCategory map[FailureCategory]uint64
+	numRejected        uint64
+	recentFailures     []error
+	abortErr           error
+	bufferLock         sync.Mutex
+	bufferedBytes      int64
+	bufferFreed        chan struct{}
+	limiter            rateLimiter
+	dedup              *dedupFilter
+	routeLock          sync.Mutex
+	routeCounts        map[string]uint64
+
+	// numDecoders is the number of concurrent goroutines to use for
+	// decoding, set by the reader's constructor
+	numDecoders int
+
+	// bytesRead returns the number of bytes the reader has read, as set by
+	// beginStream, and metricsDue is signalled when a snapshot for Metrics
+	// falls due
+	bytesRead  func() int64
+	metricsDue chan struct{}
+
+	// stop is closed by Stop, and done by streamConverted once streaming has
+	// ended; stopInit makes them both
+	stopInit sync.Once
+	stopOnce sync.Once
+	doneOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
 }
 
-// an interface for tracking the number of bytes, which is used in mongoimport to feed
-// the progress bar.
-type sizeTracker interface {
-	Size() int64
+// streamOptions returns the options, so that mongoimport can set those of
+// any reader that embeds them.
+func (opts *StreamOptions) streamOptions() *StreamOptions {
+	return opts
 }
 
-// sizeTrackingReader implements Reader and sizeTracker by wrapping an io.Reader and keeping track
-// of the total number of bytes read from each call to Read().
-type sizeTrackingReader struct {
-	bytesRead int64
-	reader    io.Reader
+// streamConverted is streamDocumentsContext for the reader's stream: it
+// sends the documents to sink, and closes it once it is done.
+func (opts *StreamOptions) streamConverted(ctx context.Context, ordered bool, records chan Converter, sink documentSink) error {
+	opts.initStop()
+	defer opts.doneOnce.Do(func() { close(opts.done) })
+	opts.initDedup(ordered)
+	finishMetrics := opts.startMetrics()
+	opts.startTiming(opts.numDecoders)
+	err := streamDocumentsTo(ctx, ordered, opts.numDecoders, opts.ReorderWindow, records, sink)
+	atomic.StoreInt64(&opts.finished, time.Now().UnixNano())
+	finishMetrics()
+	return err
 }
 
-func (str *sizeTrackingReader) Size() int64 {
-	bytes := atomic.LoadInt64(&str.bytesRead)
-	return bytes
+// Stop stops the reader from reading any more records, and waits for those
+// it has read to be converted and streamed, and for the channel of documents
+// to be closed, so they must still be received. StreamDocument then returns
+// as if the input had ended. Stop returns the number of records, after any
+// header and counting those skipped by SkipRecords, that were read: every one
+// of them has been streamed or has failed, so a later import given that many
+// SkipRecords resumes with the first record that was not read, unless
+// streaming failed. Records read in several ranges at once, as by a
+// SplitTSVInputReader, are not the first ones of the input, so such a stream
+// can not be resumed. Stop may be called more than once, and after streaming
+// has ended, or before it has begun, when nothing is then read.
+func (opts *StreamOptions) Stop() uint64 {
+	opts.initStop()
+	opts.stopOnce.Do(func() { close(opts.stop) })
+	if atomic.LoadInt64(&opts.started) != 0 {
+		<-opts.done
+	}
+	return opts.NumProcessed()
 }
 
-func (str *sizeTrackingReader) Read(p []byte) (n int, err error) {
-	n, err = str.reader.Read(p)
-	atomic.AddInt64(&str.bytesRead, int64(n))
-	return
+// NumProcessed returns the number of records read so far, after any header,
+// each of them handed over to be converted, skipped by SkipRecords or Skip or
+// left out by sampling. The count is kept atomically, so it may be read while
+// StreamDocument runs.
+func (opts *StreamOptions) NumProcessed() uint64 {
+	return atomic.LoadUint64(&opts.numSkipped) + atomic.LoadUint64(&opts.numRead) + atomic.LoadUint64(&opts.numUnsampled)
 }
 
-func newSizeTrackingReader(reader io.Reader) *sizeTrackingReader {
-	return &sizeTrackingReader{
-		reader:    reader,
-		bytesRead: 0,
+// initStop makes the channels that Stop and streamConverted close.
+func (opts *StreamOptions) initStop() {
+	opts.stopInit.Do(func() {
+		opts.stop = make(chan struct{})
+		opts.done = make(chan struct{})
+	})
+}
+
+// stopped reports whether Stop has been called, or the Limit of records has
+// been read, after which the reader reads no more records.
+func (opts *StreamOptions) stopped() bool {
+	if opts.Limit != 0 && atomic.LoadUint64(&opts.numRead) >= opts.Limit {
+		return true
+	}
+	opts.initStop()
+	select {
+	case <-opts.stop:
+		return true
+	default:
+		return false
 	}
 }
 
-var (
-	UTF8_BOM = []byte{0xEF, 0xBB, 0xBF}
-)
+// StreamMetrics is a snapshot of the progress of a stream, as passed to the
+// Metrics callback of StreamOptions.
+type StreamMetrics struct {
+	ReaderProgress
 
-// bomDiscardingReader implements and wraps io.Reader, discarding the UTF-8 BOM, if applicable
-type bomDiscardingReader struct {
-	buf     *bufio.Reader
-	didRead bool
+	// RecordsPerSecond is the rate at which records were read since the
+	// previous snapshot, or, for the first, since streaming began
+	RecordsPerSecond float64
+
+	// Final is set for the snapshot taken once streaming has ended
+	Final bool
 }
 
-func (bd *bomDiscardingReader) Read(p []byte) (int, error) {
-	if !bd.didRead {
-		bom, err := bd.buf.Peek(3)
-		if err == nil && bytes.Equal(bom, UTF8_BOM) {
-			bd.buf.Read(make([]byte, 3)) // discard BOM
+// startMetrics starts the goroutine that calls Metrics, if it is set, and
+// returns the function that makes the final call, once streaming has ended,
+// and waits for it.
+func (opts *StreamOptions) startMetrics() func() {
+	if opts.Metrics == nil {
+		return func() {}
+	}
+	// a signal is dropped if one is waiting, so the decoders never block
+	due := make(chan struct{}, 1)
+	opts.metricsDue = due
+	end, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		var lastRead uint64
+		var lastElapsed time.Duration
+		report := func(final bool) {
+			metrics := StreamMetrics{ReaderProgress: opts.progress(opts.readerBytes()), Final: final}
+			if interval := (metrics.Elapsed - lastElapsed).Seconds(); interval > 0 {
+				metrics.RecordsPerSecond = float64(metrics.RecordsRead-lastRead) / interval
+			}
+			lastRead, lastElapsed = metrics.RecordsRead, metrics.Elapsed
+			opts.Metrics(metrics)
 		}
-		bd.didRead = true
+		for {
+			select {
+			case <-due:
+				report(false)
+			case <-end:
+				report(true)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(end)
+		<-finished
 	}
-	return bd.buf.Read(p)
 }
 
-func newBomDiscardingReader(r io.Reader) *bomDiscardingReader {
-	return &bomDiscardingReader{buf: bufio.NewReader(r)}
+// readerBytes returns the number of bytes the reader has read, or zero if
+// streaming has not begun.
+func (opts *StreamOptions) readerBytes() int64 {
+	if opts.bytesRead == nil {
+		return 0
+	}
+	return opts.bytesRead()
 }
 
-// channelQuorumError takes a channel and a quorum - which specifies how many
-// messages to receive on that channel before returning. It either returns the
-// first non-nil error received on the channel or nil if up to `quorum` nil
-// errors are received
-func channelQuorumError(ch <-chan error, quorum int) (err error) {
-	for i := 0; i < quorum; i++ {
-		if err = <-ch; err != nil {
-			return
+// countConverted counts a converted document, signalling that a snapshot for
+// Metrics is due if it is the last of an interval.
+func (opts *StreamOptions) countConverted() {
+	numConverted := atomic.AddUint64(&opts.numConverted, 1)
+	if opts.metricsDue != nil && opts.MetricsInterval != 0 && numConverted%opts.MetricsInterval == 0 {
+		select {
+		case opts.metricsDue <- struct{}{}:
+		default:
 		}
 	}
-	return
 }
 
-// constructUpsertDocument constructs a BSON document to use for upserts
-func constructUpsertDocument(upsertFields []string, document bson.D) bson.D {
-	upsertDocument := bson.D{}
-	var hasDocumentKey bool
-	for _, key := range upsertFields {
-		val := getUpsertValue(key, document)
-		if val != nil {
-			hasDocumentKey = true
+// Rejected returns the number of records written to Rejects so far, and of
+// those dropped by InvalidUTF8Skip, whether or not Rejects is set.
+func (opts *StreamOptions) Rejected() uint64 {
+	opts.failureLock.Lock()
+	defer opts.failureLock.Unlock()
+	return opts.numRejected
+}
+
+// Dropped returns the number of documents dropped by Transform so far.
+func (opts *StreamOptions) Dropped() uint64 {
+	return atomic.LoadUint64(&opts.numDropped)
+}
+
+// ReaderProgress is a snapshot of how far an input reader has got with
+// StreamDocument.
+type ReaderProgress struct {
+	// BytesRead is the number of bytes read from the input so far, and
+	// TotalBytes the size of the input, or zero if it is not known
+	BytesRead  int64
+	TotalBytes int64
+
+	// BytesEmitted is the length of the input of the records whose
+	// documents have been streamed, after any decompression and decoding.
+	// It trails BytesRead by what is read ahead and buffered, and by the
+	// records that failed, were skipped, or were dropped
+	BytesEmitted int64
+
+	// RecordsRead is the number of records read from the input so far, of
+	// which RecordsConverted were converted and RecordsFailed failed to; the
+	// rest have yet to be converted, or were skipped or dropped by the
+	// Transform
+	RecordsRead      uint64
+	RecordsConverted uint64
+	RecordsFailed    uint64
+
+	// Elapsed is the time since StreamDocument was first called
+	Elapsed time.Duration
+
+	// DocumentSizes is the histogram of the sizes of the documents streamed
+	// so far, if StreamOptions.DocumentSizes is set, and otherwise nil
+	DocumentSizes *SizeHistogram
+}
+
+// skipRecordsLogInterval is how many records are skipped between each log of
+// the progress of SkipRecords.
+const skipRecordsLogInterval = 1000000
+
+// skipsRecord reports whether the record at index, counting from zero after
+// any header, is one of the SkipRecords or the Skip after them, logging every
+// million skipped, or one that sampling leaves out.
+func (opts *StreamOptions) skipsRecord(index uint64) bool {
+	toSkip := opts.SkipRecords + opts.Skip
+	if index >= toSkip {
+		if opts.samples(index - toSkip) {
+			return false
+		}
+		atomic.AddUint64(&opts.numUnsampled, 1)
+		return true
+	}
+	atomic.AddUint64(&opts.numSkipped, 1)
+	if skipped := index + 1; skipped%skipRecordsLogInterval == 0 || skipped == toSkip {
+		log.Logvf(log.Always, "skipped %v of %v records", skipped, toSkip)
+	}
+	return true
+}
+
+// beginStream records the time streaming began, the first time it is called,
+// and the function that returns the number of bytes the reader has read.
+func (opts *StreamOptions) beginStream(bytesRead func() int64) {
+	atomic.CompareAndSwapInt64(&opts.started, 0, time.Now().UnixNano())
+	opts.bytesRead = bytesRead
+}
+
+// progress returns a snapshot of the stream's progress, given the number of
+// bytes read so far. It may be called concurrently with StreamDocument. The
+// records converted and failed are counted before the records read, so they
+// never add up to more.
+func (opts *StreamOptions) progress(bytesRead int64) ReaderProgress {
+	numConverted := atomic.LoadUint64(&opts.numConverted)
+	opts.failureLock.Lock()
+	numFailed := opts.numFailed
+	opts.failureLock.Unlock()
+	var elapsed time.Duration
+	if started := atomic.LoadInt64(&opts.started); started != 0 {
+		elapsed = time.Since(time.Unix(0, started))
+	}
+	return ReaderProgress{
+		BytesRead:        bytesRead,
+		TotalBytes:       opts.TotalBytes,
+		BytesEmitted:     atomic.LoadInt64(&opts.bytesEmitted),
+		RecordsRead:      atomic.LoadUint64(&opts.numRead),
+		RecordsConverted: numConverted,
+		RecordsFailed:    numFailed,
+		Elapsed:          elapsed,
+		DocumentSizes:    opts.documentSizes(),
+	}
+}
+
+// wrapConverter returns c, wrapped so that its documents are transformed and
+// checked for their upsert keys, it and its conversions are counted, and its
+// conversion failures are handled as configured. Size is the length of the
+// record's input, which counts towards MaxBufferedBytes until it is converted.
+func (opts *StreamOptions) wrapConverter(c Converter, size int) Converter {
+	index := atomic.AddUint64(&opts.numRead, 1) - 1
+	return rejectingConverter{c, opts, int64(size), index}
+}
+
+// recordBuffer returns the capacity of the channel on which the reader hands
+// its records to its decoding goroutines.
+func (opts *StreamOptions) recordBuffer() int {
+	if opts.RecordBuffer > 0 {
+		return opts.RecordBuffer
+	}
+	return opts.numDecoders
+}
+
+// NumDecoders returns the number of goroutines that StreamDocument decodes
+// on, which is DefaultNumDecoders() if the reader was given zero or fewer,
+// and at most MaxNumDecoders.
+func (opts *StreamOptions) NumDecoders() int {
+	return opts.numDecoders
+}
+
+// handOver sends c, a Converter returned by wrapConverter or a batch of them,
+// on records once the records buffered but not yet converted leave room for
+// it under MaxBufferedBytes. It returns ctx.Err() if ctx is done first.
+func (opts *StreamOptions) handOver(ctx context.Context, records chan<- Converter, c Converter) error {
+	if timer := opts.decoderTimer(); timer != nil {
+		defer timer.blockedSince(time.Now())
+	}
+	size := bufferedSize(c)
+	if err := opts.reserveBuffer(ctx, size); err != nil {
+		return err
+	}
+	select {
+	case records <- c:
+		return nil
+	case <-ctx.Done():
+		opts.releaseBuffer(size)
+		return ctx.Err()
+	}
+}
+
+// bufferedSize returns the length of the input that c converts, which was
+// given to wrapConverter.
+func bufferedSize(c Converter) (size int64) {
+	switch c := c.(type) {
+	case rejectingConverter:
+		return c.size
+	case converterBatch:
+		for _, converter := range c.converters {
+			size += bufferedSize(converter)
+		}
+	}
+	return size
+}
+
+// reserveBuffer adds size to the bytes buffered, waiting until they leave
+// room for it under MaxBufferedBytes or ctx is done.
+func (opts *StreamOptions) reserveBuffer(ctx context.Context, size int64) error {
+	if opts.MaxBufferedBytes <= 0 {
+		return nil
+	}
+	for {
+		opts.bufferLock.Lock()
+		if opts.bufferedBytes == 0 || opts.bufferedBytes+size <= opts.MaxBufferedBytes {
+			opts.bufferedBytes += size
+			opts.bufferLock.Unlock()
+			return nil
+		}
+		if opts.bufferFreed == nil {
+			opts.bufferFreed = make(chan struct{})
+		}
+		freed := opts.bufferFreed
+		opts.bufferLock.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// releaseBuffer takes size from the bytes buffered, waking the reader if it
+// is waiting for room.
+func (opts *StreamOptions) releaseBuffer(size int64) {
+	if opts.MaxBufferedBytes <= 0 {
+		return
+	}
+	opts.bufferLock.Lock()
+	defer opts.bufferLock.Unlock()
+	opts.bufferedBytes -= size
+	if opts.bufferFreed != nil {
+		close(opts.bufferFreed)
+		opts.bufferFreed = nil
+	}
+}
+
+// toleratesFailures reports whether conversion failures are handled by
+// convertFailed rather than stopping the stream at once.
+func (opts *StreamOptions) toleratesFailures() bool {
+	return opts.Rejects != nil || opts.MaxErrors != 0
+}
+
+// tolerates reports whether the stream may continue after numFailed records
+// failed to convert.
+func (opts *StreamOptions) tolerates(numFailed uint64) bool {
+	if opts.MaxErrors < 0 || opts.MaxErrors == 0 && opts.Rejects != nil {
+		return true
+	}
+	return numFailed <= uint64(opts.MaxErrors)
+}
+
+// countFailure counts a record failing to convert with err. The caller holds
+// failureLock.
+func (opts *StreamOptions) countFailure(err error) {
+	opts.numFailed++
+	if opts.failuresByCategory == nil {
+		opts.failuresByCategory = make(map[FailureCategory]uint64)
+	}
+	opts.failuresByCategory[ErrorCategory(err)]++
+}
+
+// Failures returns the number of records that have failed to convert so far
+// in each FailureCategory.
+func (opts *StreamOptions) Failures() map[FailureCategory]uint64 {
+	opts.failureLock.Lock()
+	defer opts.failureLock.Unlock()
+	failures := make(map[FailureCategory]uint64, len(opts.failuresByCategory))
+	for category, count := range opts.failuresByCategory {
+		failures[category] = count
+	}
+	return failures
+}
+
+// rejectSkipped handles c being dropped by a policy, for the reason err: it is
+// counted as rejected, and written to Rejects if that is set.
+func (opts *StreamOptions) rejectSkipped(c Converter, err error) error {
+	opts.failureLock.Lock()
+	defer opts.failureLock.Unlock()
+	if rc, ok := c.(rawRecordConverter); ok && opts.Rejects != nil {
+		if _, writeErr := opts.Rejects.Write(rc.rawRecord()); writeErr != nil {
+			return fmt.Errorf("error writing rejected record: %v (rejected because: %v)", writeErr, err)
+		}
+	}
+	opts.numRejected++
+	log.Logvf(log.Always, "rejected record: %v", err)
+	return nil
+}
+
+// convertFailed handles c failing to convert with err. It returns a non-nil
+// error if the stream must stop. Once the limit has been exceeded, every
+// later call returns the same error, so its count is exact however many
+// decoders fail concurrently.
+func (opts *StreamOptions) convertFailed(c Converter, err error) error {
+	opts.failureLock.Lock()
+	defer opts.failureLock.Unlock()
+	if opts.abortErr != nil {
+		return opts.abortErr
+	}
+	opts.countFailure(err)
+	opts.recentFailures = append(opts.recentFailures, err)
+	if len(opts.recentFailures) > maxRecentFailures {
+		opts.recentFailures = opts.recentFailures[1:]
+	}
+	if !opts.tolerates(opts.numFailed) {
+		failures := make([]string, len(opts.recentFailures))
+		for i, failure := range opts.recentFailures {
+			failures[i] = failure.Error()
+		}
+		opts.abortErr = fmt.Errorf("%v records failed to convert, more than the maximum of %v; "+
+			"last %v failures: %v", opts.numFailed, opts.MaxErrors, len(failures), strings.Join(failures, "; "))
+		return opts.abortErr
+	}
+	if opts.Rejects != nil {
+		rc, ok := c.(rawRecordConverter)
+		if !ok {
+			return err
+		}
+		if _, writeErr := opts.Rejects.Write(rc.rawRecord()); writeErr != nil {
+			return fmt.Errorf("error writing rejected record: %v (rejected because: %v)", writeErr, err)
+		}
+		opts.numRejected++
+		log.Logvf(log.Always, "rejected record: %v", err)
+	} else {
+		log.Logvf(log.Always, "skipping record: %v", err)
+	}
+	return nil
+}
+
+// rejectingConverter is a Converter that applies the stream's Transform and
+// Route, checks the upsert key of each document, drops its duplicates, counts its
+// conversions, and hands its conversion failures to the stream's
+// StreamOptions, rather than returning them directly. Once converted, its
+// size no longer counts towards the bytes buffered.
+type rejectingConverter struct {
+	Converter
+	opts *StreamOptions
+	size int64
+
+	// index is the index of the record among those read, for Dedup
+	index uint64
+}
+
+func (c rejectingConverter) Convert() (bson.D, error) {
+	document, _, _, err := c.convert(false)
+	return document, err
+}
+
+// ConvertRaw implements RawConverter. A document that is too large to import
+// is handled like a conversion failure.
+func (c rejectingConverter) ConvertRaw() ([]byte, error) {
+	_, raw, _, err := c.convert(true)
+	return raw, err
+}
+
+// convertRouted converts the record for StreamRouted.
+func (c rejectingConverter) convertRouted() (RoutedDocument, error) {
+	document, _, route, err := c.convert(false)
+	return RoutedDocument{route, document}, err
+}
+
+// convert converts the record, also marshalling its document if marshal is
+// set, and returns the route of its document. A document that nothing
+// inspects is converted straight to BSON, if the record's Converter can.
+func (c rejectingConverter) convert(marshal bool) (document bson.D, raw []byte, route string, err error) {
+	// the time waited under the rate limit is not spent converting
+	var throttled time.Duration
+	if timer := c.opts.decoderTimer(); timer != nil {
+		start := time.Now()
+		defer func() { timer.converted(c.index, time.Since(start)-throttled) }()
+	}
+	// every record takes its turn at Dedup, unless streaming fails
+	checked := false
+	if dedup := c.opts.dedup; dedup != nil {
+		defer func() {
+			if err != nil {
+				dedup.abort()
+			} else if !checked {
+				dedup.pass(c.index)
+			}
+		}()
+	}
+	rc, direct := c.Converter.(RawConverter)
+	direct = direct && marshal && c.opts.Transform == nil && c.opts.Route == nil &&
+		len(c.opts.UpsertFields) == 0 && c.opts.dedup == nil
+	if direct {
+		raw, err = rc.ConvertRaw()
+	} else {
+		document, err = c.Converter.Convert()
+	}
+	c.opts.releaseBuffer(c.size)
+	if skipped, ok := err.(skippedRecordError); ok {
+		return nil, nil, "", c.opts.rejectSkipped(c.Converter, skipped.error)
+	}
+	if err == nil && document != nil && c.opts.Transform != nil {
+		document, err = c.opts.Transform(document)
+		if err != nil {
+			err = categorizedError{FailureTransform, fmt.Errorf("transform failed: %v", err)}
+		} else if document == nil {
+			atomic.AddUint64(&c.opts.numDropped, 1)
+		}
+	}
+	if err == nil && document != nil && c.opts.Route != nil {
+		if route, err = c.opts.Route(document); err != nil {
+			err = categorizedError{FailureRoute, fmt.Errorf("route failed: %v", err)}
+		}
+	}
+	if err == nil && document != nil && len(c.opts.UpsertFields) != 0 {
+		if _, err = UpsertKey(c.opts.UpsertFields, document); err != nil {
+			err = categorizedError{FailureUpsertKey, err}
+		}
+	}
+	if err == nil && document != nil && c.opts.dedup != nil {
+		var key dedupKey
+		if key, err = documentKey(c.opts.DedupFields, document); err == nil {
+			// the record has had its turn, even if checking it fails
+			checked = true
+			var duplicate bool
+			if duplicate, err = c.opts.dedup.check(c.index, key); err == nil && duplicate {
+				atomic.AddUint64(&c.opts.numDuplicates, 1)
+				return nil, nil, "", nil
+			}
+		}
+		if err != nil {
+			err = categorizedError{FailureDedup, err}
+		}
+	}
+	if err == nil && document != nil {
+		raw, err = c.sizeDocument(document, marshal)
+	} else if err == nil && raw != nil {
+		err = c.sizeRaw(raw, c.maxDocumentSize(true))
+	}
+	if err == nil && (document != nil || raw != nil) {
+		throttled = c.opts.throttle(c.size)
+	}
+	if err != nil {
+		if !c.opts.toleratesFailures() {
+			c.opts.failureLock.Lock()
+			c.opts.countFailure(err)
+			c.opts.failureLock.Unlock()
+			return nil, nil, "", err
+		}
+		return nil, nil, "", c.opts.convertFailed(c.Converter, err)
+	}
+	if document != nil || raw != nil {
+		atomic.AddInt64(&c.opts.bytesEmitted, c.size)
+		c.opts.countConverted()
+		if len(document) == 0 && len(raw) <= 5 {
+			atomic.AddUint64(&c.opts.numEmpty, 1)
+		}
+		if c.opts.Route != nil {
+			c.opts.countRoute(route)
+		}
+	}
+	return document, raw, route, nil
+}
+
+// sizeDocument checks that document is no larger than MaxDocumentSize once
+// marshalled, and records its size if DocumentSizes is set, returning it
+// marshalled if marshal is set. When streaming raw, the document is only
+// marshalled once, for all of them.
+func (c rejectingConverter) sizeDocument(document bson.D, marshal bool) ([]byte, error) {
+	maxSize := c.maxDocumentSize(marshal)
+	if maxSize < 0 && !marshal && c.opts.sizeRecorder() == nil {
+		return nil, nil
+	}
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling document: %v", err)
+	}
+	if err = c.sizeRaw(raw, maxSize); err != nil || !marshal {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// maxDocumentSize returns the largest size, once marshalled, of a document
+// that may be streamed, as MaxDocumentSize gives it, except that one that is
+// streamed raw, when marshal is set, is never larger than the server's
+// maximum.
+func (c rejectingConverter) maxDocumentSize(marshal bool) int {
+	maxSize := c.opts.MaxDocumentSize
+	if maxSize == 0 || maxSize > db.MaxBSONSize && marshal {
+		maxSize = db.MaxBSONSize
+	}
+	return maxSize
+}
+
+// sizeRaw is sizeDocument for a document that is already marshalled: it
+// checks it against maxSize, or db.MaxBSONSize if that is negative, and
+// records its size.
+func (c rejectingConverter) sizeRaw(raw []byte, maxSize int) error {
+	if maxSize < 0 {
+		maxSize = db.MaxBSONSize
+	}
+	if len(raw) > maxSize {
+		number := atomic.LoadUint64(&c.opts.numSkipped) + c.index + 1
+		return categorizedError{FailureSize, fmt.Errorf("record #%v: document is %v bytes, more than the maximum of %v",
+			number, len(raw), maxSize)}
+	}
+	if sizes := c.opts.sizeRecorder(); sizes != nil {
+		sizes.record(c.index, len(raw))
+	}
+	return nil
+}
+
+// marshalDocument marshals document to BSON, failing if it is larger than
+// the server accepts.
+func marshalDocument(document bson.D) ([]byte, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling document: %v", err)
+	}
+	if len(raw) > db.MaxBSONSize {
+		return nil, categorizedError{FailureSize, fmt.Errorf("document is %v bytes, more than the maximum of %v",
+			len(raw), db.MaxBSONSize)}
+	}
+	return raw, nil
+}
+
+// convertRaw converts c with ConvertRaw if it is a RawConverter, and
+// otherwise marshals the document it converts to.
+func convertRaw(c Converter) ([]byte, error) {
+	if rc, ok := c.(RawConverter); ok {
+		return rc.ConvertRaw()
+	}
+	document, err := c.Convert()
+	if err != nil || document == nil {
+		return nil, err
+	}
+	return marshalDocument(document)
+}
+
+// converterBatch is the Converter for several consecutive records that are
+// handed to the decoding goroutines at once. The goroutines convert it with
+// convert rather than Convert.
+type converterBatch struct {
+	converters []Converter
+
+	// first is the index of the first record in the batch, counting from
+	// zero after any header
+	first uint64
+}
+
+// Convert implements the Converter interface, but a batch can not make a
+// single document.
+func (b converterBatch) Convert() (bson.D, error) {
+	return nil, fmt.Errorf("can not convert a batch of %v records to one document", len(b.converters))
+}
+
+// convert converts each record of the batch in turn into the form that sink
+// takes, returning its documents, nil for each record that was dropped. It
+// stops at the first record that fails, returning the documents converted
+// before it and a BatchRecordError.
+func (b converterBatch) convert(sink documentSink) ([]interface{}, error) {
+	documents := make([]interface{}, 0, len(b.converters))
+	for i, converter := range b.converters {
+		document, err := sink.convert(converter)
+		if err != nil {
+			return documents, BatchRecordError{b.first + uint64(i), err}
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// BatchRecordError is returned when a record fails to convert while streaming
+// with a BatchSize. Its message is that of Err, which for a conversion error
+// already names the record.
+type BatchRecordError struct {
+	// Index is the index of the record that failed, counting from zero after
+	// any header
+	Index uint64
+
+	// Err is the error the record failed with
+	Err error
+}
+
+func (e BatchRecordError) Error() string {
+	return e.Err.Error()
+}
+
+func (e BatchRecordError) Unwrap() error {
+	return e.Err
+}
+
+// recordBatcher groups the Converters that a reader hands to the decoding
+// goroutines into batches of its StreamOptions' BatchSize.
+type recordBatcher struct {
+	size  int
+	batch converterBatch
+}
+
+// newRecordBatcher returns a recordBatcher for the stream.
+func (opts *StreamOptions) newRecordBatcher() *recordBatcher {
+	return &recordBatcher{size: opts.BatchSize}
+}
+
+// add adds c, the Converter for the record at index, returning the Converter
+// to hand over next and true once there is one: c itself if records are not
+// batched, or else the batch once it is full.
+func (b *recordBatcher) add(c Converter, index uint64) (Converter, bool) {
+	if b.size <= 1 {
+		return c, true
+	}
+	if len(b.batch.converters) == 0 {
+		b.batch = converterBatch{make([]Converter, 0, b.size), index}
+	}
+	b.batch.converters = append(b.batch.converters, c)
+	if len(b.batch.converters) < b.size {
+		return nil, false
+	}
+	return b.flush()
+}
+
+// flush returns the batch added to so far and true, if it is not empty, and
+// starts a new one.
+func (b *recordBatcher) flush() (Converter, bool) {
+	if len(b.batch.converters) == 0 {
+		return nil, false
+	}
+	batch := b.batch
+	b.batch = converterBatch{}
+	return batch, true
+}
+
+// An importWorker reads Converter from the unprocessedDataChan channel and
+// sends processed BSON documents on the processedDocumentChan channel
+type importWorker struct {
+	// unprocessedDataChan is used to stream the input data for a worker to process
+	unprocessedDataChan chan Converter
+
+	// used to stream the processed document back to the caller
+	sink documentSink
+
+	// used to synchronise all worker goroutines
+	tomb *tomb.Tomb
+}
+
+// an interface for tracking the number of bytes, which is used in mongoimport to feed
+// the progress bar.
+type sizeTracker interface {
+	Size() int64
+}
+
+// sizeTrackingReader implements Reader and sizeTracker by wrapping an io.Reader and keeping track
+// of the total number of bytes read from each call to Read().
+type sizeTrackingReader struct {
+	bytesRead int64
+	reader    io.Reader
+}
+
+func (str *sizeTrackingReader) Size() int64 {
+	bytes := atomic.LoadInt64(&str.bytesRead)
+	return bytes
+}
+
+func (str *sizeTrackingReader) Read(p []byte) (n int, err error) {
+	n, err = str.reader.Read(p)
+	atomic.AddInt64(&str.bytesRead, int64(n))
+	return
+}
+
+func newSizeTrackingReader(reader io.Reader) *sizeTrackingReader {
+	return &sizeTrackingReader{
+		reader:    reader,
+		bytesRead: 0,
+	}
+}
+
+var (
+	UTF8_BOM = []byte{0xEF, 0xBB, 0xBF}
+)
+
+// bomDiscardingReader implements and wraps io.Reader, discarding the UTF-8 BOM, if applicable.
+// Only a BOM at the very start of the stream is discarded; the same bytes anywhere else
+// are passed through untouched. Readers wrap it around the sizeTrackingReader, rather than
+// the other way round, so that the discarded bytes still count towards the bytes read.
+type bomDiscardingReader struct {
+	buf     *bufio.Reader
+	didRead bool
+}
+
+func (bd *bomDiscardingReader) Read(p []byte) (int, error) {
+	if !bd.didRead {
+		bom, err := bd.buf.Peek(3)
+		if err == nil && bytes.Equal(bom, UTF8_BOM) {
+			bd.buf.Read(make([]byte, 3)) // discard BOM
+		}
+		bd.didRead = true
+	}
+	return bd.buf.Read(p)
+}
+
+func newBomDiscardingReader(r io.Reader) *bomDiscardingReader {
+	return &bomDiscardingReader{buf: bufio.NewReader(r)}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+
+	// ErrTruncatedGzip is returned when gzip-compressed input ends before
+	// the end of the compressed stream.
+	ErrTruncatedGzip = errors.New("gzip-compressed input is truncated")
+)
+
+// decompressingReader implements and wraps io.Reader, transparently decompressing
+// the stream if it begins with the gzip magic bytes and passing it through untouched
+// otherwise. Readers wrap a sizeTrackingReader with it so that Size() reports the
+// compressed bytes consumed, which is what progress against the file size needs.
+type decompressingReader struct {
+	buf    *bufio.Reader
+	reader io.Reader
+	err    error
+}
+
+func (dr *decompressingReader) Read(p []byte) (int, error) {
+	if dr.err != nil {
+		return 0, dr.err
+	}
+	if dr.reader == nil {
+		dr.reader = dr.buf
+		magic, err := dr.buf.Peek(len(gzipMagic))
+		if err == nil && bytes.Equal(magic, gzipMagic) {
+			gz, err := gzip.NewReader(dr.buf)
+			if err != nil {
+				dr.err = gzipError(err)
+				return 0, dr.err
+			}
+			dr.reader = gz
+		}
+	}
+	n, err := dr.reader.Read(p)
+	if _, isGzip := dr.reader.(*gzip.Reader); isGzip {
+		err = gzipError(err)
+	}
+	return n, err
+}
+
+// gzipError reports an unexpected end of a gzip stream as ErrTruncatedGzip.
+func gzipError(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return ErrTruncatedGzip
+	}
+	return err
+}
+
+func newDecompressingReader(r io.Reader) *decompressingReader {
+	return &decompressingReader{buf: bufio.NewReader(r)}
+}
+
+const (
+	// defaultMaxRecordSize is the MaxRecordSize of the line-based readers if
+	// it is not set: the largest document the server accepts.
+	defaultMaxRecordSize = 16 * 1024 * 1024
+
+	// defaultReadBufferSize is the size of the read buffer of the line-based
+	// readers, unless another is given to their constructor.
+	defaultReadBufferSize = 4096
+)
+
+// RecordTooLargeError is returned by the line-based readers for a record
+// longer than their MaxRecordSize, which is not read any further.
+type RecordTooLargeError struct {
+	// Line is the line on which the record starts
+	Line uint64
+
+	// MaxSize is the MaxRecordSize that the record exceeds
+	MaxSize int
+}
+
+func (e RecordTooLargeError) Error() string {
+	return fmt.Sprintf("record too large at line %v: longer than the maximum of %v bytes", e.Line, e.MaxSize)
+}
+
+// errLineTooLong is returned when a line is longer than the bytes it is
+// allowed; the caller knows which record to report in a RecordTooLargeError.
+var errLineTooLong = errors.New("line too long")
+
+// maxRecordSize returns maxSize, or defaultMaxRecordSize if it is not set.
+func maxRecordSize(maxSize int) int {
+	if maxSize <= 0 {
+		return defaultMaxRecordSize
+	}
+	return maxSize
+}
+
+// readBoundedLine reads from r up to and including the next '\n', however
+// long the line is, like ReadBytes. Once the line is longer than maxSize, it
+// stops reading and returns errLineTooLong.
+func readBoundedLine(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxSize {
+			return nil, errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// RecordCountEstimate is an estimate of the number of records in an input,
+// which is likely to lie between Low and High.
+type RecordCountEstimate struct {
+	Records int64
+	Low     int64
+	High    int64
+}
+
+// UnknownRecordCount is the estimate returned for input whose size can not be
+// found, such as standard input, or whose records can not be sampled.
+var UnknownRecordCount = RecordCountEstimate{Records: -1, Low: -1, High: -1}
+
+// estimateRecordCount estimates the number of records from the current
+// offset of source to its end by reading up to sampleSize bytes from there,
+// and then seeking back, so that source can still be imported. The sample is
+// cut after its last complete line and passed to recordLengths, which
+// returns the length of each record in it; the count is the size of the
+// input divided by their mean, and the band is that of two standard errors
+// either side of the mean. Compressed input is not sampled.
+func estimateRecordCount(source io.Reader, sampleSize int, recordLengths func(io.Reader) []int) (RecordCountEstimate, error) {
+	seeker, ok := source.(io.Seeker)
+	if !ok {
+		return UnknownRecordCount, nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// pipes and terminals can not seek
+		return UnknownRecordCount, nil
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return UnknownRecordCount, nil
+	}
+	if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+		return UnknownRecordCount, fmt.Errorf("error seeking back after sizing the input: %v", err)
+	}
+	size := end - start
+	if size == 0 {
+		return RecordCountEstimate{}, nil
+	}
+	if int64(sampleSize) > size {
+		sampleSize = int(size)
+	}
+	sample := make([]byte, sampleSize)
+	n, err := io.ReadFull(source, sample)
+	if _, seekErr := seeker.Seek(start, io.SeekStart); seekErr != nil {
+		return UnknownRecordCount, fmt.Errorf("error seeking back after sampling the input: %v", seekErr)
+	}
+	if err != nil {
+		return UnknownRecordCount, fmt.Errorf("error sampling the input: %v", err)
+	}
+	sample = sample[:n]
+	if bytes.HasPrefix(sample, gzipMagic) {
+		return UnknownRecordCount, nil
+	}
+	if int64(n) == size {
+		// the sample is the whole input, so its count is exact
+		records := int64(len(recordLengths(bytes.NewReader(sample))))
+		return RecordCountEstimate{records, records, records}, nil
+	}
+	cut := bytes.LastIndexByte(sample, '\n')
+	if cut == -1 {
+		return UnknownRecordCount, nil
+	}
+	sample = sample[:cut+1]
+	lengths := recordLengths(bytes.NewReader(sample))
+	if len(lengths) == 0 {
+		return UnknownRecordCount, nil
+	}
+
+	// the lengths are scaled to add up to the sample, as they need not count
+	// every byte of it
+	sum := 0
+	for _, length := range lengths {
+		sum += length
+	}
+	scale := float64(len(sample)) / float64(sum)
+	mean := float64(len(sample)) / float64(len(lengths))
+	var squares float64
+	for _, length := range lengths {
+		deviation := float64(length)*scale - mean
+		squares += deviation * deviation
+	}
+	var stdErr float64
+	if len(lengths) > 1 {
+		stdErr = math.Sqrt(squares/float64(len(lengths)-1)) / math.Sqrt(float64(len(lengths)))
+	}
+	estimate := RecordCountEstimate{
+		Records: int64(math.Floor(float64(size)/mean + 0.5)),
+		Low:     int64(math.Floor(float64(size) / (mean + 2*stdErr))),
+		High:    size,
+	}
+	if mean > 2*stdErr {
+		estimate.High = int64(math.Ceil(float64(size) / (mean - 2*stdErr)))
+	}
+	if sampled := int64(len(lengths)); estimate.Low < sampled {
+		// there are at least as many records as were sampled
+		estimate.Low = sampled
+	}
+	return estimate, nil
+}
+
+const (
+	// maxErrorLineLength is the number of characters of an offending line
+	// quoted in error messages.
+	maxErrorLineLength = 80
+
+	// maxErrorValueLength is the number of characters of an offending value
+	// quoted in a FieldConversionError.
+	maxErrorValueLength = 100
+)
+
+// truncateLine returns line without surrounding whitespace, cut down to at
+// most maxErrorLineLength characters for quoting in error messages.
+func truncateLine(line []byte) string {
+	runes := []rune(string(bytes.TrimSpace(line)))
+	if len(runes) <= maxErrorLineLength {
+		return string(runes)
+	}
+	return string(runes[:maxErrorLineLength]) + "..."
+}
+
+// recordError annotates err, which occurred while converting the given
+// 1-based record that starts on the given 1-based physical input line, with
+// both numbers and a truncated copy of the record. The error keeps the
+// FailureCategory of err.
+func recordError(number, line uint64, record string, err error) error {
+	return keepCategory(err, recordFailure{number, line, truncateLine([]byte(record)), true, err})
+}
+
+// recordError is recordError for a record converted with opts, which leaves
+// the record out if opts anonymize any column, so that its values are not
+// quoted.
+func (opts *ConvertOptions) recordError(number, line uint64, record string, err error) error {
+	if opts.quotesRecords() {
+		return recordError(number, line, record, err)
+	}
+	return keepCategory(err, recordFailure{number, line, "", false, err})
+}
+
+// recordFailure is err, annotated by recordError with the number and line of
+// its record, and the record itself if it is quoted.
+type recordFailure struct {
+	number, line uint64
+	record       string
+	quoted       bool
+	err          error
+}
+
+func (e recordFailure) Error() string {
+	if e.quoted {
+		return fmt.Sprintf("record #%v (line %v): %v: %s", e.number, e.line, e.err, e.record)
+	}
+	return fmt.Sprintf("record #%v (line %v): %v", e.number, e.line, e.err)
+}
+
+func (e recordFailure) Unwrap() error {
+	return e.err
+}
+
+// unwrapError returns the error that err wraps, if it has an Unwrap method,
+// and otherwise nil.
+func unwrapError(err error) error {
+	if wrapper, ok := err.(interface {
+		Unwrap() error
+	}); ok {
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
+// quotesRecords returns whether records converted with opts, which may be
+// nil, may be quoted in errors and logs: whether no column is anonymized.
+func (opts *ConvertOptions) quotesRecords() bool {
+	return opts == nil || len(opts.AnonymizeFields) == 0
+}
+
+// keepCategory returns annotated, an error that wraps err, with the
+// FailureCategory of err, or as skipped if err was.
+func keepCategory(err, annotated error) error {
+	switch e := err.(type) {
+	case categorizedError:
+		return categorizedError{e.category, annotated}
+	case skippedRecordError:
+		return skippedRecordError{annotated}
+	}
+	return annotated
+}
+
+// generatedFieldCount returns the number of columns that GenerateFields
+// gives a first record of tokens: one for each, but for an empty last token
+// if AllowTrailingDelimiter is set.
+func (opts *ConvertOptions) generatedFieldCount(tokens []string) int {
+	if opts.AllowTrailingDelimiter && len(tokens) > 1 && tokens[len(tokens)-1] == "" {
+		return len(tokens) - 1
+	}
+	return len(tokens)
+}
+
+// generatedColumns returns the columns that GenerateFields gives a first
+// record of numTokens tokens: field0, field1, and so on. They are the names
+// that tokensToBSON gives the tokens of a record beyond its columns, so a
+// longer record's extra tokens continue the sequence.
+func generatedColumns(numTokens int) []ColumnSpec {
+	names := make([]string, numTokens)
+	for i := range names {
+		names[i] = "field" + strconv.Itoa(i)
+	}
+	return ParseAutoHeaders(names)
+}
+
+// FailureCategory is the kind of problem that made a record fail to convert.
+type FailureCategory string
+
+// The categories of conversion failures counted by Failures.
+const (
+	// FailureType is a value that could not be parsed as its field's type.
+	FailureType FailureCategory = "type"
+
+	// FailureRaggedRow is a row with more or fewer fields than the columns
+	// that its reader's policy allows.
+	FailureRaggedRow FailureCategory = "ragged row"
+
+	// FailureJSON is a JSON document that is malformed or has invalid
+	// extended JSON.
+	FailureJSON FailureCategory = "json"
+
+	// FailureID is a record whose _id can not be built from its IDFields.
+	FailureID FailureCategory = "_id"
+
+	// FailureUpsertKey is a document that lacks one of the UpsertFields.
+	FailureUpsertKey FailureCategory = "upsert key"
+
+	// FailureTransform is a document that the Transform failed on.
+	FailureTransform FailureCategory = "transform"
+
+	// FailureEncoding is a string value that is not valid UTF-8.
+	FailureEncoding FailureCategory = "encoding"
+
+	// FailureRoute is a document that the Route failed on.
+	FailureRoute FailureCategory = "route"
+
+	// FailureDedup is a document that lacks one of the DedupFields, or that
+	// could not be checked for duplicates once DedupMaxMemory was reached.
+	FailureDedup FailureCategory = "dedup"
+
+	// FailureSize is a document that, once marshalled to BSON, is larger than
+	// MaxDocumentSize, or than the server accepts.
+	FailureSize FailureCategory = "size"
+
+	// FailureOther is any other failure.
+	FailureOther FailureCategory = "other"
+)
+
+// categorizedError is a conversion error of a known FailureCategory.
+type categorizedError struct {
+	category FailureCategory
+	error
+}
+
+func (e categorizedError) Unwrap() error {
+	return e.error
+}
+
+// ErrorCategory returns the FailureCategory of a conversion failure, looking
+// through the errors that wrap the failures of records, batches and files,
+// or FailureOther if err is not of a known category.
+func ErrorCategory(err error) FailureCategory {
+	for ; err != nil; err = unwrapError(err) {
+		if categorized, ok := err.(categorizedError); ok {
+			return categorized.category
+		}
+	}
+	return FailureOther
+}
+
+// AsFieldConversionError returns the FieldConversionError that a conversion
+// failure wraps, looking through the same errors as ErrorCategory, and
+// whether there is one.
+func AsFieldConversionError(err error) (FieldConversionError, bool) {
+	for ; err != nil; err = unwrapError(err) {
+		if conversionErr, ok := err.(FieldConversionError); ok {
+			return conversionErr, true
+		}
+	}
+	return FieldConversionError{}, false
+}
+
+// FieldConversionError is the failure of a field's value to parse as the
+// type of its column. Conversion errors wrap it, so AsFieldConversionError
+// finds it.
+type FieldConversionError struct {
+	// Field is the name of the field, and Column its 1-based column
+	Field  string
+	Column int
+
+	// Value is the value that failed to parse, cut down to at most
+	// maxErrorValueLength characters, and Type the name of the column's type
+	Value string
+	Type  string
+
+	// Err is the error that the parser returned
+	Err error
+}
+
+func (e FieldConversionError) Error() string {
+	return fmt.Sprintf("field '%v' (column %v): cannot parse '%v' as %v: %v", e.Field, e.Column, e.Value, e.Type, e.Err)
+}
+
+func (e FieldConversionError) Unwrap() error {
+	return e.Err
+}
+
+// truncateValue returns value cut down to at most maxErrorValueLength
+// characters for quoting in a FieldConversionError.
+func truncateValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= maxErrorValueLength {
+		return value
+	}
+	return string(runes[:maxErrorValueLength]) + "..."
+}
+
+// skipLinesError describes a failure to skip the numSkipped+1th of the
+// numToSkip leading lines of an input source.
+func skipLinesError(numSkipped, numToSkip int, err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("input ended after %v of the %v lines to skip", numSkipped, numToSkip)
+	}
+	return fmt.Errorf("error skipping line %v: %v", numSkipped+1, err)
+}
+
+// channelQuorumError takes a channel and a quorum - which specifies how many
+// messages to receive on that channel before returning. It either returns the
+// first non-nil error received on the channel or nil if up to `quorum` nil
+// errors are received
+func channelQuorumError(ch <-chan error, quorum int) (err error) {
+	for i := 0; i < quorum; i++ {
+		if err = <-ch; err != nil {
+			return
+		}
+	}
+	return
+}
+
+// UpsertKey returns the selector that identifies document for an upsert:
+// the value of each of the upsertFields, given in dot notation. It returns an
+// error naming the first field that document lacks.
+func UpsertKey(upsertFields []string, document bson.D) (bson.D, error) {
+	key := make(bson.D, 0, len(upsertFields))
+	for _, field := range upsertFields {
+		value, ok := findUpsertValue(field, document)
+		if !ok {
+			return nil, fmt.Errorf("upsert field '%v' is missing", field)
+		}
+		key = append(key, bson.DocElem{Name: field, Value: value})
+	}
+	return key, nil
+}
+
+// constructUpsertDocument constructs a BSON document to use for upserts
+func constructUpsertDocument(upsertFields []string, document bson.D) bson.D {
+	upsertDocument := bson.D{}
+	var hasDocumentKey bool
+	for _, key := range upsertFields {
+		val := getUpsertValue(key, document)
+		if val != nil {
+			hasDocumentKey = true
+		}
+		upsertDocument = append(upsertDocument, bson.DocElem{Name: key, Value: val})
+	}
+	if !hasDocumentKey {
+		return nil
+	}
+	return upsertDocument
+}
+
+// mergeUpdate returns the fields that a merge of document sets, for use with
+// $set: each field that is not a subdocument, in dot notation, so that the
+// fields already in a subdocument are kept. The upsertFields, and the fields
+// nested under them, are left out, as they are matched rather than set. It
+// returns nil if nothing is left.
+func mergeUpdate(upsertFields []string, document bson.D) bson.D {
+	var update bson.D
+	var flatten func(prefix string, document bson.D)
+	flatten = func(prefix string, document bson.D) {
+	elems:
+		for _, elem := range document {
+			name := prefix + elem.Name
+			for _, field := range upsertFields {
+				if fieldContains(field, name) {
+					continue elems
+				}
+			}
+			if subDoc, ok := elem.Value.(*bson.D); ok && subDoc != nil {
+				elem.Value = *subDoc
+			}
+			if subDoc, ok := elem.Value.(bson.D); ok && len(subDoc) != 0 {
+				flatten(name+".", subDoc)
+			} else {
+				update = append(update, bson.DocElem{Name: name, Value: elem.Value})
+			}
+		}
+	}
+	flatten("", document)
+	return update
+}
+
+// sequencedConverter is a Converter numbered in the order it was read, so
+// that its documents can be streamed in that order however long it takes to
+// convert. Records are read in index order, so the numbers follow the
+// records' indices, but count a batch only once.
+type sequencedConverter struct {
+	Converter
+	seq uint64
+}
+
+// sequencedDocuments are the documents converted from the sequencedConverter
+// with the same seq, in the form that the documentSink takes them: document
+// for a single record, or documents for a batch, nil for each record that was
+// dropped. If failed is set, a record of the batch failed to convert after
+// them, so nothing read later may be streamed.
+type sequencedDocuments struct {
+	seq       uint64
+	document  interface{}
+	documents []interface{}
+	failed    bool
+}
+
+// documentSink is where streaming sends the documents it converts, in the
+// form that it takes them: a bson.D for StreamDocument, a bson.M for
+// StreamMaps, BSON for StreamRaw and a RoutedDocument for StreamRouted. The
+// documents are put in that form by the decoding goroutines that convert
+// them.
+type documentSink interface {
+	// convert converts the record of c, returning nil if it was dropped
+	convert(c Converter) (interface{}, error)
+
+	// send sends a converted document on, returning false if dying or done
+	// is closed first; a document is still sent if the output has room for
+	// it, so those converted before a failure are passed on
+	send(document interface{}, dying, done <-chan struct{}) bool
+
+	// close closes the output once streaming is done
+	close()
+}
+
+// sinkStreamer is implemented by the readers that can stream their documents
+// to any documentSink, converting them on their own decoding goroutines.
+type sinkStreamer interface {
+	streamTo(ordered bool, sink documentSink) error
+}
+
+// docSink is the documentSink of StreamDocument.
+type docSink chan bson.D
+
+func (s docSink) convert(c Converter) (interface{}, error) {
+	document, err := c.Convert()
+	if err != nil || document == nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (s docSink) send(document interface{}, dying, done <-chan struct{}) bool {
+	select {
+	case s <- document.(bson.D):
+		return true
+	default:
+	}
+	select {
+	case s <- document.(bson.D):
+		return true
+	case <-dying:
+	case <-done:
+	}
+	return false
+}
+
+func (s docSink) close() {
+	close(s)
+}
+
+// mapSink is the documentSink of StreamMaps.
+type mapSink chan bson.M
+
+func (s mapSink) convert(c Converter) (interface{}, error) {
+	document, err := c.Convert()
+	if err != nil || document == nil {
+		return nil, err
+	}
+	return DocumentMap(document), nil
+}
+
+func (s mapSink) send(document interface{}, dying, done <-chan struct{}) bool {
+	select {
+	case s <- document.(bson.M):
+		return true
+	default:
+	}
+	select {
+	case s <- document.(bson.M):
+		return true
+	case <-dying:
+	case <-done:
+	}
+	return false
+}
+
+func (s mapSink) close() {
+	close(s)
+}
+
+// rawSink is the documentSink of StreamRaw.
+type rawSink chan []byte
+
+func (s rawSink) convert(c Converter) (interface{}, error) {
+	document, err := convertRaw(c)
+	if err != nil || document == nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (s rawSink) send(document interface{}, dying, done <-chan struct{}) bool {
+	select {
+	case s <- document.([]byte):
+		return true
+	default:
+	}
+	select {
+	case s <- document.([]byte):
+		return true
+	case <-dying:
+	case <-done:
+	}
+	return false
+}
+
+func (s rawSink) close() {
+	close(s)
+}
+
+// DocumentMap returns document as a bson.M, along with each subdocument
+// within it, including those in arrays. Fields keep their values but lose
+// their order, and if a name appears more than once, as it may after a
+// header names two columns alike, the last of its values is kept.
+func DocumentMap(document bson.D) bson.M {
+	m := make(bson.M, len(document))
+	for _, elem := range document {
+		m[elem.Name] = mapValue(elem.Value)
+	}
+	return m
+}
+
+// mapValue returns value with any documents within it converted by
+// DocumentMap.
+func mapValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		return DocumentMap(v)
+	case *bson.D:
+		if v == nil {
+			return nil
+		}
+		return DocumentMap(*v)
+	case []interface{}:
+		elements := make([]interface{}, len(v))
+		for i, element := range v {
+			elements[i] = mapValue(element)
+		}
+		return elements
+	}
+	return value
+}
+
+// StreamMaps is like r.StreamDocument, but streams each document to read as
+// a bson.M, converted by DocumentMap, rather than as a bson.D. The order of
+// the documents, the limits on conversion failures and the errors returned
+// are the same as StreamDocument's, and read is likewise closed once
+// streaming is done. The documents are converted on the reader's decoding
+// goroutines; a reader without them of its own, such as a
+// MultiFileInputReader, has them converted as they are passed on.
+func StreamMaps(r InputReader, ordered bool, read chan bson.M) error {
+	if streamer, ok := r.(sinkStreamer); ok {
+		return streamer.streamTo(ordered, mapSink(read))
+	}
+	docs := make(chan bson.D, workerBufferSize)
+	go func() {
+		defer close(read)
+		for document := range docs {
+			read <- DocumentMap(document)
+		}
+	}()
+	return r.StreamDocument(ordered, docs)
+}
+
+// StreamRaw is like r.StreamDocument, but streams each document to read
+// marshalled to BSON, by the reader's decoding goroutines, ready to be
+// inserted as is. A document larger than the reader's MaxDocumentSize, or
+// than the server accepts, fails to convert, in the FailureSize category,
+// and is handled as any other conversion failure. The order of the documents and the errors returned are
+// otherwise the same as StreamDocument's, and read is likewise closed once
+// streaming is done. A reader without decoding goroutines of its own, such
+// as a MultiFileInputReader, has its documents marshalled as they are passed
+// on, and fails streaming at the first that is too large. Each is a whole
+// BSON document, so a client of any driver can insert it, as raw BSON,
+// without unmarshalling it first.
+func StreamRaw(r InputReader, ordered bool, read chan []byte) error {
+	if streamer, ok := r.(sinkStreamer); ok {
+		return streamer.streamTo(ordered, rawSink(read))
+	}
+	docs := make(chan bson.D, workerBufferSize)
+	// buffered so neither goroutine blocks once the other's error is returned
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- r.StreamDocument(ordered, docs)
+	}()
+	go func() {
+		defer close(read)
+		for document := range docs {
+			raw, err := marshalDocument(document)
+			if err != nil {
+				errChan <- err
+				// the reader is left to finish into the buffer
+				go func() {
+					for range docs {
+					}
+				}()
+				return
+			}
+			read <- raw
+		}
+		errChan <- nil
+	}()
+	return channelQuorumError(errChan, 2)
+}
+
+// doOrderedStreaming converts the Converters read from readDocs on
+// numDecoders goroutines and sends their documents to outputChan in the order
+// they were read. Each decoder takes the next Converter as soon as it is free,
+// so one slow record holds up only the streaming of those after it, not their
+// conversion: converted documents wait in a reorder buffer of up to window
+// Converters until all those before them have been streamed. Once the buffer
+// is full, no more is read from readDocs. It returns once every decoder has
+// stopped, which it does when readDocs is closed or t is dying; a decoder's
+// conversion failure kills t.
+func doOrderedStreaming(ctx context.Context, t *tomb.Tomb, numDecoders, window int, readDocs chan Converter, sink documentSink) {
+	// a slot is taken for each Converter handed to the decoders, and given
+	// back once its documents are streamed
+	slots := make(chan struct{}, window)
+	sequenced := make(chan sequencedConverter, numDecoders)
+	// as many results as there are slots, so the decoders never block on it
+	converted := make(chan sequencedDocuments, window)
+
+	go func() {
+		defer close(sequenced)
+		var seq uint64
+		for c := range readDocs {
+			select {
+			case slots <- struct{}{}:
+			case <-t.Dying():
+				return
+			}
+			select {
+			case sequenced <- sequencedConverter{c, seq}:
+			case <-t.Dying():
+				return
+			}
+			seq++
+		}
+	}()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < numDecoders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the tomb keeps only the first decoder error and causes sibling
+			// goroutines to terminate immediately
+			if err := decodeSequenced(t, sequenced, converted, sink); err != nil {
+				t.Kill(err)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(converted)
+	}()
+
+	// the buffer is indexed by sequence number modulo the window, which is
+	// never more than window ahead of the next to stream
+	buffer := make([]sequencedDocuments, window)
+	ready := make([]bool, window)
+	var next uint64
+	stopped := false
+	for result := range converted {
+		if stopped {
+			continue
+		}
+		buffer[result.seq%uint64(window)] = result
+		ready[result.seq%uint64(window)] = true
+		for !stopped && ready[next%uint64(window)] {
+			i := next % uint64(window)
+			result := buffer[i]
+			buffer[i], ready[i] = sequencedDocuments{}, false
+			emit(ctx, t, sink, result.document)
+			for _, document := range result.documents {
+				emit(ctx, t, sink, document)
+			}
+			stopped = result.failed
+			next++
+			<-slots
+		}
+	}
+}
+
+// emit sends document, unless it is nil, to sink. Once t is dying,
+// documents converted before the failure are still passed on if the output
+// has room for them, but an abandoned output must not hold up the return.
+func emit(ctx context.Context, t *tomb.Tomb, sink documentSink, document interface{}) {
+	if document != nil {
+		sink.send(document, t.Dying(), ctx.Done())
+	}
+}
+
+// decodeSequenced converts the sequencedConverters read from in, sending
+// their documents to out, in the form that sink takes them, until in is
+// closed or t is dying. It returns the error of the first record that fails
+// to convert.
+func decodeSequenced(t *tomb.Tomb, in <-chan sequencedConverter, out chan<- sequencedDocuments, sink documentSink) error {
+	for {
+		select {
+		case c, alive := <-in:
+			if !alive {
+				return nil
+			}
+			result := sequencedDocuments{seq: c.seq}
+			var err error
+			if batch, ok := c.Converter.(converterBatch); ok {
+				result.documents, err = batch.convert(sink)
+				// the documents of the batch before a failure may still be
+				// streamed
+				result.failed = err != nil
+			} else if result.document, err = sink.convert(c.Converter); err != nil {
+				return err
+			}
+			out <- result
+			if err != nil {
+				return err
+			}
+		case <-t.Dying():
+			return nil
+		}
+	}
+}
+
+// getUpsertValue takes a given BSON document and a given field, and returns the
+// field's associated value in the document. The field is specified using dot
+// notation for nested fields. e.g. "person.age" would return 34 would return
+// 34 in the document: bson.M{"person": bson.M{"age": 34}} whereas,
+// "person.name" would return nil
+func getUpsertValue(field string, document bson.D) interface{} {
+	val, _ := findUpsertValue(field, document)
+	return val
+}
+
+// findUpsertValue is like getUpsertValue, but also reports whether the field
+// is in the document at all, so a missing field can be told from a null one.
+// Subdocuments may be either bson.D or *bson.D values, as the CSV and TSV
+// readers build them.
+func findUpsertValue(field string, document bson.D) (interface{}, bool) {
+	index := strings.Index(field, ".")
+	if index == -1 {
+		val, err := bsonutil.FindValueByKey(field, &document)
+		return val, err == nil
+	}
+	// recurse into subdocuments
+	left := field[0:index]
+	subDoc, _ := bsonutil.FindValueByKey(left, &document)
+	switch subDocD := subDoc.(type) {
+	case bson.D:
+		return findUpsertValue(field[index+1:], subDocD)
+	case *bson.D:
+		return findUpsertValue(field[index+1:], *subDocD)
+	}
+	return nil, false
+}
+
+// filterIngestError accepts a boolean indicating if a non-nil error should be,
+// returned as an actual error.
+//
+// If the error indicates an unreachable server, it returns that immediately.
+//
+// If the error indicates an invalid write concern was passed, it returns nil
+//
+// If the error is not nil, it logs the error. If the error is an io.EOF error -
+// indicating a lost connection to the server, it sets the error as such.
+//
+func filterIngestError(stopOnError bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	if err.Error() == io.EOF.Error() {
+		return fmt.Errorf(db.ErrLostConnection)
+	}
+	if stopOnError || db.IsConnectionError(err) {
+		return err
+	}
+	log.Logvf(log.Always, "error inserting documents: %v", err)
+	return nil
+}
+
+// removeBlankFields takes document and returns a new copy in which
+// fields with empty/blank values are removed
+func removeBlankFields(document bson.D) (newDocument bson.D) {
+	for _, keyVal := range document {
+		if val, ok := keyVal.Value.(*bson.D); ok {
+			keyVal.Value = removeBlankFields(*val)
+		}
+		if val, ok := keyVal.Value.(string); ok && val == "" {
+			continue
+		}
+		if val, ok := keyVal.Value.(bson.D); ok && val == nil {
+			continue
+		}
+		newDocument = append(newDocument, keyVal)
+	}
+	return newDocument
+}
+
+// setNestedValue takes a nested field - in the form "a.b.c" -
+// its associated value, and a document. It then assigns that
+// value to the appropriate nested field within the document
+func setNestedValue(key string, value interface{}, document *bson.D) {
+	index := strings.Index(key, ".")
+	if index == -1 {
+		*document = append(*document, bson.DocElem{Name: key, Value: value})
+		return
+	}
+	keyName := key[0:index]
+	subDocument := &bson.D{}
+	elem, err := bsonutil.FindValueByKey(keyName, document)
+	if err != nil { // no such key in the document
+		elem = nil
+	}
+	var existingKey bool
+	if elem != nil {
+		subDocument = elem.(*bson.D)
+		existingKey = true
+	}
+	setNestedValue(key[index+1:], value, subDocument)
+	if !existingKey {
+		*document = append(*document, bson.DocElem{Name: keyName, Value: subDocument})
+	}
+}
+
+// streamDocuments concurrently processes data gotten from the inputChan
+// channel in parallel and then sends over the processed data to the outputChan
+// channel - either in sequence or concurrently (depending on the value of
+// ordered) - in which the data was received. In ordered mode, up to window
+// Converters are converted ahead of the next to be streamed; zero picks a
+// window of workerBufferSize for each decoder.
+func streamDocuments(ordered bool, numDecoders, window int, readDocs chan Converter, outputChan chan bson.D) (retErr error) {
+	return streamDocumentsContext(context.Background(), ordered, numDecoders, window, readDocs, outputChan)
+}
+
+// streamDocumentsContext is like streamDocuments, but also stops the workers
+// once ctx is done, returning ctx.Err() if no worker failed first.
+func streamDocumentsContext(ctx context.Context, ordered bool, numDecoders, window int, readDocs chan Converter, outputChan chan bson.D) error {
+	return streamDocumentsTo(ctx, ordered, numDecoders, window, readDocs, docSink(outputChan))
+}
+
+// streamDocumentsTo is like streamDocumentsContext, but sends the documents to
+// sink, which it closes once it is done.
+func streamDocumentsTo(ctx context.Context, ordered bool, numDecoders, window int, readDocs chan Converter, sink documentSink) error {
+	if numDecoders == 0 {
+		numDecoders = 1
+	}
+	if window <= 0 {
+		window = numDecoders * workerBufferSize
+	}
+	wg := new(sync.WaitGroup)
+	importTomb := new(tomb.Tomb)
+	workersDone := make(chan struct{})
+	defer close(workersDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			importTomb.Kill(ctx.Err())
+		case <-workersDone:
+		}
+	}()
+	// if ordered, we have to coordinate the sequence in which processed
+	// documents are passed to the main read channel
+	if ordered {
+		doOrderedStreaming(ctx, importTomb, numDecoders, window, readDocs, sink)
+	} else {
+		for i := 0; i < numDecoders; i++ {
+			iw := &importWorker{
+				unprocessedDataChan: readDocs,
+				sink:                sink,
+				tomb:                importTomb,
+			}
+			wg.Add(1)
+			go func(iw importWorker) {
+				defer wg.Done()
+				// the tomb keeps only the first worker error and causes sibling
+				// goroutines to terminate immediately
+				if err := iw.processDocuments(); err != nil {
+					iw.tomb.Kill(err)
+				}
+			}(*iw)
+		}
+	}
+	wg.Wait()
+	sink.close()
+	if err := importTomb.Err(); err != tomb.ErrStillAlive {
+		return err
+	}
+	return ctx.Err()
+}
+
+// ConvertOptions controls how the tokens of a CSV or TSV record are converted
+// into a BSON document. It is embedded in the CSV and TSV input readers, so its
+// fields can be changed before the first call to ReadAndValidateHeader or
+// StreamDocument; each reader keeps its own copy.
+type ConvertOptions struct {
+	// IgnoreBlanks omits empty tokens from the converted document. The check
+	// happens before type parsing, so an empty cell in a typed column is left
+	// out rather than parsed. Tokens made up only of whitespace are not empty.
+	IgnoreBlanks bool
+
+	// TrimWhitespace trims surrounding whitespace from each token before the
+	// IgnoreBlanks check and type parsing, so a token of only whitespace
+	// counts as blank. Whitespace within a token is kept.
+	TrimWhitespace bool
+
+	// NullTokens are the tokens that stand for a missing value. A matching
+	// token is converted to null whatever the type of its column, or is
+	// omitted if IgnoreBlanks is set. Matching is case-sensitive unless
+	// NullTokensIgnoreCase is set.
+	NullTokens           []string
+	NullTokensIgnoreCase bool
+
+	// TrueTokens and FalseTokens are the tokens that boolean columns accept
+	// as true and false; either defaults to the values accepted by
+	// FieldBooleanParser if empty. Matching ignores case unless
+	// BooleanCaseSensitive is set.
+	TrueTokens           []string
+	FalseTokens          []string
+	BooleanCaseSensitive bool
+
+	// DecimalSeparator and ThousandsSeparator are the separators used by
+	// the tokens of int32, int64, double, decimal and percent columns; if
+	// either is set, tokens are rewritten to the form strconv parses before
+	// they are parsed. Thousands separators must separate groups of three digits in
+	// the integer part. Other columns are not affected.
+	DecimalSeparator   string
+	ThousandsSeparator string
+
+	// CurrencySymbols, if set, are the currency symbols that the tokens of
+	// money columns, those of type double(money) or decimal(money), may
+	// start or end with, in place of the DefaultCurrencySymbols. They are
+	// tried in order, so a symbol must come before any that it ends with,
+	// as "R$" must come before "$".
+	CurrencySymbols []string
+
+	// StringsOnly disables automatic type inference: tokens in columns
+	// without an explicit type annotation, and tokens beyond the known
+	// columns, are stored as strings.
+	StringsOnly bool
+
+	// BigIntegersAsDecimal infers a decimal128, rather than a double that
+	// rounds them, for integers beyond the int64 range in columns without a
+	// type annotation.
+	BigIntegersAsDecimal bool
+
+	// FlatFields stores column names containing '.' as literal top-level
+	// keys instead of building nested subdocuments from them.
+	FlatFields bool
+
+	// ShortRows controls records with fewer tokens than there are columns:
+	// RaggedError, RaggedPadWithNull, RaggedPadWithEmpty or
+	// RaggedPadWithMissing. Only the trailing columns that a row stops short
+	// of are missing; a row that ends in empty tokens has them all.
+	ShortRows RaggedRowPolicy
+
+	// LongRows controls records with more tokens than there are columns:
+	// RaggedError, RaggedTruncateExtra or RaggedCollectExtraIntoField.
+	// Trailing empty tokens count towards the length of a row.
+	LongRows RaggedRowPolicy
+
+	// AllowTrailingDelimiter drops the last token of a record if it is empty
+	// and the record has exactly one token more than there are columns, as
+	// it would if every line ended in a delimiter. ShortRows and LongRows
+	// apply to the remaining tokens, and records with other counts are left
+	// alone. GenerateFields leaves out an empty last token in the same way.
+	AllowTrailingDelimiter bool
+
+	// TrimFieldNames, LowercaseFieldNames and UnderscoreFieldNames normalize
+	// column names before they are sanitized and validated: surrounding
+	// whitespace is trimmed, letters are lowercased, and runs of whitespace
+	// are replaced by a single underscore, respectively.
+	TrimFieldNames       bool
+	LowercaseFieldNames  bool
+	UnderscoreFieldNames bool
+
+	// SanitizeFields replaces the parts of column names that make them
+	// illegal field names with FieldSubstitute, instead of failing header
+	// validation. Each substitution is logged.
+	SanitizeFields bool
+
+	// FieldSubstitute is the replacement used by SanitizeFields; "_" if empty.
+	FieldSubstitute string
+
+	// RenameFields maps column names, as they appear in the input, to the
+	// field names that documents are given instead. Renamed names are not
+	// normalized, but are sanitized and validated like any other, so a name
+	// containing '.' builds a subdocument. Every column renamed must be in
+	// the header, and no two may be given the same name.
+	RenameFields map[string]string
+
+	// ProjectFields, if set, restricts documents to the named fields, which
+	// are checked against the columns when the header is validated. A field
+	// selects the column of that name and the columns nested under it, so
+	// "address" selects "address.city". Every token of a record is still
+	// split, but only the selected columns are parsed.
+	ProjectFields []string
+
+	// ExcludeFields, if set, leaves the named fields out of documents. Like
+	// ProjectFields, they are checked against the columns when the header is
+	// validated, and a field also excludes the columns nested under it, so
+	// "address" drops the whole subdocument. A field may not be both
+	// projected and excluded.
+	ExcludeFields []string
+
+	// IDFields, if set, are the fields whose values are combined, in order,
+	// into the _id of each document: joined into a string with IDSeparator
+	// if it is set, or else assembled into a subdocument of the fields. Each
+	// must name a column, and a record with no value or a blank one for any
+	// of them is an error, whether or not IgnoreBlanks is set. The string
+	// form joins the tokens as they appear in the input.
+	IDFields    []string
+	IDSeparator string
+
+	// KeepIDFields keeps the IDFields as fields of their own, in addition to
+	// their values in the _id.
+	KeepIDFields bool
+
+	// TimeZone is the zone of the dates of date, date_go, date_ms and
+	// date_oracle columns that carry no offset of their own; UTC if nil.
+	// ColumnTimeZones maps the names of date columns, as documents are given
+	// them, to zones that override it for those columns. A date with an
+	// offset keeps it, whatever the zone. DSTGap controls wall times that a
+	// zone skips when its clocks go forward; a wall time that happens twice,
+	// as they go back, is the earlier of the two.
+	TimeZone        *time.Location
+	ColumnTimeZones map[string]*time.Location
+	DSTGap          DSTGapPolicy
+
+	// InvalidUTF8 controls values converted to strings that are not valid
+	// UTF-8. Values of other types, such as binary, are not checked.
+	InvalidUTF8 InvalidUTF8Policy
+
+	// AnonymizeFields maps the names of string and auto columns, as
+	// documents are given them, to the anonymizers that replace their values
+	// once they are parsed, so that they are not imported. Each must name a
+	// column when the header is validated. A null value stays null, and a
+	// string _id is built from the anonymized values. Records are not quoted
+	// in errors and logs, nor skipped rows printed, if any column is
+	// anonymized, but those that fail to convert are still written to
+	// Rejects as they were read.
+	AnonymizeFields map[string]FieldAnonymizer
+
+	// utf8Replacements, if set, counts the runs of invalid UTF-8 that
+	// InvalidUTF8Replace replaces. The reader points it at the count in its
+	// StreamOptions as it starts streaming.
+	utf8Replacements *uint64
+
+	// selected records, for each column, whether it is converted; nil if
+	// every column is. It is set by selectColumns.
+	selected []bool
+
+	// unmatchedRenames are the RenameFields keys that the last call to
+	// prepareColumnNames found no column for.
+	unmatchedRenames []string
+
+	// idColumns is the index of the column of each of the IDFields. It is
+	// set by selectColumns.
+	idColumns []int
+
+	// timeZones is the zone of each column, or nil if every column is in
+	// TimeZone. It is set by selectColumns.
+	timeZones []*time.Location
+
+	// anonymizers is the anonymizer of each column, or nil if no column is
+	// anonymized. It is set by selectColumns.
+	anonymizers []*FieldAnonymizer
+}
+
+// validateColumns checks the renames of the columns, validates their names as
+// validateReaderFields does, selects the columns to convert, and then checks
+// that their defaults parse, so that an invalid default is reported before
+// any record is read.
+func (opts *ConvertOptions) validateColumns(colSpecs []ColumnSpec) error {
+	if err := opts.checkRenames(); err != nil {
+		return err
+	}
+	if err := validateReaderFields(ColumnNames(colSpecs), opts.FlatFields); err != nil {
+		return err
+	}
+	if err := opts.selectColumns(colSpecs); err != nil {
+		return err
+	}
+	for index, colSpec := range colSpecs {
+		if dp, ok := colSpec.Parser.(*FieldDefaultParser); ok {
+			if _, err := opts.parseDefault(colSpec, dp, index); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRenames checks that the RenameFields, as applied by the last call to
+// prepareColumnNames, each renamed a column, and to a name of its own.
+func (opts *ConvertOptions) checkRenames() error {
+	if len(opts.unmatchedRenames) != 0 {
+		return fmt.Errorf("renamed field '%v' is not in the header", opts.unmatchedRenames[0])
+	}
+	sources := make(map[string]string, len(opts.RenameFields))
+	for source, name := range opts.RenameFields {
+		if other, ok := sources[name]; ok {
+			if other > source {
+				other, source = source, other
+			}
+			return fmt.Errorf("fields '%v' and '%v' are both renamed to '%v'", other, source, name)
+		}
+		sources[name] = source
+	}
+	return nil
+}
+
+// selectColumns checks the ProjectFields, ExcludeFields and IDFields against
+// the columns, and records which columns are converted and make up the _id.
+func (opts *ConvertOptions) selectColumns(colSpecs []ColumnSpec) error {
+	if err := opts.markColumns(colSpecs); err != nil {
+		return err
+	}
+	if err := opts.findTimeZoneColumns(colSpecs); err != nil {
+		return err
+	}
+	if err := opts.findAnonymizedColumns(colSpecs); err != nil {
+		return err
+	}
+	return opts.findIDColumns(colSpecs)
+}
+
+// findTimeZoneColumns checks that each of the ColumnTimeZones names a date
+// column, and records the zone of every column.
+func (opts *ConvertOptions) findTimeZoneColumns(colSpecs []ColumnSpec) error {
+	opts.timeZones = nil
+	if len(opts.ColumnTimeZones) == 0 {
+		return nil
+	}
+	opts.timeZones = make([]*time.Location, len(colSpecs))
+	for i := range opts.timeZones {
+		opts.timeZones[i] = opts.TimeZone
+	}
+	fields := make([]string, 0, len(opts.ColumnTimeZones))
+	for field := range opts.ColumnTimeZones {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		index := -1
+		for i, colSpec := range colSpecs {
+			if colSpec.Name == field {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return fmt.Errorf("time zone field '%v' is not in the header", field)
+		}
+		parser := colSpecs[index].Parser
+		if dp, ok := parser.(*FieldDefaultParser); ok {
+			parser = dp.parser
+		}
+		if _, ok := parser.(locationParser); !ok {
+			return fmt.Errorf("time zone field '%v' is not a date field of a type with a layout", field)
+		}
+		opts.timeZones[index] = opts.ColumnTimeZones[field]
+	}
+	return nil
+}
+
+// timeZone returns the zone of the dates of the column at index.
+func (opts *ConvertOptions) timeZone(index int) *time.Location {
+	if opts.timeZones != nil {
+		return opts.timeZones[index]
+	}
+	return opts.TimeZone
+}
+
+// markColumns checks the ProjectFields and ExcludeFields against the
+// columns, and records which columns are converted.
+func (opts *ConvertOptions) markColumns(colSpecs []ColumnSpec) error {
+	opts.selected = nil
+	if len(opts.ProjectFields) == 0 && len(opts.ExcludeFields) == 0 {
+		return nil
+	}
+	for _, projected := range opts.ProjectFields {
+		for _, excluded := range opts.ExcludeFields {
+			if fieldContains(projected, excluded) || fieldContains(excluded, projected) {
+				return fmt.Errorf("field '%v' is projected but '%v' is excluded", projected, excluded)
+			}
+		}
+	}
+	opts.selected = make([]bool, len(colSpecs))
+	mark := func(field, description string, selected bool) error {
+		found := false
+		for i, colSpec := range colSpecs {
+			if fieldContains(field, colSpec.Name) {
+				opts.selected[i] = selected
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%v field '%v' is not in the header", description, field)
+		}
+		return nil
+	}
+	if len(opts.ProjectFields) == 0 {
+		for i := range opts.selected {
+			opts.selected[i] = true
+		}
+	}
+	for _, field := range opts.ProjectFields {
+		if err := mark(field, "projected", true); err != nil {
+			return err
+		}
+	}
+	for _, field := range opts.ExcludeFields {
+		if err := mark(field, "excluded", false); err != nil {
+			return err
+		}
+	}
+	log.Logvf(log.Info, "importing %v of %v columns", len(colSpecs)-opts.DroppedColumns(), len(colSpecs))
+	return nil
+}
+
+// findIDColumns finds the column of each of the IDFields, which must be
+// converted, and checks that no converted column already holds an _id.
+func (opts *ConvertOptions) findIDColumns(colSpecs []ColumnSpec) error {
+	opts.idColumns = nil
+	if len(opts.IDFields) == 0 {
+		return nil
+	}
+	for i, colSpec := range colSpecs {
+		if opts.converts(i) && fieldContains("_id", colSpec.Name) {
+			return fmt.Errorf("can not build the _id from fields when field '%v' is imported", colSpec.Name)
+		}
+	}
+	for n, field := range opts.IDFields {
+		if util.StringSliceContains(opts.IDFields[:n], field) {
+			return fmt.Errorf("_id field '%v' is given more than once", field)
+		}
+		index := -1
+		for i, colSpec := range colSpecs {
+			if colSpec.Name == field {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return fmt.Errorf("_id field '%v' is not in the header", field)
+		}
+		if !opts.converts(index) {
+			return fmt.Errorf("_id field '%v' is not imported", field)
+		}
+		opts.idColumns = append(opts.idColumns, index)
+	}
+	return nil
+}
+
+// idPosition returns the position among the IDFields of the column at
+// index, or -1 if it is not one of them.
+func (opts *ConvertOptions) idPosition(index int) int {
+	for position, column := range opts.idColumns {
+		if column == index {
+			return position
+		}
+	}
+	return -1
+}
+
+// buildID combines the values and tokens of the IDFields in a record into
+// its _id. A false entry in found means the record has no value for that field.
+func (opts *ConvertOptions) buildID(values []interface{}, tokens []string, found []bool) (interface{}, error) {
+	for position, field := range opts.IDFields {
+		if !found[position] {
+			return nil, categorizedError{FailureID, fmt.Errorf("_id field '%v' is missing", field)}
+		}
+		if value := values[position]; value == nil || value == "" {
+			return nil, categorizedError{FailureID, fmt.Errorf("_id field '%v' is blank", field)}
+		}
+	}
+	if opts.IDSeparator != "" {
+		parts := make([]string, len(values))
+		for position, value := range values {
+			parts[position] = strings.TrimSpace(tokens[position])
+			if parts[position] == "" {
+				// the value is a default for a blank token
+				parts[position] = fmt.Sprint(value)
+			}
+		}
+		return strings.Join(parts, opts.IDSeparator), nil
+	}
+	id := bson.D{}
+	for position, field := range opts.IDFields {
+		if !opts.FlatFields && strings.Index(field, ".") != -1 {
+			setNestedValue(field, values[position], &id)
+		} else {
+			id = append(id, bson.DocElem{Name: field, Value: values[position]})
+		}
+	}
+	return id, nil
+}
+
+// converts reports whether the token at index is converted. Tokens beyond
+// the columns are dropped only by ProjectFields.
+func (opts *ConvertOptions) converts(index int) bool {
+	if opts.selected == nil {
+		return true
+	}
+	if index < len(opts.selected) {
+		return opts.selected[index]
+	}
+	return len(opts.ProjectFields) == 0
+}
+
+// fieldContains reports whether the column name is field or nested under it.
+func fieldContains(field, name string) bool {
+	return name == field || strings.HasPrefix(name, field+".")
+}
+
+// fieldNames returns the names of the fields of the converted documents:
+// the _id, if it is built from IDFields, and the columns that are converted.
+func (opts *ConvertOptions) fieldNames(colSpecs []ColumnSpec) (names []string) {
+	if len(opts.idColumns) != 0 {
+		names = append(names, "_id")
+	}
+	for i, colSpec := range colSpecs {
+		if opts.converts(i) && (opts.KeepIDFields || opts.idPosition(i) == -1) {
+			names = append(names, colSpec.Name)
+		}
+	}
+	return
+}
+
+// DroppedColumns returns the number of columns that are left out of every
+// document by ProjectFields and ExcludeFields, once the header has been
+// validated.
+func (opts *ConvertOptions) DroppedColumns() (n int) {
+	for _, selected := range opts.selected {
+		if !selected {
+			n++
 		}
-		upsertDocument = append(upsertDocument, bson.DocElem{Name: key, Value: val})
 	}
-	if !hasDocumentKey {
-		return nil
+	return
+}
+
+// prepareColumnNames renames or normalizes, and then sanitizes, the name of
+// each column in place, returning the names as they were beforehand.
+func (opts *ConvertOptions) prepareColumnNames(colSpecs []ColumnSpec) []string {
+	original := ColumnNames(colSpecs)
+	opts.unmatchedRenames = nil
+	for source := range opts.RenameFields {
+		if !util.StringSliceContains(original, source) {
+			opts.unmatchedRenames = append(opts.unmatchedRenames, source)
+		}
 	}
-	return upsertDocument
+	sort.Strings(opts.unmatchedRenames)
+	for i := range colSpecs {
+		name, renamed := opts.columnName(colSpecs[i].Name)
+		if renamed {
+			log.Logvf(log.Info, "column %v: renamed field %q to %q", i+1, colSpecs[i].Name, name)
+		} else if name != colSpecs[i].Name {
+			log.Logvf(log.Info, "column %v: normalized field name %q to %q", i+1, colSpecs[i].Name, name)
+		}
+		colSpecs[i].Name = name
+	}
+	opts.sanitizeColumnNames(colSpecs)
+	return original
+}
+
+// columnName returns the name given to a column named name in the input,
+// before sanitization, and whether it was renamed rather than normalized.
+func (opts *ConvertOptions) columnName(name string) (string, bool) {
+	if renamed, ok := opts.RenameFields[name]; ok {
+		return renamed, true
+	}
+	return opts.normalizeField(name), false
 }
 
-// doSequentialStreaming takes a slice of workers, a readDocs (input) channel and
-// an outputChan (output) channel. It sequentially writes unprocessed data read from
-// the input channel to each worker and then sequentially reads the processed data
-// from each worker before passing it on to the output channel
-func doSequentialStreaming(workers []*importWorker, readDocs chan Converter, outputChan chan bson.D) {
-	numWorkers := len(workers)
+// HeaderCheck is how strictly VerifyHeader compares the header line of the
+// input with the fields that were given for it.
+type HeaderCheck int
 
-	// feed in the data to be processed and do round-robin
-	// reads from each worker once processing is completed
-	go func() {
-		i := 0
-		for doc := range readDocs {
-			workers[i].unprocessedDataChan <- doc
-			i = (i + 1) % numWorkers
-		}
+const (
+	// HeaderCheckNames requires the header to name the same fields in the
+	// same order, once they are renamed, normalized and sanitized as header
+	// fields are.
+	HeaderCheckNames HeaderCheck = iota
+
+	// HeaderCheckCount requires only that the header has as many columns as
+	// there are fields.
+	HeaderCheckCount
+)
+
+// ValidateHeaderCheck ensures the user-provided header check is one of the
+// allowed values.
+func ValidateHeaderCheck(check string) (HeaderCheck, error) {
+	switch check {
+	case "", "names":
+		return HeaderCheckNames, nil
+	case "count":
+		return HeaderCheckCount, nil
+	default:
+		return HeaderCheckNames, fmt.Errorf("invalid header check: %s", check)
+	}
+}
 
-		// close the read channels of all the workers
-		for i := 0; i < numWorkers; i++ {
-			close(workers[i].unprocessedDataChan)
+// verifyHeader checks header, the fields of the input's header line, against
+// colSpecs, the columns that were given for the input, as check says.
+func (opts *ConvertOptions) verifyHeader(header []string, colSpecs []ColumnSpec, check HeaderCheck) error {
+	if check == HeaderCheckCount {
+		if len(header) != len(colSpecs) {
+			return fmt.Errorf("the header has %v columns, but %v fields are given", len(header), len(colSpecs))
 		}
-	}()
+		return nil
+	}
+	headerSpecs := ParseAutoHeaders(header)
+	for i := range headerSpecs {
+		headerSpecs[i].Name, _ = opts.columnName(headerSpecs[i].Name)
+	}
+	opts.sanitizeColumnNames(headerSpecs)
+	fields, names := ColumnNames(colSpecs), ColumnNames(headerSpecs)
+	if diff := headerDiff(fields, names); len(diff) != 0 {
+		return fmt.Errorf("the header does not match the fields given: %v", strings.Join(diff, "; "))
+	}
+	return nil
+}
 
-	// coordinate the order in which the documents are sent over to the
-	// main output channel
-	numDoneWorkers := 0
-	i := 0
-	for {
-		processedDocument, open := <-workers[i].processedDocumentChan
-		if open {
-			outputChan <- processedDocument
+// headerDiff describes how header differs from fields: the fields that it
+// lacks, the columns it has besides them, and the order of the fields that
+// it has in a different order. It returns nil if they are the same.
+func headerDiff(fields, header []string) (diff []string) {
+	inFields := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		inFields[field] = true
+	}
+	inHeader := make(map[string]bool, len(header))
+	for _, name := range header {
+		inHeader[name] = true
+	}
+	var missing, extra, fieldOrder, headerOrder []string
+	for i, field := range fields {
+		if inHeader[field] {
+			fieldOrder = append(fieldOrder, field)
 		} else {
-			numDoneWorkers++
+			missing = append(missing, fmt.Sprintf("'%v' (field %v)", field, i+1))
 		}
-		if numDoneWorkers == numWorkers {
-			break
+	}
+	for i, name := range header {
+		if inFields[name] {
+			headerOrder = append(headerOrder, name)
+		} else {
+			extra = append(extra, fmt.Sprintf("'%v' (column %v)", name, i+1))
 		}
-		i = (i + 1) % numWorkers
 	}
+	if len(missing) != 0 {
+		diff = append(diff, "missing "+strings.Join(missing, ", "))
+	}
+	if len(extra) != 0 {
+		diff = append(diff, "extra "+strings.Join(extra, ", "))
+	}
+	if !equalFields(fieldOrder, headerOrder) {
+		diff = append(diff, fmt.Sprintf("reordered: the header has '%v' where the fields are '%v'",
+			strings.Join(headerOrder, ","), strings.Join(fieldOrder, ",")))
+	}
+	return diff
 }
 
-// getUpsertValue takes a given BSON document and a given field, and returns the
-// field's associated value in the document. The field is specified using dot
-// notation for nested fields. e.g. "person.age" would return 34 would return
-// 34 in the document: bson.M{"person": bson.M{"age": 34}} whereas,
-// "person.name" would return nil
-func getUpsertValue(field string, document bson.D) interface{} {
-	index := strings.Index(field, ".")
-	if index == -1 {
-		// grab the value (ignoring errors because we are okay with nil)
-		val, _ := bsonutil.FindValueByKey(field, &document)
-		return val
+// normalizeField applies the field name normalizations that are enabled.
+func (opts *ConvertOptions) normalizeField(field string) string {
+	if opts.TrimFieldNames {
+		field = strings.TrimSpace(field)
 	}
-	// recurse into subdocuments
-	left := field[0:index]
-	subDoc, _ := bsonutil.FindValueByKey(left, &document)
-	if subDoc == nil {
-		return nil
+	if opts.LowercaseFieldNames {
+		field = strings.ToLower(field)
 	}
-	subDocD, ok := subDoc.(bson.D)
-	if !ok {
-		return nil
+	if opts.UnderscoreFieldNames {
+		field = whitespaceRun.ReplaceAllString(field, "_")
 	}
-	return getUpsertValue(field[index+1:], subDocD)
+	return field
 }
 
-// filterIngestError accepts a boolean indicating if a non-nil error should be,
-// returned as an actual error.
-//
-// If the error indicates an unreachable server, it returns that immediately.
-//
-// If the error indicates an invalid write concern was passed, it returns nil
-//
-// If the error is not nil, it logs the error. If the error is an io.EOF error -
-// indicating a lost connection to the server, it sets the error as such.
-//
-func filterIngestError(stopOnError bool, err error) error {
-	if err == nil {
-		return nil
+// sanitizeColumnNames sanitizes the name of each column in place if
+// SanitizeFields is set.
+func (opts *ConvertOptions) sanitizeColumnNames(colSpecs []ColumnSpec) {
+	if !opts.SanitizeFields {
+		return
 	}
-	if err.Error() == io.EOF.Error() {
-		return fmt.Errorf(db.ErrLostConnection)
+	substitute := opts.FieldSubstitute
+	if substitute == "" {
+		substitute = "_"
 	}
-	if stopOnError || db.IsConnectionError(err) {
-		return err
+	for i := range colSpecs {
+		if name := sanitizeField(colSpecs[i].Name, substitute); name != colSpecs[i].Name {
+			log.Logvf(log.Always, "column %v: replacing illegal field name %q with %q", i+1, colSpecs[i].Name, name)
+			colSpecs[i].Name = name
+		}
 	}
-	log.Logvf(log.Always, "error inserting documents: %v", err)
-	return nil
 }
 
-// removeBlankFields takes document and returns a new copy in which
-// fields with empty/blank values are removed
-func removeBlankFields(document bson.D) (newDocument bson.D) {
-	for _, keyVal := range document {
-		if val, ok := keyVal.Value.(*bson.D); ok {
-			keyVal.Value = removeBlankFields(*val)
+// raggedRowPolicy checks the number of tokens in a record against the number
+// of columns, returning the tokens to convert and whether missing trailing
+// fields should be set to null.
+func (opts *ConvertOptions) raggedRowPolicy(tokens []string, numColumns int) ([]string, bool, error) {
+	if opts.AllowTrailingDelimiter && len(tokens) == numColumns+1 && tokens[numColumns] == "" {
+		tokens = tokens[:numColumns]
+	}
+	switch {
+	case len(tokens) < numColumns:
+		switch opts.ShortRows {
+		case RaggedDefault, RaggedPadWithMissing:
+		case RaggedPadWithNull:
+			return tokens, true, nil
+		case RaggedPadWithEmpty:
+			padded := make([]string, numColumns)
+			copy(padded, tokens)
+			return padded, false, nil
+		case RaggedError:
+			return nil, false, categorizedError{FailureRaggedRow, fmt.Errorf("row has %v fields, fewer than the %v columns (short row policy: %v)",
+				len(tokens), numColumns, opts.ShortRows)}
+		default:
+			return nil, false, fmt.Errorf("%v is not a valid short row policy", opts.ShortRows)
 		}
-		if val, ok := keyVal.Value.(string); ok && val == "" {
-			continue
+	case len(tokens) > numColumns:
+		switch opts.LongRows {
+		case RaggedDefault, RaggedCollectExtraIntoField:
+		case RaggedTruncateExtra:
+			return tokens[:numColumns], false, nil
+		case RaggedError:
+			return nil, false, categorizedError{FailureRaggedRow, fmt.Errorf("row has %v fields, more than the %v columns (long row policy: %v)",
+				len(tokens), numColumns, opts.LongRows)}
+		default:
+			return nil, false, fmt.Errorf("%v is not a valid long row policy", opts.LongRows)
 		}
-		if val, ok := keyVal.Value.(bson.D); ok && val == nil {
-			continue
+	}
+	return tokens, false, nil
+}
+
+// isNullToken reports whether token is one of the NullTokens.
+func (opts *ConvertOptions) isNullToken(token string) bool {
+	for _, nullToken := range opts.NullTokens {
+		if token == nullToken || opts.NullTokensIgnoreCase && strings.EqualFold(token, nullToken) {
+			return true
 		}
-		newDocument = append(newDocument, keyVal)
 	}
-	return newDocument
+	return false
 }
 
-// setNestedValue takes a nested field - in the form "a.b.c" -
-// its associated value, and a document. It then assigns that
-// value to the appropriate nested field within the document
-func setNestedValue(key string, value interface{}, document *bson.D) {
-	index := strings.Index(key, ".")
-	if index == -1 {
-		*document = append(*document, bson.DocElem{Name: key, Value: value})
-		return
+// parseBoolean interprets a token of a boolean column using the TrueTokens
+// and FalseTokens.
+func (opts *ConvertOptions) parseBoolean(token string) (interface{}, error) {
+	trueTokens, falseTokens := opts.TrueTokens, opts.FalseTokens
+	if len(trueTokens) == 0 {
+		trueTokens = defaultTrueTokens
 	}
-	keyName := key[0:index]
-	subDocument := &bson.D{}
-	elem, err := bsonutil.FindValueByKey(keyName, document)
-	if err != nil { // no such key in the document
-		elem = nil
+	if len(falseTokens) == 0 {
+		falseTokens = defaultFalseTokens
 	}
-	var existingKey bool
-	if elem != nil {
-		subDocument = elem.(*bson.D)
-		existingKey = true
+	return parseBoolean(token, trueTokens, falseTokens, opts.BooleanCaseSensitive)
+}
+
+// parsePercent parses token as pp does, rewriting its number as
+// delocalizeNumber does if either the DecimalSeparator or the
+// ThousandsSeparator is set.
+func (opts *ConvertOptions) parsePercent(pp *FieldPercentParser, token string) (interface{}, error) {
+	number, err := trimPercent(token)
+	if err != nil {
+		return nil, err
 	}
-	setNestedValue(key[index+1:], value, subDocument)
-	if !existingKey {
-		*document = append(*document, bson.DocElem{Name: keyName, Value: subDocument})
+	if opts.DecimalSeparator != "" || opts.ThousandsSeparator != "" {
+		if number, err = opts.delocalizeNumber(number); err != nil {
+			return nil, err
+		}
 	}
+	return pp.parseNumber(number)
 }
 
-// streamDocuments concurrently processes data gotten from the inputChan
-// channel in parallel and then sends over the processed data to the outputChan
-// channel - either in sequence or concurrently (depending on the value of
-// ordered) - in which the data was received
-func streamDocuments(ordered bool, numDecoders int, readDocs chan Converter, outputChan chan bson.D) (retErr error) {
-	if numDecoders == 0 {
-		numDecoders = 1
+// delocalizeNumber rewrites a token of a numeric column that uses the
+// DecimalSeparator and ThousandsSeparator with a '.' decimal point and
+// no thousands separators.
+func (opts *ConvertOptions) delocalizeNumber(token string) (string, error) {
+	decimalSeparator, thousandsSeparator := opts.DecimalSeparator, opts.ThousandsSeparator
+	if decimalSeparator == "" {
+		decimalSeparator = "."
 	}
-	var importWorkers []*importWorker
-	wg := new(sync.WaitGroup)
-	importTomb := new(tomb.Tomb)
-	inChan := readDocs
-	outChan := outputChan
-	for i := 0; i < numDecoders; i++ {
-		if ordered {
-			inChan = make(chan Converter, workerBufferSize)
-			outChan = make(chan bson.D, workerBufferSize)
-		}
-		iw := &importWorker{
-			unprocessedDataChan:   inChan,
-			processedDocumentChan: outChan,
-			tomb: importTomb,
+	if decimalSeparator != "." && thousandsSeparator != "." && strings.Contains(token, ".") {
+		// otherwise "1.234" would silently become one point two three four
+		return "", fmt.Errorf("'.' is not the decimal separator %q", decimalSeparator)
+	}
+	integer, fraction := token, ""
+	if i := strings.Index(token, decimalSeparator); i != -1 {
+		integer, fraction = token[:i], token[i+len(decimalSeparator):]
+		if thousandsSeparator != "" && strings.Contains(fraction, thousandsSeparator) {
+			return "", fmt.Errorf("thousands separator %q after the decimal separator", thousandsSeparator)
 		}
-		importWorkers = append(importWorkers, iw)
-		wg.Add(1)
-		go func(iw importWorker) {
-			defer wg.Done()
-			// only set the first worker error and cause sibling goroutines
-			// to terminate immediately
-			err := iw.processDocuments(ordered)
-			if err != nil && retErr == nil {
-				retErr = err
-				iw.tomb.Kill(err)
+		fraction = "." + fraction
+	}
+	if thousandsSeparator == "" {
+		return integer + fraction, nil
+	}
+	groups := strings.Split(integer, thousandsSeparator)
+	for i, group := range groups {
+		if i == 0 && len(groups) > 1 {
+			// the first group may be shorter, and carries the sign
+			group = strings.TrimLeft(group, "+-")
+			if len(group) >= 1 && len(group) <= 3 {
+				continue
 			}
-		}(*iw)
+		} else if i == 0 || len(group) == 3 {
+			continue
+		}
+		return "", fmt.Errorf("misplaced thousands separator %q", thousandsSeparator)
 	}
+	return strings.Join(groups, "") + fraction, nil
+}
 
-	// if ordered, we have to coordinate the sequence in which processed
-	// documents are passed to the main read channel
-	if ordered {
-		doSequentialStreaming(importWorkers, readDocs, outputChan)
+// parseAuto interprets a token that has no declared type, inferring a numeric
+// type unless StringsOnly is set.
+func (opts *ConvertOptions) parseAuto(token string) interface{} {
+	if opts.StringsOnly {
+		return token
 	}
-	wg.Wait()
-	close(outputChan)
-	return
+	return autoParse(token, opts.BigIntegersAsDecimal)
+}
+
+// checkUTF8 applies the InvalidUTF8 policy to value, the value of the column
+// at index, of the given name, if it is a string, and returns the value to
+// give its field.
+func (opts *ConvertOptions) checkUTF8(value interface{}, index int, name string) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok || utf8.ValidString(s) {
+		return value, nil
+	}
+	switch opts.InvalidUTF8 {
+	case InvalidUTF8Replace:
+		replaced, numReplaced := replaceInvalidUTF8(s)
+		if opts.utf8Replacements != nil {
+			atomic.AddUint64(opts.utf8Replacements, uint64(numReplaced))
+		}
+		return replaced, nil
+	case InvalidUTF8Skip:
+		return nil, skippedRecordError{invalidUTF8Error(s, index, name)}
+	default:
+		return nil, categorizedError{FailureEncoding, invalidUTF8Error(s, index, name)}
+	}
+}
+
+// invalidUTF8Error describes the first invalid UTF-8 in s, the value of the
+// column at index, of the given name.
+func invalidUTF8Error(s string, index int, name string) error {
+	offset := 0
+	for offset < len(s) {
+		r, size := utf8.DecodeRuneInString(s[offset:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		offset += size
+	}
+	return fmt.Errorf("field '%v' (column %v): invalid UTF-8 at byte %v of the value", name, index+1, offset)
+}
+
+// replaceInvalidUTF8 returns s with each run of bytes that are not valid
+// UTF-8 replaced by U+FFFD, and the number of runs replaced.
+func replaceInvalidUTF8(s string) (string, int) {
+	var replaced bytes.Buffer
+	numReplaced := 0
+	inRun := false
+	for offset := 0; offset < len(s); {
+		r, size := utf8.DecodeRuneInString(s[offset:])
+		if r == utf8.RuneError && size == 1 {
+			if !inRun {
+				replaced.WriteRune(utf8.RuneError)
+				numReplaced++
+			}
+			inRun = true
+		} else {
+			replaced.WriteString(s[offset : offset+size])
+			inRun = false
+		}
+		offset += size
+	}
+	return replaced.String(), numReplaced
+}
+
+// skippedRecordError is returned by the conversion of a record that a policy
+// drops, rather than fails; the stream counts it as rejected.
+type skippedRecordError struct {
+	error
+}
+
+func (e skippedRecordError) Unwrap() error {
+	return e.error
 }
 
 // coercionError should only be used as a specific error type to check
@@ -350,73 +3066,245 @@ type coercionError struct{}
 func (coercionError) Error() string { return "coercionError" }
 
 // tokensToBSON reads in slice of records - along with ordered column names -
-// and returns a BSON document for the record.
-func tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, ignoreBlanks bool) (bson.D, error) {
-	log.Logvf(log.DebugHigh, "got line: %v", tokens)
+// and returns a BSON document for the record. A nil opts uses the default
+// conversion options.
+func tokensToBSON(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, opts *ConvertOptions) (bson.D, error) {
+	if opts == nil {
+		opts = &ConvertOptions{}
+	}
+	b := &docBuilder{document: make(bson.D, 0, len(colSpecs)), flat: opts.FlatFields}
+	if err := convertTokens(colSpecs, tokens, numProcessed, opts, b); err != nil {
+		return nil, err
+	}
+	return b.document, nil
+}
+
+// documentBuilder builds the document that convertTokens converts a record
+// to, a field at a time: as a bson.D, or as BSON directly.
+type documentBuilder interface {
+	// add adds the field of the given name, which is nested, unless
+	// FlatFields is set, if it has a '.'
+	add(name string, value interface{})
+
+	// setID sets the _id of the document, which goes before its other fields
+	setID(id interface{})
+}
+
+// docBuilder is the documentBuilder of a bson.D.
+type docBuilder struct {
+	document bson.D
+	flat     bool
+}
+
+func (b *docBuilder) add(name string, value interface{}) {
+	if !b.flat && strings.Index(name, ".") != -1 {
+		setNestedValue(name, value, &b.document)
+	} else {
+		b.document = append(b.document, bson.DocElem{Name: name, Value: value})
+	}
+}
+
+func (b *docBuilder) setID(id interface{}) {
+	b.document = append(bson.D{{Name: "_id", Value: id}}, b.document...)
+}
+
+// convertTokens converts the tokens of a record to the fields of the
+// document that b builds, as tokensToBSON does.
+func convertTokens(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, opts *ConvertOptions, b documentBuilder) error {
+	if opts.quotesRecords() && log.IsInVerbosity(log.DebugHigh) {
+		// checked first, as passing tokens to Logvf allocates
+		log.Logvf(log.DebugHigh, "got line: %v", tokens)
+	}
+	tokens, padWithNull, err := opts.raggedRowPolicy(tokens, len(colSpecs))
+	if err != nil {
+		return err
+	}
 	var parsedValue interface{}
-	document := bson.D{}
+	idValues := make([]interface{}, len(opts.idColumns))
+	idTokens := make([]string, len(opts.idColumns))
+	idFound := make([]bool, len(opts.idColumns))
+	appendValue := func(index int, value interface{}) {
+		name := colSpecs[index].Name
+		if position := opts.idPosition(index); position != -1 {
+			idValues[position], idFound[position] = value, true
+			// the _id of an anonymized column is built from its value
+			if index < len(tokens) && opts.anonymizer(index) == nil {
+				idTokens[position] = tokens[index]
+			}
+			if !opts.KeepIDFields {
+				return
+			}
+		}
+		b.add(name, value)
+	}
 	for index, token := range tokens {
-		if token == "" && ignoreBlanks {
+		if !opts.converts(index) {
+			continue
+		}
+		if opts.TrimWhitespace {
+			token = strings.TrimSpace(token)
+		}
+		if index < len(colSpecs) && strings.TrimSpace(token) == "" {
+			// a default takes precedence over IgnoreBlanks
+			if dp, ok := colSpecs[index].Parser.(*FieldDefaultParser); ok {
+				value, err := opts.parseDefault(colSpecs[index], dp, index)
+				if err != nil {
+					return err
+				}
+				appendValue(index, value)
+				continue
+			}
+		}
+		isNull := opts.isNullToken(token)
+		if (token == "" || isNull) && opts.IgnoreBlanks {
 			continue
 		}
 		if index < len(colSpecs) {
-			parsedValue, err := colSpecs[index].Parser.Parse(token)
+			var parsedValue interface{}
+			var err error
+			parser := colSpecs[index].Parser
+			if dp, ok := parser.(*FieldDefaultParser); ok {
+				parser = dp.parser
+			}
+			if !isNull {
+				parsedValue, err = opts.parseToken(parser, index, token)
+			}
 			if err != nil {
-				log.Logvf(log.DebugHigh, "parse failure in document #%d for column '%s',"+
-					"could not parse token '%s' to type %s",
-					numProcessed, colSpecs[index].Name, token, colSpecs[index].TypeName)
+				if opts.quotesRecords() {
+					log.Logvf(log.DebugHigh, "parse failure in document #%d for column '%s',"+
+						"could not parse token '%s' to type %s",
+						numProcessed+1, colSpecs[index].Name, token, colSpecs[index].TypeName)
+				}
 				switch colSpecs[index].ParseGrace {
 				case pgAutoCast:
-					parsedValue = autoParse(token)
+					parsedValue = opts.parseAuto(token)
 				case pgSkipField:
 					continue
 				case pgSkipRow:
-					log.Logvf(log.Always, "skipping row #%d: %v", numProcessed, tokens)
-					return nil, coercionError{}
+					if opts.quotesRecords() {
+						log.Logvf(log.Always, "skipping row #%d: %v", numProcessed+1, tokens)
+					} else {
+						log.Logvf(log.Always, "skipping row #%d", numProcessed+1)
+					}
+					return coercionError{}
 				case pgStop:
-					return nil, fmt.Errorf("type coercion failure in document #%d for column '%s', "+
-						"could not parse token '%s' to type %s",
-						numProcessed, colSpecs[index].Name, token, colSpecs[index].TypeName)
+					return categorizedError{FailureType, FieldConversionError{
+						Field:  colSpecs[index].Name,
+						Column: index + 1,
+						Value:  truncateValue(token),
+						Type:   colSpecs[index].TypeName,
+						Err:    err,
+					}}
 				}
 			}
-			if strings.Index(colSpecs[index].Name, ".") != -1 {
-				setNestedValue(colSpecs[index].Name, parsedValue, &document)
-			} else {
-				document = append(document, bson.DocElem{Name: colSpecs[index].Name, Value: parsedValue})
+			if parsedValue, err = opts.checkUTF8(parsedValue, index, colSpecs[index].Name); err != nil {
+				return err
+			}
+			if anonymizer := opts.anonymizer(index); anonymizer != nil && parsedValue != nil {
+				// an auto column's value is anonymized as the string it was read as
+				s, isString := parsedValue.(string)
+				if !isString {
+					s = token
+				}
+				parsedValue = anonymizer.anonymize(s)
 			}
+			appendValue(index, parsedValue)
 		} else {
-			parsedValue = autoParse(token)
+			parsedValue = nil
+			if !isNull {
+				parsedValue = opts.parseAuto(token)
+			}
 			key := "field" + strconv.Itoa(index)
+			if parsedValue, err = opts.checkUTF8(parsedValue, index, key); err != nil {
+				return err
+			}
 			if util.StringSliceContains(ColumnNames(colSpecs), key) {
-				return nil, fmt.Errorf("duplicate field name - on %v - for token #%v ('%v') in document #%v",
-					key, index+1, parsedValue, numProcessed)
+				return fmt.Errorf("duplicate field name - on %v - for token #%v ('%v')",
+					key, index+1, parsedValue)
 			}
-			document = append(document, bson.DocElem{Name: key, Value: parsedValue})
+			b.add(key, parsedValue)
 		}
 	}
-	return document, nil
+	if padWithNull {
+		for index := len(tokens); index < len(colSpecs); index++ {
+			if !opts.converts(index) {
+				continue
+			}
+			// a missing field takes the column's default, like a blank one
+			var value interface{}
+			if dp, ok := colSpecs[index].Parser.(*FieldDefaultParser); ok {
+				if value, err = opts.parseDefault(colSpecs[index], dp, index); err != nil {
+					return err
+				}
+			}
+			appendValue(index, value)
+		}
+	}
+	if len(opts.idColumns) != 0 {
+		id, err := opts.buildID(idValues, idTokens, idFound)
+		if err != nil {
+			return err
+		}
+		b.setID(id)
+	}
+	return nil
+}
+
+// parseToken parses the token of the column at index with its parser, which
+// is not a FieldDefaultParser, applying the options that change how values
+// of its type are read.
+func (opts *ConvertOptions) parseToken(parser FieldParser, index int, token string) (interface{}, error) {
+	if _, isAuto := parser.(*FieldAutoParser); isAuto {
+		return opts.parseAuto(token), nil
+	} else if _, isBoolean := parser.(*FieldBooleanParser); isBoolean {
+		return opts.parseBoolean(token)
+	} else if lp, isDate := parser.(locationParser); isDate && opts.timeZone(index) != nil {
+		return parseInLocation(lp, token, opts.timeZone(index), opts.DSTGap)
+	} else if mp, isMoney := parser.(*FieldMoneyParser); isMoney {
+		return opts.parseMoney(mp, token)
+	} else if pp, isPercent := parser.(*FieldPercentParser); isPercent {
+		return opts.parsePercent(pp, token)
+	} else if (opts.DecimalSeparator != "" || opts.ThousandsSeparator != "") && isNumericParser(parser) {
+		number, err := opts.delocalizeNumber(token)
+		if err != nil {
+			return nil, err
+		}
+		return parser.Parse(number)
+	}
+	return parser.Parse(token)
+}
+
+// parseDefault parses the default of colSpec, the column at index, as its
+// tokens are parsed.
+func (opts *ConvertOptions) parseDefault(colSpec ColumnSpec, dp *FieldDefaultParser, index int) (interface{}, error) {
+	value, err := opts.parseToken(dp.parser, index, dp.token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default '%s' for %s field %s: %v", dp.token, colSpec.TypeName, colSpec.Name, err)
+	}
+	return value, nil
 }
 
 // validateFields takes a slice of fields and returns an error if the fields
-// are invalid, returns nil otherwise
-func validateFields(fields []string) error {
+// are invalid, returns nil otherwise. If flat is set, as it is for FlatFields,
+// dotted fields are literal keys, so a field such as "a.b" does not collide
+// with "a".
+func validateFields(fields []string, flat bool) error {
+	if err := duplicateFieldsError(fields); err != nil {
+		return err
+	}
+	for index, field := range fields {
+		if err := validateField(field); err != nil {
+			return fmt.Errorf("column %v: %v", index+1, err)
+		}
+	}
+	if flat {
+		return nil
+	}
 	fieldsCopy := make([]string, len(fields), len(fields))
 	copy(fieldsCopy, fields)
 	sort.Sort(sort.StringSlice(fieldsCopy))
 
 	for index, field := range fieldsCopy {
-		if strings.HasSuffix(field, ".") {
-			return fmt.Errorf("field '%v' cannot end with a '.'", field)
-		}
-		if strings.HasPrefix(field, ".") {
-			return fmt.Errorf("field '%v' cannot start with a '.'", field)
-		}
-		if strings.HasPrefix(field, "$") {
-			return fmt.Errorf("field '%v' cannot start with a '$'", field)
-		}
-		if strings.Contains(field, "..") {
-			return fmt.Errorf("field '%v' cannot contain consecutive '.' characters", field)
-		}
 		// NOTE: since fields is sorted, this check ensures that no field
 		// is incompatible with another one that occurs further down the list.
 		// meant to prevent cases where we have fields like "a" and "a.c"
@@ -426,19 +3314,77 @@ func validateFields(fields []string) error {
 			if strings.HasPrefix(latterField, field+".") {
 				return fmt.Errorf("fields '%v' and '%v' are incompatible", field, latterField)
 			}
-			// NOTE: this means we will not support imports that have fields like
-			// a, a - since this is invalid in MongoDB
-			if field == latterField {
-				return fmt.Errorf("fields cannot be identical: '%v' and '%v'", field, latterField)
-			}
 		}
 	}
 	return nil
 }
 
+// validateField returns an error if field is not a legal field name on its own
+func validateField(field string) error {
+	if field == "" {
+		return errors.New("field name cannot be empty")
+	}
+	if strings.HasSuffix(field, ".") {
+		return fmt.Errorf("field '%v' cannot end with a '.'", field)
+	}
+	if strings.HasPrefix(field, ".") {
+		return fmt.Errorf("field '%v' cannot start with a '.'", field)
+	}
+	if strings.HasPrefix(field, "$") {
+		return fmt.Errorf("field '%v' cannot start with a '$'", field)
+	}
+	if strings.Contains(field, "..") {
+		return fmt.Errorf("field '%v' cannot contain consecutive '.' characters", field)
+	}
+	if strings.Contains(field, "\x00") {
+		return fmt.Errorf("field %q cannot contain a NUL byte", field)
+	}
+	return nil
+}
+
+// sanitizeField returns field with any NUL bytes, a leading '$' and any empty
+// path segments replaced by substitute, so that it passes validateField.
+func sanitizeField(field, substitute string) string {
+	field = strings.Replace(field, "\x00", substitute, -1)
+	if strings.HasPrefix(field, "$") {
+		field = substitute + field[1:]
+	}
+	segments := strings.Split(field, ".")
+	for i, segment := range segments {
+		if segment == "" {
+			segments[i] = substitute
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
+// duplicateFieldsError returns an error listing every field that occurs more
+// than once in fields, with the 1-based column positions it occurs at, or nil
+// if there are no duplicates. Identical fields are not supported since the
+// later value would clobber the earlier one; this also covers dotted fields
+// such as "a.b" that would set the same nested value twice.
+func duplicateFieldsError(fields []string) error {
+	positions := make(map[string][]string)
+	var duplicated []string
+	for index, field := range fields {
+		positions[field] = append(positions[field], strconv.Itoa(index+1))
+		if len(positions[field]) == 2 {
+			duplicated = append(duplicated, field)
+		}
+	}
+	if len(duplicated) == 0 {
+		return nil
+	}
+	descriptions := make([]string, len(duplicated))
+	for i, field := range duplicated {
+		descriptions[i] = fmt.Sprintf("'%v' (columns %v)", field, strings.Join(positions[field], ", "))
+	}
+	return fmt.Errorf("duplicate field names: %v", strings.Join(descriptions, "; "))
+}
+
 // validateReaderFields is a helper to validate fields for input readers
-func validateReaderFields(fields []string) error {
-	if err := validateFields(fields); err != nil {
+func validateReaderFields(fields []string, flat bool) error {
+	if err := validateFields(fields, flat); err != nil {
 		return err
 	}
 	if len(fields) == 1 {
@@ -450,27 +3396,34 @@ func validateReaderFields(fields []string) error {
 }
 
 // processDocuments reads from the Converter channel and for each record, converts it
-// to a bson.D document before sending it on the processedDocumentChan channel. Once the
-// input channel is closed the processed channel is also closed if the worker streams its
-// reads in order
-func (iw *importWorker) processDocuments(ordered bool) error {
-	if ordered {
-		defer close(iw.processedDocumentChan)
-	}
+// to a document before sending it on to the sink, in whatever order the
+// records are converted, until the input channel is closed.
+func (iw *importWorker) processDocuments() error {
 	for {
 		select {
 		case converter, alive := <-iw.unprocessedDataChan:
 			if !alive {
 				return nil
 			}
-			document, err := converter.Convert()
+			var documents []interface{}
+			var err error
+			if batch, ok := converter.(converterBatch); ok {
+				documents, err = batch.convert(iw.sink)
+			} else {
+				var document interface{}
+				if document, err = iw.sink.convert(converter); err != nil {
+					return err
+				}
+				documents = []interface{}{document}
+			}
+			for _, document := range documents {
+				if document != nil && !iw.sink.send(document, iw.tomb.Dying(), nil) {
+					return nil
+				}
+			}
 			if err != nil {
 				return err
 			}
-			if document == nil {
-				continue
-			}
-			iw.processedDocumentChan <- document
 		case <-iw.tomb.Dying():
 			return nil
 		}
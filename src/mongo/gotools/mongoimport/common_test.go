@@ -7,9 +7,16 @@
 package mongoimport
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mongodb/mongo-tools/common/db"
 	"github.com/mongodb/mongo-tools/common/log"
@@ -115,33 +122,81 @@ func TestValidateFields(t *testing.T) {
 
 	Convey("Given an import input, in validating the headers", t, func() {
 		Convey("if the fields contain '..', an error should be thrown", func() {
-			So(validateFields([]string{"a..a"}), ShouldNotBeNil)
+			So(validateFields([]string{"a..a"}, false), ShouldNotBeNil)
 		})
 		Convey("if the fields start/end in a '.', an error should be thrown", func() {
-			So(validateFields([]string{".a"}), ShouldNotBeNil)
-			So(validateFields([]string{"a."}), ShouldNotBeNil)
+			So(validateFields([]string{".a"}, false), ShouldNotBeNil)
+			So(validateFields([]string{"a."}, false), ShouldNotBeNil)
 		})
 		Convey("if the fields start in a '$', an error should be thrown", func() {
-			So(validateFields([]string{"$.a"}), ShouldNotBeNil)
-			So(validateFields([]string{"$"}), ShouldNotBeNil)
-			So(validateFields([]string{"$a"}), ShouldNotBeNil)
-			So(validateFields([]string{"a$a"}), ShouldBeNil)
+			So(validateFields([]string{"$.a"}, false), ShouldNotBeNil)
+			So(validateFields([]string{"$"}, false), ShouldNotBeNil)
+			So(validateFields([]string{"$a"}, false), ShouldNotBeNil)
+			So(validateFields([]string{"a$a"}, false), ShouldBeNil)
 		})
 		Convey("if the fields collide, an error should be thrown", func() {
-			So(validateFields([]string{"a", "a.a"}), ShouldNotBeNil)
-			So(validateFields([]string{"a", "a.ba", "b.a"}), ShouldNotBeNil)
-			So(validateFields([]string{"a", "a.ba", "b.a"}), ShouldNotBeNil)
-			So(validateFields([]string{"a", "a.b.c"}), ShouldNotBeNil)
+			So(validateFields([]string{"a", "a.a"}, false), ShouldNotBeNil)
+			So(validateFields([]string{"a", "a.ba", "b.a"}, false), ShouldNotBeNil)
+			So(validateFields([]string{"a", "a.ba", "b.a"}, false), ShouldNotBeNil)
+			So(validateFields([]string{"a", "a.b.c"}, false), ShouldNotBeNil)
+		})
+		Convey("if the fields are flat, dotted fields should not collide", func() {
+			So(validateFields([]string{"a", "a.a", "a.b.c"}, true), ShouldBeNil)
+			So(validateFields([]string{"a", "a.a", "a"}, true), ShouldNotBeNil)
+			So(validateFields([]string{"a", "a..b"}, true), ShouldNotBeNil)
+			colSpecs := ParseAutoHeaders([]string{"a", "a.b"})
+			So((&ConvertOptions{}).validateColumns(colSpecs), ShouldNotBeNil)
+			So((&ConvertOptions{FlatFields: true}).validateColumns(colSpecs), ShouldBeNil)
 		})
 		Convey("if the fields don't collide, no error should be thrown", func() {
-			So(validateFields([]string{"a", "aa"}), ShouldBeNil)
-			So(validateFields([]string{"a", "aa", "b.a", "b.c"}), ShouldBeNil)
-			So(validateFields([]string{"a", "ba", "ab", "b.a"}), ShouldBeNil)
-			So(validateFields([]string{"a", "ba", "ab", "b.a", "b.c.d"}), ShouldBeNil)
-			So(validateFields([]string{"a", "ab.c"}), ShouldBeNil)
+			So(validateFields([]string{"a", "aa"}, false), ShouldBeNil)
+			So(validateFields([]string{"a", "aa", "b.a", "b.c"}, false), ShouldBeNil)
+			So(validateFields([]string{"a", "ba", "ab", "b.a"}, false), ShouldBeNil)
+			So(validateFields([]string{"a", "ba", "ab", "b.a", "b.c.d"}, false), ShouldBeNil)
+			So(validateFields([]string{"a", "ab.c"}, false), ShouldBeNil)
 		})
 		Convey("if the fields contain the same keys, an error should be thrown", func() {
-			So(validateFields([]string{"a", "ba", "a"}), ShouldNotBeNil)
+			So(validateFields([]string{"a", "ba", "a"}, false), ShouldNotBeNil)
+		})
+		Convey("empty fields and fields with NUL bytes should be rejected with their column", func() {
+			err := validateFields([]string{"a", ""}, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "column 2: field name cannot be empty")
+			err = validateFields([]string{"a\x00b"}, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `column 1: field "a\x00b" cannot contain a NUL byte`)
+			err = validateFields([]string{"a", "b", "$where"}, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "column 3: ")
+		})
+		Convey("duplicate fields should all be listed with their column positions", func() {
+			err := validateFields([]string{"id", "a.b", "name", "id", "a.b", "id"}, false)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "duplicate field names: 'id' (columns 1, 4, 6); 'a.b' (columns 2, 5)")
+		})
+	})
+}
+
+func TestSanitizeColumnNames(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Given column names that are illegal field names", t, func() {
+		colSpecs := ParseAutoHeaders([]string{"$where", "a..b", "c.", "a\x00b", "", "ok"})
+		Convey("sanitizing should make each of them legal", func() {
+			opts := &ConvertOptions{SanitizeFields: true}
+			opts.sanitizeColumnNames(colSpecs)
+			So(ColumnNames(colSpecs), ShouldResemble, []string{"_where", "a._.b", "c._", "a_b", "_", "ok"})
+			So(validateFields(ColumnNames(colSpecs), false), ShouldBeNil)
+		})
+		Convey("sanitizing should use the configured substitute", func() {
+			opts := &ConvertOptions{SanitizeFields: true, FieldSubstitute: "x"}
+			opts.sanitizeColumnNames(colSpecs)
+			So(ColumnNames(colSpecs)[:2], ShouldResemble, []string{"xwhere", "a.x.b"})
+		})
+		Convey("names should be left alone unless SanitizeFields is set", func() {
+			opts := &ConvertOptions{}
+			opts.sanitizeColumnNames(colSpecs)
+			So(ColumnNames(colSpecs)[0], ShouldEqual, "$where")
 		})
 	})
 }
@@ -173,6 +228,28 @@ func TestGetUpsertValue(t *testing.T) {
 		Convey("the value of the key should be nil for nil document values", func() {
 			So(getUpsertValue("a", bson.D{{"a", nil}}), ShouldBeNil)
 		})
+		Convey("the value of the key should be correct for subdocument pointers", func() {
+			bsonDocument := bson.D{{"a", &bson.D{{"b", 4}}}}
+			So(getUpsertValue("a.b", bsonDocument), ShouldEqual, 4)
+		})
+	})
+}
+
+func TestUpsertKey(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Given a set of upsert fields and a BSON document, on calling UpsertKey", t, func() {
+		document := bson.D{{"region", "EU"}, {"order", &bson.D{{"no", 7}}}, {"note", nil}}
+		Convey("the key should hold the value of each field", func() {
+			key, err := UpsertKey([]string{"region", "order.no", "note"}, document)
+			So(err, ShouldBeNil)
+			So(key, ShouldResemble, bson.D{{"region", "EU"}, {"order.no", 7}, {"note", nil}})
+		})
+		Convey("a missing field should be an error", func() {
+			_, err := UpsertKey([]string{"region", "order.id"}, document)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "upsert field 'order.id' is missing")
+		})
 	})
 }
 
@@ -218,6 +295,25 @@ func TestConstructUpsertDocument(t *testing.T) {
 	})
 }
 
+func TestMergeUpdate(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+
+	Convey("Given a set of upsert fields and a BSON document, on calling mergeUpdate", t, func() {
+		Convey("subdocuments should be flattened into dotted fields", func() {
+			document := bson.D{{"_id", 1}, {"name", "Ann"}, {"address", &bson.D{{"city", "Dublin"}, {"geo", bson.D{{"lat", 53}}}}}}
+			So(mergeUpdate([]string{"_id"}, document), ShouldResemble,
+				bson.D{{"name", "Ann"}, {"address.city", "Dublin"}, {"address.geo.lat", 53}})
+		})
+		Convey("the upsert fields and the fields nested under them should be left out", func() {
+			document := bson.D{{"_id", bson.D{{"region", "EU"}, {"no", 7}}}, {"order", bson.D{{"no", 7}, {"qty", 2}}}}
+			So(mergeUpdate([]string{"_id", "order.no"}, document), ShouldResemble, bson.D{{"order.qty", 2}})
+		})
+		Convey("the update should be nil for a document with only its upsert key", func() {
+			So(mergeUpdate([]string{"a", "b"}, bson.D{{"b", 2}, {"a", 1}}), ShouldBeNil)
+		})
+	})
+}
+
 func TestSetNestedValue(t *testing.T) {
 	testutil.VerifyTestType(t, testutil.UnitTestType)
 
@@ -324,7 +420,7 @@ func TestTokensToBSON(t *testing.T) {
 				{"b", int32(2)},
 				{"c", "hello"},
 			}
-			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), false)
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), nil)
 			So(err, ShouldBeNil)
 			So(bsonD, ShouldResemble, expectedDocument)
 		})
@@ -343,7 +439,7 @@ func TestTokensToBSON(t *testing.T) {
 				{"field3", "mongodb"},
 				{"field4", "user"},
 			}
-			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), false)
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), nil)
 			So(err, ShouldBeNil)
 			So(bsonD, ShouldResemble, expectedDocument)
 		})
@@ -354,7 +450,7 @@ func TestTokensToBSON(t *testing.T) {
 				{"field3", new(FieldAutoParser), pgAutoCast, "auto"},
 			}
 			tokens := []string{"1", "2", "hello", "mongodb", "user"}
-			_, err := tokensToBSON(colSpecs, tokens, uint64(0), false)
+			_, err := tokensToBSON(colSpecs, tokens, uint64(0), nil)
 			So(err, ShouldNotBeNil)
 		})
 		Convey("fields with nested values should be set appropriately", func() {
@@ -372,7 +468,7 @@ func TestTokensToBSON(t *testing.T) {
 				{"b", int32(2)},
 				{"c", c},
 			}
-			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), false)
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), nil)
 			So(err, ShouldBeNil)
 			So(expectedDocument[0].Name, ShouldResemble, bsonD[0].Name)
 			So(expectedDocument[0].Value, ShouldResemble, bsonD[0].Value)
@@ -381,6 +477,527 @@ func TestTokensToBSON(t *testing.T) {
 			So(expectedDocument[2].Name, ShouldResemble, bsonD[2].Name)
 			So(expectedDocument[2].Value, ShouldResemble, *bsonD[2].Value.(*bson.D))
 		})
+		Convey("with StringsOnly set, untyped tokens should be kept as strings", func() {
+			colSpecs := []ColumnSpec{
+				{"code", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"exp", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"long", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"count", new(FieldInt32Parser), pgAutoCast, "int32"},
+			}
+			tokens := []string{"007", "1e5", "123456789012345678901234567890", "12", "42"}
+			expectedDocument := bson.D{
+				{"code", "007"},
+				{"exp", "1e5"},
+				{"long", "123456789012345678901234567890"},
+				{"count", int32(12)},
+				{"field4", "42"},
+			}
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), &ConvertOptions{StringsOnly: true})
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, expectedDocument)
+
+			Convey("and type coercion failures that auto cast should also "+
+				"produce strings", func() {
+				bsonD, err := tokensToBSON(colSpecs[3:], []string{"007"}, uint64(0), &ConvertOptions{StringsOnly: true})
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"count", int32(7)}})
+				bsonD, err = tokensToBSON(colSpecs[3:], []string{"1e5"}, uint64(0), &ConvertOptions{StringsOnly: true})
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"count", "1e5"}})
+			})
+		})
+		Convey("empty array cells should be empty arrays unless ignoreBlanks is set", func() {
+			arrayParser, err := NewFieldParser(ctArray, "")
+			So(err, ShouldBeNil)
+			colSpecs := []ColumnSpec{
+				{"tags", arrayParser, pgStop, "array"},
+			}
+			bsonD, err := tokensToBSON(colSpecs, []string{""}, uint64(0), nil)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"tags", []interface{}{}}})
+			bsonD, err = tokensToBSON(colSpecs, []string{""}, uint64(0), &ConvertOptions{IgnoreBlanks: true})
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{})
+		})
+		Convey("dotted fields sharing a prefix should be merged into one subdocument", func() {
+			colSpecs := []ColumnSpec{
+				{"address.city", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"name", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"address.zip", new(FieldStringParser), pgAutoCast, "string"},
+			}
+			tokens := []string{"Dublin", "Ann", "02134"}
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), nil)
+			So(err, ShouldBeNil)
+			So(len(bsonD), ShouldEqual, 2)
+			So(bsonD[0].Name, ShouldEqual, "address")
+			So(*bsonD[0].Value.(*bson.D), ShouldResemble, bson.D{{"city", "Dublin"}, {"zip", "02134"}})
+			So(bsonD[1], ShouldResemble, bson.DocElem{"name", "Ann"})
+
+			Convey("unless FlatFields is set", func() {
+				bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), &ConvertOptions{FlatFields: true})
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{
+					{"address.city", "Dublin"},
+					{"name", "Ann"},
+					{"address.zip", "02134"},
+				})
+			})
+		})
+		Convey("with TrimWhitespace set, tokens should be trimmed before they are parsed", func() {
+			colSpecs := []ColumnSpec{
+				{"count", new(FieldInt32Parser), pgStop, "int32"},
+				{"auto", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"name", new(FieldStringParser), pgStop, "string"},
+				{"blank", new(FieldStringParser), pgStop, "string"},
+			}
+			tokens := []string{" 42 ", "7\t", "  Ann  Lee\t", "   "}
+			_, err := tokensToBSON(colSpecs, tokens, uint64(0), nil)
+			So(err, ShouldNotBeNil)
+
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), &ConvertOptions{TrimWhitespace: true})
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{
+				{"count", int32(42)},
+				{"auto", int32(7)},
+				{"name", "Ann  Lee"},
+				{"blank", ""},
+			})
+
+			Convey("so that tokens of only whitespace count as blank", func() {
+				bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0),
+					&ConvertOptions{TrimWhitespace: true, IgnoreBlanks: true})
+				So(err, ShouldBeNil)
+				So(len(bsonD), ShouldEqual, 3)
+			})
+		})
+		Convey("boolean columns should accept the configured true and false tokens", func() {
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldBooleanParser), pgStop, "bool"},
+				{"b", new(FieldBooleanParser), pgStop, "bool"},
+				{"c", new(FieldBooleanParser), pgStop, "bool"},
+			}
+			bsonD, err := tokensToBSON(colSpecs, []string{"TRUE", "0", "1"}, uint64(0), nil)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"a", true}, {"b", false}, {"c", true}})
+
+			opts := &ConvertOptions{TrueTokens: []string{"Y", "yes", "1"}, FalseTokens: []string{"N", "no", "0"}}
+			bsonD, err = tokensToBSON(colSpecs, []string{"y", "NO", "1"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"a", true}, {"b", false}, {"c", true}})
+
+			_, err = tokensToBSON(colSpecs, []string{"y", "true", "1"}, uint64(0), opts)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'b' (column 2): cannot parse 'true' as bool: failed to parse boolean: "+
+				"true (true values: Y, yes, 1; false values: N, no, 0)")
+
+			_, err = tokensToBSON(colSpecs, []string{"y", strings.Repeat("x", 150), "1"}, uint64(0), opts)
+			So(err, ShouldNotBeNil)
+			conversionErr, ok := AsFieldConversionError(err)
+			So(ok, ShouldBeTrue)
+			So(conversionErr.Value, ShouldEqual, strings.Repeat("x", 100)+"...")
+			So(conversionErr.Err, ShouldNotBeNil)
+
+			opts.BooleanCaseSensitive = true
+			_, err = tokensToBSON(colSpecs, []string{"y", "N", "1"}, uint64(0), opts)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("numeric columns should use the configured separators", func() {
+			colSpecs := []ColumnSpec{
+				{"price", new(FieldDoubleParser), pgStop, "double"},
+				{"count", new(FieldInt64Parser), pgStop, "int64"},
+				{"total", new(FieldDecimalParser), pgStop, "decimal"},
+				{"code", new(FieldStringParser), pgStop, "string"},
+				{"auto", new(FieldAutoParser), pgStop, "auto"},
+			}
+			opts := &ConvertOptions{DecimalSeparator: ",", ThousandsSeparator: "."}
+			bsonD, err := tokensToBSON(colSpecs, []string{"1.234,56", "-1.234.567", "0,5", "1.234,56", "1.234"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			total, _ := bson.ParseDecimal128("0.5")
+			So(bsonD, ShouldResemble, bson.D{{"price", 1234.56}, {"count", int64(-1234567)},
+				{"total", total}, {"code", "1.234,56"}, {"auto", 1.234}})
+
+			Convey("and reject misplaced thousands separators", func() {
+				for _, price := range []string{"12.34,5", "1.2345,6", ".234,5", "1234.567,8", "1,234.5", "1.234,5.6"} {
+					_, err := tokensToBSON(colSpecs[:1], []string{price}, uint64(0), opts)
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldStartWith, "field 'price' (column 1): cannot parse '"+price+"' as double: ")
+				}
+			})
+			Convey("and reject '.' if it is not the decimal separator", func() {
+				opts.ThousandsSeparator = " "
+				bsonD, err := tokensToBSON(colSpecs[:1], []string{"1 234,5"}, uint64(0), opts)
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"price", 1234.5}})
+				_, err = tokensToBSON(colSpecs[:1], []string{"1.234"}, uint64(0), opts)
+				So(err, ShouldNotBeNil)
+			})
+			Convey("and accept thousands separators alone", func() {
+				opts = &ConvertOptions{ThousandsSeparator: ","}
+				bsonD, err := tokensToBSON(colSpecs[:2], []string{"1,234.56", "+12,345"}, uint64(0), opts)
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"price", 1234.56}, {"count", int64(12345)}})
+			})
+		})
+		Convey("blank tokens should take the column's default", func() {
+			colSpecs, err := ParseTypedHeaders([]string{"status.default(pending)", "retries.int32().default(0)", "name"}, pgStop)
+			So(err, ShouldBeNil)
+			bsonD, err := tokensToBSON(colSpecs, []string{"", " ", ""}, uint64(0), nil)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"status", "pending"}, {"retries", int32(0)}, {"name", ""}})
+			bsonD, err = tokensToBSON(colSpecs, []string{"done", "3", "x"}, uint64(0), nil)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"status", "done"}, {"retries", int32(3)}, {"name", "x"}})
+
+			Convey("even if IgnoreBlanks is set", func() {
+				bsonD, err := tokensToBSON(colSpecs, []string{"", "", ""}, uint64(0), &ConvertOptions{IgnoreBlanks: true})
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"status", "pending"}, {"retries", int32(0)}})
+			})
+			Convey("for the missing fields of short rows padded with null", func() {
+				opts := &ConvertOptions{ShortRows: RaggedPadWithNull}
+				bsonD, err := tokensToBSON(colSpecs, []string{"done"}, uint64(0), opts)
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"status", "done"}, {"retries", int32(0)}, {"name", nil}})
+			})
+			Convey("parsed with the options that tokens are parsed with", func() {
+				colSpecs, err := ParseTypedHeaders([]string{"active.boolean().default(ja)", "rate.double().default(1.234,5)"}, pgStop)
+				So(err, ShouldBeNil)
+				opts := &ConvertOptions{TrueTokens: []string{"ja"}, FalseTokens: []string{"nein"},
+					DecimalSeparator: ",", ThousandsSeparator: "."}
+				So(opts.validateColumns(colSpecs), ShouldBeNil)
+				bsonD, err := tokensToBSON(colSpecs, []string{"", ""}, uint64(0), opts)
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"active", true}, {"rate", 1234.5}})
+				So((&ConvertOptions{}).validateColumns(colSpecs), ShouldNotBeNil)
+			})
+		})
+		Convey("only the selected columns should be converted", func() {
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldInt32Parser), pgStop, "int32"},
+				{"b", new(FieldAutoParser), pgStop, "auto"},
+				{"c", new(FieldAutoParser), pgStop, "auto"},
+			}
+			opts := &ConvertOptions{ProjectFields: []string{"b", "c"}, ShortRows: RaggedPadWithNull}
+			So(opts.selectColumns(colSpecs), ShouldBeNil)
+			bsonD, err := tokensToBSON(colSpecs, []string{"x", "1", "2", "3"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"b", int32(1)}, {"c", int32(2)}})
+			bsonD, err = tokensToBSON(colSpecs, []string{"x"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"b", nil}, {"c", nil}})
+		})
+		Convey("dates should be in the time zone of their column", func() {
+			colSpecs := []ColumnSpec{
+				{"opened", &FieldDateParser{"2006-01-02 15:04"}, pgStop, "date"},
+				{"closed", &FieldDateParser{"2006-01-02 15:04"}, pgStop, "date"},
+				{"logged", &FieldDateParser{"2006-01-02 15:04Z07:00"}, pgStop, "date"},
+				{"count", new(FieldInt32Parser), pgStop, "int32"},
+			}
+			tokyo, err := ParseTimeZone("+09:00")
+			So(err, ShouldBeNil)
+			chicago, err := ParseTimeZone("America/Chicago")
+			So(err, ShouldBeNil)
+			opts := &ConvertOptions{TimeZone: tokyo, ColumnTimeZones: map[string]*time.Location{"closed": chicago}}
+			So(opts.selectColumns(colSpecs), ShouldBeNil)
+			tokens := []string{"2024-03-10 12:00", "2024-03-10 12:00", "2024-03-10 12:00-02:00", "1"}
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD[0].Value.(time.Time).UTC(), ShouldResemble, time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC))
+			So(bsonD[1].Value.(time.Time).UTC(), ShouldResemble, time.Date(2024, 3, 10, 17, 0, 0, 0, time.UTC))
+			So(bsonD[2].Value.(time.Time).UTC(), ShouldResemble, time.Date(2024, 3, 10, 14, 0, 0, 0, time.UTC))
+
+			Convey("which must be date columns in the header", func() {
+				opts.ColumnTimeZones = map[string]*time.Location{"count": chicago}
+				So(opts.selectColumns(colSpecs), ShouldNotBeNil)
+				opts.ColumnTimeZones = map[string]*time.Location{"missing": chicago}
+				So(opts.selectColumns(colSpecs), ShouldNotBeNil)
+			})
+		})
+		Convey("null tokens should be null regardless of the column type", func() {
+			colSpecs := []ColumnSpec{
+				{"count", new(FieldInt32Parser), pgStop, "int32"},
+				{"name", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"when", &FieldDateParser{"2006-01-02"}, pgStop, "date"},
+			}
+			tokens := []string{"NULL", "na", `\N`, "NA"}
+			_, err := tokensToBSON(colSpecs, tokens, uint64(0), nil)
+			So(err, ShouldNotBeNil)
+
+			opts := &ConvertOptions{NullTokens: []string{"NULL", `\N`, "NA"}}
+			bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"count", nil}, {"name", "na"}, {"when", nil}, {"field3", nil}})
+
+			Convey("and should match case-insensitively if requested", func() {
+				opts.NullTokensIgnoreCase = true
+				bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), opts)
+				So(err, ShouldBeNil)
+				So(bsonD[1], ShouldResemble, bson.DocElem{"name", nil})
+			})
+			Convey("and should be omitted if IgnoreBlanks is set", func() {
+				opts.IgnoreBlanks = true
+				bsonD, err := tokensToBSON(colSpecs, tokens, uint64(0), opts)
+				So(err, ShouldBeNil)
+				So(bsonD, ShouldResemble, bson.D{{"name", "na"}})
+			})
+		})
+		Convey("ragged rows should be handled according to the row policies", func() {
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"c.d", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			short := []string{"1", "2"}
+			long := []string{"1", "2", "3", "4"}
+			opts := func(shortRows, longRows RaggedRowPolicy) *ConvertOptions {
+				return &ConvertOptions{ShortRows: shortRows, LongRows: longRows}
+			}
+
+			Convey("rows with exactly as many tokens as columns should be unaffected", func() {
+				for _, policy := range []RaggedRowPolicy{RaggedError, RaggedPadWithNull, RaggedTruncateExtra} {
+					bsonD, err := tokensToBSON(colSpecs, []string{"1", "2", "3"}, uint64(0), opts(policy, policy))
+					So(err, ShouldBeNil)
+					So(len(bsonD), ShouldEqual, 3)
+				}
+			})
+			Convey("short rows should error, be padded with null, or omit trailing fields", func() {
+				_, err := tokensToBSON(colSpecs, short, uint64(0), opts(RaggedError, RaggedDefault))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "row has 2 fields, fewer than the 3 columns (short row policy: error)")
+
+				bsonD, err := tokensToBSON(colSpecs, short, uint64(0), opts(RaggedPadWithNull, RaggedDefault))
+				So(err, ShouldBeNil)
+				So(len(bsonD), ShouldEqual, 3)
+				So(bsonD[2].Name, ShouldEqual, "c")
+				So(*bsonD[2].Value.(*bson.D), ShouldResemble, bson.D{{"d", nil}})
+
+				for _, policy := range []RaggedRowPolicy{RaggedDefault, RaggedPadWithMissing} {
+					bsonD, err = tokensToBSON(colSpecs, short, uint64(0), opts(policy, RaggedDefault))
+					So(err, ShouldBeNil)
+					So(bsonD, ShouldResemble, bson.D{{"a", int32(1)}, {"b", int32(2)}})
+				}
+			})
+			Convey("long rows should error, drop the extras, or collect them", func() {
+				_, err := tokensToBSON(colSpecs, long, uint64(0), opts(RaggedDefault, RaggedError))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "row has 4 fields, more than the 3 columns (long row policy: error)")
+
+				bsonD, err := tokensToBSON(colSpecs, long, uint64(0), opts(RaggedDefault, RaggedTruncateExtra))
+				So(err, ShouldBeNil)
+				So(len(bsonD), ShouldEqual, 3)
+
+				for _, policy := range []RaggedRowPolicy{RaggedDefault, RaggedCollectExtraIntoField} {
+					bsonD, err = tokensToBSON(colSpecs, long, uint64(0), opts(RaggedDefault, policy))
+					So(err, ShouldBeNil)
+					So(len(bsonD), ShouldEqual, 4)
+					So(bsonD[3], ShouldResemble, bson.DocElem{"field3", int32(4)})
+				}
+			})
+			Convey("a trailing empty token should count towards the length of a row", func() {
+				trailing := []string{"1", "2", "3", ""}
+				_, err := tokensToBSON(colSpecs, trailing, uint64(0), opts(RaggedDefault, RaggedError))
+				So(err, ShouldNotBeNil)
+				bsonD, err := tokensToBSON(colSpecs, trailing, uint64(0),
+					&ConvertOptions{LongRows: RaggedCollectExtraIntoField, IgnoreBlanks: true})
+				So(err, ShouldBeNil)
+				So(len(bsonD), ShouldEqual, 3)
+
+				// a short row whose last token is empty is still one token short
+				_, err = tokensToBSON(colSpecs, []string{"1", ""}, uint64(0), opts(RaggedError, RaggedDefault))
+				So(err, ShouldNotBeNil)
+				bsonD, err = tokensToBSON(colSpecs, []string{"1", ""}, uint64(0), opts(RaggedPadWithNull, RaggedDefault))
+				So(err, ShouldBeNil)
+				So(bsonD[1], ShouldResemble, bson.DocElem{"b", ""})
+			})
+			Convey("a single trailing empty token should be dropped if AllowTrailingDelimiter is set", func() {
+				trailing := &ConvertOptions{LongRows: RaggedError, ShortRows: RaggedError, AllowTrailingDelimiter: true}
+				bsonD, err := tokensToBSON(colSpecs, []string{"1", "2", "3", ""}, uint64(0), trailing)
+				So(err, ShouldBeNil)
+				So(len(bsonD), ShouldEqual, 3)
+
+				// an empty last column is kept when the counts already match
+				bsonD, err = tokensToBSON(colSpecs, []string{"1", "2", ""}, uint64(0), trailing)
+				So(err, ShouldBeNil)
+				So(*bsonD[2].Value.(*bson.D), ShouldResemble, bson.D{{"d", ""}})
+
+				// other counts are still handled by the row policies
+				_, err = tokensToBSON(colSpecs, []string{"1", "2", "3", "", ""}, uint64(0), trailing)
+				So(err, ShouldNotBeNil)
+				_, err = tokensToBSON(colSpecs, []string{"1", "2", "3", "4"}, uint64(0), trailing)
+				So(err, ShouldNotBeNil)
+				_, err = tokensToBSON(colSpecs, []string{"1", ""}, uint64(0), trailing)
+				So(err, ShouldNotBeNil)
+				trailing.LongRows = RaggedCollectExtraIntoField
+				bsonD, err = tokensToBSON(colSpecs, []string{"1", "2", "3", "", ""}, uint64(0), trailing)
+				So(err, ShouldBeNil)
+				So(len(bsonD), ShouldEqual, 5)
+			})
+			Convey("policies that do not apply to the row's length should be rejected", func() {
+				_, err := tokensToBSON(colSpecs, short, uint64(0), opts(RaggedTruncateExtra, RaggedDefault))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "truncateExtra")
+				_, err = tokensToBSON(colSpecs, long, uint64(0), opts(RaggedDefault, RaggedPadWithNull))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "padWithNull")
+			})
+		})
+	})
+}
+
+func TestShortRows(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	// the CSV and TSV readers should treat short rows the same way
+	contents := "a\tb\tc\td\te\n1\t2\n1\t2\t\t\t\n"
+	newReaders := map[string]func(policy RaggedRowPolicy, ignoreBlanks bool) InputReader{
+		"CSV": func(policy RaggedRowPolicy, ignoreBlanks bool) InputReader {
+			r := NewCSVInputReader(nil, strings.NewReader(strings.Replace(contents, "\t", ",", -1)), ioutil.Discard, 1, ignoreBlanks)
+			r.ShortRows = policy
+			return r
+		},
+		"TSV": func(policy RaggedRowPolicy, ignoreBlanks bool) InputReader {
+			r := NewTSVInputReader(nil, strings.NewReader(contents), ioutil.Discard, 1, ignoreBlanks)
+			r.ShortRows = policy
+			return r
+		},
+	}
+	for name, newReader := range newReaders {
+		Convey("With a "+name+" input reader of a row that stops short and one that ends in empty cells", t, func() {
+			stream := func(policy RaggedRowPolicy, ignoreBlanks bool) ([]bson.D, error) {
+				r := newReader(policy, ignoreBlanks)
+				if err := r.ReadAndValidateHeader(); err != nil {
+					return nil, err
+				}
+				docChan := make(chan bson.D, 2)
+				err := r.StreamDocument(true, docChan)
+				var docs []bson.D
+				for doc := range docChan {
+					docs = append(docs, doc)
+				}
+				return docs, err
+			}
+			empty := bson.D{{"a", int32(1)}, {"b", int32(2)}, {"c", ""}, {"d", ""}, {"e", ""}}
+			blank := bson.D{{"a", int32(1)}, {"b", int32(2)}}
+
+			Convey("the missing columns should be missing by default, but the empty ones empty", func() {
+				for _, policy := range []RaggedRowPolicy{RaggedDefault, RaggedPadWithMissing} {
+					docs, err := stream(policy, false)
+					So(err, ShouldBeNil)
+					So(docs, ShouldResemble, []bson.D{blank, empty})
+				}
+			})
+			Convey("the missing columns should be null with RaggedPadWithNull", func() {
+				docs, err := stream(RaggedPadWithNull, false)
+				So(err, ShouldBeNil)
+				So(docs, ShouldResemble, []bson.D{{{"a", int32(1)}, {"b", int32(2)}, {"c", nil}, {"d", nil}, {"e", nil}}, empty})
+			})
+			Convey("the missing columns should be empty with RaggedPadWithEmpty, and follow IgnoreBlanks", func() {
+				docs, err := stream(RaggedPadWithEmpty, false)
+				So(err, ShouldBeNil)
+				So(docs, ShouldResemble, []bson.D{empty, empty})
+				docs, err = stream(RaggedPadWithEmpty, true)
+				So(err, ShouldBeNil)
+				So(docs, ShouldResemble, []bson.D{blank, blank})
+			})
+			Convey("the short row, but not the empty cells, should fail with RaggedError", func() {
+				_, err := stream(RaggedError, false)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "row has 2 fields, fewer than the 5 columns")
+				So(err.Error(), ShouldStartWith, "record #1 (line 2)")
+			})
+		})
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With string values that are not valid UTF-8", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"b", new(FieldStringParser), pgAutoCast, "string"},
+			{"c", new(FieldBinaryParser), pgAutoCast, "binary"},
+		}
+		tokens := []string{"ok", "caf\xe9 \xff\xfe!", "AQ=="}
+
+		Convey("the default policy should fail the record, saying where", func() {
+			_, err := tokensToBSON(colSpecs, tokens, 0, &ConvertOptions{})
+			So(err, ShouldNotBeNil)
+			So(ErrorCategory(err), ShouldEqual, FailureEncoding)
+			So(err.Error(), ShouldContainSubstring, "field 'b' (column 2): invalid UTF-8 at byte 3 of the value")
+		})
+		Convey("replace should replace each run of invalid bytes, counting them", func() {
+			var numReplaced uint64
+			opts := &ConvertOptions{InvalidUTF8: InvalidUTF8Replace, utf8Replacements: &numReplaced}
+			document, err := tokensToBSON(colSpecs, tokens, 0, opts)
+			So(err, ShouldBeNil)
+			So(document[1].Value, ShouldEqual, "caf\ufffd \ufffd!")
+			So(numReplaced, ShouldEqual, 2)
+		})
+		Convey("skip should drop the record", func() {
+			_, err := tokensToBSON(colSpecs, tokens, 0, &ConvertOptions{InvalidUTF8: InvalidUTF8Skip})
+			So(err, ShouldHaveSameTypeAs, skippedRecordError{})
+		})
+		Convey("values that fall back to strings, and extra tokens, should be checked too", func() {
+			_, err := tokensToBSON(colSpecs[2:], []string{"\xff"}, 0, &ConvertOptions{})
+			So(err, ShouldNotBeNil)
+			document, err := tokensToBSON(colSpecs[:1], []string{"1", "\xff"}, 0, &ConvertOptions{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "field 'field1' (column 2)")
+			document, err = tokensToBSON(colSpecs[:1], []string{"1", "2"}, 0, &ConvertOptions{})
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, bson.D{{"a", int32(1)}, {"field1", int32(2)}})
+		})
+	})
+
+	Convey("With a CSV input reader of records with invalid UTF-8", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+		input := "1,caf\xe9\n2,ok\n3,\"\xff\"\n"
+		stream := func(policy InvalidUTF8Policy, rejects io.Writer) ([]bson.D, StreamSummary, error) {
+			r := NewCSVInputReader(colSpecs, strings.NewReader(input), ioutil.Discard, 1, false)
+			r.InvalidUTF8 = policy
+			r.Rejects = rejects
+			docChan := make(chan bson.D, 3)
+			err := r.StreamDocument(true, docChan)
+			var docs []bson.D
+			for doc := range docChan {
+				docs = append(docs, doc)
+			}
+			return docs, r.Summary(), err
+		}
+
+		Convey("the bytes should reach the policy as they were", func() {
+			_, _, err := stream(InvalidUTF8Error, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "record #1 (line 1): field 'b' (column 2): invalid UTF-8 at byte 3")
+		})
+		Convey("replace should count the replacements in the summary", func() {
+			docs, summary, err := stream(InvalidUTF8Replace, nil)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{
+				{{"a", int32(1)}, {"b", "caf\ufffd"}},
+				{{"a", int32(2)}, {"b", "ok"}},
+				{{"a", int32(3)}, {"b", "\ufffd"}},
+			})
+			So(summary.InvalidUTF8Replaced, ShouldEqual, 2)
+		})
+		Convey("skip should drop the records and count them as rejected", func() {
+			var rejects bytes.Buffer
+			docs, summary, err := stream(InvalidUTF8Skip, &rejects)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{{{"a", int32(2)}, {"b", "ok"}}})
+			So(summary.Rejected, ShouldEqual, 2)
+			So(summary.Failed, ShouldEqual, 0)
+			So(rejects.String(), ShouldEqual, "1,caf\xe9\n3,\"\xff\"\n")
+
+			Convey("even without Rejects or MaxErrors", func() {
+				docs, summary, err := stream(InvalidUTF8Skip, nil)
+				So(err, ShouldBeNil)
+				So(len(docs), ShouldEqual, 1)
+				So(summary.Rejected, ShouldEqual, 2)
+			})
+		})
 	})
 }
 
@@ -421,40 +1038,18 @@ func TestProcessDocuments(t *testing.T) {
 			},
 		}
 		Convey("processDocuments should execute the expected conversion for documents, "+
-			"pass then on the output channel, and close the input channel if ordered is true", func() {
-			inputChannel := make(chan Converter, 100)
-			outputChannel := make(chan bson.D, 100)
-			iw := &importWorker{
-				unprocessedDataChan:   inputChannel,
-				processedDocumentChan: outputChannel,
-				tomb: &tomb.Tomb{},
-			}
-			inputChannel <- csvConverters[0]
-			inputChannel <- csvConverters[1]
-			close(inputChannel)
-			So(iw.processDocuments(true), ShouldBeNil)
-			doc1, open := <-outputChannel
-			So(doc1, ShouldResemble, expectedDocuments[0])
-			So(open, ShouldEqual, true)
-			doc2, open := <-outputChannel
-			So(doc2, ShouldResemble, expectedDocuments[1])
-			So(open, ShouldEqual, true)
-			_, open = <-outputChannel
-			So(open, ShouldEqual, false)
-		})
-		Convey("processDocuments should execute the expected conversion for documents, "+
-			"pass then on the output channel, and leave the input channel open if ordered is false", func() {
+			"pass then on the output channel, and leave the output channel open", func() {
 			inputChannel := make(chan Converter, 100)
 			outputChannel := make(chan bson.D, 100)
 			iw := &importWorker{
-				unprocessedDataChan:   inputChannel,
-				processedDocumentChan: outputChannel,
-				tomb: &tomb.Tomb{},
+				unprocessedDataChan: inputChannel,
+				sink:                docSink(outputChannel),
+				tomb:                &tomb.Tomb{},
 			}
 			inputChannel <- csvConverters[0]
 			inputChannel <- csvConverters[1]
 			close(inputChannel)
-			So(iw.processDocuments(false), ShouldBeNil)
+			So(iw.processDocuments(), ShouldBeNil)
 			doc1, open := <-outputChannel
 			So(doc1, ShouldResemble, expectedDocuments[0])
 			So(open, ShouldEqual, true)
@@ -467,47 +1062,69 @@ func TestProcessDocuments(t *testing.T) {
 	})
 }
 
-func TestDoSequentialStreaming(t *testing.T) {
+// gatedConverter converts to a document holding its index, once its gate,
+// if it has one, is closed, counting its conversions.
+type gatedConverter struct {
+	index     int
+	gate      chan struct{}
+	converted *int32
+}
+
+func (c gatedConverter) Convert() (bson.D, error) {
+	if c.gate != nil {
+		<-c.gate
+	}
+	atomic.AddInt32(c.converted, 1)
+	return bson.D{{"index", c.index}}, nil
+}
+
+func TestDoOrderedStreaming(t *testing.T) {
 	testutil.VerifyTestType(t, testutil.UnitTestType)
 
-	Convey("Given some import workers, a Converters input channel and an bson.D output channel", t, func() {
-		inputChannel := make(chan Converter, 5)
-		outputChannel := make(chan bson.D, 5)
-		workerInputChannel := []chan Converter{
-			make(chan Converter),
-			make(chan Converter),
-		}
-		workerOutputChannel := []chan bson.D{
-			make(chan bson.D),
-			make(chan bson.D),
-		}
-		importWorkers := []*importWorker{
-			&importWorker{
-				unprocessedDataChan:   workerInputChannel[0],
-				processedDocumentChan: workerOutputChannel[0],
-				tomb: &tomb.Tomb{},
-			},
-			&importWorker{
-				unprocessedDataChan:   workerInputChannel[1],
-				processedDocumentChan: workerOutputChannel[1],
-				tomb: &tomb.Tomb{},
-			},
-		}
+	Convey("Given a Converters input channel and an bson.D output channel", t, func() {
+		inputChannel := make(chan Converter, 20)
+		outputChannel := make(chan bson.D, 20)
+
 		Convey("documents moving through the input channel should be processed and returned in sequence", func() {
-			// start goroutines to do sequential processing
-			for _, iw := range importWorkers {
-				go iw.processDocuments(true)
-			}
-			// feed in a bunch of documents
 			for _, inputCSVDocument := range csvConverters {
 				inputChannel <- inputCSVDocument
 			}
 			close(inputChannel)
-			doSequentialStreaming(importWorkers, inputChannel, outputChannel)
+			doOrderedStreaming(context.Background(), new(tomb.Tomb), 2, 4, inputChannel, docSink(outputChannel))
 			for _, document := range expectedDocuments {
 				So(<-outputChannel, ShouldResemble, document)
 			}
 		})
+		Convey("a slow record should hold up only the streaming of those after it, "+
+			"and only as many as fit in the window should be converted", func() {
+			gate := make(chan struct{})
+			var converted int32
+			for i := 0; i < 20; i++ {
+				c := gatedConverter{index: i, converted: &converted}
+				if i == 0 {
+					c.gate = gate
+				}
+				inputChannel <- c
+			}
+			close(inputChannel)
+			done := make(chan error)
+			go func() {
+				done <- streamDocuments(true, 3, 5, inputChannel, outputChannel)
+			}()
+			deadline := time.Now().Add(5 * time.Second)
+			for atomic.LoadInt32(&converted) < 4 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+			So(atomic.LoadInt32(&converted), ShouldEqual, 4)
+			So(len(outputChannel), ShouldEqual, 0)
+
+			close(gate)
+			So(<-done, ShouldBeNil)
+			for i := 0; i < 20; i++ {
+				So(<-outputChannel, ShouldResemble, bson.D{{"index", i}})
+			}
+		})
 	})
 }
 
@@ -527,7 +1144,7 @@ func TestStreamDocuments(t *testing.T) {
 				inputChannel <- csvConverter
 			}
 			close(inputChannel)
-			So(streamDocuments(true, 3, inputChannel, outputChannel), ShouldBeNil)
+			So(streamDocuments(true, 3, 0, inputChannel, outputChannel), ShouldBeNil)
 
 			// ensure documents are streamed out and processed in the correct manner
 			for _, expectedDocument := range expectedDocuments {
@@ -548,7 +1165,29 @@ func TestStreamDocuments(t *testing.T) {
 			close(inputChannel)
 
 			// ensure that an error is returned on the error channel
-			So(streamDocuments(true, 3, inputChannel, outputChannel), ShouldNotBeNil)
+			So(streamDocuments(true, 3, 0, inputChannel, outputChannel), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestNumDecoders(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Given the number of decoders asked of an input reader", t, func() {
+		numDecoders := func(n int) int {
+			return NewTSVInputReader(nil, bytes.NewReader(nil), nil, n, false).NumDecoders()
+		}
+
+		Convey("zero or fewer should pick the default of one for each CPU", func() {
+			So(DefaultNumDecoders(), ShouldEqual, runtime.NumCPU())
+			So(numDecoders(0), ShouldEqual, DefaultNumDecoders())
+			So(numDecoders(-3), ShouldEqual, DefaultNumDecoders())
+		})
+		Convey("a reasonable number should be used as is", func() {
+			So(numDecoders(1), ShouldEqual, 1)
+			So(numDecoders(MaxNumDecoders), ShouldEqual, MaxNumDecoders)
+		})
+		Convey("too many should be capped", func() {
+			So(numDecoders(10000), ShouldEqual, MaxNumDecoders)
 		})
 	})
 }
@@ -604,3 +1243,246 @@ func TestFilterIngestError(t *testing.T) {
 		})
 	})
 }
+
+func TestStreamMaps(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader", t, func() {
+		contents := "1,x,a\n2,y,b\n3,z,c\n"
+		colSpecs := ParseAutoHeaders([]string{"n", "s.t", "s.u"})
+		newReader := func(contents string) *CSVInputReader {
+			return NewCSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), nil, 3, false)
+		}
+		readAll := func(read chan bson.M) (maps []bson.M) {
+			for m := range read {
+				maps = append(maps, m)
+			}
+			return maps
+		}
+
+		Convey("ordered streaming of maps should keep the input order", func() {
+			read := make(chan bson.M, 3)
+			So(StreamMaps(newReader(contents), true, read), ShouldBeNil)
+			So(readAll(read), ShouldResemble, []bson.M{
+				{"n": int32(1), "s": bson.M{"t": "x", "u": "a"}},
+				{"n": int32(2), "s": bson.M{"t": "y", "u": "b"}},
+				{"n": int32(3), "s": bson.M{"t": "z", "u": "c"}},
+			})
+		})
+		Convey("unordered streaming of maps should stream every document", func() {
+			read := make(chan bson.M, 3)
+			So(StreamMaps(newReader(contents), false, read), ShouldBeNil)
+			So(len(readAll(read)), ShouldEqual, 3)
+		})
+		Convey("a reader should stream bson.D again once streaming maps is done", func() {
+			r := newReader(contents)
+			So(StreamMaps(r, true, make(chan bson.M, 3)), ShouldBeNil)
+			docs := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docs), ShouldBeNil)
+			_, open := <-docs
+			So(open, ShouldBeFalse)
+		})
+		Convey("failures should be handled as when streaming bson.D", func() {
+			colSpecs = []ColumnSpec{{"n", new(FieldInt32Parser), pgStop, "int32"}}
+			read := make(chan bson.M, 3)
+			err := StreamMaps(newReader("1\nx\n3\n"), true, read)
+			So(err, ShouldNotBeNil)
+			So(readAll(read), ShouldResemble, []bson.M{{"n": int32(1)}})
+
+			r := newReader("1\nx\n3\n")
+			r.MaxErrors = 1
+			read = make(chan bson.M, 3)
+			So(StreamMaps(r, true, read), ShouldBeNil)
+			So(readAll(read), ShouldResemble, []bson.M{{"n": int32(1)}, {"n": int32(3)}})
+		})
+	})
+	Convey("A document converted to a map", t, func() {
+		Convey("should keep the last value of a duplicate name", func() {
+			So(DocumentMap(bson.D{{"a", 1}, {"a", 2}}), ShouldResemble, bson.M{"a": 2})
+		})
+		Convey("should convert documents within arrays", func() {
+			So(DocumentMap(bson.D{{"a", []interface{}{bson.D{{"b", 1}}, 2}}}), ShouldResemble,
+				bson.M{"a": []interface{}{bson.M{"b": 1}, 2}})
+		})
+	})
+}
+
+func TestStreamRaw(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader", t, func() {
+		colSpecs := ParseAutoHeaders([]string{"n", "s"})
+		newReader := func() *CSVInputReader {
+			return NewCSVInputReader(colSpecs, bytes.NewReader([]byte("1,x\n2,y\n3,z\n")), nil, 3, false)
+		}
+		readAll := func(read chan []byte) (documents []bson.D) {
+			for raw := range read {
+				var document bson.D
+				So(bson.Unmarshal(raw, &document), ShouldBeNil)
+				documents = append(documents, document)
+			}
+			return documents
+		}
+		expected := []bson.D{{{"n", 1}, {"s", "x"}}, {{"n", 2}, {"s", "y"}}, {{"n", 3}, {"s", "z"}}}
+
+		Convey("ordered streaming should stream the marshalled documents in order", func() {
+			read := make(chan []byte, 3)
+			So(StreamRaw(newReader(), true, read), ShouldBeNil)
+			So(readAll(read), ShouldResemble, expected)
+		})
+		Convey("batched, unordered streaming should stream every document", func() {
+			r := newReader()
+			r.BatchSize = 2
+			read := make(chan []byte, 3)
+			So(StreamRaw(r, false, read), ShouldBeNil)
+			So(len(readAll(read)), ShouldEqual, 3)
+		})
+		Convey("a document that is too large should fail to convert", func() {
+			transform := func(document bson.D) (bson.D, error) {
+				if document[0].Value == int32(2) {
+					return bson.D{{"big", strings.Repeat("x", db.MaxBSONSize)}}, nil
+				}
+				return document, nil
+			}
+			r := newReader()
+			r.Transform = transform
+			err := StreamRaw(r, true, make(chan []byte, 3))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "more than the maximum of")
+
+			r = newReader()
+			r.Transform = transform
+			r.MaxErrors = 1
+			read := make(chan []byte, 3)
+			So(StreamRaw(r, true, read), ShouldBeNil)
+			So(readAll(read), ShouldResemble, []bson.D{expected[0], expected[2]})
+			So(r.Failures(), ShouldResemble, map[FailureCategory]uint64{FailureSize: 1})
+		})
+	})
+}
+
+func TestMaxDocumentSize(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader with a MaxDocumentSize", t, func() {
+		colSpecs := ParseAutoHeaders([]string{"n", "s"})
+		contents := "1,x\n2," + strings.Repeat("y", 30) + "\n3,z\n"
+		newReader := func(maxSize int) *CSVInputReader {
+			r := NewCSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), nil, 3, false)
+			r.MaxDocumentSize = maxSize
+			return r
+		}
+		stream := func(r *CSVInputReader) ([]bson.D, error) {
+			read := make(chan bson.D, 3)
+			err := r.StreamDocument(true, read)
+			var docs []bson.D
+			for doc := range read {
+				docs = append(docs, doc)
+			}
+			return docs, err
+		}
+
+		Convey("a larger document should fail to convert, naming its record and size", func() {
+			_, err := stream(newReader(40))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "record #2: document is 50 bytes, more than the maximum of 40")
+			So(ErrorCategory(err), ShouldEqual, FailureSize)
+
+			r := newReader(40)
+			r.MaxErrors = 1
+			docs, err := stream(r)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{{{"n", int32(1)}, {"s", "x"}}, {{"n", int32(3)}, {"s", "z"}}})
+			So(r.Failures(), ShouldResemble, map[FailureCategory]uint64{FailureSize: 1})
+		})
+		Convey("skipped records should count towards the record's number", func() {
+			r := newReader(40)
+			r.SkipRecords = 1
+			_, err := stream(r)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #2: ")
+		})
+		Convey("a document larger than the server accepts should fail by default", func() {
+			r := newReader(0)
+			r.Transform = func(document bson.D) (bson.D, error) {
+				if document[0].Value == int32(3) {
+					return bson.D{{"big", strings.Repeat("x", db.MaxBSONSize)}}, nil
+				}
+				return document, nil
+			}
+			docs, err := stream(r)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "record #3: document is")
+			So(len(docs), ShouldEqual, 2)
+		})
+		Convey("a negative size should check nothing", func() {
+			docs, err := stream(newReader(-1))
+			So(err, ShouldBeNil)
+			So(len(docs), ShouldEqual, 3)
+		})
+		Convey("streaming raw should check the documents it marshals", func() {
+			err := StreamRaw(newReader(40), true, make(chan []byte, 3))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "record #2: document is 50 bytes, more than the maximum of 40")
+		})
+	})
+}
+
+func TestStreamMetrics(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader reporting metrics", t, func() {
+		colSpecs := []ColumnSpec{{"n", new(FieldInt32Parser), pgStop, "int32"}}
+		contents := "1\n2\n3\n4\n5\n6\n7\n"
+		var snapshots []StreamMetrics
+		newReader := func(contents string, interval uint64) *CSVInputReader {
+			r := NewCSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), nil, 2, false)
+			r.MetricsInterval = interval
+			r.Metrics = func(metrics StreamMetrics) {
+				snapshots = append(snapshots, metrics)
+			}
+			return r
+		}
+
+		Convey("the callback should be called with a final snapshot of the totals", func() {
+			So(newReader(contents, 2).StreamDocument(true, make(chan bson.D, 7)), ShouldBeNil)
+			So(len(snapshots), ShouldBeBetweenOrEqual, 1, 4)
+			final := snapshots[len(snapshots)-1]
+			So(final.Final, ShouldBeTrue)
+			So(final.RecordsRead, ShouldEqual, 7)
+			So(final.RecordsConverted, ShouldEqual, 7)
+			So(final.BytesRead, ShouldEqual, len(contents))
+			for _, metrics := range snapshots[:len(snapshots)-1] {
+				So(metrics.Final, ShouldBeFalse)
+				So(metrics.RecordsConverted, ShouldBeGreaterThanOrEqualTo, 2)
+			}
+		})
+		Convey("without an interval, the callback should only be called at the end", func() {
+			So(newReader(contents, 0).StreamDocument(false, make(chan bson.D, 7)), ShouldBeNil)
+			So(len(snapshots), ShouldEqual, 1)
+			So(snapshots[0].Final, ShouldBeTrue)
+		})
+		Convey("the callback should be called at the end of a stream that fails", func() {
+			So(newReader("1\n2\nx\n4\n", 1).StreamDocument(true, make(chan bson.D, 4)), ShouldNotBeNil)
+			final := snapshots[len(snapshots)-1]
+			So(final.Final, ShouldBeTrue)
+			So(final.RecordsFailed, ShouldEqual, 1)
+		})
+		Convey("a slow callback should not hold up conversion", func() {
+			r := newReader(contents, 1)
+			release := make(chan struct{})
+			r.Metrics = func(metrics StreamMetrics) {
+				<-release
+				snapshots = append(snapshots, metrics)
+			}
+			docChan := make(chan bson.D)
+			errChan := make(chan error)
+			go func() {
+				errChan <- r.StreamDocument(true, docChan)
+			}()
+			for i := 0; i < 7; i++ {
+				<-docChan
+			}
+			close(release)
+			So(<-errChan, ShouldBeNil)
+			So(snapshots[len(snapshots)-1].RecordsConverted, ShouldEqual, 7)
+			So(len(snapshots), ShouldBeLessThan, 7)
+		})
+	})
+}
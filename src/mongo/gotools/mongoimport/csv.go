@@ -7,16 +7,28 @@
 package mongoimport
 
 import (
+	"bytes"
+	"context"
 	gocsv "encoding/csv"
 	"fmt"
 	"io"
+	"strings"
+	"sync/atomic"
 
+	"github.com/mongodb/mongo-tools/common/log"
 	"github.com/mongodb/mongo-tools/mongoimport/csv"
 	"gopkg.in/mgo.v2/bson"
 )
 
 // CSVInputReader implements the InputReader interface for CSV input types.
+//
+// The exported fields can be changed to customize the details before the
+// first call to ReadAndValidateHeader or StreamDocument.
 type CSVInputReader struct {
+	// SkipLines is the number of leading lines to discard before the header
+	// or first record. They still count towards the line numbers in errors.
+	SkipLines int
+
 	// colSpecs is a list of column specifications in the BSON documents to be imported
 	colSpecs []ColumnSpec
 
@@ -29,62 +41,120 @@ type CSVInputReader struct {
 	// csvRecord stores each line of input we read from the underlying reader
 	csvRecord []string
 
-	// numProcessed tracks the number of CSV records processed by the underlying reader
+	// numProcessed is the number of CSV records read in full, whether handed
+	// over to be converted or skipped by SkipRecords, but not one that fails
+	// to be read, so the one that does is number numProcessed+1; it is
+	// updated atomically, so it can be read while StreamDocument runs
 	numProcessed uint64
 
-	// numDecoders is the number of concurrent goroutines to use for decoding
-	numDecoders int
-
 	// embedded sizeTracker exposes the Size() method to check the number of bytes read so far
 	sizeTracker
 
-	// ignoreBlanks is whether empty fields should be ignored
-	ignoreBlanks bool
+	// Quote is the character that quoted fields start and end with, '"' if
+	// zero. Escape, if set to a character other than Quote, makes the
+	// character after it part of the field, rather than doubled quotes
+	// standing for a quote. LazyQuotes accepts quotes within unquoted fields,
+	// quotes in quoted fields that are not escaped, and an escape character
+	// at the end of the input. Strict instead requires RFC 4180 exactly,
+	// with \r\n ending each record and quotes only doubled, and fails on the
+	// first line that breaks it; leading white space is then kept. They apply
+	// to the header and to every record, and so must be set before either is
+	// read.
+	Quote      rune
+	Escape     rune
+	LazyQuotes bool
+	Strict     bool
+
+	// DetectDelimiter, if set, has each record split on the delimiter that
+	// SniffDelimiter guesses from the input after SkipLines, rather than on
+	// commas, once the header or first record is read. Reading fails if the
+	// guess is not confident enough.
+	DetectDelimiter bool
+
+	// Encoding is the character encoding of the input, which is converted
+	// to UTF-8 as it is read, and so must be set before anything is.
+	Encoding InputEncoding
+
+	// embedded ConvertOptions controls how each record's tokens are converted
+	ConvertOptions
+
+	// embedded StreamOptions controls how records that fail to convert are handled
+	StreamOptions
+
+	// originalHeader is the column names read from the header, before normalization
+	originalHeader []string
+
+	// skippedLines is the number of leading lines discarded so far
+	skippedLines int
+
+	// comma is the delimiter that DetectDelimiter detected, or 0 until then
+	comma rune
+
+	// peeked are the records read by GenerateFields or ReadAndDetectHeader,
+	// which are streamed before any more are read
+	peeked []peekedFields
 }
 
 // CSVConverter implements the Converter interface for CSV input.
 type CSVConverter struct {
 	colSpecs     []ColumnSpec
 	data         []string
+	raw          []byte
 	index        uint64
-	ignoreBlanks bool
+	line         uint64
 	rejectWriter *gocsv.Writer
+	options      *ConvertOptions
 }
 
 // NewCSVInputReader returns a CSVInputReader configured to read data from the
-// given io.Reader, extracting only the specified columns using exactly "numDecoders"
-// goroutines.
+// given io.Reader, extracting only the specified columns using "numDecoders"
+// goroutines, as NumDecoders reports.
 func NewCSVInputReader(colSpecs []ColumnSpec, in io.Reader, rejects io.Writer, numDecoders int, ignoreBlanks bool) *CSVInputReader {
-	szCount := newSizeTrackingReader(newBomDiscardingReader(in))
-	csvReader := csv.NewReader(szCount)
-	// allow variable number of colSpecs in document
-	csvReader.FieldsPerRecord = -1
-	csvReader.TrimLeadingSpace = true
-	return &CSVInputReader{
+	szCount := newSizeTrackingReader(in)
+	r := &CSVInputReader{
 		colSpecs:        colSpecs,
-		csvReader:       csvReader,
 		csvRejectWriter: gocsv.NewWriter(rejects),
 		numProcessed:    uint64(0),
-		numDecoders:     numDecoders,
 		sizeTracker:     szCount,
-		ignoreBlanks:    ignoreBlanks,
+		ConvertOptions:  ConvertOptions{IgnoreBlanks: ignoreBlanks},
+		StreamOptions:   StreamOptions{numDecoders: effectiveNumDecoders(numDecoders)},
 	}
+	r.csvReader = csv.NewReader(newTextReader(szCount, &r.Encoding))
+	// allow variable number of colSpecs in document
+	r.csvReader.FieldsPerRecord = -1
+	r.csvReader.TrimLeadingSpace = true
+	return r
+}
+
+// peekedFields is a record read ahead of the stream, the bytes it was read
+// from, if they are kept, and the line it starts on.
+type peekedFields struct {
+	fields []string
+	raw    []byte
+	line   int
 }
 
 // ReadAndValidateHeader reads the header from the underlying reader and validates
 // the header fields. It sets err if the read/validation fails.
 func (r *CSVInputReader) ReadAndValidateHeader() (err error) {
+	if err = r.skipLeadingLines(); err != nil {
+		return err
+	}
 	fields, err := r.csvReader.Read()
 	if err != nil {
 		return err
 	}
 	r.colSpecs = ParseAutoHeaders(fields)
-	return validateReaderFields(ColumnNames(r.colSpecs))
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	return r.validateColumns(r.colSpecs)
 }
 
 // ReadAndValidateHeader reads the header from the underlying reader and validates
 // the header fields. It sets err if the read/validation fails.
 func (r *CSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err error) {
+	if err = r.skipLeadingLines(); err != nil {
+		return err
+	}
 	fields, err := r.csvReader.Read()
 	if err != nil {
 		return err
@@ -93,63 +163,359 @@ func (r *CSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err
 	if err != nil {
 		return err
 	}
-	return validateReaderFields(ColumnNames(r.colSpecs))
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	return r.validateColumns(r.colSpecs)
+}
+
+// VerifyHeader reads the header line and checks it against the fields that
+// the reader was given, as check says, rather than taking the fields from
+// it. The header line is not imported.
+func (r *CSVInputReader) VerifyHeader(check HeaderCheck) error {
+	if err := r.skipLeadingLines(); err != nil {
+		return err
+	}
+	header, err := r.csvReader.Read()
+	if err == io.EOF {
+		return fmt.Errorf("the input has no header line to verify")
+	}
+	if err != nil {
+		return err
+	}
+	r.originalHeader = header
+	return r.verifyHeader(header, r.colSpecs, check)
+}
+
+// GenerateFields names the fields field0, field1, and so on, one for each
+// field of the first record, for input that has no header. Blank lines are
+// not records, so they are passed over. The generated names are logged. The
+// record is not consumed, so it is still streamed. A later record with more
+// fields is handled by LongRows, which by default names its extra fields in
+// the same way, and one with fewer by ShortRows.
+func (r *CSVInputReader) GenerateFields() error {
+	if err := r.skipLeadingLines(); err != nil {
+		return err
+	}
+	record, err := r.csvReader.Read()
+	if err == io.EOF {
+		log.Logvf(log.Always, "no records to generate field names from")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read error on entry #1 (line %v): %v", r.csvReader.RecordLine(), err)
+	}
+	r.peeked = []peekedFields{{record, r.rawRecord(), r.csvReader.RecordLine()}}
+	return r.generateFields(record)
+}
+
+// generateFields names the fields of the reader after those of record, the
+// first, as GenerateFields does.
+func (r *CSVInputReader) generateFields(record []string) error {
+	r.colSpecs = generatedColumns(r.generatedFieldCount(record))
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	log.Logvf(log.Always, "generated fields from the first record: %v", strings.Join(r.originalHeader, ","))
+	return r.validateColumns(r.colSpecs)
+}
+
+// ReadAndDetectHeader reads the first few records, up to ten, and takes the
+// first for a header, as ReadAndValidateHeader does, if DetectHeader is
+// confident that it is one, or else for data, logging which. Any header is
+// not imported, but the other records read are still streamed. A header
+// names the fields unless the reader was given them; if the first record is
+// data, and the reader was not, they are generated from it as by
+// GenerateFields.
+func (r *CSVInputReader) ReadAndDetectHeader() error {
+	if err := r.skipLeadingLines(); err != nil {
+		return err
+	}
+	var records [][]string
+	for len(records) < headerSampleRecords {
+		record, err := r.csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read error on entry #%v (line %v): %v", len(records)+1, r.csvReader.RecordLine(), err)
+		}
+		r.peeked = append(r.peeked, peekedFields{record, r.rawRecord(), r.csvReader.RecordLine()})
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		log.Logvf(log.Always, "no records to detect a header in")
+		return nil
+	}
+	if detectsHeader(records) {
+		r.peeked = r.peeked[1:]
+		if len(r.colSpecs) != 0 {
+			return nil
+		}
+		r.colSpecs = ParseAutoHeaders(records[0])
+		r.originalHeader = r.prepareColumnNames(r.colSpecs)
+		return r.validateColumns(r.colSpecs)
+	}
+	if len(r.colSpecs) != 0 {
+		return nil
+	}
+	return r.generateFields(records[0])
+}
+
+// OriginalHeader returns the column names as they were before normalization
+// and sanitization, or nil if the header has not been read and validated.
+func (r *CSVInputReader) OriginalHeader() []string {
+	return r.originalHeader
+}
+
+// Fields returns the names of the fields that documents are given, once the
+// header has been read and validated. The slice is the caller's to change.
+func (r *CSVInputReader) Fields() []string {
+	return r.fieldNames(r.colSpecs)
+}
+
+// EstimateRecordCount estimates the number of records, including any header,
+// from the current offset of source to its end, by parsing up to sampleSize
+// bytes of it as this reader would. Source should be a separate handle on the
+// input, such as a second *os.File; it is sought back to where it was. For
+// input that can not seek, such as standard input, it returns
+// UnknownRecordCount.
+func (r *CSVInputReader) EstimateRecordCount(source io.Reader, sampleSize int) (RecordCountEstimate, error) {
+	return estimateRecordCount(source, sampleSize, func(sample io.Reader) (lengths []int) {
+		sampler := csv.NewReader(newBomDiscardingReader(sample))
+		sampler.FieldsPerRecord = -1
+		sampler.TrimLeadingSpace = true
+		r.configureQuoting(sampler)
+		for {
+			record, err := sampler.Read()
+			if err != nil {
+				// a parse error is a record cut off by the sample
+				return
+			}
+			// the parser does not say how long the record was, so this
+			// leaves out quotes and trimmed spaces
+			length := len(record)
+			for _, field := range record {
+				length += len(field)
+			}
+			lengths = append(lengths, length)
+		}
+	})
+}
+
+// Progress returns a snapshot of how far StreamDocument has got. It is safe
+// to call while StreamDocument is running.
+func (r *CSVInputReader) Progress() ReaderProgress {
+	return r.progress(r.Size())
 }
 
 // StreamDocument takes a boolean indicating if the documents should be streamed
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if streaming fails.
-func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (retErr error) {
-	csvRecordChan := make(chan Converter, r.numDecoders)
-	csvErrChan := make(chan error)
+func (r *CSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	return r.streamTo(ordered, docSink(readDocs))
+}
+
+// streamTo is StreamDocument for the documents that sink takes.
+func (r *CSVInputReader) streamTo(ordered bool, sink documentSink) (retErr error) {
+	r.beginStream(r.Size)
+	r.utf8Replacements = &r.numReplaced
+	// cancelling on return stops the read loop if decoding fails first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	csvRecordChan := make(chan Converter, r.recordBuffer())
+	// buffered so neither goroutine blocks once the other's error is returned
+	csvErrChan := make(chan error, 2)
 
 	// begin reading from source
 	go func() {
+		if err := r.skipLeadingLines(); err != nil {
+			close(csvRecordChan)
+			csvErrChan <- err
+			return
+		}
+		batcher := r.newRecordBatcher()
+		send := func(c Converter) error {
+			if err := r.handOver(ctx, csvRecordChan, c); err != nil {
+				close(csvRecordChan)
+				return err
+			}
+			return nil
+		}
 		var err error
 		for {
-			r.csvRecord, err = r.csvReader.Read()
+			var raw []byte
+			var line int
+			if r.stopped() {
+				r.csvRecord, err = nil, io.EOF
+			} else {
+				r.csvRecord, raw, line, err = r.nextRecord()
+			}
 			if err != nil {
+				// the records read before the end or the failure are still
+				// converted
+				if batch, ok := batcher.flush(); ok {
+					if sendErr := send(batch); sendErr != nil {
+						csvErrChan <- sendErr
+						return
+					}
+				}
 				close(csvRecordChan)
 				if err == io.EOF {
 					csvErrChan <- nil
 				} else {
-					r.numProcessed++
-					csvErrChan <- fmt.Errorf("read error on entry #%v: %v", r.numProcessed, err)
+					csvErrChan <- fmt.Errorf("read error on entry #%v (line %v): %v",
+						r.numProcessed+1, r.csvReader.RecordLine(), err)
 				}
 				return
 			}
-			csvRecordChan <- CSVConverter{
+			if r.skipsRecord(r.numProcessed) {
+				atomic.AddUint64(&r.numProcessed, 1)
+				continue
+			}
+			converter, ready := batcher.add(r.wrapConverter(CSVConverter{
 				colSpecs:     r.colSpecs,
 				data:         r.csvRecord,
+				raw:          raw,
 				index:        r.numProcessed,
-				ignoreBlanks: r.ignoreBlanks,
+				line:         uint64(line),
 				rejectWriter: r.csvRejectWriter,
+				options:      &r.ConvertOptions,
+			}, csvRecordSize(r.csvRecord)), r.numProcessed)
+			if ready {
+				if err = send(converter); err != nil {
+					csvErrChan <- err
+					return
+				}
 			}
-			r.numProcessed++
+			atomic.AddUint64(&r.numProcessed, 1)
 		}
 	}()
 
 	go func() {
-		csvErrChan <- streamDocuments(ordered, r.numDecoders, csvRecordChan, readDocs)
+		csvErrChan <- r.streamConverted(context.Background(), ordered, csvRecordChan, sink)
 	}()
 
 	return channelQuorumError(csvErrChan, 2)
 }
 
+// nextRecord returns the next record to stream, the bytes it was read from,
+// if they are kept, and the line it starts on: the first of the peeked
+// records if there are any, or the next one read.
+func (r *CSVInputReader) nextRecord() ([]string, []byte, int, error) {
+	if len(r.peeked) != 0 {
+		peeked := r.peeked[0]
+		r.peeked = r.peeked[1:]
+		return peeked.fields, peeked.raw, peeked.line, nil
+	}
+	record, err := r.csvReader.Read()
+	return record, r.rawRecord(), r.csvReader.RecordLine(), err
+}
+
+// rawRecord returns a copy of the bytes that the last record read was parsed
+// from, which are only kept for writing to Rejects, or nil if they are not.
+func (r *CSVInputReader) rawRecord() []byte {
+	if !r.csvReader.KeepRaw {
+		return nil
+	}
+	return append([]byte(nil), r.csvReader.Raw()...)
+}
+
+// csvRecordSize returns the approximate length of the input that record was
+// read from: that of its fields, with a separator or terminator after each.
+func csvRecordSize(record []string) (size int) {
+	for _, field := range record {
+		size += len(field) + 1
+	}
+	return size
+}
+
+// skipLeadingLines discards the first SkipLines lines of input, if they have
+// not been discarded already, and then detects the delimiter if
+// DetectDelimiter is set and it has not been detected already. As every read
+// of the input starts with it, it also applies the quoting settings to the
+// parser.
+func (r *CSVInputReader) skipLeadingLines() error {
+	r.configureQuoting(r.csvReader)
+	for ; r.skippedLines < r.SkipLines; r.skippedLines++ {
+		if err := r.csvReader.SkipLine(); err != nil {
+			return skipLinesError(r.skippedLines, r.SkipLines, err)
+		}
+	}
+	if r.DetectDelimiter && r.comma == 0 {
+		guess, err := sniffSample(r.csvReader.Peek(sniffSampleSize))
+		if err != nil {
+			return err
+		}
+		delimiter, err := confidentDelimiter(guess)
+		if err != nil {
+			return err
+		}
+		log.Logvf(log.Info, "detected the delimiter %q, with a confidence of %.2f", guess.Delimiter, guess.Confidence)
+		r.comma = rune(delimiter[0])
+		r.csvReader.Comma = r.comma
+	}
+	return nil
+}
+
+// configureQuoting sets the quote and escape characters of a parser of the
+// input, whether it is strict, and the delimiter once it has been detected.
+func (r *CSVInputReader) configureQuoting(parser *csv.Reader) {
+	parser.Quote = r.Quote
+	if parser.Quote == 0 {
+		parser.Quote = '"'
+	}
+	parser.Escape = r.Escape
+	parser.LazyQuotes = r.LazyQuotes
+	parser.Strict = r.Strict
+	parser.KeepRaw = r.Rejects != nil
+	if r.comma != 0 {
+		parser.Comma = r.comma
+	}
+}
+
 // Convert implements the Converter interface for CSV input. It converts a
 // CSVConverter struct to a BSON document.
-func (c CSVConverter) Convert() (b bson.D, err error) {
-	b, err = tokensToBSON(
+func (c CSVConverter) Convert() (bson.D, error) {
+	b, err := tokensToBSON(
 		c.colSpecs,
 		c.data,
 		c.index,
-		c.ignoreBlanks,
+		c.options,
 	)
+	return b, c.conversionError(err)
+}
+
+// ConvertRaw implements the RawConverter interface for CSV input, building
+// the BSON of the document without a bson.D.
+func (c CSVConverter) ConvertRaw() ([]byte, error) {
+	raw, err := tokensToRaw(c.colSpecs, c.data, c.index, c.options)
+	return raw, c.conversionError(err)
+}
+
+// conversionError returns nil for a row that was skipped, once it is
+// printed, and otherwise err with the position of the record.
+func (c CSVConverter) conversionError(err error) error {
 	if _, ok := err.(coercionError); ok {
-		c.Print()
-		err = nil
+		// a skipped row is printed unless its columns are anonymized
+		if c.options.quotesRecords() {
+			c.Print()
+		}
+		return nil
+	} else if err != nil {
+		return c.options.recordError(c.index+1, c.line, strings.Join(c.data, ","), err)
+	}
+	return nil
+}
+
+// rawRecord returns the bytes the record was read from, line terminator
+// included, or, for a converter not given them, the record re-encoded as a
+// single CSV line.
+func (c CSVConverter) rawRecord() []byte {
+	if c.raw != nil {
+		return c.raw
 	}
-	return
+	var buf bytes.Buffer
+	w := gocsv.NewWriter(&buf)
+	w.Write(c.data)
+	w.Flush()
+	return buf.Bytes()
 }
 
 func (c CSVConverter) Print() {
@@ -61,6 +61,7 @@ import (
 	"fmt"
 	"io"
 	"unicode"
+	"unicode/utf8"
 )
 
 // A ParseError is returned for parsing errors.
@@ -81,6 +82,9 @@ var (
 	ErrBareQuote     = errors.New("bare \" in non-quoted-field")
 	ErrQuote         = errors.New("extraneous \" in field")
 	ErrFieldCount    = errors.New("wrong number of fields in line")
+	ErrEscape        = errors.New("escape character at end of input")
+	ErrBareLF        = errors.New("line break not preceded by \\r")
+	ErrBareCR        = errors.New("bare \\r in non-quoted-field")
 )
 
 // A Reader reads records from a CSV-encoded file.
@@ -104,23 +108,48 @@ var (
 // non-doubled quote may appear in a quoted field.
 //
 // If TrimLeadingSpace is true, leading white space in a field is ignored.
+//
+// Quote is the character that quoted-fields start and stop with. It defaults
+// to '"'.
+//
+// Escape, if not 0 or Quote, is the escape character: in any field, it makes
+// the character after it, even a Quote, Comma or newline, part of the field,
+// and a Quote followed by a second Quote no longer stands for a single
+// Quote. If LazyQuotes is true, an Escape at the end of the input is kept.
+//
+// If Strict is true, the input must conform to RFC 4180 exactly: records end
+// with \r\n, though the last may have no terminator, a quote in a quoted
+// field can only be doubled, and a line break or \r can only be part of a
+// quoted field. Quote is '"', and Escape, LazyQuotes and TrimLeadingSpace
+// are ignored.
+//
+// If KeepRaw is true, Raw returns the bytes that the most recently read
+// record was parsed from.
 type Reader struct {
 	Comma            rune // field delimiter (set to ',' by NewReader)
+	Quote            rune // quote character (set to '"' by NewReader)
+	Escape           rune // escape character, or 0 to double quotes
 	Comment          rune // comment character for start of line
 	FieldsPerRecord  int  // number of expected fields per record
 	LazyQuotes       bool // allow lazy quotes
 	TrailingComma    bool // ignored; here for backwards compatibility
 	TrimLeadingSpace bool // trim leading space
+	Strict           bool // require RFC 4180
+	KeepRaw          bool // keep the bytes of each record for Raw
 	line             int
+	recordLine       int
 	column           int
+	crlf             bool // whether the last '\n' read was folded from \r\n
 	r                *bufio.Reader
 	field            bytes.Buffer
+	raw              []byte
 }
 
 // NewReader returns a new Reader that reads from r.
 func NewReader(r io.Reader) *Reader {
 	return &Reader{
 		Comma: ',',
+		Quote: '"',
 		r:     bufio.NewReader(r),
 	}
 }
@@ -158,6 +187,42 @@ func (r *Reader) Read() (record []string, err error) {
 	return record, nil
 }
 
+// Raw returns the bytes of the input that the most recently read record was
+// parsed from, line terminator included, if KeepRaw was set when it was read.
+// It is only valid until the next call to Read.
+func (r *Reader) Raw() []byte {
+	return r.raw
+}
+
+// RecordLine returns the line on which the most recently read record
+// started. The first line is 1.
+func (r *Reader) RecordLine() int {
+	return r.recordLine
+}
+
+// SkipLine reads and discards a single line, including its terminator,
+// without parsing it. A final line that is not terminated is skipped without
+// error. It returns io.EOF if there is nothing left to read.
+func (r *Reader) SkipLine() error {
+	if _, err := r.r.Peek(1); err != nil {
+		return err
+	}
+	r.line++
+	if err := r.skip('\n'); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Peek returns up to the next n bytes of the input without reading them, as
+// bufio.Reader's Peek does, but never more than fit in the Reader's buffer.
+func (r *Reader) Peek(n int) ([]byte, error) {
+	if n > r.r.Size() {
+		n = r.r.Size()
+	}
+	return r.r.Peek(n)
+}
+
 // ReadAll reads all the remaining records from r.
 // Each record is a slice of fields.
 // A successful call returns err == nil, not err == EOF. Because ReadAll is
@@ -178,19 +243,37 @@ func (r *Reader) ReadAll() (records [][]string, err error) {
 
 // readRune reads one rune from r, folding \r\n to \n and keeping track
 // of how far into the line we have read.  r.column will point to the start
-// of this rune, not the end of this rune.
+// of this rune, not the end of this rune.  A byte that is not valid UTF-8 is
+// returned as an invalidByte, so that it is kept as it is.
 func (r *Reader) readRune() (rune, error) {
-	r1, _, err := r.r.ReadRune()
+	r1, size, err := r.r.ReadRune()
+	if r1 == utf8.RuneError && size == 1 {
+		r.r.UnreadRune()
+		b, _ := r.r.ReadByte()
+		r.column++
+		if r.KeepRaw {
+			r.raw = append(r.raw, b)
+		}
+		return invalidByte(b), nil
+	}
+	if r.KeepRaw && size > 0 {
+		r.raw = appendRune(r.raw, r1)
+	}
 
 	// Handle \r\n here.  We make the simplifying assumption that
 	// anytime \r is followed by \n that it can be folded to \n.
-	// We will not detect files which contain both \r\n and bare \n.
+	// We will not detect files which contain both \r\n and bare \n,
+	// unless Strict is set.
+	r.crlf = false
 	if r1 == '\r' {
 		r1, _, err = r.r.ReadRune()
 		if err == nil {
+			r.crlf = r1 == '\n'
 			if r1 != '\n' {
 				r.r.UnreadRune()
 				r1 = '\r'
+			} else if r.KeepRaw {
+				r.raw = append(r.raw, '\n')
 			}
 		}
 	}
@@ -198,6 +281,28 @@ func (r *Reader) readRune() (rune, error) {
 	return r1, err
 }
 
+// appendRune appends the UTF-8 encoding of r1 to buf.
+func appendRune(buf []byte, r1 rune) []byte {
+	var encoded [utf8.UTFMax]byte
+	return append(buf, encoded[:utf8.EncodeRune(encoded[:], r1)]...)
+}
+
+// invalidByte returns the rune that stands for b, a byte that is not valid
+// UTF-8, which is negative so that it is never a delimiter.
+func invalidByte(b byte) rune {
+	return -1 - rune(b)
+}
+
+// writeRune writes r1 to the field, writing the byte that an invalidByte
+// stands for as it is.
+func (r *Reader) writeRune(r1 rune) {
+	if r1 < 0 {
+		r.field.WriteByte(byte(-1 - r1))
+		return
+	}
+	r.field.WriteRune(r1)
+}
+
 // skip reads runes up to and including the rune delim or until error.
 func (r *Reader) skip(delim rune) error {
 	for {
@@ -217,7 +322,9 @@ func (r *Reader) parseRecord() (fields []string, err error) {
 	// number (lines start at 1, not 0) and set column to -1
 	// so as we increment in readRune it points to the character we read.
 	r.line++
+	r.recordLine = r.line
 	r.column = -1
+	r.raw = r.raw[:0]
 
 	// Peek at the first rune.  If it is an error we are done.
 	// If we are support comments and it is the comment character
@@ -247,14 +354,47 @@ func (r *Reader) parseRecord() (fields []string, err error) {
 	}
 }
 
+// readEscaped writes the rune after an escape character to the field. At the
+// end of the input, the escape character itself is written if LazyQuotes is
+// set.
+func (r *Reader) readEscaped() error {
+	r1, err := r.readRune()
+	if err == io.EOF {
+		if r.LazyQuotes {
+			r.field.WriteRune(r.Escape)
+			return err
+		}
+		return r.error(ErrEscape)
+	}
+	if err != nil {
+		return err
+	}
+	if r1 == '\n' {
+		r.line++
+		r.column = -1
+	}
+	r.writeRune(r1)
+	return nil
+}
+
+// endRecord returns the error, if Strict is set, for the '\n' just read
+// outside a quoted field not having been folded from \r\n.
+func (r *Reader) endRecord() error {
+	if r.Strict && !r.crlf {
+		return r.error(ErrBareLF)
+	}
+	return nil
+}
+
 // parseField parses the next field in the record.  The read field is
 // located in r.field.  Delim is the first character not part of the field
 // (r.Comma or '\n').
 func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 	r.field.Reset()
 
+	lazy, trim := r.LazyQuotes && !r.Strict, r.TrimLeadingSpace && !r.Strict
 	r1, err := r.readRune()
-	for err == nil && r.TrimLeadingSpace && r1 != '\n' && unicode.IsSpace(r1) {
+	for err == nil && trim && r1 != '\n' && unicode.IsSpace(r1) {
 		r1, err = r.readRune()
 	}
 
@@ -266,6 +406,16 @@ func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 	}
 
 	var ws bytes.Buffer
+	quote, escape := r.Quote, r.Escape
+	if quote == 0 {
+		quote = '"'
+	}
+	if escape == quote {
+		escape = 0
+	}
+	if r.Strict {
+		quote, escape = '"', 0
+	}
 
 	switch r1 {
 	case r.Comma:
@@ -273,37 +423,46 @@ func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 
 	case '\n':
 		// We are a trailing empty field or a blank line
+		if err = r.endRecord(); err != nil {
+			return false, 0, err
+		}
 		if r.column == 0 {
 			return false, r1, nil
 		}
 		return true, r1, nil
 
-	case '"':
+	case quote:
 		// quoted field
 	Quoted:
 		for {
 			r1, err = r.readRune()
 			if err != nil {
 				if err == io.EOF {
-					if r.LazyQuotes {
+					if lazy {
 						return true, 0, err
 					}
 					return false, 0, r.error(ErrQuote)
 				}
 				return false, 0, err
 			}
+			if escape != 0 && r1 == escape {
+				if err = r.readEscaped(); err != nil {
+					return err == io.EOF, 0, err
+				}
+				continue
+			}
 			switch r1 {
-			case '"':
+			case quote:
 				r1, err = r.readRune()
-				if err == nil && r.TrimLeadingSpace && r1 != '\n' && unicode.IsSpace(r1) {
-					for err == nil && r.TrimLeadingSpace && r1 != '\n' && unicode.IsSpace(r1) {
+				if err == nil && trim && r1 != '\n' && unicode.IsSpace(r1) {
+					for err == nil && trim && r1 != '\n' && unicode.IsSpace(r1) {
 						r1, err = r.readRune()
 					}
 					// we don't want '"foo" "bar",' to look like '"foo""bar"'
 					// which evaluates to 'foo"bar'
 					// so we explicitly test for the case that the trimed whitespace isn't
 					// followed by a '"'
-					if err == nil && r1 == '"' {
+					if err == nil && r1 == quote {
 						r.column--
 						return false, 0, r.error(ErrQuote)
 					}
@@ -312,48 +471,63 @@ func (r *Reader) parseField() (haveField bool, delim rune, err error) {
 					break Quoted
 				}
 				if r1 == '\n' {
+					if err = r.endRecord(); err != nil {
+						return false, 0, err
+					}
 					return true, r1, nil
 				}
-				if r1 != '"' {
-					if !r.LazyQuotes {
+				if r1 != quote || escape != 0 {
+					if !lazy {
 						r.column--
 						return false, 0, r.error(ErrQuote)
 					}
 					// accept the bare quote
-					r.field.WriteRune('"')
+					r.field.WriteRune(quote)
 				}
 			case '\n':
 				r.line++
 				r.column = -1
 			}
-			r.field.WriteRune(r1)
+			r.writeRune(r1)
 		}
 
 	default:
 		// unquoted field
 		for {
-			// only write sections of whitespace if it's followed by non-whitespace
-			if unicode.IsSpace(r1) {
+			if r.Strict && r1 == '\r' {
+				return false, 0, r.error(ErrBareCR)
+			}
+			if escape != 0 && r1 == escape {
+				r.field.WriteString(ws.String())
+				ws.Reset()
+				if err = r.readEscaped(); err != nil {
+					return err == io.EOF, 0, err
+				}
+			} else if unicode.IsSpace(r1) {
+				// only write sections of whitespace if it's followed by non-whitespace
 				ws.WriteRune(r1)
 			} else {
 				r.field.WriteString(ws.String())
 				ws.Reset()
-				r.field.WriteRune(r1)
+				r.writeRune(r1)
 			}
 			r1, err = r.readRune()
 			if err != nil || r1 == r.Comma {
 				break
 			}
 			if r1 == '\n' {
+				if err = r.endRecord(); err != nil {
+					return false, 0, err
+				}
 				return true, r1, nil
 			}
-			if !r.LazyQuotes && r1 == '"' {
+			if !lazy && r1 == quote {
 				return false, 0, r.error(ErrBareQuote)
 			}
 		}
 	}
 	//write any remaining section of whitespace unless TrimLeadingSpace on
-	if !r.TrimLeadingSpace {
+	if !trim {
 		r.field.WriteString(ws.String())
 	}
 
@@ -174,6 +174,20 @@ func TestCSVStreamDocument(t *testing.T) {
 			So(<-docChan, ShouldResemble, expectedReadOne)
 			So(<-docChan, ShouldResemble, expectedReadTwo)
 		})
+		Convey("records that fail to convert should be rejected as they were read", func() {
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldInt32Parser), pgStop, "int32"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			contents := "1,x\r\nN/A,\"y, z\"\r\n3,w\nN/A,'v'"
+			var rejects bytes.Buffer
+			r := NewCSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.Rejects = &rejects
+			docChan := make(chan bson.D, 4)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 2)
+			So(rejects.String(), ShouldEqual, "N/A,\"y, z\"\r\nN/A,'v'")
+		})
 		Convey("valid CSV input file that starts with the UTF-8 BOM should "+
 			"not raise an error", func() {
 			colSpecs := []ColumnSpec{
@@ -244,6 +258,83 @@ func TestCSVReadAndValidateHeader(t *testing.T) {
 			So(len(r.colSpecs), ShouldEqual, 4)
 		})
 
+		Convey("conversion errors should name the 1-based line, counting the header, "+
+			"and quote the record", func() {
+			contents := "name,price.double()\nwidget,2.5\ngadget,N/A\n"
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			err := r.StreamDocument(true, make(chan bson.D, 2))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #2 (line 3): field 'price' (column 2): cannot parse 'N/A' as double: ")
+			So(err.Error(), ShouldEndWith, ": gadget,N/A")
+		})
+
+		Convey("batched records should be streamed in order, including a partial last batch", func() {
+			contents := "a.int32()\n1\n2\n3\n4\n5\n"
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 2, false)
+			r.BatchSize = 2
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			docChan := make(chan bson.D, 5)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 5)
+			for i := 1; i <= 5; i++ {
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(i)}})
+			}
+		})
+
+		Convey("binary columns should be decoded, and errors should name the field and line", func() {
+			contents := "name,thumb.binary(base64)\nwidget,Zm9vYmFy\nblank,\ngadget,Zm9vYg\n"
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, true)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			docChan := make(chan bson.D, 3)
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #3 (line 4): field 'thumb' (column 2): cannot parse 'Zm9vYg' as binary: ")
+			So(<-docChan, ShouldResemble, bson.D{{"name", "widget"}, {"thumb", []byte("foobar")}})
+			So(<-docChan, ShouldResemble, bson.D{{"name", "blank"}})
+		})
+
+		Convey("an invalid default should fail when the header is read", func() {
+			contents := "name,retries.int32().default(none)\nwidget,2\n"
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			err := r.ReadAndValidateTypedHeader(pgSkipField)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid default 'none' for int32 field retries")
+		})
+
+		Convey("decimal columns should keep full precision, and errors should name "+
+			"the field and line", func() {
+			contents := "name,price.decimal()\nwidget,19999.99\ngizmo,1.5E-3\ngadget,1E+7000\n"
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			docChan := make(chan bson.D, 3)
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #3 (line 4): field 'price' (column 2): cannot parse '1E+7000' as decimal: ")
+			So(err.Error(), ShouldContainSubstring, "out of range for decimal128")
+			price, _ := bson.ParseDecimal128("19999.99")
+			So(<-docChan, ShouldResemble, bson.D{{"name", "widget"}, {"price", price}})
+			price, _ = bson.ParseDecimal128("1.5E-3")
+			So(<-docChan, ShouldResemble, bson.D{{"name", "gizmo"}, {"price", price}})
+		})
+
+		Convey("leading lines should be skipped before the header", func() {
+			contents := "Report generated 2024-01-03\r\n\"quoted, banner\n\nname,price.double()\nwidget,N/A\n"
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.SkipLines = 3
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"name", "price"})
+			err := r.StreamDocument(true, make(chan bson.D, 1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #1 (line 5): ")
+
+			r = NewCSVInputReader(nil, bytes.NewReader([]byte("banner")), os.Stdout, 1, false)
+			r.SkipLines = 2
+			err = r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "input ended after 1 of the 2 lines to skip")
+		})
+
 		Convey("setting colliding nested CSV headers should raise an error", func() {
 			contents := "a, a.b, c"
 			colSpecs := []ColumnSpec{}
@@ -358,3 +449,193 @@ func TestCSVConvert(t *testing.T) {
 		})
 	})
 }
+
+func TestCSVEstimateRecordCount(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader", t, func() {
+		r := NewCSVInputReader(nil, bytes.NewReader(nil), os.Stdout, 1, false)
+
+		Convey("records spanning lines should be counted once", func() {
+			contents := strings.Repeat("1,\"two\nlines\"\n", 1000)
+			estimate, err := r.EstimateRecordCount(bytes.NewReader([]byte(contents)), 1000)
+			So(err, ShouldBeNil)
+			So(estimate.Records, ShouldAlmostEqual, 1000, 100)
+		})
+		Convey("the count should be unknown for compressed input", func() {
+			estimate, err := r.EstimateRecordCount(bytes.NewReader([]byte{0x1f, 0x8b, 8, 0}), 1000)
+			So(err, ShouldBeNil)
+			So(estimate, ShouldResemble, UnknownRecordCount)
+		})
+	})
+}
+
+func TestCSVGenerateFields(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader of a file without a header", t, func() {
+		contents := "\n1,x\n2,\"y\nz\",w\n"
+		r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+
+		Convey("the fields should be named after the first record, which is still imported", func() {
+			So(r.GenerateFields(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"field0", "field1"})
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(1)}, {"field1", "x"}})
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(2)}, {"field1", "y\nz"}, {"field2", "w"}})
+		})
+		Convey("the generated fields should be renamed as header fields are", func() {
+			r.RenameFields = map[string]string{"field1": "name"}
+			So(r.GenerateFields(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"field0", "name"})
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(1)}, {"name", "x"}})
+		})
+	})
+}
+
+func TestCSVVerifyHeader(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader given fields for a file with a header", t, func() {
+		colSpecs := ParseAutoHeaders([]string{"a", "b"})
+
+		Convey("a matching header should be consumed rather than imported", func() {
+			r := NewCSVInputReader(colSpecs, bytes.NewReader([]byte("a,b\n1,2\n")), os.Stdout, 1, false)
+			So(r.VerifyHeader(HeaderCheckNames), ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", int32(2)}})
+		})
+		Convey("a header in another order should fail", func() {
+			r := NewCSVInputReader(colSpecs, bytes.NewReader([]byte("b,a\n")), os.Stdout, 1, false)
+			err := r.VerifyHeader(HeaderCheckNames)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "the header does not match the fields given: "+
+				"reordered: the header has 'b,a' where the fields are 'a,b'")
+			So(NewCSVInputReader(colSpecs, bytes.NewReader([]byte("b,a\n")), os.Stdout, 1, false).
+				VerifyHeader(HeaderCheckCount), ShouldBeNil)
+		})
+	})
+}
+
+func TestCSVQuoting(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader with custom quoting", t, func() {
+		read := func(contents string, configure func(r *CSVInputReader)) ([]bson.D, error) {
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			configure(r)
+			if err := r.ReadAndValidateHeader(); err != nil {
+				return nil, err
+			}
+			docChan := make(chan bson.D, 10)
+			err := r.StreamDocument(true, docChan)
+			var docs []bson.D
+			for doc := range docChan {
+				docs = append(docs, doc)
+			}
+			return docs, err
+		}
+		singleQuoted := func(r *CSVInputReader) {
+			r.Quote, r.Escape = '\'', '\\'
+		}
+
+		Convey("the header and records should honor the quote and escape characters", func() {
+			contents := "'first, name',note\n'O\\'Brien','say \"hi\", \\\\ then'\nplain\\, text,x\n"
+			docs, err := read(contents, singleQuoted)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{
+				{{"first, name", "O'Brien"}, {"note", `say "hi", \ then`}},
+				{{"first, name", "plain, text"}, {"note", "x"}},
+			})
+		})
+		Convey("doubled quotes should not stand for a quote when there is an escape character", func() {
+			_, err := read("a\n'it''s'\n", singleQuoted)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("an escape character at the end of a field should escape what follows", func() {
+			docs, err := read("a,b\n'x\\\\',y\\\n", singleQuoted)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{{{"a", `x\`}, {"b", "y\n"}}})
+
+			_, err = read("a\nx\\", singleQuoted)
+			So(err, ShouldNotBeNil)
+			docs, err = read("a\nx\\", func(r *CSVInputReader) {
+				singleQuoted(r)
+				r.LazyQuotes = true
+			})
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{{{"a", `x\`}}})
+		})
+		Convey("a quote mid-field should only be accepted with lazy quotes", func() {
+			contents := "a,b\nit's,'it's here'\n"
+			_, err := read(contents, singleQuoted)
+			So(err, ShouldNotBeNil)
+			docs, err := read(contents, func(r *CSVInputReader) {
+				singleQuoted(r)
+				r.LazyQuotes = true
+			})
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{{{"a", "it's"}, {"b", "it's here"}}})
+		})
+		Convey("by default, fields should be quoted as RFC 4180 says", func() {
+			docs, err := read("a,b\n\"it's\",\"x \"\"y\"\"\"\n", func(*CSVInputReader) {})
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{{{"a", "it's"}, {"b", `x "y"`}}})
+		})
+	})
+}
+
+func TestCSVStrict(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a CSV input reader held to RFC 4180", t, func() {
+		read := func(contents string, strict bool) ([]bson.D, error) {
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.Strict = strict
+			if err := r.ReadAndValidateHeader(); err != nil {
+				return nil, err
+			}
+			docChan := make(chan bson.D, 10)
+			err := r.StreamDocument(true, docChan)
+			var docs []bson.D
+			for doc := range docChan {
+				docs = append(docs, doc)
+			}
+			return docs, err
+		}
+
+		Convey("records ending with CRLF should be read, with line breaks and doubled quotes in quoted fields", func() {
+			docs, err := read("a,b\r\n\"x\r\ny\", z\r\n\"say \"\"hi\"\"\",\r\n1,2", true)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{
+				{{"a", "x\ny"}, {"b", " z"}},
+				{{"a", `say "hi"`}, {"b", ""}},
+				{{"a", int32(1)}, {"b", int32(2)}},
+			})
+		})
+		Convey("a record ending with a bare LF should fail at its line and column", func() {
+			contents := "a,b\r\n1,2\r\n3,4\n5,6\r\n"
+			_, err := read(contents, true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 3, column 3: line break not preceded by \\r")
+			docs, err := read(contents, false)
+			So(err, ShouldBeNil)
+			So(len(docs), ShouldEqual, 3)
+		})
+		Convey("a bare CR or quote in an unquoted field should fail", func() {
+			_, err := read("a,b\r\n1,x\ry\r\n", true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 2, column 3: bare \\r in non-quoted-field")
+			_, err = read("a,b\r\n1,it\"s\r\n", true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 2, column 4: bare \" in non-quoted-field")
+		})
+		Convey("the header should be held to the same rules", func() {
+			_, err := read("a,b\n1,2\r\n", true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 1, column 3: line break not preceded by \\r")
+			_, err = read("\"a\" ,b\r\n1,2\r\n", true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line 1, column 2: extraneous \" in field")
+		})
+	})
+}
@@ -31,6 +31,12 @@ var (
 		"m", "4",
 		"ss", "05",
 		"s", "5",
+		"fffffff", "0000000",
+		"ffffff", "000000",
+		"fffff", "00000",
+		"ffff", "0000",
+		"fff", "000",
+		"ff", "00",
 		// "f", "?",
 		"tt", "PM",
 		// "t", "?",
@@ -59,6 +65,10 @@ var (
 		"DAY", "Monday",
 		"DY", "Mon",
 		"DD", "02",
+		"FF9", "000000000",
+		"FF6", "000000",
+		"FF3", "000",
+		"FF", "000000",
 		"HH12", "03",
 		"HH24", "15",
 		"HH", "03",
@@ -0,0 +1,212 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// DedupOverflowPolicy is what a stream does once the keys of the documents
+// it has streamed need more than its DedupMaxMemory.
+type DedupOverflowPolicy string
+
+const (
+	// DedupOverflowError fails each document after the cap is reached to
+	// convert, which stops the stream unless its failures are tolerated.
+	DedupOverflowError DedupOverflowPolicy = "error"
+
+	// DedupOverflowBloom switches to a Bloom filter of DedupMaxMemory bytes,
+	// which holds any number of keys but mistakes about one in a hundred
+	// unique documents for duplicates once it holds as many keys as it has
+	// bytes, and more after that; those documents are dropped too.
+	DedupOverflowBloom DedupOverflowPolicy = "bloom"
+)
+
+// ValidateDedupOverflowPolicy returns the DedupOverflowPolicy of the given
+// name; an empty name is DedupOverflowError.
+func ValidateDedupOverflowPolicy(name string) (DedupOverflowPolicy, error) {
+	switch policy := DedupOverflowPolicy(name); policy {
+	case "":
+		return DedupOverflowError, nil
+	case DedupOverflowError, DedupOverflowBloom:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid dedup overflow policy: %s", name)
+}
+
+// dedupKeyBytes is the memory that a key is taken to need in the set of the
+// keys streamed, with the overhead of the map, for DedupMaxMemory.
+const dedupKeyBytes = 64
+
+// bloomHashes is the number of bits that the Bloom filter sets for each key,
+// which gives the fewest false positives at about a byte for each key.
+const bloomHashes = 7
+
+// dedupKey is the 128-bit FNV-1a hash of a document's key.
+type dedupKey [16]byte
+
+// dedupFilter is the set of the keys of the documents streamed so far, which
+// the decoders check each document against. If ordered, each record waits
+// for its turn, after every record read before it has been checked, so that
+// the first occurrence of a key in the input is the one that is kept.
+type dedupFilter struct {
+	lock    sync.Mutex
+	turn    *sync.Cond
+	ordered bool
+
+	// next is the index of the record whose turn it is, and broken is set
+	// once a record will never take its turn, because streaming failed
+	next   uint64
+	broken bool
+
+	// keys is the set of keys, until it would hold more than maxKeys, if
+	// that is positive, when overflow says whether keys are moved to bloom
+	keys     map[dedupKey]struct{}
+	maxKeys  int64
+	maxBytes int64
+	overflow DedupOverflowPolicy
+	bloom    []byte
+}
+
+// initDedup makes the dedupFilter for a stream, if Dedup is set.
+func (opts *StreamOptions) initDedup(ordered bool) {
+	if !opts.Dedup {
+		return
+	}
+	d := &dedupFilter{
+		ordered:  ordered,
+		keys:     make(map[dedupKey]struct{}),
+		maxKeys:  opts.DedupMaxMemory / dedupKeyBytes,
+		maxBytes: opts.DedupMaxMemory,
+		overflow: opts.DedupOverflow,
+	}
+	if opts.DedupMaxMemory > 0 && d.maxKeys == 0 {
+		d.maxKeys = 1
+	}
+	d.turn = sync.NewCond(&d.lock)
+	opts.dedup = d
+}
+
+// Duplicates returns the number of documents that Dedup dropped.
+func (opts *StreamOptions) Duplicates() uint64 {
+	return atomic.LoadUint64(&opts.numDuplicates)
+}
+
+// documentKey returns the key of document: the values of fields, in dot
+// notation, each of which it must have, or if there are no fields the whole
+// document.
+func documentKey(fields []string, document bson.D) (dedupKey, error) {
+	hash := fnv.New128a()
+	if len(fields) == 0 {
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			return dedupKey{}, fmt.Errorf("error marshalling document: %v", err)
+		}
+		hash.Write(raw)
+	}
+	for _, field := range fields {
+		value, ok := findUpsertValue(field, document)
+		if !ok {
+			return dedupKey{}, fmt.Errorf("dedup field '%v' is missing", field)
+		}
+		raw, err := bson.Marshal(bson.D{{Name: field, Value: value}})
+		if err != nil {
+			return dedupKey{}, fmt.Errorf("error marshalling field '%v': %v", field, err)
+		}
+		hash.Write(raw)
+	}
+	var key dedupKey
+	hash.Sum(key[:0])
+	return key, nil
+}
+
+// wait waits, if ordered, for the turn of the record of the given index.
+// The lock must be held.
+func (d *dedupFilter) wait(index uint64) {
+	for d.ordered && d.next != index && !d.broken {
+		d.turn.Wait()
+	}
+}
+
+// pass takes the turn of a record that has no document to check.
+func (d *dedupFilter) pass(index uint64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.wait(index)
+	d.advance()
+}
+
+// advance hands the turn to the next record. The lock must be held.
+func (d *dedupFilter) advance() {
+	if d.ordered {
+		d.next++
+		d.turn.Broadcast()
+	}
+}
+
+// abort gives up on the order, once streaming has failed, so that no record
+// waits for one that will never take its turn.
+func (d *dedupFilter) abort() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.broken = true
+	d.turn.Broadcast()
+}
+
+// check takes the turn of the record of the given index, adding key to the
+// set, and reports whether it was already there. It returns an error if the
+// set is full and overflow is DedupOverflowError.
+func (d *dedupFilter) check(index uint64, key dedupKey) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.wait(index)
+	defer d.advance()
+	if d.bloom != nil {
+		return !d.bloomAdd(key), nil
+	}
+	if _, ok := d.keys[key]; ok {
+		return true, nil
+	}
+	if d.maxKeys > 0 && int64(len(d.keys)) >= d.maxKeys {
+		if d.overflow != DedupOverflowBloom {
+			return false, fmt.Errorf("deduplication needs more than the %v bytes allowed for the keys of the %v documents so far",
+				d.maxBytes, len(d.keys))
+		}
+		d.bloom = make([]byte, d.maxBytes)
+		for seen := range d.keys {
+			d.bloomAdd(seen)
+		}
+		d.keys = nil
+		d.bloomAdd(key)
+		return false, nil
+	}
+	d.keys[key] = struct{}{}
+	return false, nil
+}
+
+// bloomAdd sets the bits of key in the Bloom filter, and reports whether any
+// of them was unset, in which case key was not in it.
+func (d *dedupFilter) bloomAdd(key dedupKey) bool {
+	bits := uint64(len(d.bloom)) * 8
+	h1 := binary.BigEndian.Uint64(key[:8])
+	h2 := binary.BigEndian.Uint64(key[8:]) | 1
+	added := false
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % bits
+		if d.bloom[bit/8]&(1<<(bit%8)) == 0 {
+			d.bloom[bit/8] |= 1 << (bit % 8)
+			added = true
+		}
+	}
+	return added
+}
@@ -0,0 +1,202 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDedup(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that drops duplicates", t, func() {
+		colSpecs := []ColumnSpec{
+			{"k", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"n", new(FieldInt32Parser), pgStop, "int32"},
+		}
+		// each of 50 keys occurs four times, at lines k, k+50, k+100 and k+150
+		var lines []string
+		for i := 0; i < 200; i++ {
+			lines = append(lines, fmt.Sprintf("key%v\t%v", i%50, i))
+		}
+		contents := strings.Join(lines, "\n") + "\n"
+		newReader := func(contents string) *TSVInputReader {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), nil, 4, false)
+			r.Dedup = true
+			r.DedupFields = []string{"k"}
+			// convert the later records faster, so that they would be first
+			// if nothing kept them in order
+			r.Transform = func(doc bson.D) (bson.D, error) {
+				if n := doc[1].Value.(int32); n < 50 && n%5 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				return doc, nil
+			}
+			return r
+		}
+		stream := func(r *TSVInputReader, ordered bool) ([]bson.D, error) {
+			readDocs := make(chan bson.D, 200)
+			err := r.StreamDocument(ordered, readDocs)
+			var docs []bson.D
+			for doc := range readDocs {
+				docs = append(docs, doc)
+			}
+			return docs, err
+		}
+
+		Convey("streaming in order should keep the first occurrence of each key", func() {
+			for _, batchSize := range []int{0, 7} {
+				r := newReader(contents)
+				r.BatchSize = batchSize
+				docs, err := stream(r, true)
+				So(err, ShouldBeNil)
+				So(len(docs), ShouldEqual, 50)
+				for i, doc := range docs {
+					So(doc, ShouldResemble, bson.D{{"k", fmt.Sprintf("key%v", i)}, {"n", int32(i)}})
+				}
+				So(r.Summary().Duplicates, ShouldEqual, 150)
+				So(r.Summary().Documents, ShouldEqual, 50)
+			}
+		})
+
+		Convey("streaming out of order should keep one occurrence of each key, whichever converts first", func() {
+			r := newReader(contents)
+			docs, err := stream(r, false)
+			So(err, ShouldBeNil)
+			So(len(docs), ShouldEqual, 50)
+			seen := map[interface{}]bool{}
+			for _, doc := range docs {
+				So(seen[doc[0].Value], ShouldBeFalse)
+				seen[doc[0].Value] = true
+				So(doc[0].Value, ShouldEqual, fmt.Sprintf("key%v", doc[1].Value.(int32)%50))
+			}
+			So(r.Summary().Duplicates, ShouldEqual, 150)
+		})
+
+		Convey("without fields, only documents that are the same throughout should be dropped", func() {
+			r := newReader("a\t1\na\t2\na\t1\nb\t1\n")
+			r.DedupFields = nil
+			docs, err := stream(r, true)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{
+				{{"k", "a"}, {"n", int32(1)}},
+				{{"k", "a"}, {"n", int32(2)}},
+				{{"k", "b"}, {"n", int32(1)}},
+			})
+			So(r.Summary().Duplicates, ShouldEqual, 1)
+		})
+
+		Convey("documents that lack the key should fail to convert, but a null key should be a key", func() {
+			for _, batchSize := range []int{0, 2} {
+				var rejects bytes.Buffer
+				r := newReader("a\t1\n\t2\n\t3\n\t4\nb\t5\n")
+				r.BatchSize = batchSize
+				r.IgnoreBlanks = true
+				r.MaxErrors = 1
+				r.Rejects = &rejects
+				r.Transform = func(doc bson.D) (bson.D, error) {
+					if doc[0].Name == "n" && doc[0].Value != int32(2) {
+						return bson.D{{"k", nil}, doc[0]}, nil
+					}
+					return doc, nil
+				}
+				docs, err := stream(r, true)
+				So(err, ShouldBeNil)
+				So(docs, ShouldResemble, []bson.D{
+					{{"k", "a"}, {"n", int32(1)}},
+					{{"k", nil}, {"n", int32(3)}},
+					{{"k", "b"}, {"n", int32(5)}},
+				})
+				So(rejects.String(), ShouldEqual, "\t2\n")
+				So(r.Failures(), ShouldResemble, map[FailureCategory]uint64{FailureDedup: 1})
+				So(r.Summary().Duplicates, ShouldEqual, 1)
+			}
+			r := newReader("\t1\n")
+			r.IgnoreBlanks = true
+			r.Transform = nil
+			_, err := stream(r, true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "dedup field 'k' is missing")
+			So(ErrorCategory(err), ShouldEqual, FailureDedup)
+		})
+
+		Convey("records that fail or are dropped should not hold up those after them", func() {
+			r := newReader("a\t1\nb\tx\na\t2\nc\t3\nc\t4\n")
+			r.MaxErrors = -1
+			transform := r.Transform
+			r.Transform = func(doc bson.D) (bson.D, error) {
+				if doc[1].Value == int32(3) {
+					return nil, nil
+				}
+				return transform(doc)
+			}
+			docs, err := stream(r, true)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []bson.D{
+				{{"k", "a"}, {"n", int32(1)}},
+				{{"k", "c"}, {"n", int32(4)}},
+			})
+			summary := r.Summary()
+			So(summary.Duplicates, ShouldEqual, 1)
+			So(summary.Dropped, ShouldEqual, 1)
+			So(summary.Failed, ShouldEqual, 1)
+		})
+
+		Convey("a stream that fails should not wait for the turns of records that were never converted", func() {
+			r := newReader(contents)
+			r.BatchSize = 7
+			r.Transform = func(doc bson.D) (bson.D, error) {
+				if doc[1].Value == int32(30) {
+					return nil, fmt.Errorf("bad")
+				}
+				return doc, nil
+			}
+			_, err := stream(r, true)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("more keys than DedupMaxMemory allows", func() {
+			Convey("should fail the stream by default", func() {
+				r := newReader(contents)
+				r.DedupMaxMemory = 10 * dedupKeyBytes
+				_, err := stream(r, true)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "deduplication needs more than the 640 bytes")
+			})
+			Convey("should switch to a Bloom filter with DedupOverflowBloom", func() {
+				for _, ordered := range []bool{true, false} {
+					r := newReader(contents)
+					r.DedupMaxMemory = 10 * dedupKeyBytes
+					r.DedupOverflow = DedupOverflowBloom
+					docs, err := stream(r, ordered)
+					So(err, ShouldBeNil)
+					// 50 keys in 5120 bits should not collide
+					So(len(docs), ShouldEqual, 50)
+					So(r.Summary().Duplicates, ShouldEqual, 150)
+				}
+			})
+		})
+	})
+
+	Convey("A dedup overflow policy", t, func() {
+		for name, policy := range map[string]DedupOverflowPolicy{"": DedupOverflowError, "error": DedupOverflowError, "bloom": DedupOverflowBloom} {
+			validated, err := ValidateDedupOverflowPolicy(name)
+			So(err, ShouldBeNil)
+			So(validated, ShouldEqual, policy)
+		}
+		_, err := ValidateDedupOverflowPolicy("spill")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "invalid dedup overflow policy: spill")
+	})
+}
@@ -0,0 +1,136 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BSON is the input type of a sequence of BSON documents, such as a file
+// that mongodump writes, and the type DetectInputType gives input that starts
+// with one.
+const BSON = "bson"
+
+// maxDetectedBSONSize is the largest length that DetectInputType accepts as
+// that of a BSON document: the server's maximum, with room for the overhead
+// of a command.
+const maxDetectedBSONSize = 16*1024*1024 + 16*1024
+
+// InputTypeGuess is the input type that DetectInputType takes an input to
+// have. Confidence, from 0 to 1, is how sure the guess is: 1 for a BSON
+// document that parses, or for JSON, and the confidence of the delimiter that
+// SniffDelimiter guesses for CSV and TSV. A zero Confidence, with an empty
+// Type, means that the input looks like none of them.
+type InputTypeGuess struct {
+	Type       string
+	Confidence float64
+}
+
+// DetectInputType guesses the type of the input in from up to its first 4KB,
+// once any gzip compression and UTF-8 byte order mark are taken off: BSON if
+// it starts with the length of a document that fits it, JSON if its first
+// character, after any white space, is '{' or '[', and otherwise TSV or CSV
+// if SniffDelimiter takes it to be delimited by tabs or commas. The input is
+// only peeked at, so the sample is still read from in afterwards.
+func DetectInputType(in *bufio.Reader) (InputTypeGuess, error) {
+	return detectSample(peekSample(in))
+}
+
+// detectSample is DetectInputType for a sample of the input and the error
+// peeking at it returned, which is io.EOF if the input is shorter.
+func detectSample(sample []byte, err error) (InputTypeGuess, error) {
+	if err != nil && err != io.EOF {
+		return InputTypeGuess{}, err
+	}
+	if bytes.HasPrefix(sample, gzipMagic) {
+		sample, err = gunzipSample(sample, err)
+	}
+	if confidence := bsonConfidence(sample); confidence > 0 {
+		return InputTypeGuess{BSON, confidence}, nil
+	}
+	text := bytes.TrimLeft(bytes.TrimPrefix(sample, UTF8_BOM), " \t\r\n")
+	if len(text) != 0 && (text[0] == '{' || text[0] == '[') {
+		return InputTypeGuess{JSON, 1}, nil
+	}
+	guess, err := sniffSample(sample, err)
+	if err != nil {
+		return InputTypeGuess{}, err
+	}
+	switch guess.Delimiter {
+	case "\t":
+		return InputTypeGuess{TSV, guess.Confidence}, nil
+	case ",":
+		return InputTypeGuess{CSV, guess.Confidence}, nil
+	}
+	return InputTypeGuess{}, nil
+}
+
+// gunzipSample returns as much of the decompressed input as the start of it
+// that sample holds gives, and io.EOF if that is all of it.
+func gunzipSample(sample []byte, err error) ([]byte, error) {
+	gz, gzErr := gzip.NewReader(bytes.NewReader(sample))
+	if gzErr != nil {
+		return nil, io.EOF
+	}
+	decompressed := make([]byte, sniffSampleSize)
+	n, gzErr := io.ReadFull(gz, decompressed)
+	if gzErr == io.EOF || gzErr == io.ErrUnexpectedEOF {
+		// a sample that is cut short ends the decompressed input early, too
+		return decompressed[:n], err
+	}
+	return decompressed[:n], nil
+}
+
+// bsonConfidence returns 1 if sample starts with a whole BSON document that
+// parses, 0.75 if it starts with the length of a document of a valid size,
+// longer than the sample, followed by a valid element, and otherwise 0.
+func bsonConfidence(sample []byte) float64 {
+	if len(sample) < 5 {
+		return 0
+	}
+	size := int(binary.LittleEndian.Uint32(sample))
+	if size < 5 || size > maxDetectedBSONSize {
+		return 0
+	}
+	if size <= len(sample) {
+		if sample[size-1] != 0 || bson.Unmarshal(sample[:size], &bson.D{}) != nil {
+			return 0
+		}
+		return 1
+	}
+	// the first element of a document that is cut short: its type, then
+	// its name, which ends in a NUL
+	switch elementType := sample[4]; {
+	case elementType >= 0x01 && elementType <= 0x13, elementType == 0x7f, elementType == 0xff:
+	default:
+		return 0
+	}
+	if bytes.IndexByte(sample[5:], 0) < 0 {
+		return 0
+	}
+	return 0.75
+}
+
+// confidentInputType returns the type of guess, or an error asking for one
+// to be given if the guess is not confident enough.
+func confidentInputType(guess InputTypeGuess) (string, error) {
+	if guess.Type == "" {
+		return "", fmt.Errorf("can not tell the type of the input; give it with --type")
+	}
+	if guess.Confidence < minSniffConfidence {
+		return "", fmt.Errorf("can not tell the type of the input: %v is the best guess, "+
+			"but with a confidence of only %.2f; give it with --type", guess.Type, guess.Confidence)
+	}
+	return guess.Type, nil
+}
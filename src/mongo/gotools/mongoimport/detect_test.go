@@ -0,0 +1,159 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDetectInputType(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	detect := func(input []byte) InputTypeGuess {
+		guess, err := DetectInputType(bufio.NewReader(bytes.NewReader(input)))
+		So(err, ShouldBeNil)
+		return guess
+	}
+	Convey("The type of an input should be guessed from its start", t, func() {
+		document, err := bson.Marshal(bson.D{{"a", 1}, {"b", "two"}})
+		So(err, ShouldBeNil)
+		large, err := bson.Marshal(bson.D{{"s", strings.Repeat("x", 2*sniffSampleSize)}})
+		So(err, ShouldBeNil)
+		tests := []struct {
+			input string
+			guess InputTypeGuess
+		}{
+			{`{"a": 1}` + "\n" + `{"a": 2}`, InputTypeGuess{JSON, 1}},
+			{"\n  [1, 2, 3]", InputTypeGuess{JSON, 1}},
+			{"\xef\xbb\xbf{\"a\": 1}", InputTypeGuess{JSON, 1}},
+			{"a\tb\tc\n1\t2\t3\n4\t5\t6\n", InputTypeGuess{TSV, 1}},
+			{"a,b,c\n1,2,3\n4,\"5,5\",6\n", InputTypeGuess{CSV, 1}},
+			{string(document) + string(document), InputTypeGuess{BSON, 1}},
+			{string(large), InputTypeGuess{BSON, 0.75}},
+			{"just some words\nof prose\n", InputTypeGuess{}},
+			{"", InputTypeGuess{}},
+		}
+		for _, test := range tests {
+			So(detect([]byte(test.input)), ShouldResemble, test.guess)
+		}
+	})
+	Convey("Compressed input should be guessed from its content", t, func() {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		for i := 0; i < 2000; i++ {
+			gz.Write([]byte("name,count\nwidget,3\n"))
+		}
+		So(gz.Close(), ShouldBeNil)
+		So(compressed.Len(), ShouldBeLessThan, sniffSampleSize)
+		So(detect(compressed.Bytes()), ShouldResemble, InputTypeGuess{CSV, 1})
+	})
+	Convey("Binary data that is not a document should not be taken for BSON", t, func() {
+		document, err := bson.Marshal(bson.D{{"a", 1}})
+		So(err, ShouldBeNil)
+		document[len(document)-1] = 1
+		So(detect(document).Type, ShouldNotEqual, BSON)
+		So(detect([]byte{0xff, 0xff, 0, 0, 0x42, 'a', 0}).Type, ShouldNotEqual, BSON)
+	})
+	Convey("The sample should still be read after it is peeked at", t, func() {
+		input := "a\tb\n" + strings.Repeat("1\t2\n", 2000)
+		in := bufio.NewReader(strings.NewReader(input))
+		guess, err := DetectInputType(in)
+		So(err, ShouldBeNil)
+		So(guess.Type, ShouldEqual, TSV)
+		read, err := ioutil.ReadAll(in)
+		So(err, ShouldBeNil)
+		So(string(read), ShouldEqual, input)
+	})
+	Convey("A guess should only be taken if it is confident", t, func() {
+		inputType, err := confidentInputType(InputTypeGuess{CSV, 0.9})
+		So(err, ShouldBeNil)
+		So(inputType, ShouldEqual, CSV)
+		_, err = confidentInputType(InputTypeGuess{CSV, 0.5})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "--type")
+		_, err = confidentInputType(InputTypeGuess{})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestMongoImportDetectInputType(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With an import that is not given a type", t, func() {
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.ToolOptions.Collection = "c"
+
+		Convey("the type should be detected for stdin and files without an extension", func() {
+			for _, file := range []string{"", "-", "data", "dir.d/data", "https://host/export"} {
+				imp.InputOptions.File = file
+				So(imp.detectsInputType(), ShouldBeTrue)
+			}
+			for _, file := range []string{"data.csv", "data.txt", "https://host/export.json"} {
+				imp.InputOptions.File = file
+				So(imp.detectsInputType(), ShouldBeFalse)
+			}
+			imp.InputOptions.File = ""
+			imp.InputOptions.Type = TSV
+			So(imp.detectsInputType(), ShouldBeFalse)
+		})
+		Convey("it should be left to be detected by ValidateSettings", func() {
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, "")
+			imp.InputOptions.HeaderLine = true
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, "")
+			imp.InputOptions.HeaderLine = false
+			imp.InputOptions.File = "data.txt"
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, JSON)
+		})
+		Convey("the detected type should be validated, and the input replayed", func() {
+			input := "name,count\nwidget,3\ngadget,5\n"
+			source, err := imp.detectInputType(ioutil.NopCloser(strings.NewReader(input)))
+			So(err, ShouldNotBeNil)
+			imp.InputOptions.Type = ""
+			imp.InputOptions.HeaderLine = true
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			source, err = imp.detectInputType(ioutil.NopCloser(strings.NewReader(input)))
+			So(err, ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, CSV)
+			read, err := ioutil.ReadAll(source)
+			So(err, ShouldBeNil)
+			So(string(read), ShouldEqual, input)
+		})
+		Convey("empty input should be taken to be JSON", func() {
+			_, err := imp.detectInputType(ioutil.NopCloser(strings.NewReader("")))
+			So(err, ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, JSON)
+		})
+		Convey("input of no type should fail with a clear error", func() {
+			_, err := imp.detectInputType(ioutil.NopCloser(strings.NewReader("one line of prose\n")))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "can not tell the type of the input")
+		})
+		Convey("BSON input should be detected and imported as BSON", func() {
+			document, err := bson.Marshal(bson.D{{"a", 1}})
+			So(err, ShouldBeNil)
+			source, err := imp.detectInputType(ioutil.NopCloser(bytes.NewReader(document)))
+			So(err, ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, BSON)
+			r, err := imp.getInputReader(source)
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", 1}})
+		})
+	})
+}
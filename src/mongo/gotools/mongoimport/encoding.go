@@ -0,0 +1,239 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// InputEncoding is the character encoding of an input, which the text input
+// readers convert to UTF-8 before they split it into records and fields.
+// The zero value is UTF-8, which is read as it is. Whatever the encoding,
+// input that starts with a UTF-16 byte order mark is read as UTF-16.
+type InputEncoding struct {
+	// name is the encoding's name, for errors
+	name string
+
+	// charmap is the table of a single-byte encoding
+	charmap *charmap.Charmap
+
+	// utf16 is set for UTF-16, which is big-endian if bigEndian is set; a
+	// byte order mark overrides it
+	utf16     bool
+	bigEndian bool
+}
+
+// String returns the name of the encoding.
+func (e InputEncoding) String() string {
+	if e.name == "" {
+		return "UTF-8"
+	}
+	return e.name
+}
+
+// ValidateInputEncoding returns the InputEncoding of the given name, which
+// is UTF-8, UTF-16, which is big-endian unless it starts with a byte order
+// mark, UTF-16LE, UTF-16BE, or the IANA name or alias of a single-byte
+// encoding, such as windows-1252 or latin1, in any case. An empty name is
+// UTF-8.
+func ValidateInputEncoding(name string) (InputEncoding, error) {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8":
+		return InputEncoding{}, nil
+	case "utf-16", "utf16":
+		return InputEncoding{name: "UTF-16", utf16: true, bigEndian: true}, nil
+	case "utf-16le", "utf16le":
+		return InputEncoding{name: "UTF-16LE", utf16: true}, nil
+	case "utf-16be", "utf16be":
+		return InputEncoding{name: "UTF-16BE", utf16: true, bigEndian: true}, nil
+	}
+	encoding, err := ianaindex.IANA.Encoding(name)
+	if err != nil || encoding == nil {
+		return InputEncoding{}, fmt.Errorf("unknown input encoding: %v", name)
+	}
+	table, ok := encoding.(*charmap.Charmap)
+	if !ok {
+		return InputEncoding{}, fmt.Errorf("unsupported input encoding: %v; only UTF-8, UTF-16 and single-byte encodings are supported", name)
+	}
+	return InputEncoding{name: table.String(), charmap: table}, nil
+}
+
+// EncodingError is returned by the text input readers for input that can not
+// be decoded in its InputEncoding.
+type EncodingError struct {
+	// Offset is the byte offset of the bytes in the input, after any
+	// decompression, and Bytes are the bytes that can not be decoded
+	Offset int64
+	Bytes  []byte
+
+	// Encoding is the encoding that the input was decoded in
+	Encoding InputEncoding
+
+	// reason says why the bytes can not be decoded
+	reason string
+}
+
+func (e EncodingError) Error() string {
+	return fmt.Sprintf("invalid %v at byte offset %v: [% x] %v; the input may be in another encoding",
+		e.Encoding, e.Offset, e.Bytes, e.reason)
+}
+
+// decodingReader implements and wraps io.Reader, converting the input to
+// UTF-8 from the encoding that it points to, which is looked at the first
+// time that it is read. UTF-8 input, without a UTF-16 byte order mark, is
+// passed through untouched; other input is decoded by the decoders of
+// golang.org/x/text, which replace what they can not decode, so it is
+// checked by an encodingChecker first. Readers wrap it around the
+// decompressingReader, so the sizeTrackingReader still counts the bytes of
+// the input as it is.
+type decodingReader struct {
+	in       *bufio.Reader
+	encoding *InputEncoding
+
+	// decoded is the reader of the decoded input, once the first Read has
+	// picked the encoding
+	decoded io.Reader
+}
+
+func newDecodingReader(r io.Reader, encoding *InputEncoding) *decodingReader {
+	return &decodingReader{in: bufio.NewReader(r), encoding: encoding}
+}
+
+// newTextReader returns the reader of a text input read through a
+// sizeTrackingReader: decompressed, converted to UTF-8 from encoding, and
+// without a UTF-8 byte order mark.
+func newTextReader(szCount io.Reader, encoding *InputEncoding) io.Reader {
+	return newBomDiscardingReader(newDecodingReader(newDecompressingReader(szCount), encoding))
+}
+
+func (dr *decodingReader) Read(p []byte) (int, error) {
+	if dr.decoded == nil {
+		dr.decoded = dr.start()
+	}
+	return dr.decoded.Read(p)
+}
+
+// start returns the reader of the decoded input, picking how to decode it
+// from its encoding or from a UTF-16 byte order mark at its start, which the
+// decoder discards.
+func (dr *decodingReader) start() io.Reader {
+	active := *dr.encoding
+	if bom, err := dr.in.Peek(2); err == nil {
+		switch {
+		case bom[0] == 0xFF && bom[1] == 0xFE:
+			active = InputEncoding{name: "UTF-16LE", utf16: true}
+		case bom[0] == 0xFE && bom[1] == 0xFF:
+			active = InputEncoding{name: "UTF-16BE", utf16: true, bigEndian: true}
+		}
+	}
+	var decoder transform.Transformer
+	switch {
+	case active.utf16 && active.bigEndian:
+		decoder = unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder()
+	case active.utf16:
+		decoder = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder()
+	case active.charmap != nil:
+		decoder = active.charmap.NewDecoder()
+	default:
+		return dr.in
+	}
+	return transform.NewReader(dr.in, transform.Chain(&encodingChecker{encoding: active}, decoder))
+}
+
+// encodingChecker is a transform.Transformer that passes on the input up
+// to the first bytes that can not be decoded in its encoding, a single-byte
+// or UTF-16 one, for which it returns an EncodingError.
+type encodingChecker struct {
+	transform.NopResetter
+	encoding InputEncoding
+
+	// offset is the offset of the next byte of the input
+	offset int64
+}
+
+func (c *encodingChecker) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		size, err := c.check(src[nSrc:], atEOF)
+		if err != nil {
+			return nDst, nSrc, err
+		}
+		if nDst+size > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], src[nSrc:nSrc+size])
+		nSrc += size
+		c.offset += int64(size)
+	}
+	return nDst, nSrc, nil
+}
+
+// check returns the size of the character that b starts with, once it has
+// checked that it is one in the encoding. atEOF says whether b ends the input.
+func (c *encodingChecker) check(b []byte, atEOF bool) (int, error) {
+	if !c.encoding.utf16 {
+		if c.encoding.charmap.DecodeByte(b[0]) == utf8.RuneError {
+			return 0, c.encodingError(0, b[:1], "is not a character in it")
+		}
+		return 1, nil
+	}
+	if len(b) < 2 {
+		return 0, c.shortError(b, atEOF, "is a single byte at the end of the input")
+	}
+	switch unit := c.unit(b); {
+	case unit >= 0xDC00 && unit < 0xE000:
+		return 0, c.encodingError(0, b[:2], "is a low surrogate without a high one before it")
+	case unit >= 0xD800 && unit < 0xDC00:
+		if len(b) < 4 {
+			return 0, c.shortError(b, atEOF, "is a high surrogate at the end of the input")
+		}
+		if low := c.unit(b[2:]); low < 0xDC00 || low >= 0xE000 {
+			return 0, c.encodingError(0, b[:4], "is a high surrogate without a low one after it")
+		}
+		return 4, nil
+	}
+	return 2, nil
+}
+
+// shortError returns the error for b, the start of a character that the
+// input, if atEOF, ends before the end of, and otherwise transform.ErrShortSrc.
+func (c *encodingChecker) shortError(b []byte, atEOF bool, reason string) error {
+	switch {
+	case !atEOF:
+		return transform.ErrShortSrc
+	case len(b)%2 == 1:
+		return c.encodingError(len(b)-1, b[len(b)-1:], "is a single byte at the end of the input")
+	}
+	return c.encodingError(0, b, reason)
+}
+
+// unit returns the UTF-16 code unit that b starts with.
+func (c *encodingChecker) unit(b []byte) uint16 {
+	if c.encoding.bigEndian {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return uint16(b[1])<<8 | uint16(b[0])
+}
+
+// encodingError returns an EncodingError for the bytes at skip bytes past
+// the offset of the next byte.
+func (c *encodingChecker) encodingError(skip int, bytes []byte, reason string) error {
+	return EncodingError{
+		Offset:   c.offset + int64(skip),
+		Bytes:    append([]byte(nil), bytes...),
+		Encoding: c.encoding,
+		reason:   reason,
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// encodeUTF16 returns s in UTF-16, little-endian unless bigEndian is set.
+func encodeUTF16(s string, bigEndian bool) []byte {
+	var out []byte
+	for _, unit := range utf16.Encode([]rune(s)) {
+		if bigEndian {
+			out = append(out, byte(unit>>8), byte(unit))
+		} else {
+			out = append(out, byte(unit), byte(unit>>8))
+		}
+	}
+	return out
+}
+
+func TestValidateInputEncoding(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With ValidateInputEncoding", t, func() {
+		Convey("UTF-8 should be the zero value, by any of its names", func() {
+			for _, name := range []string{"", "utf-8", "UTF8"} {
+				encoding, err := ValidateInputEncoding(name)
+				So(err, ShouldBeNil)
+				So(encoding, ShouldResemble, InputEncoding{})
+				So(encoding.String(), ShouldEqual, "UTF-8")
+			}
+		})
+		Convey("UTF-16 and single-byte encodings should be accepted in any case", func() {
+			for name, expected := range map[string]string{
+				"utf-16":       "UTF-16",
+				"UTF-16LE":     "UTF-16LE",
+				"utf16be":      "UTF-16BE",
+				"windows-1252": "Windows 1252",
+				"Latin1":       "ISO 8859-1",
+				"KOI8-R":       "KOI8-R",
+			} {
+				encoding, err := ValidateInputEncoding(name)
+				So(err, ShouldBeNil)
+				So(encoding.String(), ShouldEqual, expected)
+			}
+		})
+		Convey("unknown and multi-byte encodings should be rejected", func() {
+			_, err := ValidateInputEncoding("klingon")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown input encoding")
+			_, err = ValidateInputEncoding("Shift_JIS")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unsupported input encoding")
+		})
+	})
+}
+
+func TestDecodingReader(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a decoding reader", t, func() {
+		decode := func(name string, input []byte) (string, error) {
+			encoding, err := ValidateInputEncoding(name)
+			So(err, ShouldBeNil)
+			decoded, err := ioutil.ReadAll(newTextReader(bytes.NewReader(input), &encoding))
+			return string(decoded), err
+		}
+
+		Convey("UTF-8 should be passed through, without its byte order mark", func() {
+			decoded, err := decode("", []byte("\xef\xbb\xbfcaf\xc3\xa9\n"))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldEqual, "café\n")
+		})
+		Convey("Windows-1252 should be converted to UTF-8", func() {
+			decoded, err := decode("windows-1252", []byte("caf\xe9,\x80 5\n"))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldEqual, "café,€ 5\n")
+		})
+		Convey("a byte that is not a character should be an error with its offset", func() {
+			_, err := decode("windows-1252", []byte("abc\x81def"))
+			So(err, ShouldHaveSameTypeAs, EncodingError{})
+			So(err.(EncodingError).Offset, ShouldEqual, 3)
+			So(err.Error(), ShouldContainSubstring, "invalid Windows 1252 at byte offset 3: [81]")
+			So(err.Error(), ShouldContainSubstring, "another encoding")
+		})
+		Convey("UTF-16 should be detected by its byte order mark, whatever the encoding", func() {
+			text := "a\tb 𝄞\n"
+			for _, name := range []string{"", "windows-1252", "utf-16be"} {
+				decoded, err := decode(name, append([]byte{0xFF, 0xFE}, encodeUTF16(text, false)...))
+				So(err, ShouldBeNil)
+				So(decoded, ShouldEqual, text)
+			}
+			decoded, err := decode("", append([]byte{0xFE, 0xFF}, encodeUTF16(text, true)...))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldEqual, text)
+		})
+		Convey("UTF-16 without a byte order mark should be read in the configured order", func() {
+			decoded, err := decode("utf-16le", encodeUTF16("héllo", false))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldEqual, "héllo")
+			decoded, err = decode("utf-16", encodeUTF16("héllo", true))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldEqual, "héllo")
+		})
+		Convey("input longer than the buffers of the decoder should be decoded whole", func() {
+			text := "a" + strings.Repeat("𝄞é", 3000)
+			decoded, err := decode("utf-16le", encodeUTF16(text, false))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldEqual, text)
+			_, err = decode("windows-1252", append(bytes.Repeat([]byte("caf\xe9"), 3000), 0x81))
+			So(err, ShouldNotBeNil)
+			So(err.(EncodingError).Offset, ShouldEqual, 12000)
+		})
+		Convey("broken UTF-16 should be an error with its offset", func() {
+			_, err := decode("utf-16le", append(encodeUTF16("ab", false), 0x00, 0xD8, 'c', 0x00))
+			So(err, ShouldNotBeNil)
+			So(err.(EncodingError).Offset, ShouldEqual, 4)
+			So(err.Error(), ShouldContainSubstring, "high surrogate without a low one")
+			_, err = decode("utf-16le", append(encodeUTF16("ab", false), 'c'))
+			So(err, ShouldNotBeNil)
+			So(err.(EncodingError).Offset, ShouldEqual, 4)
+			So(err.Error(), ShouldContainSubstring, "single byte at the end")
+			_, err = decode("utf-16be", []byte{0xDC, 0x00})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "low surrogate")
+		})
+	})
+
+	Convey("With a CSV input reader of UTF-16 input", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+		input := append([]byte{0xFF, 0xFE}, encodeUTF16("1,\"é, ü\"\r\n2,x\r\n", false)...)
+		r := NewCSVInputReader(colSpecs, bytes.NewReader(input), os.Stdout, 1, false)
+		docChan := make(chan bson.D, 2)
+		So(r.StreamDocument(true, docChan), ShouldBeNil)
+		Convey("the records should be split and parsed as UTF-8", func() {
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", "é, ü"}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(2)}, {"b", "x"}})
+		})
+		Convey("the bytes read should be those of the input", func() {
+			So(r.Size(), ShouldEqual, len(input))
+		})
+	})
+}
@@ -0,0 +1,328 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExtJSONFormat is one of the two formats of extended JSON: relaxed, which
+// writes numbers and most dates as plain JSON, or canonical, which keeps the
+// exact BSON type of every value.
+type ExtJSONFormat string
+
+// The formats of extended JSON that an ExtJSONWriter writes.
+const (
+	ExtJSONRelaxed   ExtJSONFormat = "relaxed"
+	ExtJSONCanonical ExtJSONFormat = "canonical"
+)
+
+// ParseExtJSONFormat returns the ExtJSONFormat named by format.
+func ParseExtJSONFormat(format string) (ExtJSONFormat, error) {
+	switch ExtJSONFormat(format) {
+	case ExtJSONRelaxed, ExtJSONCanonical:
+		return ExtJSONFormat(format), nil
+	}
+	return "", fmt.Errorf("invalid extended JSON format '%v': must be 'relaxed' or 'canonical'", format)
+}
+
+// ExtJSONWriter writes documents to an io.Writer as extended JSON, one
+// document per line, keeping the order of the fields of each bson.D.
+// Output is buffered until Flush is called.
+type ExtJSONWriter struct {
+	out       *bufio.Writer
+	canonical bool
+	buf       []byte
+}
+
+// NewExtJSONWriter returns an ExtJSONWriter that writes to out in the given
+// format.
+func NewExtJSONWriter(out io.Writer, format ExtJSONFormat) *ExtJSONWriter {
+	return &ExtJSONWriter{
+		out:       bufio.NewWriter(out),
+		canonical: format == ExtJSONCanonical,
+	}
+}
+
+// WriteDocument writes document, followed by a newline. It returns an error
+// if document holds a value that has no extended JSON form, in which case
+// nothing is written, or if writing fails.
+func (w *ExtJSONWriter) WriteDocument(document bson.D) error {
+	buf, err := w.appendValue(w.buf[:0], document)
+	if err != nil {
+		return err
+	}
+	w.buf = append(buf, '\n')
+	if _, err := w.out.Write(w.buf); err != nil {
+		return fmt.Errorf("error writing extended JSON: %v", err)
+	}
+	return nil
+}
+
+// Flush writes any buffered output to the underlying io.Writer.
+func (w *ExtJSONWriter) Flush() error {
+	if err := w.out.Flush(); err != nil {
+		return fmt.Errorf("error writing extended JSON: %v", err)
+	}
+	return nil
+}
+
+// WriteExtJSON streams the documents of r, in order, to out as extended
+// JSON, one per line, rather than importing them. It returns the number of
+// documents written; a read error and a write error each stop streaming, and
+// the first of them is returned once r has returned. A write error stops r,
+// if it has a Stop method, as StreamOptions does, and otherwise the rest of
+// its documents are read and discarded.
+func WriteExtJSON(r InputReader, out io.Writer, format ExtJSONFormat) (uint64, error) {
+	w := NewExtJSONWriter(out, format)
+	readDocs := make(chan bson.D, workerBufferSize)
+	// buffered so neither goroutine blocks once the other's error is returned
+	errChan := make(chan error, 2)
+	var numWritten uint64
+	go func() {
+		errChan <- r.StreamDocument(true, readDocs)
+	}()
+	go func() {
+		for document := range readDocs {
+			if err := w.WriteDocument(document); err != nil {
+				errChan <- err
+				// the reader blocks until its documents are received
+				if stopper, ok := r.(interface {
+					Stop() uint64
+				}); ok {
+					go stopper.Stop()
+				}
+				for range readDocs {
+				}
+				return
+			}
+			atomic.AddUint64(&numWritten, 1)
+		}
+		errChan <- w.Flush()
+	}()
+	err := <-errChan
+	if otherErr := <-errChan; err == nil {
+		err = otherErr
+	}
+	return atomic.LoadUint64(&numWritten), err
+}
+
+// appendValue appends the extended JSON form of value to buf.
+func (w *ExtJSONWriter) appendValue(buf []byte, value interface{}) ([]byte, error) {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		return append(buf, "null"...), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case string:
+		return appendJSONString(buf, v), nil
+	case int32:
+		return w.appendInteger(buf, "$numberInt", int64(v)), nil
+	case int:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return w.appendInteger(buf, "$numberInt", int64(v)), nil
+		}
+		return w.appendInteger(buf, "$numberLong", int64(v)), nil
+	case int64:
+		return w.appendInteger(buf, "$numberLong", v), nil
+	case float64:
+		return w.appendDouble(buf, v), nil
+	case float32:
+		return w.appendDouble(buf, float64(v)), nil
+	case bson.D:
+		buf = append(buf, '{')
+		for i, elem := range v {
+			if i != 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(appendJSONString(buf, elem.Name), ':')
+			if buf, err = w.appendValue(buf, elem.Value); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	case bson.M:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		document := make(bson.D, len(names))
+		for i, name := range names {
+			document[i] = bson.DocElem{Name: name, Value: v[name]}
+		}
+		return w.appendValue(buf, document)
+	case map[string]interface{}:
+		return w.appendValue(buf, bson.M(v))
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, element := range v {
+			if i != 0 {
+				buf = append(buf, ',')
+			}
+			if buf, err = w.appendValue(buf, element); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case bson.ObjectId:
+		return append(appendJSONString(append(buf, `{"$oid":`...), v.Hex()), '}'), nil
+	case bson.Decimal128:
+		return append(appendJSONString(append(buf, `{"$numberDecimal":`...), v.String()), '}'), nil
+	case time.Time:
+		return w.appendDate(buf, v), nil
+	case []byte:
+		return appendBinary(buf, 0x00, v), nil
+	case bson.Binary:
+		return appendBinary(buf, v.Kind, v.Data), nil
+	case bson.RegEx:
+		options := []byte(v.Options)
+		sort.Slice(options, func(i, j int) bool { return options[i] < options[j] })
+		buf = appendJSONString(append(buf, `{"$regularExpression":{"pattern":`...), v.Pattern)
+		buf = appendJSONString(append(buf, `,"options":`...), string(options))
+		return append(buf, "}}"...), nil
+	case bson.MongoTimestamp:
+		buf = strconv.AppendUint(append(buf, `{"$timestamp":{"t":`...), uint64(v)>>32, 10)
+		buf = strconv.AppendUint(append(buf, `,"i":`...), uint64(uint32(v)), 10)
+		return append(buf, "}}"...), nil
+	case bson.JavaScript:
+		buf = appendJSONString(append(buf, `{"$code":`...), v.Code)
+		if v.Scope != nil {
+			if buf, err = w.appendValue(append(buf, `,"$scope":`...), v.Scope); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	case bson.Symbol:
+		return append(appendJSONString(append(buf, `{"$symbol":`...), string(v)), '}'), nil
+	case bson.DBPointer:
+		buf = appendJSONString(append(buf, `{"$dbPointer":{"$ref":`...), v.Namespace)
+		buf = append(appendJSONString(append(buf, `,"$id":{"$oid":`...), v.Id.Hex()), "}}}"...)
+		return buf, nil
+	case mgo.DBRef:
+		ref := bson.D{{"$ref", v.Collection}, {"$id", v.Id}}
+		if v.Database != "" {
+			ref = append(ref, bson.DocElem{Name: "$db", Value: v.Database})
+		}
+		return w.appendValue(buf, ref)
+	}
+	switch value {
+	case bson.MinKey:
+		return append(buf, `{"$minKey":1}`...), nil
+	case bson.MaxKey:
+		return append(buf, `{"$maxKey":1}`...), nil
+	case bson.Undefined:
+		return append(buf, `{"$undefined":true}`...), nil
+	}
+	return nil, fmt.Errorf("can not write a value of type %T as extended JSON", value)
+}
+
+// appendInteger appends an integer, which is a plain number in relaxed
+// extended JSON, and otherwise wrapped in the given type key.
+func (w *ExtJSONWriter) appendInteger(buf []byte, key string, n int64) []byte {
+	if !w.canonical {
+		return strconv.AppendInt(buf, n, 10)
+	}
+	buf = append(append(append(buf, `{"`...), key...), `":"`...)
+	return append(strconv.AppendInt(buf, n, 10), `"}`...)
+}
+
+// appendDouble appends a double, which is a plain number in relaxed extended
+// JSON unless it is infinite or NaN. Integral values keep a ".0", so that
+// they are not read back as integers.
+func (w *ExtJSONWriter) appendDouble(buf []byte, f float64) []byte {
+	var s string
+	switch {
+	case math.IsNaN(f):
+		s = "NaN"
+	case math.IsInf(f, 1):
+		s = "Infinity"
+	case math.IsInf(f, -1):
+		s = "-Infinity"
+	default:
+		s = strconv.FormatFloat(f, 'G', -1, 64)
+		if !strings.ContainsAny(s, ".E") {
+			s += ".0"
+		}
+		if !w.canonical {
+			return append(buf, s...)
+		}
+	}
+	return append(appendJSONString(append(buf, `{"$numberDouble":`...), s), '}')
+}
+
+// appendDate appends a date, which in relaxed extended JSON is an ISO-8601
+// string if its year is from 1970 to 9999, and otherwise milliseconds since
+// the epoch.
+func (w *ExtJSONWriter) appendDate(buf []byte, t time.Time) []byte {
+	t = t.UTC()
+	if !w.canonical && t.Year() >= 1970 && t.Year() <= 9999 {
+		buf = append(buf, `{"$date":"`...)
+		return append(t.AppendFormat(buf, "2006-01-02T15:04:05.999Z07:00"), `"}`...)
+	}
+	ms := t.Unix()*1000 + int64(t.Nanosecond()/1e6)
+	buf = strconv.AppendInt(append(buf, `{"$date":{"$numberLong":"`...), ms, 10)
+	return append(buf, `"}}`...)
+}
+
+// appendBinary appends binary data of the given subtype.
+func appendBinary(buf []byte, subtype byte, data []byte) []byte {
+	buf = append(append(buf, `{"$binary":{"base64":"`...), base64.StdEncoding.EncodeToString(data)...)
+	buf = append(buf, `","subType":"`...)
+	buf = append(buf, fmt.Sprintf("%02x", subtype)...)
+	return append(buf, `"}}`...)
+}
+
+// appendJSONString appends s as a quoted JSON string. Bytes that are not
+// valid UTF-8 are replaced with U+FFFD, so the output is always valid JSON.
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				buf = append(buf, '\\', c)
+			case c == '\n':
+				buf = append(buf, '\\', 'n')
+			case c == '\r':
+				buf = append(buf, '\\', 'r')
+			case c == '\t':
+				buf = append(buf, '\\', 't')
+			case c < 0x20:
+				buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			default:
+				buf = append(buf, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, "\ufffd"...)
+		} else {
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(buf, '"')
+}
@@ -0,0 +1,98 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestExtJSONWriter(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a document of many types", t, func() {
+		document := bson.D{
+			{"z", int32(1)},
+			{"a", int64(2)},
+			{"d", 2.0},
+			{"inf", math.Inf(-1)},
+			{"when", time.Date(2016, 3, 4, 5, 6, 7, 8e6, time.UTC)},
+			{"id", bson.ObjectIdHex("5a934e000102030405000000")},
+			{"bin", bson.Binary{Kind: 0x05, Data: []byte{1, 2}}},
+			{"tags", []interface{}{"x", nil, true}},
+			{"sub", bson.D{{"s", "a\"b\n\xff"}}},
+		}
+		write := func(format ExtJSONFormat) string {
+			var out bytes.Buffer
+			w := NewExtJSONWriter(&out, format)
+			So(w.WriteDocument(document), ShouldBeNil)
+			So(w.Flush(), ShouldBeNil)
+			return out.String()
+		}
+
+		Convey("relaxed extended JSON should write numbers and dates plainly, in field order", func() {
+			So(write(ExtJSONRelaxed), ShouldEqual, `{"z":1,"a":2,"d":2.0,"inf":{"$numberDouble":"-Infinity"},`+
+				`"when":{"$date":"2016-03-04T05:06:07.008Z"},"id":{"$oid":"5a934e000102030405000000"},`+
+				`"bin":{"$binary":{"base64":"AQI=","subType":"05"}},"tags":["x",null,true],"sub":{"s":"a\"b\n`+"\ufffd"+`"}}`+"\n")
+		})
+		Convey("canonical extended JSON should keep the type of every value", func() {
+			So(write(ExtJSONCanonical), ShouldEqual, `{"z":{"$numberInt":"1"},"a":{"$numberLong":"2"},`+
+				`"d":{"$numberDouble":"2.0"},"inf":{"$numberDouble":"-Infinity"},`+
+				`"when":{"$date":{"$numberLong":"1457067967008"}},"id":{"$oid":"5a934e000102030405000000"},`+
+				`"bin":{"$binary":{"base64":"AQI=","subType":"05"}},"tags":["x",null,true],"sub":{"s":"a\"b\n`+"\ufffd"+`"}}`+"\n")
+		})
+		Convey("a value with no extended JSON form should be an error", func() {
+			w := NewExtJSONWriter(&bytes.Buffer{}, ExtJSONRelaxed)
+			So(w.WriteDocument(bson.D{{"c", make(chan int)}}), ShouldNotBeNil)
+		})
+	})
+
+	Convey("With a TSV reader of typed columns", t, func() {
+		colSpecs := []ColumnSpec{
+			{"n", new(FieldInt32Parser), pgStop, "int32"},
+			{"tags", &FieldArrayParser{";", new(FieldStringParser)}, pgStop, "array"},
+		}
+		contents := strings.Repeat("1\ta;b\n", 1000)
+		r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 2, false)
+
+		Convey("the documents should be written in order, one per line", func() {
+			var out bytes.Buffer
+			numWritten, err := WriteExtJSON(r, &out, ExtJSONRelaxed)
+			So(err, ShouldBeNil)
+			So(numWritten, ShouldEqual, 1000)
+			So(out.String(), ShouldEqual, strings.Repeat(`{"n":1,"tags":["a","b"]}`+"\n", 1000))
+		})
+		Convey("an error writing the output should stop the stream", func() {
+			_, err := WriteExtJSON(r, failingWriter{}, ExtJSONRelaxed)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "error writing extended JSON: disk full")
+			So(r.NumProcessed(), ShouldBeLessThan, 1000)
+		})
+	})
+
+	Convey("Only the relaxed and canonical formats should be accepted", t, func() {
+		_, err := ParseExtJSONFormat("canonical")
+		So(err, ShouldBeNil)
+		_, err = ParseExtJSONFormat("shell")
+		So(err, ShouldNotBeNil)
+	})
+}
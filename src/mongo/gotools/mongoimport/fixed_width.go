@@ -0,0 +1,339 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FixedWidthColumn describes where a column sits within each line of
+// fixed-width input. Start and End are byte offsets: the column occupies
+// bytes [Start, End) of the line, regardless of how those bytes decode.
+type FixedWidthColumn struct {
+	Name  string
+	Start int
+	End   int
+}
+
+// ShortLinePolicy controls how lines that end before the last column's End
+// offset are handled by a FixedWidthInputReader.
+type ShortLinePolicy int
+
+const (
+	// ShortLineError rejects short lines with an error.
+	ShortLineError ShortLinePolicy = iota
+
+	// ShortLinePad pads short lines with spaces, so a column that is cut off
+	// keeps whatever part of it is present.
+	ShortLinePad
+
+	// ShortLineBlank treats every column that is not wholly present as blank.
+	ShortLineBlank
+)
+
+// FixedWidthInputReader is a struct that implements the InputReader interface
+// for input in which each field occupies a fixed byte range of every line.
+// Since columns are sliced by byte offset, a column boundary that falls
+// inside a multibyte UTF-8 character splits that character.
+//
+// The exported fields can be changed to customize the details before the
+// first call to ReadAndValidateHeader or StreamDocument.
+type FixedWidthInputReader struct {
+	// ShortLines determines how lines shorter than the last column are handled
+	ShortLines ShortLinePolicy
+
+	// MaxRecordSize is the length in bytes, including its terminator, of the
+	// longest line that is read; a longer one is a RecordTooLargeError. Zero
+	// means 16MB, the largest document the server accepts.
+	MaxRecordSize int
+
+	// Encoding is the character encoding of the input, which is converted
+	// to UTF-8 as it is read, and so must be set before anything is.
+	Encoding InputEncoding
+
+	// columns is the byte range of each column within a line
+	columns []FixedWidthColumn
+
+	// colSpecs is a list of column specifications in the BSON documents to be imported
+	colSpecs []ColumnSpec
+
+	// fixedWidthReader is the underlying reader used to read lines from the input source
+	fixedWidthReader *bufio.Reader
+
+	// fixedWidthRejectWriter is where coercion-failed rows are written, if applicable
+	fixedWidthRejectWriter io.Writer
+
+	// numProcessed is the number of lines read in full, whether handed
+	// over to be converted or skipped by SkipRecords, but not one that fails
+	// to be read, so the one that does is number numProcessed+1; it is
+	// updated atomically, so it can be read while StreamDocument runs
+	numProcessed uint64
+
+	// embedded sizeTracker exposes the Size() method to check the number of bytes read so far
+	sizeTracker
+
+	// embedded ConvertOptions controls how each line's tokens are converted
+	ConvertOptions
+
+	// embedded StreamOptions controls how lines that fail to convert are handled
+	StreamOptions
+
+	// originalHeader is the column names, before normalization
+	originalHeader []string
+}
+
+// FixedWidthConverter implements the Converter interface for fixed-width input.
+type FixedWidthConverter struct {
+	colSpecs     []ColumnSpec
+	columns      []FixedWidthColumn
+	shortLines   ShortLinePolicy
+	data         string
+	index        uint64
+	rejectWriter io.Writer
+	options      *ConvertOptions
+}
+
+// NewFixedWidthInputReader returns a FixedWidthInputReader configured to read
+// input from the given io.Reader, slicing each line into the given columns.
+// It returns an error if a column's byte range is invalid.
+func NewFixedWidthInputReader(columns []FixedWidthColumn, in io.Reader, rejects io.Writer, numDecoders int, ignoreBlanks bool) (*FixedWidthInputReader, error) {
+	return NewFixedWidthInputReaderSize(columns, in, rejects, numDecoders, ignoreBlanks, defaultReadBufferSize)
+}
+
+// NewFixedWidthInputReaderSize is like NewFixedWidthInputReader, but reads
+// the input through a buffer of bufferSize bytes. Lines of any length up to
+// MaxRecordSize are read whatever the buffer size, but reading lines much
+// longer than the buffer is slower.
+func NewFixedWidthInputReaderSize(columns []FixedWidthColumn, in io.Reader, rejects io.Writer, numDecoders int, ignoreBlanks bool, bufferSize int) (*FixedWidthInputReader, error) {
+	for _, column := range columns {
+		if column.Start < 0 || column.End <= column.Start {
+			return nil, fmt.Errorf("invalid byte range [%v, %v) for column '%v'",
+				column.Start, column.End, column.Name)
+		}
+	}
+	szCount := newSizeTrackingReader(in)
+	r := &FixedWidthInputReader{
+		columns:                columns,
+		colSpecs:               ParseAutoHeaders(fixedWidthColumnNames(columns)),
+		fixedWidthRejectWriter: rejects,
+		sizeTracker:            szCount,
+		ConvertOptions:         ConvertOptions{IgnoreBlanks: ignoreBlanks},
+		StreamOptions:          StreamOptions{numDecoders: effectiveNumDecoders(numDecoders)},
+	}
+	r.fixedWidthReader = bufio.NewReaderSize(newTextReader(szCount, &r.Encoding), bufferSize)
+	return r, nil
+}
+
+// ParseColumnWidths parses widths, a comma-separated list of the widths in
+// bytes of the columns of fixed-width input, as --columnWidths gives them.
+func ParseColumnWidths(widths string) ([]int, error) {
+	if widths == "" {
+		return nil, fmt.Errorf("no column widths given")
+	}
+	var parsed []int
+	for _, width := range strings.Split(widths, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(width))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid column width '%v': must be a positive number of bytes", width)
+		}
+		parsed = append(parsed, n)
+	}
+	return parsed, nil
+}
+
+// fixedWidthColumnNames maps a FixedWidthColumn slice to their associated names
+func fixedWidthColumnNames(columns []FixedWidthColumn) (names []string) {
+	for _, column := range columns {
+		names = append(names, column.Name)
+	}
+	return
+}
+
+// ReadAndValidateHeader validates the column names. Fixed-width input has no
+// header line, so nothing is read from the underlying reader.
+func (r *FixedWidthInputReader) ReadAndValidateHeader() error {
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	return r.validateColumns(r.colSpecs)
+}
+
+// ReadAndValidateTypedHeader parses types from the column names and validates
+// them. Fixed-width input has no header line, so nothing is read from the
+// underlying reader.
+func (r *FixedWidthInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err error) {
+	r.colSpecs, err = ParseTypedHeaders(fixedWidthColumnNames(r.columns), parseGrace)
+	if err != nil {
+		return err
+	}
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	return r.validateColumns(r.colSpecs)
+}
+
+// OriginalHeader returns the column names as they were before normalization
+// and sanitization, or nil if the header has not been read and validated.
+func (r *FixedWidthInputReader) OriginalHeader() []string {
+	return r.originalHeader
+}
+
+// Fields returns the names of the fields that documents are given, once the
+// columns have been validated. The slice is the caller's to change.
+func (r *FixedWidthInputReader) Fields() []string {
+	return r.fieldNames(r.colSpecs)
+}
+
+// Progress returns a snapshot of how far StreamDocument has got, counting
+// lines as records. It is safe to call while StreamDocument is running.
+func (r *FixedWidthInputReader) Progress() ReaderProgress {
+	return r.progress(r.Size())
+}
+
+// StreamDocument takes a boolean indicating if the documents should be streamed
+// in read order and a channel on which to stream the documents processed from
+// the underlying reader. Returns a non-nil error if streaming fails.
+func (r *FixedWidthInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	return r.streamTo(ordered, docSink(readDocs))
+}
+
+// streamTo is StreamDocument for the documents that sink takes.
+func (r *FixedWidthInputReader) streamTo(ordered bool, sink documentSink) error {
+	r.beginStream(r.Size)
+	r.utf8Replacements = &r.numReplaced
+	// cancelling on return stops the read loop if decoding fails first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rawChan := make(chan Converter, r.recordBuffer())
+	// buffered so neither goroutine blocks once the other's error is returned
+	fixedWidthErrChan := make(chan error, 2)
+
+	// begin reading from source
+	go func() {
+		for {
+			if r.stopped() {
+				close(rawChan)
+				fixedWidthErrChan <- nil
+				return
+			}
+			rawLine, err := readBoundedLine(r.fixedWidthReader, maxRecordSize(r.MaxRecordSize))
+			line := string(rawLine)
+			if line != "" && r.skipsRecord(r.numProcessed) {
+				atomic.AddUint64(&r.numProcessed, 1)
+			} else if line != "" {
+				if err := r.handOver(ctx, rawChan, r.wrapConverter(FixedWidthConverter{
+					colSpecs:     r.colSpecs,
+					columns:      r.columns,
+					shortLines:   r.ShortLines,
+					data:         line,
+					index:        r.numProcessed,
+					rejectWriter: r.fixedWidthRejectWriter,
+					options:      &r.ConvertOptions,
+				}, len(rawLine))); err != nil {
+					close(rawChan)
+					fixedWidthErrChan <- err
+					return
+				}
+				atomic.AddUint64(&r.numProcessed, 1)
+			}
+			if err != nil {
+				close(rawChan)
+				if err == io.EOF {
+					fixedWidthErrChan <- nil
+				} else if err == errLineTooLong {
+					fixedWidthErrChan <- RecordTooLargeError{r.numProcessed + 1, maxRecordSize(r.MaxRecordSize)}
+				} else {
+					fixedWidthErrChan <- fmt.Errorf("read error on line %v: %v", r.numProcessed+1, err)
+				}
+				return
+			}
+		}
+	}()
+
+	// begin processing read bytes
+	go func() {
+		fixedWidthErrChan <- r.streamConverted(context.Background(), ordered, rawChan, sink)
+	}()
+
+	return channelQuorumError(fixedWidthErrChan, 2)
+}
+
+// Convert implements the Converter interface for fixed-width input. It
+// converts a FixedWidthConverter struct to a BSON document.
+func (c FixedWidthConverter) Convert() (b bson.D, err error) {
+	tokens, err := c.tokens()
+	if err == nil {
+		b, err = tokensToBSON(c.colSpecs, tokens, c.index, c.options)
+	}
+	return b, c.conversionError(err)
+}
+
+// ConvertRaw implements the RawConverter interface for fixed-width input,
+// building the BSON of the document without a bson.D.
+func (c FixedWidthConverter) ConvertRaw() (raw []byte, err error) {
+	tokens, err := c.tokens()
+	if err == nil {
+		raw, err = tokensToRaw(c.colSpecs, tokens, c.index, c.options)
+	}
+	return raw, c.conversionError(err)
+}
+
+// conversionError returns nil for a row that was skipped, once it is
+// printed, and otherwise err with the position of the record.
+func (c FixedWidthConverter) conversionError(err error) error {
+	if _, ok := err.(coercionError); ok {
+		// a skipped row is printed unless its columns are anonymized
+		if c.options.quotesRecords() {
+			c.Print()
+		}
+		return nil
+	} else if err != nil {
+		// fixed-width input has no header line, so each line is a record
+		return c.options.recordError(c.index+1, c.index+1, c.line(), err)
+	}
+	return nil
+}
+
+// tokens slices the converter's line into one token per column, trimming
+// surrounding whitespace from each.
+func (c FixedWidthConverter) tokens() ([]string, error) {
+	line := c.line()
+	tokens := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		if column.End > len(line) {
+			switch c.shortLines {
+			case ShortLinePad:
+				line += strings.Repeat(" ", column.End-len(line))
+			case ShortLineBlank:
+				continue
+			default:
+				return nil, categorizedError{FailureRaggedRow, fmt.Errorf("field '%v': line of %v bytes is too short for a column "+
+					"ending at byte %v", column.Name, len(c.line()), column.End)}
+			}
+		}
+		tokens[i] = strings.TrimSpace(line[column.Start:column.End])
+	}
+	return tokens, nil
+}
+
+// line returns the converter's line without its line terminator.
+func (c FixedWidthConverter) line() string {
+	return strings.TrimRight(c.data, "\r\n")
+}
+
+// rawRecord returns the line as read, including its line terminator.
+func (c FixedWidthConverter) rawRecord() []byte {
+	return []byte(c.data)
+}
+
+func (c FixedWidthConverter) Print() {
+	c.rejectWriter.Write([]byte(c.line() + "\n"))
+}
@@ -0,0 +1,144 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestFixedWidthStreamDocument(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a fixed-width input reader", t, func() {
+		columns := []FixedWidthColumn{
+			{"id", 0, 4},
+			{"name", 4, 12},
+			{"zip", 12, 17},
+		}
+
+		Convey("each line should be sliced into trimmed columns", func() {
+			contents := "0001Ann     02134\r\n  42Bob      9021\n"
+			r, err := NewFixedWidthInputReader(columns, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(err, ShouldBeNil)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"id", int32(1)}, {"name", "Ann"}, {"zip", int32(2134)}})
+			So(<-docChan, ShouldResemble, bson.D{{"id", int32(42)}, {"name", "Bob"}, {"zip", int32(9021)}})
+		})
+
+		Convey("skipped lines should not be converted", func() {
+			contents := "0001Ann     02134\n0002Bob     90210\n0003Cid     10001\n"
+			r, err := NewFixedWidthInputReader(columns, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(err, ShouldBeNil)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			r.SkipRecords = 2
+			docChan := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1)
+			So((<-docChan)[0].Value, ShouldEqual, int32(3))
+		})
+
+		Convey("type annotations and ignoreBlanks should apply to columns", func() {
+			typed := []FixedWidthColumn{
+				{"id.int64()", 0, 4},
+				{"name.string()", 4, 12},
+				{"zip.string()", 12, 17},
+			}
+			contents := "0001        02134\n"
+			r, err := NewFixedWidthInputReader(typed, bytes.NewReader([]byte(contents)), os.Stdout, 1, true)
+			So(err, ShouldBeNil)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"id", int64(1)}, {"zip", "02134"}})
+		})
+
+		Convey("lines that fail to convert should be rejected as they were read", func() {
+			typed := []FixedWidthColumn{
+				{"id.int32()", 0, 4},
+				{"name.string()", 4, 12},
+			}
+			contents := "0001Ann     \r\nN/A Bob     \r\n"
+			var rejects bytes.Buffer
+			r, err := NewFixedWidthInputReader(typed, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(err, ShouldBeNil)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			r.Rejects = &rejects
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1)
+			So(rejects.String(), ShouldEqual, "N/A Bob     \r\n")
+		})
+
+		Convey("short lines should be handled according to the policy", func() {
+			contents := "0001Ann     021\n"
+
+			Convey("by erroring out by default", func() {
+				r, err := NewFixedWidthInputReader(columns, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+				So(err, ShouldBeNil)
+				err = r.StreamDocument(true, make(chan bson.D, 1))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "'zip'")
+			})
+			Convey("by padding the line", func() {
+				r, err := NewFixedWidthInputReader(columns, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+				So(err, ShouldBeNil)
+				r.ShortLines = ShortLinePad
+				docChan := make(chan bson.D, 1)
+				So(r.StreamDocument(true, docChan), ShouldBeNil)
+				So(<-docChan, ShouldResemble, bson.D{{"id", int32(1)}, {"name", "Ann"}, {"zip", int32(21)}})
+			})
+			Convey("by treating incomplete columns as blank", func() {
+				r, err := NewFixedWidthInputReader(columns, bytes.NewReader([]byte(contents)), os.Stdout, 1, true)
+				So(err, ShouldBeNil)
+				r.ShortLines = ShortLineBlank
+				docChan := make(chan bson.D, 1)
+				So(r.StreamDocument(true, docChan), ShouldBeNil)
+				So(<-docChan, ShouldResemble, bson.D{{"id", int32(1)}, {"name", "Ann"}})
+			})
+		})
+
+		Convey("multibyte UTF-8 input should be sliced by byte offsets", func() {
+			// "é" is two bytes, so "José" fills the whole 5-byte column
+			utf8Columns := []FixedWidthColumn{
+				{"name", 0, 5},
+				{"rest", 5, 7},
+			}
+			contents := "JoséAB\n"
+			r, err := NewFixedWidthInputReader(utf8Columns, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"name", "José"}, {"rest", "AB"}})
+		})
+
+		Convey("lines longer than the buffer should be read whole, up to the maximum", func() {
+			contents := "0001Ann     02134" + strings.Repeat(" ", 1000) + "\n0002Bob     02135" + strings.Repeat(" ", 2000) + "\n"
+			r, err := NewFixedWidthInputReaderSize(columns, bytes.NewReader([]byte(contents)), os.Stdout, 1, false, 16)
+			So(err, ShouldBeNil)
+			r.MaxRecordSize = 1500
+			docChan := make(chan bson.D, 2)
+			err = r.StreamDocument(true, docChan)
+			So(err, ShouldResemble, RecordTooLargeError{Line: 2, MaxSize: 1500})
+			So(<-docChan, ShouldResemble, bson.D{{"id", int32(1)}, {"name", "Ann"}, {"zip", int32(2134)}})
+		})
+
+		Convey("invalid byte ranges should be rejected", func() {
+			_, err := NewFixedWidthInputReader([]FixedWidthColumn{{"a", 3, 3}}, bytes.NewReader(nil), os.Stdout, 1, false)
+			So(err, ShouldNotBeNil)
+			_, err = NewFixedWidthInputReader([]FixedWidthColumn{{"a", -1, 3}}, bytes.NewReader(nil), os.Stdout, 1, false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -7,10 +7,12 @@
 package mongoimport
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 
 	"github.com/mongodb/mongo-tools/common/bsonutil"
 	"github.com/mongodb/mongo-tools/common/json"
@@ -25,10 +27,17 @@ type JSONInputReader struct {
 	// or not
 	isArray bool
 
+	// Encoding is the character encoding of the input, which is converted
+	// to UTF-8 as it is read, and so must be set before anything is.
+	Encoding InputEncoding
+
 	// decoder is used to read the 	next valid JSON documents from the input source
 	decoder *json.Decoder
 
-	// numProcessed indicates the number of JSON documents processed
+	// numProcessed is the number of JSON documents read in full, whether handed
+	// over to be converted or skipped by SkipRecords, but not one that fails
+	// to be read, so the one that does is number numProcessed+1; it is
+	// updated atomically, so it can be read while StreamDocument runs
 	numProcessed uint64
 
 	// readOpeningBracket indicates if the underlying io.Reader has consumed
@@ -52,8 +61,8 @@ type JSONInputReader struct {
 	// embedded sizeTracker exposes the Size() method to check the number of bytes read so far
 	sizeTracker
 
-	// numDecoders is the number of concurrent goroutines to use for decoding
-	numDecoders int
+	// embedded StreamOptions controls how records that fail to convert are handled
+	StreamOptions
 }
 
 // JSONConverter implements the Converter interface for JSON input.
@@ -77,15 +86,23 @@ var (
 // NewJSONInputReader creates a new JSONInputReader in array mode if specified,
 // configured to read data to the given io.Reader.
 func NewJSONInputReader(isArray bool, in io.Reader, numDecoders int) *JSONInputReader {
-	szCount := newSizeTrackingReader(newBomDiscardingReader(in))
-	return &JSONInputReader{
+	szCount := newSizeTrackingReader(in)
+	r := &JSONInputReader{
 		isArray:            isArray,
 		sizeTracker:        szCount,
-		decoder:            json.NewDecoder(szCount),
 		readOpeningBracket: false,
 		bytesFromReader:    make([]byte, 1),
-		numDecoders:        numDecoders,
+		StreamOptions:      StreamOptions{numDecoders: effectiveNumDecoders(numDecoders)},
 	}
+	r.decoder = json.NewDecoder(newTextReader(szCount, &r.Encoding))
+	return r
+}
+
+// NewJSONArrayInputReader creates a new JSONInputReader that reads a single
+// top-level JSON array from the given io.Reader. Elements are scanned and
+// converted one at a time, so memory use does not grow with the input size.
+func NewJSONArrayInputReader(in io.Reader, numDecoders int) *JSONInputReader {
+	return NewJSONInputReader(true, in, numDecoders)
 }
 
 // ReadAndValidateHeader is a no-op for JSON imports; always returns nil.
@@ -98,24 +115,49 @@ func (r *JSONInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) erro
 	return nil
 }
 
+// Fields returns nil, as JSON documents have no fixed set of fields.
+func (r *JSONInputReader) Fields() []string {
+	return nil
+}
+
+// Progress returns a snapshot of how far StreamDocument has got. It is safe
+// to call while StreamDocument is running.
+func (r *JSONInputReader) Progress() ReaderProgress {
+	return r.progress(r.Size())
+}
+
 // StreamDocument takes a boolean indicating if the documents should be streamed
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if encountered
-func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (retErr error) {
-	rawChan := make(chan Converter, r.numDecoders)
-	jsonErrChan := make(chan error)
+func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) error {
+	return r.streamTo(ordered, docSink(readChan))
+}
+
+// streamTo is StreamDocument for the documents that sink takes.
+func (r *JSONInputReader) streamTo(ordered bool, sink documentSink) (retErr error) {
+	r.beginStream(r.Size)
+	// cancelling on return stops the read loop if decoding fails first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rawChan := make(chan Converter, r.recordBuffer())
+	// buffered so neither goroutine blocks once the other's error is returned
+	jsonErrChan := make(chan error, 2)
 
 	// begin reading from source
 	go func() {
 		var err error
 		for {
+			if r.stopped() {
+				close(rawChan)
+				jsonErrChan <- nil
+				return
+			}
 			if r.isArray {
 				if err = r.readJSONArraySeparator(); err != nil {
 					close(rawChan)
 					if err == io.EOF {
 						jsonErrChan <- nil
 					} else {
-						r.numProcessed++
 						jsonErrChan <- fmt.Errorf("error reading separator after document #%v: %v", r.numProcessed, err)
 					}
 					return
@@ -127,22 +169,29 @@ func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (re
 				if err == io.EOF {
 					jsonErrChan <- nil
 				} else {
-					r.numProcessed++
-					jsonErrChan <- fmt.Errorf("error processing document #%v: %v", r.numProcessed, err)
+					jsonErrChan <- fmt.Errorf("error processing document #%v: %v", r.numProcessed+1, err)
 				}
 				return
 			}
-			rawChan <- JSONConverter{
+			if r.skipsRecord(r.numProcessed) {
+				atomic.AddUint64(&r.numProcessed, 1)
+				continue
+			}
+			if err := r.handOver(ctx, rawChan, r.wrapConverter(JSONConverter{
 				data:  rawBytes,
 				index: r.numProcessed,
+			}, len(rawBytes))); err != nil {
+				close(rawChan)
+				jsonErrChan <- err
+				return
 			}
-			r.numProcessed++
+			atomic.AddUint64(&r.numProcessed, 1)
 		}
 	}()
 
 	// begin processing read bytes
 	go func() {
-		jsonErrChan <- streamDocuments(ordered, r.numDecoders, rawChan, readChan)
+		jsonErrChan <- r.streamConverted(context.Background(), ordered, rawChan, sink)
 	}()
 
 	return channelQuorumError(jsonErrChan, 2)
@@ -153,18 +202,24 @@ func (r *JSONInputReader) StreamDocument(ordered bool, readChan chan bson.D) (re
 func (c JSONConverter) Convert() (bson.D, error) {
 	document, err := json.UnmarshalBsonD(c.data)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling bytes on document #%v: %v", c.index, err)
+		return nil, categorizedError{FailureJSON, fmt.Errorf("error unmarshaling bytes on document #%v: %v", c.index+1, err)}
 	}
 	log.Logvf(log.DebugHigh, "got line: %v", document)
 
 	bsonD, err := bsonutil.GetExtendedBsonD(document)
 	if err != nil {
-		return nil, fmt.Errorf("error getting extended BSON for document #%v: %v", c.index, err)
+		return nil, categorizedError{FailureJSON, fmt.Errorf("error getting extended BSON for document #%v: %v", c.index+1, err)}
 	}
 	log.Logvf(log.DebugHigh, "got extended line: %#v", bsonD)
 	return bsonD, nil
 }
 
+// rawRecord returns the document's JSON text as read, without the white
+// space or array separators around it.
+func (c JSONConverter) rawRecord() []byte {
+	return c.data
+}
+
 // readJSONArraySeparator is a helper method used to process JSON arrays. It is
 // used to read any of the valid separators for a JSON array and flag invalid
 // characters.
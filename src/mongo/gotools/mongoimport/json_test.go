@@ -73,6 +73,41 @@ func TestJSONArrayStreamDocument(t *testing.T) {
 			So(<-docChan, ShouldResemble, expectedReadTwo)
 		})
 
+		Convey("whitespace and newlines between array elements should be "+
+			"tolerated", func() {
+			contents := "\n[\n\t{\"a\": 1}\n\n ,\r\n{\"a\": 2} ,{\"a\": 3}\n]\n\n"
+			r := NewJSONArrayInputReader(bytes.NewReader([]byte(contents)), 1)
+			docChan := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(2)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}})
+		})
+
+		Convey("trailing garbage after the closing bracket should error out", func() {
+			contents := `[{"a": 1}] {"a": 2}`
+			r := NewJSONArrayInputReader(bytes.NewReader([]byte(contents)), 1)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldNotBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}})
+		})
+
+		Convey("a malformed element should be reported with its index", func() {
+			contents := `[{"a": 1}, {"a": 2}, {"a": }]`
+			r := NewJSONArrayInputReader(bytes.NewReader([]byte(contents)), 1)
+			err := r.StreamDocument(true, make(chan bson.D, 3))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "document #3")
+		})
+
+		Convey("a document that fails to convert should be reported with its 1-based index", func() {
+			contents := `[{"a": 1}, {"a": {"$date": "bad"}}]`
+			r := NewJSONArrayInputReader(bytes.NewReader([]byte(contents)), 1)
+			err := r.StreamDocument(true, make(chan bson.D, 2))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "document #2")
+		})
+
 		Reset(func() {
 			jsonFile.Close()
 			fileHandle.Close()
@@ -114,6 +149,29 @@ func TestJSONPlainStreamDocument(t *testing.T) {
 			So(<-docChan, ShouldResemble, expectedRead)
 		})
 
+		Convey("documents without an upsert field should return an error", func() {
+			contents := `{"a": "x", "b": "y"}{"b": "z"}`
+			r := NewJSONInputReader(false, bytes.NewReader([]byte(contents)), 1)
+			r.UpsertFields = []string{"a"}
+			docChan := make(chan bson.D, 2)
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "upsert field 'a' is missing")
+			So(<-docChan, ShouldResemble, bson.D{{"a", "x"}, {"b", "y"}})
+		})
+
+		Convey("documents that fail to convert should be rejected as they were read", func() {
+			contents := "{\"a\": \"x\"}\n{ \"b\" : 1 }\n"
+			var rejects bytes.Buffer
+			r := NewJSONInputReader(false, bytes.NewReader([]byte(contents)), 1)
+			r.UpsertFields = []string{"a"}
+			r.Rejects = &rejects
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1)
+			So(rejects.String(), ShouldEqual, "\n{ \"b\" : 1 }")
+		})
+
 		Convey("JSON arrays should return an error", func() {
 			contents := `[{"a": "ae", "b": 2.0}]`
 			r := NewJSONInputReader(false, bytes.NewReader([]byte(contents)), 1)
@@ -268,3 +326,33 @@ func TestJSONConvert(t *testing.T) {
 		})
 	})
 }
+
+func TestJSONStreamDocumentSkipRecords(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a JSON array input reader that skips records", t, func() {
+		contents := `[{"a": "w"}, {"a": "x"}, {"a": "y"}]`
+		r := NewJSONInputReader(true, bytes.NewReader([]byte(contents)), 1)
+		r.SkipRecords = 2
+
+		Convey("skipped documents should not be converted", func() {
+			docChan := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", "y"}})
+			So(r.Progress().RecordsRead, ShouldEqual, 1)
+		})
+	})
+	Convey("With a JSON input reader that imports a range of its records", t, func() {
+		contents := `{"a": 1} {"a": 2} {"a": 3} {"a": 4} {"a": `
+		r := NewJSONInputReader(false, bytes.NewReader([]byte(contents)), 1)
+		r.Skip = 1
+		r.Limit = 2
+
+		Convey("reading should stop at the limit, before the broken document", func() {
+			docChan := make(chan bson.D, 4)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(2)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}})
+			So(r.NumProcessed(), ShouldEqual, 3)
+		})
+	})
+}
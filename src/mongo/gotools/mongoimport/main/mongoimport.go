@@ -53,14 +53,18 @@ func main() {
 	// verify uri options and log them
 	opts.URI.LogUnsupportedOptions()
 
-	// create a session provider to connect to the db
-	sessionProvider, err := db.NewSessionProvider(*opts)
-	if err != nil {
-		log.Logvf(log.Always, "error connecting to host: %v", err)
-		os.Exit(util.ExitError)
+	// create a session provider to connect to the db, unless this is a dry
+	// run, a validation, or a conversion, which only read the input
+	var sessionProvider *db.SessionProvider
+	if !inputOpts.DryRun && !inputOpts.Validate && inputOpts.ConvertTo == "" {
+		sessionProvider, err = db.NewSessionProvider(*opts)
+		if err != nil {
+			log.Logvf(log.Always, "error connecting to host: %v", err)
+			os.Exit(util.ExitError)
+		}
+		defer sessionProvider.Close()
+		sessionProvider.SetBypassDocumentValidation(ingestOpts.BypassDocumentValidation)
 	}
-	defer sessionProvider.Close()
-	sessionProvider.SetBypassDocumentValidation(ingestOpts.BypassDocumentValidation)
 
 	m := mongoimport.MongoImport{
 		ToolOptions:     opts,
@@ -80,11 +84,13 @@ func main() {
 		if err != nil {
 			log.Logvf(log.Always, "Failed: %v", err)
 		}
-		message := fmt.Sprintf("imported 1 document")
-		if numDocs != 1 {
-			message = fmt.Sprintf("imported %v documents", numDocs)
+		if !inputOpts.DryRun && !inputOpts.Validate && inputOpts.ConvertTo == "" {
+			message := fmt.Sprintf("imported 1 document")
+			if numDocs != 1 {
+				message = fmt.Sprintf("imported %v documents", numDocs)
+			}
+			log.Logvf(log.Always, message)
 		}
-		log.Logvf(log.Always, message)
 	}
 	if err != nil {
 		os.Exit(util.ExitError)
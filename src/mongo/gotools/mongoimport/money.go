@@ -0,0 +1,98 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"strings"
+)
+
+// moneyArg is the argument of the double and decimal types that parses their
+// tokens as amounts of money.
+const moneyArg = "money"
+
+// DefaultCurrencySymbols are the currency symbols that money columns strip
+// from their tokens if ConvertOptions.CurrencySymbols is not set.
+var DefaultCurrencySymbols = []string{"$", "€", "£", "¥", "₹", "₩", "₽", "₺", "₪", "₫", "₱", "₦", "฿", "¢"}
+
+// FieldMoneyParser parses amounts of money, such as "$1,299.00", "€ 42" or
+// the accounting negative "(350.00)", as the doubles or decimals of the
+// number parser. On its own, it strips the DefaultCurrencySymbols and ','
+// grouping separators; tokensToBSON strips those of its ConvertOptions.
+type FieldMoneyParser struct {
+	number FieldParser
+}
+
+func (mp *FieldMoneyParser) Parse(in string) (interface{}, error) {
+	return (&ConvertOptions{}).parseMoney(mp, in)
+}
+
+// parseMoney parses token as an amount of money: a number with a sign or in
+// parentheses, for a negative amount, and with one of the CurrencySymbols, or
+// the DefaultCurrencySymbols, before or after it, optionally separated from
+// it by spaces. The number may use the DecimalSeparator and the
+// ThousandsSeparator, which defaults to ',', or '.' if the DecimalSeparator
+// is ','. What is left is parsed by mp's number parser.
+func (opts *ConvertOptions) parseMoney(mp *FieldMoneyParser, token string) (interface{}, error) {
+	amount := strings.TrimSpace(token)
+	sign := ""
+	parenthesized := strings.HasPrefix(amount, "(") && strings.HasSuffix(amount, ")")
+	if parenthesized {
+		amount, sign = strings.TrimSpace(amount[1:len(amount)-1]), "-"
+	}
+	amount, signed := trimSign(amount, &sign)
+	amount = opts.trimCurrencySymbol(amount)
+	if !signed {
+		amount, signed = trimSign(amount, &sign)
+	}
+	// anything else left, such as a second symbol, fails to parse
+	if signed && parenthesized || amount == "" || strings.IndexAny(amount[:1], "0123456789.,") == -1 {
+		return nil, fmt.Errorf("%q is not an amount of money", token)
+	}
+	numberOpts := ConvertOptions{DecimalSeparator: opts.DecimalSeparator, ThousandsSeparator: opts.ThousandsSeparator}
+	if numberOpts.ThousandsSeparator == "" {
+		numberOpts.ThousandsSeparator = ","
+		if numberOpts.DecimalSeparator == "," {
+			numberOpts.ThousandsSeparator = "."
+		}
+	}
+	number, err := numberOpts.delocalizeNumber(amount)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not an amount of money: %v", token, err)
+	}
+	return mp.number.Parse(sign + number)
+}
+
+// trimSign trims a leading '+' or '-' from amount, setting sign to "-" for a
+// '-', and reports whether there was one.
+func trimSign(amount string, sign *string) (string, bool) {
+	if amount == "" || amount[0] != '+' && amount[0] != '-' {
+		return amount, false
+	}
+	if amount[0] == '-' {
+		*sign = "-"
+	}
+	return strings.TrimSpace(amount[1:]), true
+}
+
+// trimCurrencySymbol trims the first of the currency symbols that amount
+// starts or ends with.
+func (opts *ConvertOptions) trimCurrencySymbol(amount string) string {
+	symbols := opts.CurrencySymbols
+	if symbols == nil {
+		symbols = DefaultCurrencySymbols
+	}
+	for _, symbol := range symbols {
+		if strings.HasPrefix(amount, symbol) {
+			return strings.TrimSpace(amount[len(symbol):])
+		}
+		if strings.HasSuffix(amount, symbol) {
+			return strings.TrimSpace(amount[:len(amount)-len(symbol)])
+		}
+	}
+	return amount
+}
@@ -0,0 +1,102 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseMoney(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a double money column", t, func() {
+		parser, err := NewFieldParser(ctDouble, "money")
+		So(err, ShouldBeNil)
+		mp := parser.(*FieldMoneyParser)
+
+		Convey("currency symbols, grouping separators and signs should be stripped", func() {
+			tests := []struct {
+				token string
+				value float64
+			}{
+				{"1299", 1299},
+				{"$1,299.00", 1299},
+				{"1,299.00$", 1299},
+				{"€ 42", 42},
+				{"42 €", 42},
+				{"£0.99", 0.99},
+				{"¥1,000,000", 1000000},
+				{"₹ 12,345.5", 12345.5},
+				{"₩5", 5},
+				{"(350.00)", -350},
+				{"( $350.00 )", -350},
+				{"-$5", -5},
+				{"$-5", -5},
+				{"+$5", 5},
+				{" $.50 ", 0.5},
+			}
+			for _, test := range tests {
+				value, err := mp.Parse(test.token)
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, test.value)
+			}
+		})
+		Convey("malformed amounts should fail", func() {
+			for _, token := range []string{
+				"", "$", "$$12..3", "12..3", "$1,29.00", "(-5)", "--5", "$NaN", "$Inf", "12 EUR", "1.2.3",
+				"(5", "5)", "$ 1 2", "A$5",
+			} {
+				_, err := mp.Parse(token)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("the configured symbols and separators should be used", func() {
+			tests := []struct {
+				opts  ConvertOptions
+				token string
+				value float64
+			}{
+				{ConvertOptions{CurrencySymbols: []string{"R$", "$"}}, "R$ 1,500", 1500},
+				{ConvertOptions{CurrencySymbols: []string{"R$"}, DecimalSeparator: ","}, "R$ 1.234,56", 1234.56},
+				{ConvertOptions{CurrencySymbols: []string{"CHF"}, ThousandsSeparator: "'"}, "CHF 1'000.25", 1000.25},
+				{ConvertOptions{CurrencySymbols: []string{"₿"}}, "₿0.5", 0.5},
+			}
+			for _, test := range tests {
+				value, err := test.opts.parseMoney(mp, test.token)
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, test.value)
+			}
+			opts := ConvertOptions{CurrencySymbols: []string{"CHF"}}
+			_, err := opts.parseMoney(mp, "$5")
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("With a decimal money column in a typed header", t, func() {
+		colSpecs, err := ParseTypedHeaders([]string{"price.decimal(money)", "total.double(money).default(0)"}, pgStop)
+		So(err, ShouldBeNil)
+
+		Convey("amounts should be parsed as decimals", func() {
+			bsonD, err := tokensToBSON(colSpecs, []string{"(1,299.95)", ""}, uint64(0), nil)
+			So(err, ShouldBeNil)
+			expected, _ := bson.ParseDecimal128("-1299.95")
+			So(bsonD, ShouldResemble, bson.D{{"price", expected}, {"total", float64(0)}})
+		})
+		Convey("malformed amounts should be conversion failures", func() {
+			_, err := tokensToBSON(colSpecs, []string{"$$12..3", "1"}, uint64(0), nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("other arguments should not be accepted", func() {
+			_, err := ParseTypedHeader("price.decimal(cash)", pgStop)
+			So(err, ShouldNotBeNil)
+			_, err = ParseTypedHeader("count.int32(money)", pgStop)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
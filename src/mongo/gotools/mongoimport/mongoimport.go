@@ -17,13 +17,20 @@ import (
 	"gopkg.in/mgo.v2/bson"
 	"gopkg.in/tomb.v2"
 
+	"bufio"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 // Input format types accepted by mongoimport.
@@ -53,6 +60,10 @@ type MongoImport struct {
 	// updated atomically, aligned at the beginning of the struct
 	insertionCount uint64
 
+	// keyOnlyCount keeps track of how many documents were skipped in merge
+	// mode for having no fields besides their upsert key; updated atomically
+	keyOnlyCount uint64
+
 	// generic mongo tool options
 	ToolOptions *options.ToolOptions
 
@@ -74,6 +85,13 @@ type MongoImport struct {
 
 	// type of node the SessionProvider is connected to
 	nodeType db.NodeType
+
+	// rejects receives records that fail to convert, if --rejectsFile is set
+	rejects io.Writer
+
+	// Transform, if set, is applied to each document after it is converted;
+	// see StreamOptions.Transform
+	Transform func(bson.D) (bson.D, error)
 }
 
 type InputReader interface {
@@ -92,10 +110,80 @@ type InputReader interface {
 	// will be handled according parseGrace.
 	ReadAndValidateTypedHeader(parseGrace ParseGrace) error
 
+	// Fields returns a copy of the names of the fields that documents are
+	// given, once the header has been read and validated, or nil if the
+	// input has no fixed set of fields, as JSON and BSON do not.
+	Fields() []string
+
+	// NumProcessed returns the number of records read so far, after any
+	// header, including those skipped by SkipRecords or Skip. It is safe to
+	// call while StreamDocument runs.
+	NumProcessed() uint64
+
 	// embedded io.Reader that tracks number of bytes read, to allow feeding into progress bar.
 	sizeTracker
 }
 
+// typeOptions are the options that only some input types accept, named as
+// their flags are, each with whether it is set. An input type accepts those
+// it declares with AcceptInputOptions, and none of the others.
+var typeOptions = []struct {
+	name string
+	set  func(imp *MongoImport) bool
+}{
+	{"headerline", func(imp *MongoImport) bool { return imp.InputOptions.HeaderLine }},
+	{"fields", func(imp *MongoImport) bool { return imp.InputOptions.Fields != nil }},
+	{"fieldFile", func(imp *MongoImport) bool { return imp.InputOptions.FieldFile != nil }},
+	{"columnWidths", func(imp *MongoImport) bool { return imp.InputOptions.ColumnWidths != "" }},
+	{"generateFields", func(imp *MongoImport) bool { return imp.InputOptions.GenerateFields }},
+	{"detectHeader", func(imp *MongoImport) bool { return imp.InputOptions.DetectHeader }},
+	{"verifyHeader", func(imp *MongoImport) bool { return imp.InputOptions.VerifyHeader != "" }},
+	{"ignoreBlanks", func(imp *MongoImport) bool { return imp.IngestOptions.IgnoreBlanks }},
+	{"columnsHaveTypes", func(imp *MongoImport) bool { return imp.InputOptions.ColumnsHaveTypes }},
+	{"delimiter", func(imp *MongoImport) bool { return imp.InputOptions.Delimiter != "" }},
+	{"detectDelimiter", func(imp *MongoImport) bool { return imp.InputOptions.DetectDelimiter }},
+	{"whitespaceDelimited", func(imp *MongoImport) bool { return imp.InputOptions.WhitespaceDelimited }},
+	{"quotedFields", func(imp *MongoImport) bool { return imp.InputOptions.QuotedFields }},
+	{"unescape", func(imp *MongoImport) bool { return imp.InputOptions.Unescape }},
+	{"readRanges", func(imp *MongoImport) bool { return imp.InputOptions.ReadRanges > 1 }},
+	{"commentPrefix", func(imp *MongoImport) bool { return imp.InputOptions.CommentPrefix != "" }},
+	{"commentIndented", func(imp *MongoImport) bool { return imp.InputOptions.CommentIndented }},
+	{"csvQuote", func(imp *MongoImport) bool { return imp.InputOptions.CSVQuote != "" }},
+	{"csvEscape", func(imp *MongoImport) bool { return imp.InputOptions.CSVEscape != "" }},
+	{"csvLazyQuotes", func(imp *MongoImport) bool { return imp.InputOptions.CSVLazyQuotes }},
+	{"csvStrict", func(imp *MongoImport) bool { return imp.InputOptions.CSVStrict }},
+	{"stringsOnly", func(imp *MongoImport) bool { return imp.InputOptions.StringsOnly }},
+	{"bigIntegersAsDecimal", func(imp *MongoImport) bool { return imp.InputOptions.BigIntegersAsDecimal }},
+	{"decodingBatchSize", func(imp *MongoImport) bool { return imp.IngestOptions.DecodingBatchSize > 1 }},
+	{"trimWhitespace", func(imp *MongoImport) bool { return imp.InputOptions.TrimWhitespace }},
+	{"nullTokens", func(imp *MongoImport) bool { return imp.InputOptions.NullTokens != "" }},
+	{"trueTokens", func(imp *MongoImport) bool { return imp.InputOptions.TrueTokens != "" }},
+	{"falseTokens", func(imp *MongoImport) bool { return imp.InputOptions.FalseTokens != "" }},
+	{"renameFields", func(imp *MongoImport) bool { return imp.InputOptions.RenameFields != "" }},
+	{"timezone", func(imp *MongoImport) bool { return imp.InputOptions.TimeZone != "" }},
+	{"columnTimezones", func(imp *MongoImport) bool { return imp.InputOptions.ColumnTimeZones != "" }},
+	{"anonymizeFields", func(imp *MongoImport) bool { return imp.InputOptions.AnonymizeFields != "" }},
+	{"anonymizeSalt", func(imp *MongoImport) bool { return imp.InputOptions.AnonymizeSalt != "" }},
+	{"dstGap", func(imp *MongoImport) bool { return imp.InputOptions.DSTGap != "" }},
+	{"invalidUTF8", func(imp *MongoImport) bool { return imp.InputOptions.InvalidUTF8 != "" }},
+	{"dryRun", func(imp *MongoImport) bool { return imp.InputOptions.DryRun }},
+	{"projectFields", func(imp *MongoImport) bool { return imp.InputOptions.ProjectFields != "" }},
+	{"excludeFields", func(imp *MongoImport) bool { return imp.InputOptions.ExcludeFields != "" }},
+	{"idFields", func(imp *MongoImport) bool { return imp.InputOptions.IDFields != "" }},
+	{"decimalSeparator", func(imp *MongoImport) bool { return imp.InputOptions.DecimalSeparator != "" }},
+	{"thousandsSeparator", func(imp *MongoImport) bool { return imp.InputOptions.ThousandsSeparator != "" }},
+	{"currencySymbols", func(imp *MongoImport) bool { return imp.InputOptions.CurrencySymbols != "" }},
+	{"flatFields", func(imp *MongoImport) bool { return imp.InputOptions.FlatFields }},
+	{"shortRows", func(imp *MongoImport) bool { return imp.InputOptions.ShortRows != "" }},
+	{"longRows", func(imp *MongoImport) bool { return imp.InputOptions.LongRows != "" }},
+	{"allowTrailingDelimiter", func(imp *MongoImport) bool { return imp.InputOptions.AllowTrailingDelimiter }},
+	{"skipLines", func(imp *MongoImport) bool { return imp.InputOptions.SkipLines != 0 }},
+	{"trimFieldNames", func(imp *MongoImport) bool { return imp.InputOptions.TrimFieldNames }},
+	{"lowercaseFieldNames", func(imp *MongoImport) bool { return imp.InputOptions.LowercaseFieldNames }},
+	{"underscoreFieldNames", func(imp *MongoImport) bool { return imp.InputOptions.UnderscoreFieldNames }},
+	{"sanitizeFields", func(imp *MongoImport) bool { return imp.InputOptions.SanitizeFields }},
+}
+
 // ValidateSettings ensures that the tool specific options supplied for
 // MongoImport are valid.
 func (imp *MongoImport) ValidateSettings(args []string) error {
@@ -108,25 +196,97 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 		return fmt.Errorf("invalid database name: %v", err)
 	}
 
-	imp.InputOptions.Type = strings.ToLower(imp.InputOptions.Type)
-	// use JSON as default input type
-	if imp.InputOptions.Type == "" {
-		imp.InputOptions.Type = JSON
-	} else {
-		if !(imp.InputOptions.Type == TSV ||
-			imp.InputOptions.Type == JSON ||
-			imp.InputOptions.Type == CSV) {
-			return fmt.Errorf("unknown type %v", imp.InputOptions.Type)
+	// ensure no more than one positional argument is supplied
+	if len(args) > 1 {
+		return fmt.Errorf("only one positional argument is allowed")
+	}
+
+	// ensure either a positional argument is supplied or an argument is passed
+	// to the --file flag - and not both
+	if imp.InputOptions.File != "" && len(args) != 0 {
+		return fmt.Errorf("incompatible options: --file and positional argument(s)")
+	}
+
+	if imp.InputOptions.File == "" {
+		if len(args) != 0 {
+			// if --file is not supplied, use the positional argument supplied
+			imp.InputOptions.File = args[0]
 		}
 	}
 
-	// ensure headers are supplied for CSV/TSV
-	if imp.InputOptions.Type == CSV ||
-		imp.InputOptions.Type == TSV {
-		if !imp.InputOptions.HeaderLine {
+	// the type of standard input, or of a file without an extension, is
+	// detected from its content once it is opened, if it is not given
+	if !imp.detectsInputType() {
+		inputType, _, err := imp.lookupInputReader()
+		if err != nil {
+			return err
+		}
+		imp.InputOptions.Type = inputType
+	}
+
+	// options that only some input types accept are refused for the others;
+	// while the type is still to be detected, they are checked once it is
+	for _, option := range typeOptions {
+		if imp.InputOptions.Type != "" && option.set(imp) && !InputTypeAccepts(imp.InputOptions.Type, option.name) {
+			return fmt.Errorf("can not use --%v when input type is %v", option.name, imp.InputOptions.Type)
+		}
+	}
+
+	// ensure headers are supplied for the types that take fields, from a
+	// header line for those that have one
+	if InputTypeAccepts(imp.InputOptions.Type, "fields") && !InputTypeAccepts(imp.InputOptions.Type, "headerline") {
+		if imp.InputOptions.Fields == nil && imp.InputOptions.FieldFile == nil {
+			return fmt.Errorf("must specify --fields or --fieldFile to import this file type")
+		}
+		if imp.InputOptions.FieldFile != nil && *imp.InputOptions.FieldFile == "" {
+			return fmt.Errorf("--fieldFile can not be empty string")
+		}
+		if imp.InputOptions.Fields != nil && imp.InputOptions.FieldFile != nil {
+			return fmt.Errorf("incompatible options: --fields and --fieldFile")
+		}
+	} else if InputTypeAccepts(imp.InputOptions.Type, "fields") {
+		if imp.InputOptions.VerifyHeader != "" {
+			if imp.InputOptions.HeaderLine {
+				return fmt.Errorf("incompatible options: --verifyHeader and --headerline")
+			}
+			if imp.InputOptions.GenerateFields {
+				return fmt.Errorf("incompatible options: --verifyHeader and --generateFields")
+			}
+			if imp.InputOptions.Fields == nil && imp.InputOptions.FieldFile == nil {
+				return fmt.Errorf("--verifyHeader requires --fields or --fieldFile")
+			}
+			if _, err := ValidateHeaderCheck(imp.InputOptions.VerifyHeader); err != nil {
+				return err
+			}
+		}
+		if imp.InputOptions.DetectHeader {
+			if imp.InputOptions.HeaderLine {
+				return fmt.Errorf("incompatible options: --detectHeader and --headerline")
+			}
+			if imp.InputOptions.GenerateFields {
+				return fmt.Errorf("incompatible options: --detectHeader and --generateFields")
+			}
+			if imp.InputOptions.VerifyHeader != "" {
+				return fmt.Errorf("incompatible options: --detectHeader and --verifyHeader")
+			}
+			if imp.InputOptions.ColumnsHaveTypes {
+				return fmt.Errorf("incompatible options: --detectHeader and --columnsHaveTypes")
+			}
+		}
+		if imp.InputOptions.GenerateFields {
+			if imp.InputOptions.HeaderLine {
+				return fmt.Errorf("incompatible options: --generateFields and --headerline")
+			}
+			if imp.InputOptions.Fields != nil {
+				return fmt.Errorf("incompatible options: --generateFields and --fields")
+			}
+			if imp.InputOptions.FieldFile != nil {
+				return fmt.Errorf("incompatible options: --generateFields and --fieldFile")
+			}
+		} else if !imp.InputOptions.HeaderLine {
 			if imp.InputOptions.Fields == nil &&
-				imp.InputOptions.FieldFile == nil {
-				return fmt.Errorf("must specify --fields, --fieldFile or --headerline to import this file type")
+				imp.InputOptions.FieldFile == nil && !imp.InputOptions.DetectHeader {
+				return fmt.Errorf("must specify --fields, --fieldFile, --headerline, --generateFields or --detectHeader to import this file type")
 			}
 			if imp.InputOptions.FieldFile != nil &&
 				*imp.InputOptions.FieldFile == "" {
@@ -144,29 +304,215 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 				return fmt.Errorf("incompatible options: --fieldFile and --headerline")
 			}
 		}
+	}
 
-		if _, err := ValidatePG(imp.InputOptions.ParseGrace); err != nil {
-			return err
+	if _, err := ValidatePG(imp.InputOptions.ParseGrace); err != nil {
+		return err
+	}
+	if imp.InputOptions.SkipLines < 0 {
+		return fmt.Errorf("--skipLines can not be negative")
+	}
+	if InputTypeAccepts(imp.InputOptions.Type, "columnWidths") {
+		if _, err := ParseColumnWidths(imp.InputOptions.ColumnWidths); err != nil {
+			return fmt.Errorf("invalid --columnWidths: %v", err)
 		}
-	} else {
-		// input type is JSON
-		if imp.InputOptions.HeaderLine {
-			return fmt.Errorf("can not use --headerline when input type is JSON")
+	}
+
+	if _, err := ValidateShortRows(imp.InputOptions.ShortRows); err != nil {
+		return err
+	}
+	if _, err := ValidateLongRows(imp.InputOptions.LongRows); err != nil {
+		return err
+	}
+	if imp.InputOptions.TrueTokens != "" && imp.InputOptions.FalseTokens != "" {
+		for _, trueToken := range strings.Split(imp.InputOptions.TrueTokens, ",") {
+			for _, falseToken := range strings.Split(imp.InputOptions.FalseTokens, ",") {
+				if trueToken == falseToken ||
+					!imp.InputOptions.BooleanTokensCaseSensitive && strings.EqualFold(trueToken, falseToken) {
+					return fmt.Errorf("%q can not be in both --trueTokens and --falseTokens", trueToken)
+				}
+			}
 		}
-		if imp.InputOptions.Fields != nil {
-			return fmt.Errorf("can not use --fields when input type is JSON")
+	}
+	for _, rename := range splitNonEmpty(imp.InputOptions.RenameFields) {
+		if i := strings.Index(rename, "="); i <= 0 {
+			return fmt.Errorf("invalid --renameFields entry '%v': must be of the form <field>=<newName>", rename)
 		}
-		if imp.InputOptions.FieldFile != nil {
-			return fmt.Errorf("can not use --fieldFile when input type is JSON")
+	}
+	if imp.InputOptions.TimeZone != "" {
+		if _, err := ParseTimeZone(imp.InputOptions.TimeZone); err != nil {
+			return fmt.Errorf("invalid --timezone: %v", err)
 		}
-		if imp.IngestOptions.IgnoreBlanks {
-			return fmt.Errorf("can not use --ignoreBlanks when input type is JSON")
+	}
+	for _, columnZone := range splitNonEmpty(imp.InputOptions.ColumnTimeZones) {
+		i := strings.LastIndex(columnZone, "=")
+		if i <= 0 {
+			return fmt.Errorf("invalid --columnTimezones entry '%v': must be of the form <field>=<zone>", columnZone)
 		}
-		if imp.InputOptions.ColumnsHaveTypes {
-			return fmt.Errorf("can not use --columnsHaveTypes when input type is JSON")
+		if _, err := ParseTimeZone(columnZone[i+1:]); err != nil {
+			return fmt.Errorf("invalid --columnTimezones entry '%v': %v", columnZone, err)
+		}
+	}
+	if _, err := ValidateDSTGapPolicy(imp.InputOptions.DSTGap); err != nil {
+		return err
+	}
+	if _, err := imp.anonymizeFields(); err != nil {
+		return err
+	}
+	if imp.InputOptions.AnonymizeSalt != "" && imp.InputOptions.AnonymizeFields == "" {
+		return fmt.Errorf("--anonymizeSalt can only be used with --anonymizeFields")
+	}
+	if _, err := ValidateInvalidUTF8Policy(imp.InputOptions.InvalidUTF8); err != nil {
+		return err
+	}
+	if imp.InputOptions.IDFields == "" {
+		if imp.InputOptions.IDSeparator != "" {
+			return fmt.Errorf("--idSeparator can only be used with --idFields")
+		}
+		if imp.InputOptions.KeepIDFields {
+			return fmt.Errorf("--keepIdFields can only be used with --idFields")
+		}
+	}
+	if imp.InputOptions.ThousandsSeparator != "" {
+		decimalSeparator := imp.InputOptions.DecimalSeparator
+		if decimalSeparator == "" {
+			decimalSeparator = "."
+		}
+		if imp.InputOptions.ThousandsSeparator == decimalSeparator {
+			return fmt.Errorf("--thousandsSeparator and --decimalSeparator must be different")
+		}
+	}
+	for _, symbol := range splitNonEmpty(imp.InputOptions.CurrencySymbols) {
+		if strings.TrimSpace(symbol) == "" {
+			return fmt.Errorf("--currencySymbols can not have an empty symbol")
+		}
+	}
+	if substitute := imp.InputOptions.FieldSubstitute; substitute != "" {
+		if !imp.InputOptions.SanitizeFields {
+			return fmt.Errorf("--fieldSubstitute can only be used with --sanitizeFields")
+		}
+		if strings.ContainsAny(substitute, ".$\x00") {
+			return fmt.Errorf("--fieldSubstitute can not contain '.', '$' or NUL characters")
 		}
 	}
 
+	if imp.InputOptions.Delimiter != "" {
+		if strings.ContainsAny(imp.InputOptions.Delimiter, "\r\n") {
+			return fmt.Errorf("--delimiter can not contain a line break")
+		}
+		if imp.InputOptions.DetectDelimiter {
+			return fmt.Errorf("can not use --detectDelimiter with --delimiter")
+		}
+	}
+	if imp.InputOptions.WhitespaceDelimited {
+		switch {
+		case imp.InputOptions.Delimiter != "":
+			return fmt.Errorf("incompatible options: --whitespaceDelimited and --delimiter")
+		case imp.InputOptions.DetectDelimiter:
+			return fmt.Errorf("incompatible options: --whitespaceDelimited and --detectDelimiter")
+		case imp.InputOptions.QuotedFields:
+			return fmt.Errorf("incompatible options: --whitespaceDelimited and --quotedFields")
+		}
+	}
+	if imp.InputOptions.DetectDelimiter && imp.InputOptions.ReadRanges > 1 {
+		return fmt.Errorf("can not use --detectDelimiter with --readRanges")
+	}
+	if imp.InputOptions.CommentIndented && imp.InputOptions.CommentPrefix == "" {
+		return fmt.Errorf("can not use --commentIndented without --commentPrefix")
+	}
+	for _, option := range []struct{ name, char string }{
+		{"--csvQuote", imp.InputOptions.CSVQuote},
+		{"--csvEscape", imp.InputOptions.CSVEscape},
+	} {
+		name, char := option.name, option.char
+		if char == "" {
+			continue
+		}
+		if !utf8.ValidString(char) || utf8.RuneCountInString(char) != 1 || strings.ContainsAny(char, ",\r\n") {
+			return fmt.Errorf("%v must be a single character other than ',' or a line break", name)
+		}
+	}
+	if imp.InputOptions.CSVStrict &&
+		(imp.InputOptions.CSVQuote != "" || imp.InputOptions.CSVEscape != "" || imp.InputOptions.CSVLazyQuotes) {
+		return fmt.Errorf("can not use --csvStrict with --csvQuote, --csvEscape or --csvLazyQuotes")
+	}
+
+	if imp.InputOptions.SkipRecords < 0 {
+		return fmt.Errorf("--skipRecords can not be negative")
+	}
+
+	if imp.InputOptions.Skip < 0 {
+		return fmt.Errorf("--skip can not be negative")
+	}
+	if imp.InputOptions.Limit < 0 {
+		return fmt.Errorf("--limit can not be negative")
+	}
+
+	if imp.InputOptions.SampleEvery < 0 {
+		return fmt.Errorf("--sampleEvery can not be negative")
+	}
+	if imp.InputOptions.SampleFraction < 0 || imp.InputOptions.SampleFraction > 1 {
+		return fmt.Errorf("--sampleFraction must be greater than 0 and at most 1")
+	}
+	if imp.InputOptions.SampleEvery != 0 && imp.InputOptions.SampleFraction != 0 {
+		return fmt.Errorf("incompatible options: --sampleEvery and --sampleFraction")
+	}
+	if imp.InputOptions.SampleSeed != 0 && imp.InputOptions.SampleFraction == 0 {
+		return fmt.Errorf("--sampleSeed requires --sampleFraction")
+	}
+
+	if _, err := ValidateInputEncoding(imp.InputOptions.InputEncoding); err != nil {
+		return fmt.Errorf("invalid --inputEncoding: %v", err)
+	}
+
+	if imp.IngestOptions.MaxDocumentSize < 0 || imp.IngestOptions.MaxDocumentSize > db.MaxBSONSize {
+		return fmt.Errorf("--maxDocumentSize can not be negative or more than %v bytes", db.MaxBSONSize)
+	}
+	if imp.IngestOptions.DocumentSizeBuckets != "" {
+		if !imp.IngestOptions.DocumentSizes {
+			return fmt.Errorf("--documentSizeBuckets can only be used with --documentSizes")
+		}
+		if _, err := imp.documentSizeBuckets(); err != nil {
+			return fmt.Errorf("invalid --documentSizeBuckets: %v", err)
+		}
+	}
+	if imp.IngestOptions.MaxDocsPerSecond < 0 {
+		return fmt.Errorf("--maxDocsPerSecond can not be negative")
+	}
+	if imp.IngestOptions.MaxBytesPerSecond < 0 {
+		return fmt.Errorf("--maxBytesPerSecond can not be negative")
+	}
+	if imp.IngestOptions.DecodingBatchSize < 0 {
+		return fmt.Errorf("--decodingBatchSize can not be negative")
+	}
+	if imp.IngestOptions.ReorderWindow < 0 {
+		return fmt.Errorf("--reorderWindow can not be negative")
+	}
+	if imp.IngestOptions.ReorderWindow != 0 && !imp.IngestOptions.MaintainInsertionOrder {
+		return fmt.Errorf("can not use --reorderWindow without --maintainInsertionOrder")
+	}
+
+	if imp.IngestOptions.DedupFields != "" || imp.IngestOptions.DedupMaxMemory != 0 || imp.IngestOptions.DedupOverflow != "" {
+		if !imp.IngestOptions.Dedup {
+			return fmt.Errorf("can not use --dedupFields, --dedupMaxMemory or --dedupOverflow without --dedup")
+		}
+	}
+	if imp.IngestOptions.DedupFields != "" {
+		if err := validateFields(strings.Split(imp.IngestOptions.DedupFields, ","), imp.InputOptions.FlatFields); err != nil {
+			return fmt.Errorf("invalid --dedupFields argument: %v", err)
+		}
+	}
+	if imp.IngestOptions.DedupMaxMemory < 0 {
+		return fmt.Errorf("--dedupMaxMemory can not be negative")
+	}
+	if _, err := ValidateDedupOverflowPolicy(imp.IngestOptions.DedupOverflow); err != nil {
+		return fmt.Errorf("invalid --dedupOverflow: %v", err)
+	}
+
+	if imp.IngestOptions.RejectsFile != "" && imp.IngestOptions.StopOnError {
+		return fmt.Errorf("incompatible options: --rejectsFile and --stopOnError")
+	}
+
 	// deprecated
 	if imp.IngestOptions.Upsert == true {
 		imp.IngestOptions.Mode = modeUpsert
@@ -180,7 +526,7 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 			return fmt.Errorf("can not use --upsertFields with --mode=insert")
 		}
 		imp.upsertFields = strings.Split(imp.IngestOptions.UpsertFields, ",")
-		if err := validateFields(imp.upsertFields); err != nil {
+		if err := validateFields(imp.upsertFields, imp.InputOptions.FlatFields); err != nil {
 			return fmt.Errorf("invalid --upsertFields argument: %v", err)
 		}
 	} else if imp.IngestOptions.Mode != modeInsert {
@@ -204,10 +550,40 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 		log.Logvf(log.Info, "using upsert fields: %v", imp.upsertFields)
 	}
 
+	if imp.InputOptions.ReadRetries < 0 {
+		return fmt.Errorf("--readRetries can not be negative")
+	}
+	if imp.InputOptions.ReadRanges < 0 {
+		return fmt.Errorf("--readRanges can not be negative")
+	}
+	if imp.InputOptions.ReadRanges > 1 {
+		switch {
+		case imp.InputOptions.File == "" || sourceScheme(imp.InputOptions.File) != FileScheme ||
+			isInputPattern(imp.InputOptions.File):
+			return fmt.Errorf("--readRanges requires --file to name a single local file")
+		case imp.IngestOptions.MaintainInsertionOrder:
+			return fmt.Errorf("can not use --readRanges when documents are imported in order, " +
+				"as they are with --maintainInsertionOrder or a --mode other than insert")
+		case imp.InputOptions.QuotedFields:
+			return fmt.Errorf("incompatible options: --readRanges and --quotedFields")
+		case imp.InputOptions.SkipRecords != 0:
+			return fmt.Errorf("incompatible options: --readRanges and --skipRecords")
+		case imp.InputOptions.Skip != 0:
+			return fmt.Errorf("incompatible options: --readRanges and --skip")
+		case imp.InputOptions.Limit != 0:
+			return fmt.Errorf("incompatible options: --readRanges and --limit")
+		case imp.InputOptions.SampleEvery != 0 || imp.InputOptions.SampleFraction != 0:
+			return fmt.Errorf("incompatible options: --readRanges and sampling")
+		case imp.InputOptions.ReadRetries != 0:
+			return fmt.Errorf("incompatible options: --readRanges and --readRetries")
+		}
+	}
+
 	// set the number of decoding workers to use for imports
 	if imp.IngestOptions.NumDecodingWorkers <= 0 {
 		imp.IngestOptions.NumDecodingWorkers = imp.ToolOptions.MaxProcs
 	}
+	imp.IngestOptions.NumDecodingWorkers = effectiveNumDecoders(imp.IngestOptions.NumDecodingWorkers)
 	log.Logvf(log.DebugLow, "using %v decoding workers", imp.IngestOptions.NumDecodingWorkers)
 
 	// set the number of insertion workers to use for imports
@@ -218,7 +594,9 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 	log.Logvf(log.DebugLow, "using %v insert workers", imp.IngestOptions.NumInsertionWorkers)
 
 	// if --maintainInsertionOrder is set, we can only allow 1 insertion worker
-	if imp.IngestOptions.MaintainInsertionOrder {
+	// for inserts; upserts keep their order with more, as ingestDocuments
+	// sends the documents with the same upsert key to the same worker
+	if imp.IngestOptions.MaintainInsertionOrder && imp.IngestOptions.Mode == modeInsert {
 		imp.IngestOptions.NumInsertionWorkers = 1
 	}
 
@@ -227,28 +605,37 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 		imp.IngestOptions.BulkBufferSize = 1000
 	}
 
-	// ensure no more than one positional argument is supplied
-	if len(args) > 1 {
-		return fmt.Errorf("only one positional argument is allowed")
-	}
-
-	// ensure either a positional argument is supplied or an argument is passed
-	// to the --file flag - and not both
-	if imp.InputOptions.File != "" && len(args) != 0 {
-		return fmt.Errorf("incompatible options: --file and positional argument(s)")
+	if imp.InputOptions.ConvertTo != "" {
+		if _, err := ParseExtJSONFormat(imp.InputOptions.ExtJSONFormat); err != nil {
+			return err
+		}
+		if imp.InputOptions.Validate {
+			return fmt.Errorf("incompatible options: --convertTo and --validate")
+		}
 	}
 
-	if imp.InputOptions.File == "" {
-		if len(args) != 0 {
-			// if --file is not supplied, use the positional argument supplied
-			imp.InputOptions.File = args[0]
+	if isInputPattern(imp.InputOptions.File) {
+		if imp.ToolOptions.Collection == "" {
+			return fmt.Errorf("--collection is required when importing the files matching a pattern")
+		}
+		if imp.InputOptions.SkipRecords != 0 {
+			return fmt.Errorf("incompatible options: --skipRecords and a file pattern")
+		}
+		if imp.InputOptions.Skip != 0 {
+			return fmt.Errorf("incompatible options: --skip and a file pattern")
+		}
+		if imp.InputOptions.Limit != 0 {
+			return fmt.Errorf("incompatible options: --limit and a file pattern")
 		}
 	}
 
 	// ensure we have a valid string to use for the collection
-	if imp.ToolOptions.Collection == "" {
+	if imp.ToolOptions.Collection == "" && sourceScheme(imp.InputOptions.File) != StdinScheme {
 		log.Logvf(log.Always, "no collection specified")
 		fileBaseName := filepath.Base(imp.InputOptions.File)
+		if _, ok := locationScheme(imp.InputOptions.File); ok {
+			fileBaseName = urlBaseName(imp.InputOptions.File)
+		}
 		lastDotIndex := strings.LastIndex(fileBaseName, ".")
 		if lastDotIndex != -1 {
 			fileBaseName = fileBaseName[0:lastDotIndex]
@@ -263,27 +650,163 @@ func (imp *MongoImport) ValidateSettings(args []string) error {
 	return nil
 }
 
-// getSourceReader returns an io.Reader to read from the input source. Also
-// returns a progress.Progressor which can be used to track progress if the
-// reader supports it.
+// getSourceReader returns an io.Reader to read from the input source, opened
+// by the source registered for its scheme, and its size, or 0 if that is not
+// known.
 func (imp *MongoImport) getSourceReader() (io.ReadCloser, int64, error) {
-	if imp.InputOptions.File != "" {
-		file, err := os.Open(util.ToUniversalPath(imp.InputOptions.File))
+	source, size, err := OpenSource(imp.InputOptions.File, imp.sourceConfig())
+	if err != nil {
+		return nil, -1, err
+	}
+	switch {
+	case sourceScheme(imp.InputOptions.File) == StdinScheme:
+		log.Logvf(log.Info, "reading from stdin")
+	case size < 0:
+		log.Logvf(log.Info, "reading from %v, of unknown size", imp.InputOptions.File)
+	default:
+		log.Logvf(log.Info, "filesize: %v bytes", size)
+	}
+
+	// a source of unknown size has no maximum for the progress bar
+	if size < 0 {
+		size = 0
+	}
+	if imp.InputOptions.ReadRetries > 0 {
+		source = NewRetryingReader(source, imp.InputOptions.File, imp.sourceConfig(),
+			readRetryPolicy(imp.InputOptions.ReadRetries), sourceResumer(imp.InputOptions.File))
+	}
+	return source, size, nil
+}
+
+// sourceConfig returns the settings that the input source is opened with.
+func (imp *MongoImport) sourceConfig() SourceConfig {
+	return SourceConfig{Timeout: time.Duration(imp.InputOptions.URLTimeout) * time.Second}
+}
+
+// readRetryPolicy returns the policy with which --readRetries retries reads.
+func readRetryPolicy(maxRetries int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: maxRetries,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// isInputPattern reports whether path, the input file, is a pattern that may
+// match several files, rather than the name of a file. A file whose name
+// looks like a pattern is still imported on its own.
+func isInputPattern(path string) bool {
+	if _, ok := locationScheme(path); ok || !strings.ContainsAny(path, "*?[") {
+		return false
+	}
+	_, err := os.Stat(util.ToUniversalPath(path))
+	return err != nil
+}
+
+// expandInputPattern returns the files that pattern matches, sorted so that
+// they are imported in the same order every time, and their total size.
+// Directories that it matches are skipped with a warning; it is an error if
+// it matches no files.
+func expandInputPattern(pattern string) ([]string, int64, error) {
+	matches, err := filepath.Glob(util.ToUniversalPath(pattern))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid file pattern '%v': %v", pattern, err)
+	}
+	sort.Strings(matches)
+	var paths []string
+	var size int64
+	for _, match := range matches {
+		info, err := os.Stat(match)
 		if err != nil {
-			return nil, -1, err
+			return nil, 0, err
 		}
-		fileStat, err := file.Stat()
+		if info.IsDir() {
+			log.Logvf(log.Always, "warning: skipping directory %v, which matches '%v'", match, pattern)
+			continue
+		}
+		paths = append(paths, match)
+		size += info.Size()
+	}
+	if len(paths) == 0 {
+		return nil, 0, fmt.Errorf("no files match '%v'", pattern)
+	}
+	return paths, size, nil
+}
+
+// openInputPattern returns the files that pattern matches, as given by
+// expandInputPattern, ready to be read with cfg, and their total size.
+func openInputPattern(pattern string, cfg SourceConfig) ([]*inputFile, int64, error) {
+	paths, size, err := expandInputPattern(pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+	log.Logvf(log.Info, "importing %v files matching '%v', %v bytes in all", len(paths), pattern, size)
+	files := make([]*inputFile, len(paths))
+	for i, path := range paths {
+		files[i] = &inputFile{path: path, config: cfg}
+	}
+	return files, size, nil
+}
+
+// inputFile is one of the files matched by an input pattern. It is opened
+// when it is first read and closed once it has been read to the end, so that
+// the files are not all open at once.
+type inputFile struct {
+	path   string
+	config SourceConfig
+	file   io.ReadCloser
+	done   bool
+}
+
+func (f *inputFile) Read(p []byte) (int, error) {
+	if f.file == nil {
+		if f.done {
+			return 0, io.EOF
+		}
+		file, _, err := OpenSource(f.path, f.config)
 		if err != nil {
-			return nil, -1, err
+			return 0, err
 		}
-		log.Logvf(log.Info, "filesize: %v bytes", fileStat.Size())
-		return file, int64(fileStat.Size()), err
+		f.file = file
 	}
+	n, err := f.file.Read(p)
+	if err == io.EOF {
+		f.Close()
+		f.done = true
+	}
+	return n, err
+}
 
-	log.Logvf(log.Info, "reading from stdin")
+// Close closes the file if it is open.
+func (f *inputFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
 
-	// Stdin has undefined max size, so return 0
-	return os.Stdin, 0, nil
+// getMultiFileInputReader returns an InputReader that imports files one after
+// the other, each read by the InputReader that getInputReader would return.
+func (imp *MongoImport) getMultiFileInputReader(files []*inputFile) (InputReader, error) {
+	newReader, err := imp.inputReaderFunc()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(files))
+	sources := make([]io.Reader, len(files))
+	for i, file := range files {
+		names[i] = file.path
+		sources[i] = file
+	}
+	return NewMultiFileInputReader(names, sources, func(in io.Reader) InputReader {
+		r, err := newReader(in)
+		if err != nil {
+			return failedInputReader{err}
+		}
+		return r
+	})
 }
 
 // fileSizeProgressor implements Progressor to allow a sizeTracker to hook up with a
@@ -297,17 +820,73 @@ func (fsp *fileSizeProgressor) Progress() (int64, int64) {
 	return fsp.sizeTracker.Size(), fsp.max
 }
 
+// writeExtJSON writes the documents of inputReader to the ConvertTo file as
+// extended JSON, rather than importing them.
+func (imp *MongoImport) writeExtJSON(inputReader InputReader) error {
+	format, err := ParseExtJSONFormat(imp.InputOptions.ExtJSONFormat)
+	if err != nil {
+		return err
+	}
+	out := io.Writer(os.Stdout)
+	if imp.InputOptions.ConvertTo != "-" {
+		file, err := os.Create(util.ToUniversalPath(imp.InputOptions.ConvertTo))
+		if err != nil {
+			return fmt.Errorf("error creating output file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	numWritten, err := WriteExtJSON(inputReader, out, format)
+	log.Logvf(log.Always, "%v document(s) written as extended JSON to %v", numWritten, imp.InputOptions.ConvertTo)
+	return err
+}
+
 // ImportDocuments is used to write input data to the database. It returns the
 // number of documents successfully imported to the appropriate namespace and
 // any error encountered in doing this
 func (imp *MongoImport) ImportDocuments() (uint64, error) {
-	source, fileSize, err := imp.getSourceReader()
-	if err != nil {
-		return 0, err
+	var source io.ReadCloser
+	var files []*inputFile
+	var fileSize int64
+	var err error
+	if isInputPattern(imp.InputOptions.File) {
+		files, fileSize, err = openInputPattern(imp.InputOptions.File, imp.sourceConfig())
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			for _, file := range files {
+				file.Close()
+			}
+		}()
+	} else {
+		source, fileSize, err = imp.getSourceReader()
+		if err != nil {
+			return 0, err
+		}
+		defer source.Close()
+		if imp.detectsInputType() {
+			if source, err = imp.detectInputType(source); err != nil {
+				return 0, err
+			}
+		}
 	}
-	defer source.Close()
 
-	inputReader, err := imp.getInputReader(source)
+	if imp.IngestOptions.RejectsFile != "" {
+		rejects, err := os.Create(util.ToUniversalPath(imp.IngestOptions.RejectsFile))
+		if err != nil {
+			return 0, fmt.Errorf("error creating rejects file: %v", err)
+		}
+		defer rejects.Close()
+		imp.rejects = rejects
+	}
+
+	var inputReader InputReader
+	if files != nil {
+		inputReader, err = imp.getMultiFileInputReader(files)
+	} else {
+		inputReader, err = imp.getInputReader(source)
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -321,6 +900,56 @@ func (imp *MongoImport) ImportDocuments() (uint64, error) {
 		if err != nil {
 			return 0, err
 		}
+	} else if imp.InputOptions.GenerateFields {
+		generator, ok := inputReader.(interface {
+			GenerateFields() error
+		})
+		if !ok {
+			return 0, fmt.Errorf("can not use --generateFields when input type is %v", imp.InputOptions.Type)
+		}
+		if err = generator.GenerateFields(); err != nil {
+			return 0, err
+		}
+	} else if imp.InputOptions.DetectHeader {
+		detector, ok := inputReader.(interface {
+			ReadAndDetectHeader() error
+		})
+		if !ok {
+			return 0, fmt.Errorf("can not use --detectHeader when input type is %v", imp.InputOptions.Type)
+		}
+		if err = detector.ReadAndDetectHeader(); err != nil {
+			return 0, err
+		}
+	} else if imp.InputOptions.VerifyHeader != "" {
+		verifier, ok := inputReader.(interface {
+			VerifyHeader(HeaderCheck) error
+		})
+		if !ok {
+			return 0, fmt.Errorf("can not use --verifyHeader when input type is %v", imp.InputOptions.Type)
+		}
+		check, _ := ValidateHeaderCheck(imp.InputOptions.VerifyHeader)
+		if err = verifier.VerifyHeader(check); err != nil {
+			return 0, err
+		}
+	}
+
+	if imp.InputOptions.DryRun {
+		if fields := inputReader.Fields(); fields != nil {
+			log.Logvf(log.Always, "dry run: documents would have the fields: %v", strings.Join(fields, ","))
+		}
+		return 0, nil
+	}
+
+	if imp.InputOptions.Validate {
+		summary, err := ValidateInput(inputReader)
+		for _, line := range strings.Split(summary.String(), "\n") {
+			log.Logvf(log.Always, "validation: %v", line)
+		}
+		return 0, err
+	}
+
+	if imp.InputOptions.ConvertTo != "" {
+		return 0, imp.writeExtJSON(inputReader)
 	}
 
 	bar := &progress.Bar{
@@ -332,7 +961,45 @@ func (imp *MongoImport) ImportDocuments() (uint64, error) {
 	}
 	bar.Start()
 	defer bar.Stop()
-	return imp.importDocuments(inputReader)
+	numImported, err := imp.importDocuments(inputReader)
+	if rejecter, ok := inputReader.(interface {
+		Rejected() uint64
+	}); ok && imp.rejects != nil {
+		log.Logvf(log.Always, "%v document(s) rejected and written to %v",
+			rejecter.Rejected(), imp.IngestOptions.RejectsFile)
+	}
+	if projector, ok := inputReader.(interface {
+		DroppedColumns() int
+	}); ok && (imp.InputOptions.ProjectFields != "" || imp.InputOptions.ExcludeFields != "") {
+		log.Logvf(log.Always, "%v column(s) left out of each document",
+			projector.DroppedColumns())
+	}
+	if dropper, ok := inputReader.(interface {
+		Dropped() uint64
+	}); ok && imp.Transform != nil {
+		log.Logvf(log.Always, "%v document(s) dropped by the transform", dropper.Dropped())
+	}
+	if summarizer, ok := inputReader.(interface {
+		Summary() StreamSummary
+	}); ok && imp.IngestOptions.DocumentSizes {
+		logDocumentSizes(summarizer.Summary().DocumentSizes)
+	}
+	if summarizer, ok := inputReader.(interface {
+		Summary() StreamSummary
+	}); ok && imp.IngestOptions.DecoderTiming {
+		logDecoderTiming(summarizer.Summary().DecoderTiming)
+	}
+	if keyOnly := atomic.LoadUint64(&imp.keyOnlyCount); keyOnly != 0 {
+		log.Logvf(log.Always, "warning: %v document(s) skipped, having no fields to merge besides their upsert key", keyOnly)
+	}
+	if summarizer, ok := inputReader.(interface {
+		Summary() StreamSummary
+	}); ok && imp.InputOptions.SummaryFile != "" {
+		if summaryErr := writeSummaryFile(imp.InputOptions.SummaryFile, summarizer.Summary()); summaryErr != nil && err == nil {
+			err = summaryErr
+		}
+	}
+	return numImported, err
 }
 
 // importDocuments is a helper to ImportDocuments and does all the ingestion
@@ -383,18 +1050,29 @@ func (imp *MongoImport) importDocuments(inputReader InputReader) (numImported ui
 		}
 	}
 
-	readDocs := make(chan bson.D, workerBufferSize)
 	processingErrChan := make(chan error)
 	ordered := imp.IngestOptions.MaintainInsertionOrder
 
-	// read and process from the input reader
-	go func() {
-		processingErrChan <- inputReader.StreamDocument(ordered, readDocs)
-	}()
+	// documents that are only inserted are marshalled by the decoding
+	// goroutines, and inserted as they are; the others are read as bson.D,
+	// for their upsert keys
+	var readDocs chan bson.D
+	var rawDocs chan []byte
+	if imp.IngestOptions.Mode == modeInsert {
+		rawDocs = make(chan []byte, workerBufferSize)
+		go func() {
+			processingErrChan <- StreamRaw(inputReader, ordered, rawDocs)
+		}()
+	} else {
+		readDocs = make(chan bson.D, workerBufferSize)
+		go func() {
+			processingErrChan <- inputReader.StreamDocument(ordered, readDocs)
+		}()
+	}
 
 	// insert documents into the target database
 	go func() {
-		processingErrChan <- imp.ingestDocuments(readDocs)
+		processingErrChan <- imp.ingestDocuments(readDocs, rawDocs)
 	}()
 
 	e1 := channelQuorumError(processingErrChan, 2)
@@ -403,9 +1081,10 @@ func (imp *MongoImport) importDocuments(inputReader InputReader) (numImported ui
 }
 
 // ingestDocuments accepts a channel from which it reads documents to be inserted
-// into the target collection. It spreads the insert/upsert workload across one
+// into the target collection, readDocs or, for documents already marshalled,
+// rawDocs, the other being nil. It spreads the insert/upsert workload across one
 // or more workers.
-func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) (retErr error) {
+func (imp *MongoImport) ingestDocuments(readDocs chan bson.D, rawDocs chan []byte) (retErr error) {
 	numInsertionWorkers := imp.IngestOptions.NumInsertionWorkers
 	if numInsertionWorkers <= 0 {
 		numInsertionWorkers = 1
@@ -419,23 +1098,77 @@ func (imp *MongoImport) ingestDocuments(readDocs chan bson.D) (retErr error) {
 	// 3. There is an insertion/update error - e.g. duplicate key
 	//    error - and stopOnError is set to true
 
+	// when upserting in order, all the documents with the same upsert key go
+	// to the same worker, so the last of them is applied last
+	workerDocs := make([]chan bson.D, numInsertionWorkers)
+	for i := range workerDocs {
+		workerDocs[i] = readDocs
+	}
+	if imp.IngestOptions.Mode != modeInsert && imp.IngestOptions.MaintainInsertionOrder && numInsertionWorkers > 1 {
+		workerDocs = imp.partitionByUpsertKey(readDocs, numInsertionWorkers)
+	}
+
 	wg := new(sync.WaitGroup)
 	for i := 0; i < numInsertionWorkers; i++ {
 		wg.Add(1)
-		go func() {
+		go func(docs chan bson.D) {
 			defer wg.Done()
 			// only set the first insertion error and cause sibling goroutines to terminate immediately
-			err := imp.runInsertionWorker(readDocs)
+			err := imp.runInsertionWorker(docs, rawDocs)
 			if err != nil && retErr == nil {
 				retErr = err
 				imp.Kill(err)
 			}
-		}()
+		}(workerDocs[i])
 	}
 	wg.Wait()
 	return
 }
 
+// partitionByUpsertKey splits the documents read from readDocs across n
+// channels, in order, so that the documents with the same upsert key are all
+// sent on the same channel. The channels are closed once readDocs is, or
+// once the import is dying.
+func (imp *MongoImport) partitionByUpsertKey(readDocs chan bson.D, n int) []chan bson.D {
+	partitions := make([]chan bson.D, n)
+	for i := range partitions {
+		partitions[i] = make(chan bson.D, workerBufferSize)
+	}
+	go func() {
+		defer func() {
+			for _, partition := range partitions {
+				close(partition)
+			}
+		}()
+		for document := range readDocs {
+			partition := partitions[upsertKeyPartition(imp.upsertFields, document, n)]
+			select {
+			case partition <- document:
+			case <-imp.Dying():
+				return
+			}
+		}
+	}()
+	return partitions
+}
+
+// upsertKeyPartition returns which of n partitions the upsert key of
+// document falls in. Documents without an upsert key, which are inserted,
+// all fall in the first.
+func upsertKeyPartition(upsertFields []string, document bson.D, n int) int {
+	selector := constructUpsertDocument(upsertFields, document)
+	if selector == nil {
+		return 0
+	}
+	key, err := bson.Marshal(selector)
+	if err != nil {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int(hash.Sum32() % uint32(n))
+}
+
 // configureSession takes in a session and modifies it with properly configured
 // settings. It does the following configurations:
 //
@@ -463,8 +1196,9 @@ type flushInserter interface {
 }
 
 // runInsertionWorker is a helper to InsertDocuments - it reads document off
-// the read channel and prepares then in batches for insertion into the databas
-func (imp *MongoImport) runInsertionWorker(readDocs chan bson.D) (err error) {
+// the read channel, or the raw channel, and prepares then in batches for
+// insertion into the databas
+func (imp *MongoImport) runInsertionWorker(readDocs chan bson.D, rawDocs chan []byte) (err error) {
 	session, err := imp.SessionProvider.GetSession()
 	if err != nil {
 		return fmt.Errorf("error connecting to mongod: %v", err)
@@ -492,7 +1226,22 @@ readLoop:
 			if !alive {
 				break readLoop
 			}
-			err = filterIngestError(imp.IngestOptions.StopOnError, inserter.Insert(document))
+			err = inserter.Insert(document)
+			if err == errKeyOnlyMerge {
+				log.Logvf(log.DebugLow, "skipping document with only its upsert key: %v", document)
+				atomic.AddUint64(&imp.keyOnlyCount, 1)
+				continue
+			}
+			err = filterIngestError(imp.IngestOptions.StopOnError, err)
+			if err != nil {
+				return err
+			}
+			atomic.AddUint64(&imp.insertionCount, 1)
+		case raw, alive := <-rawDocs:
+			if !alive {
+				break readLoop
+			}
+			err = filterIngestError(imp.IngestOptions.StopOnError, inserter.Insert(bson.Raw{Kind: 0x03, Data: raw}))
 			if err != nil {
 				return err
 			}
@@ -541,11 +1290,19 @@ func (up *upserter) Insert(doc interface{}) error {
 	} else if up.imp.IngestOptions.Mode == modeUpsert {
 		_, err = up.collection.Upsert(selector, document)
 	} else { // modeMerge
-		_, err = up.collection.Upsert(selector, bson.M{"$set": document})
+		update := mergeUpdate(up.imp.upsertFields, document)
+		if update == nil {
+			return errKeyOnlyMerge
+		}
+		_, err = up.collection.Upsert(selector, bson.M{"$set": update})
 	}
 	return err
 }
 
+// errKeyOnlyMerge is returned by upserter.Insert for a document that has
+// nothing to merge besides its upsert key, which is skipped.
+var errKeyOnlyMerge = errors.New("document has no fields besides its upsert key")
+
 // Flush is needed so that upserter implements flushInserter, but upserter
 // doesn't buffer anything so we don't need to do anything in Flush.
 func (up *upserter) Flush() error {
@@ -572,42 +1329,310 @@ func splitInlineHeader(header string) (headers []string) {
 	return
 }
 
+// convertOptions returns the ConvertOptions for CSV and TSV input readers
+// described by the user-specified options.
+func (imp *MongoImport) convertOptions() ConvertOptions {
+	shortRows, _ := ValidateShortRows(imp.InputOptions.ShortRows)
+	longRows, _ := ValidateLongRows(imp.InputOptions.LongRows)
+	var renameFields map[string]string
+	for _, rename := range splitNonEmpty(imp.InputOptions.RenameFields) {
+		if renameFields == nil {
+			renameFields = make(map[string]string)
+		}
+		if i := strings.Index(rename, "="); i > 0 {
+			renameFields[rename[:i]] = rename[i+1:]
+		}
+	}
+	// the zones were validated by ValidateSettings
+	timeZone, _ := ParseTimeZone(imp.InputOptions.TimeZone)
+	var columnTimeZones map[string]*time.Location
+	for _, columnZone := range splitNonEmpty(imp.InputOptions.ColumnTimeZones) {
+		if columnTimeZones == nil {
+			columnTimeZones = make(map[string]*time.Location)
+		}
+		if i := strings.LastIndex(columnZone, "="); i > 0 {
+			columnTimeZones[columnZone[:i]], _ = ParseTimeZone(columnZone[i+1:])
+		}
+	}
+	dstGap, _ := ValidateDSTGapPolicy(imp.InputOptions.DSTGap)
+	invalidUTF8, _ := ValidateInvalidUTF8Policy(imp.InputOptions.InvalidUTF8)
+	anonymizeFields, _ := imp.anonymizeFields()
+	return ConvertOptions{
+		// a merge sets only the fields a row has, so its blank cells are not fields
+		IgnoreBlanks:           imp.IngestOptions.IgnoreBlanks || imp.IngestOptions.Mode == modeMerge,
+		TrimWhitespace:         imp.InputOptions.TrimWhitespace,
+		NullTokens:             splitNonEmpty(imp.InputOptions.NullTokens),
+		NullTokensIgnoreCase:   imp.InputOptions.NullTokensIgnoreCase,
+		TrueTokens:             splitNonEmpty(imp.InputOptions.TrueTokens),
+		FalseTokens:            splitNonEmpty(imp.InputOptions.FalseTokens),
+		BooleanCaseSensitive:   imp.InputOptions.BooleanTokensCaseSensitive,
+		DecimalSeparator:       imp.InputOptions.DecimalSeparator,
+		ThousandsSeparator:     imp.InputOptions.ThousandsSeparator,
+		CurrencySymbols:        splitNonEmpty(imp.InputOptions.CurrencySymbols),
+		StringsOnly:            imp.InputOptions.StringsOnly,
+		BigIntegersAsDecimal:   imp.InputOptions.BigIntegersAsDecimal,
+		FlatFields:             imp.InputOptions.FlatFields,
+		ShortRows:              shortRows,
+		LongRows:               longRows,
+		AllowTrailingDelimiter: imp.InputOptions.AllowTrailingDelimiter,
+		TrimFieldNames:         imp.InputOptions.TrimFieldNames,
+		LowercaseFieldNames:    imp.InputOptions.LowercaseFieldNames,
+		UnderscoreFieldNames:   imp.InputOptions.UnderscoreFieldNames,
+		SanitizeFields:         imp.InputOptions.SanitizeFields,
+		FieldSubstitute:        imp.InputOptions.FieldSubstitute,
+		RenameFields:           renameFields,
+		ProjectFields:          splitNonEmpty(imp.InputOptions.ProjectFields),
+		ExcludeFields:          splitNonEmpty(imp.InputOptions.ExcludeFields),
+		IDFields:               splitNonEmpty(imp.InputOptions.IDFields),
+		IDSeparator:            imp.InputOptions.IDSeparator,
+		KeepIDFields:           imp.InputOptions.KeepIDFields,
+		TimeZone:               timeZone,
+		ColumnTimeZones:        columnTimeZones,
+		DSTGap:                 dstGap,
+		InvalidUTF8:            invalidUTF8,
+		AnonymizeFields:        anonymizeFields,
+	}
+}
+
+// anonymizeFields parses --anonymizeFields, returning nil if it is not set.
+func (imp *MongoImport) anonymizeFields() (map[string]FieldAnonymizer, error) {
+	var anonymizers map[string]FieldAnonymizer
+	for _, entry := range splitNonEmpty(imp.InputOptions.AnonymizeFields) {
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --anonymizeFields entry '%v': must be of the form <field>=<anonymizer>", entry)
+		}
+		anonymizer, err := ParseFieldAnonymizer(entry[i+1:], imp.InputOptions.AnonymizeSalt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --anonymizeFields entry '%v': %v", entry, err)
+		}
+		if anonymizers == nil {
+			anonymizers = make(map[string]FieldAnonymizer)
+		}
+		anonymizers[entry[:i]] = anonymizer
+	}
+	return anonymizers, nil
+}
+
+// splitNonEmpty splits a comma-separated option value, returning nil for an
+// empty one.
+func splitNonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
 // getInputReader returns an implementation of InputReader based on the input type
 func (imp *MongoImport) getInputReader(in io.Reader) (InputReader, error) {
+	newReader, err := imp.inputReaderFunc()
+	if err != nil {
+		return nil, err
+	}
+	return newReader(in)
+}
+
+// inputReaderFunc validates the options that configure the input readers,
+// returning a func that makes an InputReader for each input source.
+func (imp *MongoImport) inputReaderFunc() (func(in io.Reader) (InputReader, error), error) {
 	var colSpecs []ColumnSpec
 	var headers []string
 	var err error
-	if imp.InputOptions.Fields != nil {
-		headers = splitInlineHeader(*imp.InputOptions.Fields)
-	} else if imp.InputOptions.FieldFile != nil {
-		headers, err = util.GetFieldsFromFile(*imp.InputOptions.FieldFile)
+	convertOptions := imp.convertOptions()
+	if imp.InputOptions.FieldFile != nil {
+		// the field file is validated as it is read
+		fieldFile, err := os.Open(util.ToUniversalPath(*imp.InputOptions.FieldFile))
 		if err != nil {
 			return nil, err
 		}
-	}
-	if imp.InputOptions.ColumnsHaveTypes {
-		colSpecs, err = ParseTypedHeaders(headers, ParsePG(imp.InputOptions.ParseGrace))
+		defer fieldFile.Close()
+		colSpecs, err = ReadFieldFile(fieldFile, imp.InputOptions.ColumnsHaveTypes,
+			ParsePG(imp.InputOptions.ParseGrace), &convertOptions)
 		if err != nil {
 			return nil, err
 		}
+		if err = convertOptions.checkRenames(); err != nil {
+			return nil, err
+		}
+		if err = convertOptions.selectColumns(colSpecs); err != nil {
+			return nil, err
+		}
 	} else {
-		colSpecs = ParseAutoHeaders(headers)
+		if imp.InputOptions.Fields != nil {
+			headers = splitInlineHeader(*imp.InputOptions.Fields)
+		}
+		if imp.InputOptions.ColumnsHaveTypes {
+			colSpecs, err = ParseTypedHeaders(headers, ParsePG(imp.InputOptions.ParseGrace))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			colSpecs = ParseAutoHeaders(headers)
+		}
+
+		// header fields validation can only happen once we have an input reader
+		if !imp.InputOptions.HeaderLine && !imp.InputOptions.GenerateFields &&
+			(!imp.InputOptions.DetectHeader || len(colSpecs) != 0) {
+			convertOptions.prepareColumnNames(colSpecs)
+			if err = convertOptions.validateColumns(colSpecs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return func(in io.Reader) (InputReader, error) {
+		return imp.newInputReader(in, colSpecs, convertOptions)
+	}, nil
+}
+
+// newInputReader returns an implementation of InputReader for in, made by
+// the constructor registered for the input type, converting records as
+// colSpecs and convertOptions say, and sets the options of its stream.
+func (imp *MongoImport) newInputReader(in io.Reader, colSpecs []ColumnSpec, convertOptions ConvertOptions) (InputReader, error) {
+	inputType, ctor, err := imp.lookupInputReader()
+	if err != nil {
+		return nil, err
+	}
+	// the encoding was validated by ValidateSettings
+	encoding, _ := ValidateInputEncoding(imp.InputOptions.InputEncoding)
+	r, err := ctor(ReaderConfig{
+		In:             in,
+		File:           imp.InputOptions.File,
+		ColSpecs:       colSpecs,
+		ConvertOptions: convertOptions,
+		NumDecoders:    imp.IngestOptions.NumDecodingWorkers,
+		IgnoreBlanks:   convertOptions.IgnoreBlanks && inputType != JSON,
+		Encoding:       encoding,
+		InputOptions:   imp.InputOptions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if streamer, ok := r.(interface {
+		streamOptions() *StreamOptions
+	}); ok {
+		imp.setStreamOptions(streamer.streamOptions())
+	}
+	return r, nil
+}
+
+// lookupInputReader returns the name and the constructor of the input type:
+// the one given by --type, or else the one registered for the file's
+// extension, or JSON if there is none.
+func (imp *MongoImport) lookupInputReader() (string, InputReaderConstructor, error) {
+	name, ctor, err := LookupInputReader(imp.InputOptions.Type, imp.InputOptions.File)
+	if err != nil && imp.InputOptions.Type == "" {
+		return LookupInputReader(JSON, "")
 	}
+	return name, ctor, err
+}
+
+// detectsInputType reports whether the input type is to be detected from the
+// content of the input: if --type is not given, and the input is standard
+// input or a file without an extension.
+func (imp *MongoImport) detectsInputType() bool {
+	file := imp.InputOptions.File
+	return imp.InputOptions.Type == "" && !isInputPattern(file) &&
+		(sourceScheme(file) == StdinScheme || fileExtension(file) == "")
+}
 
-	// header fields validation can only happen once we have an input reader
-	if !imp.InputOptions.HeaderLine {
-		if err = validateReaderFields(ColumnNames(colSpecs)); err != nil {
+// detectInputType sets the input type to the one that DetectInputType
+// guesses the source to have, and validates the settings for it, returning
+// a reader of the source that reads the sample that it peeked at again.
+// Empty input is taken to be JSON.
+func (imp *MongoImport) detectInputType(source io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReaderSize(source, sniffSampleSize)
+	guess, err := DetectInputType(buffered)
+	if err != nil {
+		return nil, err
+	}
+	inputType := JSON
+	if _, err = buffered.Peek(1); err != io.EOF {
+		if inputType, err = confidentInputType(guess); err != nil {
 			return nil, err
 		}
+		log.Logvf(log.Info, "detected the input type %v, with a confidence of %.2f", inputType, guess.Confidence)
+	}
+	if _, _, err = LookupInputReader(inputType, ""); err != nil {
+		return nil, fmt.Errorf("the input looks like %v, but no input type is registered for it", inputType)
+	}
+	imp.InputOptions.Type = inputType
+	if err = imp.ValidateSettings(nil); err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{buffered, source}, nil
+}
+
+// setStreamOptions sets the options of a reader's stream that every input
+// type shares.
+func (imp *MongoImport) setStreamOptions(opts *StreamOptions) {
+	opts.Rejects = imp.rejects
+	opts.MaxErrors = imp.IngestOptions.MaxErrors
+	opts.MaxDocumentSize = imp.IngestOptions.MaxDocumentSize
+	imp.setDocumentSizes(opts)
+	opts.DecoderTiming = imp.IngestOptions.DecoderTiming
+	opts.BatchSize = imp.IngestOptions.DecodingBatchSize
+	opts.ReorderWindow = imp.IngestOptions.ReorderWindow
+	opts.SetRateLimit(imp.IngestOptions.MaxDocsPerSecond, imp.IngestOptions.MaxBytesPerSecond)
+	imp.setDedup(opts)
+	opts.Transform = imp.Transform
+	opts.UpsertFields = imp.checkedUpsertFields()
+	opts.SkipRecords = uint64(imp.InputOptions.SkipRecords)
+	opts.Skip = uint64(imp.InputOptions.Skip)
+	opts.Limit = uint64(imp.InputOptions.Limit)
+	imp.setSampling(opts)
+}
+
+// setDocumentSizes sets the DocumentSizes options of a reader's stream from
+// --documentSizes and --documentSizeBuckets, which were validated by
+// ValidateSettings.
+func (imp *MongoImport) setDocumentSizes(opts *StreamOptions) {
+	opts.DocumentSizes = imp.IngestOptions.DocumentSizes
+	opts.SizeBuckets, _ = imp.documentSizeBuckets()
+}
+
+// documentSizeBuckets parses --documentSizeBuckets, returning nil if it is
+// not set.
+func (imp *MongoImport) documentSizeBuckets() ([]int, error) {
+	var buckets []int
+	for _, bound := range splitNonEmpty(imp.IngestOptions.DocumentSizeBuckets) {
+		size, err := strconv.Atoi(strings.TrimSpace(bound))
+		if err != nil {
+			return nil, fmt.Errorf("'%v' is not a number of bytes", bound)
+		}
+		buckets = append(buckets, size)
 	}
+	return buckets, ValidateSizeBuckets(buckets)
+}
+
+// setSampling sets the sampling options of a reader's stream from
+// --sampleEvery, --sampleFraction and --sampleSeed.
+func (imp *MongoImport) setSampling(opts *StreamOptions) {
+	opts.SampleEvery = uint64(imp.InputOptions.SampleEvery)
+	opts.SampleFraction = imp.InputOptions.SampleFraction
+	opts.SampleSeed = imp.InputOptions.SampleSeed
+}
 
-	out := os.Stdout
+// setDedup sets the Dedup options of a reader's stream from --dedup and the
+// options that go with it, which were validated by ValidateSettings.
+func (imp *MongoImport) setDedup(opts *StreamOptions) {
+	opts.Dedup = imp.IngestOptions.Dedup
+	if imp.IngestOptions.DedupFields != "" {
+		opts.DedupFields = strings.Split(imp.IngestOptions.DedupFields, ",")
+	}
+	opts.DedupMaxMemory = imp.IngestOptions.DedupMaxMemory
+	opts.DedupOverflow, _ = ValidateDedupOverflowPolicy(imp.IngestOptions.DedupOverflow)
+}
 
-	ignoreBlanks := imp.IngestOptions.IgnoreBlanks && imp.InputOptions.Type != JSON
-	if imp.InputOptions.Type == CSV {
-		return NewCSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks), nil
-	} else if imp.InputOptions.Type == TSV {
-		return NewTSVInputReader(colSpecs, in, out, imp.IngestOptions.NumDecodingWorkers, ignoreBlanks), nil
+// checkedUpsertFields returns the upsert fields that every document must
+// have: those given by --upsertFields. A document without an _id, the default
+// upsert field, is inserted instead.
+func (imp *MongoImport) checkedUpsertFields() []string {
+	if imp.IngestOptions.UpsertFields == "" {
+		return nil
 	}
-	return NewJSONInputReader(imp.InputOptions.JSONArray, in, imp.IngestOptions.NumDecodingWorkers), nil
+	return imp.upsertFields
 }
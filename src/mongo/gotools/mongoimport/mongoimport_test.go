@@ -7,10 +7,12 @@
 package mongoimport
 
 import (
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
@@ -189,6 +191,7 @@ func TestMongoImportValidateSettings(t *testing.T) {
 		Convey("an error should be thrown if --headerline is used with JSON input", func() {
 			imp, err := NewMongoImport()
 			So(err, ShouldBeNil)
+			imp.InputOptions.Type = JSON
 			imp.InputOptions.HeaderLine = true
 			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
 		})
@@ -196,6 +199,7 @@ func TestMongoImportValidateSettings(t *testing.T) {
 		Convey("an error should be thrown if --fields is used with JSON input", func() {
 			imp, err := NewMongoImport()
 			So(err, ShouldBeNil)
+			imp.InputOptions.Type = JSON
 			fields := ""
 			imp.InputOptions.Fields = &fields
 			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
@@ -207,6 +211,7 @@ func TestMongoImportValidateSettings(t *testing.T) {
 		Convey("an error should be thrown if --fieldFile is used with JSON input", func() {
 			imp, err := NewMongoImport()
 			So(err, ShouldBeNil)
+			imp.InputOptions.Type = JSON
 			fieldFile := ""
 			imp.InputOptions.FieldFile = &fieldFile
 			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
@@ -215,13 +220,151 @@ func TestMongoImportValidateSettings(t *testing.T) {
 			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
 		})
 
+		Convey("an error should be thrown if --fieldFile is used with --headerline", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			fieldFile := "test.csv"
+			imp.InputOptions.FieldFile = &fieldFile
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = TSV
+			err = imp.ValidateSettings([]string{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "incompatible options: --fieldFile and --headerline")
+		})
+
 		Convey("an error should be thrown if --ignoreBlanks is used with JSON input", func() {
 			imp, err := NewMongoImport()
 			So(err, ShouldBeNil)
+			imp.InputOptions.Type = JSON
 			imp.IngestOptions.IgnoreBlanks = true
 			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
 		})
 
+		Convey("an error should be thrown if --delimiter is used with non-TSV input", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.Type = JSON
+			imp.InputOptions.Delimiter = "|"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.Type = TSV
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			imp.InputOptions.Delimiter = "\n"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown if an invalid row policy is given", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.Type = JSON
+			imp.InputOptions.LongRows = "truncateExtra"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			imp.InputOptions.ShortRows = "truncateExtra"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown if a value is both a true and a false token", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			imp.InputOptions.TrueTokens = "Y,yes"
+			imp.InputOptions.FalseTokens = "N,no"
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			imp.InputOptions.FalseTokens = "N,y"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.BooleanTokensCaseSensitive = true
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(imp.convertOptions().FalseTokens, ShouldResemble, []string{"N", "y"})
+		})
+
+		Convey("an error should be thrown if the thousands and decimal separators are the same", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			imp.InputOptions.ThousandsSeparator = "."
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.DecimalSeparator = ","
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			imp.InputOptions.Type = JSON
+			imp.InputOptions.HeaderLine = false
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("--decodingBatchSize and --reorderWindow should be checked and set on the reader", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			imp.IngestOptions.DecodingBatchSize = 64
+			imp.IngestOptions.ReorderWindow = 8
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.IngestOptions.MaintainInsertionOrder = true
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			var opts StreamOptions
+			imp.setStreamOptions(&opts)
+			So(opts.BatchSize, ShouldEqual, 64)
+			So(opts.ReorderWindow, ShouldEqual, 8)
+			imp.IngestOptions.DecodingBatchSize = -1
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("blank cells should always be left out in merge mode", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			imp.IngestOptions.Mode = modeMerge
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(imp.convertOptions().IgnoreBlanks, ShouldBeTrue)
+		})
+
+		Convey("--idSeparator and --keepIdFields should require --idFields", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			imp.InputOptions.IDSeparator = "-"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.IDSeparator = ""
+			imp.InputOptions.KeepIDFields = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.IDFields = "region,order_no"
+			imp.InputOptions.IDSeparator = "-"
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(imp.convertOptions().IDFields, ShouldResemble, []string{"region", "order_no"})
+		})
+
+		Convey("an error should be thrown if a --renameFields entry is malformed", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			imp.InputOptions.RenameFields = "Cust ID=customerId,city=address.city"
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(imp.convertOptions().RenameFields, ShouldResemble,
+				map[string]string{"Cust ID": "customerId", "city": "address.city"})
+			imp.InputOptions.RenameFields = "Cust ID=customerId,city"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+			imp.InputOptions.RenameFields = "=customerId"
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
+		Convey("an error should be thrown if --rejectsFile is used with --stopOnError", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.IngestOptions.RejectsFile = "rejected.json"
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			imp.IngestOptions.StopOnError = true
+			So(imp.ValidateSettings([]string{}), ShouldNotBeNil)
+		})
+
 		Convey("no error should be thrown if --headerline is not supplied "+
 			"but --fieldFile is supplied", func() {
 			imp, err := NewMongoImport()
@@ -286,6 +429,21 @@ func TestMongoImportValidateSettings(t *testing.T) {
 			So(imp.upsertFields, ShouldResemble, []string{"_id"})
 		})
 
+		Convey("upserts, unlike ordered inserts, should keep several insertion workers", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.Type = CSV
+			imp.IngestOptions.Mode = modeUpsert
+			imp.IngestOptions.NumInsertionWorkers = 4
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(imp.IngestOptions.MaintainInsertionOrder, ShouldBeTrue)
+			So(imp.IngestOptions.NumInsertionWorkers, ShouldEqual, 4)
+			imp.IngestOptions.Mode = modeInsert
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			So(imp.IngestOptions.NumInsertionWorkers, ShouldEqual, 1)
+		})
+
 		Convey("no error should be thrown if all fields in the --upsertFields "+
 			"argument are valid", func() {
 			imp, err := NewMongoImport()
@@ -395,6 +553,76 @@ func TestGetSourceReader(t *testing.T) {
 		})
 }
 
+func TestImportFilePattern(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Given a directory of TSV shards, some of them gzipped", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		write := func(name, contents string, compressed bool) {
+			file, err := os.Create(filepath.Join(dir, name))
+			So(err, ShouldBeNil)
+			defer file.Close()
+			if !compressed {
+				_, err = file.WriteString(contents)
+				So(err, ShouldBeNil)
+				return
+			}
+			w := gzip.NewWriter(file)
+			_, err = w.Write([]byte(contents))
+			So(err, ShouldBeNil)
+			So(w.Close(), ShouldBeNil)
+		}
+		write("part-00001.tsv", "a\n3\n4\n", true)
+		write("part-00000.tsv", "a\n1\n2\n", false)
+		write("part-00002.tsv", "a\n5\n", false)
+		write("other.tsv", "b\nx\n", false)
+		So(os.Mkdir(filepath.Join(dir, "part-99999.tsv"), 0755), ShouldBeNil)
+		pattern := filepath.Join(dir, "part-*.tsv")
+
+		Convey("the files matched should be sorted, skipping directories", func() {
+			paths, size, err := expandInputPattern(pattern)
+			So(err, ShouldBeNil)
+			So(len(paths), ShouldEqual, 3)
+			for i, path := range paths {
+				So(filepath.Base(path), ShouldEqual, fmt.Sprintf("part-0000%v.tsv", i))
+			}
+			So(size, ShouldBeGreaterThan, 0)
+		})
+		Convey("a pattern that matches no files should be an error", func() {
+			_, _, err := expandInputPattern(filepath.Join(dir, "none-*.tsv"))
+			So(err, ShouldNotBeNil)
+			_, _, err = expandInputPattern(filepath.Join(dir, "part-9*.tsv"))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("only a name that is not a file should be taken as a pattern", func() {
+			So(isInputPattern(pattern), ShouldBeTrue)
+			So(isInputPattern(filepath.Join(dir, "part-00000.tsv")), ShouldBeFalse)
+			write("literal[1].tsv", "a\n", false)
+			So(isInputPattern(filepath.Join(dir, "literal[1].tsv")), ShouldBeFalse)
+		})
+		Convey("the files should be imported in order, each decompressed as need be", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.Type = TSV
+			imp.InputOptions.HeaderLine = true
+			files, _, err := openInputPattern(pattern, SourceConfig{})
+			So(err, ShouldBeNil)
+			r, err := imp.getMultiFileInputReader(files)
+			So(err, ShouldBeNil)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 5)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			for i := 1; i <= 5; i++ {
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(i)}})
+			}
+			for _, file := range files {
+				So(file.file, ShouldBeNil)
+			}
+		})
+	})
+}
+
 func TestGetInputReader(t *testing.T) {
 	testutil.VerifyTestType(t, testutil.UnitTestType)
 	Convey("Given a io.Reader on calling getInputReader", t, func() {
@@ -466,6 +694,10 @@ func TestGetInputReader(t *testing.T) {
 			imp.InputOptions.Type = TSV
 			_, err = imp.getInputReader(&os.File{})
 			So(err, ShouldBeNil)
+			imp.InputOptions.WhitespaceDelimited = true
+			r, err := imp.getInputReader(&os.File{})
+			So(err, ShouldBeNil)
+			So(r.(*TSVInputReader).WhitespaceDelimited, ShouldBeTrue)
 		})
 		Convey("no error should be thrown for JSON import inputs", func() {
 			imp, err := NewMongoImport()
@@ -484,6 +716,40 @@ func TestGetInputReader(t *testing.T) {
 			_, err = imp.getInputReader(file)
 			So(err, ShouldNotBeNil)
 		})
+		Convey("the --fieldFile fields should be passed to the input reader", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			fieldFile := "testdata/test_fields_valid.txt"
+			imp.InputOptions.FieldFile = &fieldFile
+			imp.InputOptions.Type = TSV
+			r, err := imp.getInputReader(&os.File{})
+			So(err, ShouldBeNil)
+			So(ColumnNames(r.(*TSVInputReader).colSpecs), ShouldResemble, []string{"a", "b", "c"})
+		})
+		Convey("--projectFields and --excludeFields should be checked against --fields", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			fields := "a,b.c,b.d,e"
+			imp.InputOptions.Fields = &fields
+			imp.InputOptions.Type = CSV
+			imp.InputOptions.ProjectFields = "b,e"
+			r, err := imp.getInputReader(&os.File{})
+			So(err, ShouldBeNil)
+			So(r.(*CSVInputReader).DroppedColumns(), ShouldEqual, 1)
+			imp.InputOptions.ProjectFields = "b,f"
+			_, err = imp.getInputReader(&os.File{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "projected field 'f' is not in the header")
+			imp.InputOptions.ProjectFields = "a,e"
+			imp.InputOptions.ExcludeFields = "b.d"
+			r, err = imp.getInputReader(&os.File{})
+			So(err, ShouldBeNil)
+			So(r.(*CSVInputReader).DroppedColumns(), ShouldEqual, 2)
+			imp.InputOptions.ProjectFields = ""
+			r, err = imp.getInputReader(&os.File{})
+			So(err, ShouldBeNil)
+			So(r.(*CSVInputReader).DroppedColumns(), ShouldEqual, 1)
+		})
 		Convey("no error should be thrown if --fieldFile fields are valid", func() {
 			imp, err := NewMongoImport()
 			So(err, ShouldBeNil)
@@ -497,6 +763,71 @@ func TestGetInputReader(t *testing.T) {
 	})
 }
 
+func TestUpsertKeyPartition(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Given upsert fields, on calling upsertKeyPartition", t, func() {
+		upsertFields := []string{"region", "no"}
+		Convey("documents with the same key should fall in the same partition", func() {
+			for no := 0; no < 20; no++ {
+				first := upsertKeyPartition(upsertFields, bson.D{{"region", "EU"}, {"no", no}, {"qty", 1}}, 4)
+				second := upsertKeyPartition(upsertFields, bson.D{{"qty", 2}, {"no", no}, {"region", "EU"}}, 4)
+				So(first, ShouldEqual, second)
+				So(first, ShouldBeBetweenOrEqual, 0, 3)
+			}
+		})
+		Convey("documents without a key should fall in the first partition", func() {
+			So(upsertKeyPartition(upsertFields, bson.D{{"qty", 2}}, 4), ShouldEqual, 0)
+		})
+		Convey("partitionByUpsertKey should keep the documents of each key in order", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.upsertFields = upsertFields
+			readDocs := make(chan bson.D, 40)
+			for i := 0; i < 40; i++ {
+				readDocs <- bson.D{{"region", "eu"}, {"no", i % 5}, {"seq", i}}
+			}
+			close(readDocs)
+			last := make(map[int]int)
+			for _, partition := range imp.partitionByUpsertKey(readDocs, 3) {
+				for document := range partition {
+					no, seq := document[1].Value.(int), document[2].Value.(int)
+					if previous, ok := last[no]; ok {
+						So(seq, ShouldBeGreaterThan, previous)
+					}
+					last[no] = seq
+				}
+			}
+			So(len(last), ShouldEqual, 5)
+		})
+	})
+}
+
+func TestImportDocumentsDryRun(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a dry run of a mongoimport instance", t, func() {
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.InputOptions.File = "testdata/test.csv"
+		imp.InputOptions.Type = CSV
+		fields := "a,b,c"
+		imp.InputOptions.Fields = &fields
+		imp.InputOptions.DryRun = true
+		imp.InputOptions.RenameFields = "a=x.y,c=z"
+		Convey("the fields should be validated without importing anything", func() {
+			So(imp.ValidateSettings([]string{}), ShouldBeNil)
+			numImported, err := imp.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 0)
+		})
+		Convey("invalid renames should fail", func() {
+			imp.InputOptions.RenameFields = "a=x,d=y"
+			_, err := imp.ImportDocuments()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "renamed field 'd' is not in the header")
+		})
+	})
+}
+
 func TestImportDocuments(t *testing.T) {
 	testutil.VerifyTestType(t, testutil.IntegrationTestType)
 	Convey("With a mongoimport instance", t, func() {
@@ -0,0 +1,287 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MultiFileInputReader is an implementation of InputReader that reads several
+// input files, one after the other, as a single stream. Each file is read by
+// its own InputReader, so line numbers in errors count from the start of the
+// file, and each file's compression is detected on its own.
+//
+// If the header is read, it is read from the first file, and every later
+// file must begin with the same header, which is skipped. Fields that are
+// generated, or a header that is detected, are so for each file the same way,
+// and must name the same fields in every file. A header that is verified is
+// checked against the fields given in every file.
+type MultiFileInputReader struct {
+	// names are the names of the files, used in errors
+	names []string
+
+	// sources are the files themselves
+	sources []io.Reader
+
+	// newReader returns the InputReader for each file
+	newReader func(in io.Reader) InputReader
+
+	// header reads and validates the header of a file's reader, or is nil if
+	// the files have no header
+	header func(r InputReader) error
+
+	// verified is set if the headers are verified against the fields given,
+	// rather than naming them, so they need not match the first file's
+	verified bool
+
+	// firstReader is the reader of the first file, made when its header is
+	// read or the stream begins
+	firstReader InputReader
+
+	// lock guards the fields below it, which report the bytes read
+	lock sync.Mutex
+
+	// current is the reader of the file being streamed, if any
+	current InputReader
+
+	// doneBytes and doneRecords are the numbers of bytes and records read
+	// from the files before it
+	doneBytes   int64
+	doneRecords uint64
+}
+
+// FileError is returned by a MultiFileInputReader when one of its files can
+// not be read or imported.
+type FileError struct {
+	// Name is the name of the file
+	Name string
+
+	// Err is the error that the file's reader returned
+	Err error
+}
+
+func (e FileError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Name, e.Err)
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// NewMultiFileInputReader returns a MultiFileInputReader for the given
+// sources, named by names, that reads each of them with the InputReader that
+// newReader returns for it. Readers are made as their files are reached, so
+// newReader should configure each one the same way. Options that count
+// records, such as SkipRecords and MaxErrors, then apply to each file on its
+// own.
+func NewMultiFileInputReader(names []string, sources []io.Reader, newReader func(in io.Reader) InputReader) (*MultiFileInputReader, error) {
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no input files")
+	}
+	if len(names) != len(sources) {
+		return nil, fmt.Errorf("%v names given for %v input files", len(names), len(sources))
+	}
+	return &MultiFileInputReader{
+		names:     names,
+		sources:   sources,
+		newReader: newReader,
+	}, nil
+}
+
+// ReadAndValidateHeader reads the header of the first file and validates its
+// fields. The headers of the later files are checked as they are reached.
+func (r *MultiFileInputReader) ReadAndValidateHeader() error {
+	return r.readFirstHeader(func(reader InputReader) error {
+		return reader.ReadAndValidateHeader()
+	})
+}
+
+// ReadAndValidateTypedHeader is like ReadAndValidateHeader, but parses the
+// types of the fields of the headers too.
+func (r *MultiFileInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) error {
+	return r.readFirstHeader(func(reader InputReader) error {
+		return reader.ReadAndValidateTypedHeader(parseGrace)
+	})
+}
+
+// GenerateFields generates the fields of the first file from its first record,
+// as the reader of the file does, and those of the later files as they are
+// reached. The reader of each file must have a GenerateFields method.
+func (r *MultiFileInputReader) GenerateFields() error {
+	return r.readFirstHeader(func(reader InputReader) error {
+		generator, ok := reader.(interface {
+			GenerateFields() error
+		})
+		if !ok {
+			return fmt.Errorf("can not generate the fields of this input type")
+		}
+		return generator.GenerateFields()
+	})
+}
+
+// ReadAndDetectHeader detects whether the first file starts with a header, as
+// the reader of the file does, and each later file as it is reached. The
+// reader of each file must have a ReadAndDetectHeader method.
+func (r *MultiFileInputReader) ReadAndDetectHeader() error {
+	return r.readFirstHeader(func(reader InputReader) error {
+		detector, ok := reader.(interface {
+			ReadAndDetectHeader() error
+		})
+		if !ok {
+			return fmt.Errorf("can not detect the header of this input type")
+		}
+		return detector.ReadAndDetectHeader()
+	})
+}
+
+// VerifyHeader checks the header of the first file against the fields given,
+// as the reader of the file does, and that of each later file as it is
+// reached. The reader of each file must have a VerifyHeader method.
+func (r *MultiFileInputReader) VerifyHeader(check HeaderCheck) error {
+	r.verified = true
+	return r.readFirstHeader(func(reader InputReader) error {
+		verifier, ok := reader.(interface {
+			VerifyHeader(HeaderCheck) error
+		})
+		if !ok {
+			return fmt.Errorf("can not verify the header of this input type")
+		}
+		return verifier.VerifyHeader(check)
+	})
+}
+
+// readFirstHeader reads the first file's header with header, which is kept to
+// read the headers of the later files.
+func (r *MultiFileInputReader) readFirstHeader(header func(r InputReader) error) error {
+	r.header = header
+	if err := header(r.reader(0)); err != nil {
+		return FileError{r.names[0], err}
+	}
+	return nil
+}
+
+// reader returns the reader of the ith file, making it if need be. Only the
+// first file's reader is kept once it is made.
+func (r *MultiFileInputReader) reader(i int) InputReader {
+	if i != 0 {
+		return r.newReader(r.sources[i])
+	}
+	if r.firstReader == nil {
+		r.firstReader = r.newReader(r.sources[0])
+	}
+	return r.firstReader
+}
+
+// Fields returns the names of the fields that documents are given, as the
+// reader of the first file reports them.
+func (r *MultiFileInputReader) Fields() []string {
+	return r.reader(0).Fields()
+}
+
+// NumProcessed returns the number of records read from all the files so
+// far, after their headers. It is safe to call while StreamDocument is
+// running.
+func (r *MultiFileInputReader) NumProcessed() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.current == nil {
+		return r.doneRecords
+	}
+	return r.doneRecords + r.current.NumProcessed()
+}
+
+// Size returns the number of bytes read from all the files so far. It is safe
+// to call while StreamDocument is running.
+func (r *MultiFileInputReader) Size() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.current == nil {
+		return r.doneBytes
+	}
+	return r.doneBytes + r.current.Size()
+}
+
+// StreamDocument takes a boolean indicating if the documents should be streamed
+// in read order and a channel on which to stream the documents processed from
+// the underlying reader. The files are streamed one after the other, so in
+// order mode, the documents of each file follow those of the file before it.
+// Returns a FileError naming the file if streaming fails.
+func (r *MultiFileInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	defer close(readDocs)
+	for i, name := range r.names {
+		reader := r.reader(i)
+		if i != 0 && r.header != nil {
+			if err := r.checkHeader(reader); err != nil {
+				return FileError{name, err}
+			}
+		}
+		r.lock.Lock()
+		r.current = reader
+		r.lock.Unlock()
+
+		fileDocs := make(chan bson.D, workerBufferSize)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- reader.StreamDocument(ordered, fileDocs)
+		}()
+		for document := range fileDocs {
+			readDocs <- document
+		}
+		if err := <-errChan; err != nil {
+			return FileError{name, err}
+		}
+
+		r.lock.Lock()
+		r.doneBytes += reader.Size()
+		r.doneRecords += reader.NumProcessed()
+		r.current = nil
+		r.lock.Unlock()
+	}
+	return nil
+}
+
+// checkHeader reads the header of a later file's reader, which must match that
+// of the first file unless it is only verified.
+func (r *MultiFileInputReader) checkHeader(reader InputReader) error {
+	if err := r.header(reader); err != nil || r.verified {
+		return err
+	}
+	type headerReader interface {
+		OriginalHeader() []string
+	}
+	first, ok := r.reader(0).(headerReader)
+	if !ok {
+		return nil
+	}
+	later, ok := reader.(headerReader)
+	if !ok {
+		return nil
+	}
+	if want, got := first.OriginalHeader(), later.OriginalHeader(); !equalFields(want, got) {
+		return fmt.Errorf("header '%v' does not match '%v', the header of %v",
+			strings.Join(got, ","), strings.Join(want, ","), r.names[0])
+	}
+	return nil
+}
+
+// equalFields reports whether a and b hold the same fields in the same order.
+func equalFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMultiFileStreamDocument(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a multi-file input reader of TSV files", t, func() {
+		newReader := func(contents ...string) *MultiFileInputReader {
+			names := make([]string, len(contents))
+			sources := make([]io.Reader, len(contents))
+			for i, content := range contents {
+				names[i] = "part-0000" + string('0'+rune(i)) + ".tsv"
+				sources[i] = bytes.NewReader([]byte(content))
+			}
+			r, err := NewMultiFileInputReader(names, sources, func(in io.Reader) InputReader {
+				return NewTSVInputReader(nil, in, os.Stdout, 2, false)
+			})
+			So(err, ShouldBeNil)
+			return r
+		}
+
+		Convey("records should be streamed in order across the files, skipping later headers", func() {
+			r := newReader("a\tb\n1\tx\n2\ty\n", "a\tb\n3\tz\n", "a\tb\n")
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"a", "b"})
+			docChan := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", "x"}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(2)}, {"b", "y"}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}, {"b", "z"}})
+			_, open := <-docChan
+			So(open, ShouldBeFalse)
+			So(r.Size(), ShouldEqual, 24)
+			So(r.NumProcessed(), ShouldEqual, 3)
+		})
+		Convey("a header that does not match the first should name its file", func() {
+			r := newReader("a\tb\n1\tx\n", "a\tc\n2\ty\n")
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			err := r.StreamDocument(true, make(chan bson.D, 2))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "part-00001.tsv: header 'a,c' does not match 'a,b', the header of part-00000.tsv")
+		})
+		Convey("fields should be generated for, or a header detected in, every file", func() {
+			r := newReader("1\tx\n", "2\ty\n")
+			So(r.GenerateFields(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"field0", "field1"})
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(1)}, {"field1", "x"}})
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(2)}, {"field1", "y"}})
+
+			r = newReader("name\tcount\nann\t1\nbob\t2\n", "name\tcount\ncid\t3\ndee\t4\n")
+			So(r.ReadAndDetectHeader(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"name", "count"})
+			docChan = make(chan bson.D, 4)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 4)
+			So(<-docChan, ShouldResemble, bson.D{{"name", "ann"}, {"count", int32(1)}})
+		})
+		Convey("the header of every file should be verified against the fields given", func() {
+			verifying := func(check HeaderCheck, contents ...string) error {
+				sources := make([]io.Reader, len(contents))
+				for i, content := range contents {
+					sources[i] = bytes.NewReader([]byte(content))
+				}
+				r, err := NewMultiFileInputReader([]string{"one.tsv", "two.tsv"}, sources, func(in io.Reader) InputReader {
+					return NewTSVInputReader(ParseAutoHeaders([]string{"a", "b"}), in, os.Stdout, 2, false)
+				})
+				So(err, ShouldBeNil)
+				if err = r.VerifyHeader(check); err != nil {
+					return err
+				}
+				docChan := make(chan bson.D, 2)
+				if err = r.StreamDocument(true, docChan); err != nil {
+					return err
+				}
+				So(len(docChan), ShouldEqual, 2)
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", "x"}})
+				return nil
+			}
+			So(verifying(HeaderCheckNames, "a\tb\n1\tx\n", "a\tb\n2\ty\n"), ShouldBeNil)
+			So(verifying(HeaderCheckCount, "a\tb\n1\tx\n", "c\td\n2\ty\n"), ShouldBeNil)
+			err := verifying(HeaderCheckNames, "a\tb\n1\tx\n", "a\tc\n2\ty\n")
+			So(err, ShouldNotBeNil)
+			So(err.(FileError).Name, ShouldEqual, "two.tsv")
+			err = verifying(HeaderCheckNames, "b\ta\n1\tx\n", "a\tb\n2\ty\n")
+			So(err, ShouldNotBeNil)
+			So(err.(FileError).Name, ShouldEqual, "one.tsv")
+		})
+		Convey("generated fields that do not match the first file's should fail", func() {
+			r := newReader("1\tx\n", "2\ty\tz\n")
+			So(r.GenerateFields(), ShouldBeNil)
+			err := r.StreamDocument(true, make(chan bson.D, 2))
+			So(err, ShouldNotBeNil)
+			So(err.(FileError).Name, ShouldEqual, "part-00001.tsv")
+		})
+		Convey("errors should name the file and the line within it", func() {
+			colSpecs := []ColumnSpec{{"a", new(FieldInt32Parser), pgStop, "int32"}}
+			r, err := NewMultiFileInputReader([]string{"one.tsv", "two.tsv"},
+				[]io.Reader{bytes.NewReader([]byte("1\n2\n")), bytes.NewReader([]byte("3\nx\n"))},
+				func(in io.Reader) InputReader {
+					return NewTSVInputReader(colSpecs, in, os.Stdout, 1, false)
+				})
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, 4)
+			err = r.StreamDocument(true, docChan)
+			So(err, ShouldHaveSameTypeAs, FileError{})
+			So(err.(FileError).Name, ShouldEqual, "two.tsv")
+			So(err.Error(), ShouldStartWith, "two.tsv: record #2 (line 2):")
+			So(len(docChan), ShouldEqual, 3)
+		})
+		Convey("a corrupt file should abort the stream, naming the file", func() {
+			var compressed bytes.Buffer
+			w := gzip.NewWriter(&compressed)
+			w.Write(bytes.Repeat([]byte("1\tx\n"), 1000))
+			w.Close()
+			r := newReader("a\tb\n1\tx\n", string(compressed.Bytes()[:compressed.Len()/2]), "a\tb\n2\ty\n")
+			err := r.StreamDocument(true, make(chan bson.D, 2000))
+			So(err, ShouldNotBeNil)
+			So(err.(FileError).Name, ShouldEqual, "part-00001.tsv")
+		})
+	})
+
+	Convey("A multi-file input reader should need a name for each of its files", t, func() {
+		_, err := NewMultiFileInputReader(nil, nil, nil)
+		So(err, ShouldNotBeNil)
+		_, err = NewMultiFileInputReader([]string{"a"}, []io.Reader{nil, nil}, nil)
+		So(err, ShouldNotBeNil)
+	})
+}
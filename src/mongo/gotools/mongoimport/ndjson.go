@@ -0,0 +1,173 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// NDJSONInputReader is an implementation of InputReader that reads
+// newline-delimited JSON, one document per line. Blank lines are skipped.
+type NDJSONInputReader struct {
+	// MaxRecordSize is the length in bytes, including its terminator, of the
+	// longest line that is read; a longer one is a RecordTooLargeError. Zero
+	// means 16MB, the largest document the server accepts.
+	MaxRecordSize int
+
+	// Encoding is the character encoding of the input, which is converted
+	// to UTF-8 as it is read, and so must be set before anything is.
+	Encoding InputEncoding
+
+	// ndjsonReader is the underlying reader used to read lines from the input source
+	ndjsonReader *bufio.Reader
+
+	// lineNumber is the number of lines read so far, including blank ones,
+	// and numRecords the number of those that are not blank
+	lineNumber uint64
+	numRecords uint64
+
+	// embedded sizeTracker exposes the Size() method to check the number of bytes read so far
+	sizeTracker
+
+	// embedded StreamOptions controls how records that fail to convert are handled
+	StreamOptions
+}
+
+// NDJSONConverter implements the Converter interface for newline-delimited
+// JSON input.
+type NDJSONConverter struct {
+	data []byte
+	line uint64
+}
+
+// NewNDJSONInputReader returns a NDJSONInputReader configured to read data
+// from the given io.Reader.
+func NewNDJSONInputReader(in io.Reader, numDecoders int) *NDJSONInputReader {
+	szCount := newSizeTrackingReader(in)
+	r := &NDJSONInputReader{
+		sizeTracker:   szCount,
+		StreamOptions: StreamOptions{numDecoders: effectiveNumDecoders(numDecoders)},
+	}
+	r.ndjsonReader = bufio.NewReader(newTextReader(szCount, &r.Encoding))
+	return r
+}
+
+// ReadAndValidateHeader is a no-op for NDJSON imports; always returns nil.
+func (r *NDJSONInputReader) ReadAndValidateHeader() error {
+	return nil
+}
+
+// ReadAndValidateTypedHeader is a no-op for NDJSON imports; always returns nil.
+func (r *NDJSONInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) error {
+	return nil
+}
+
+// Fields returns nil, as NDJSON documents have no fixed set of fields.
+func (r *NDJSONInputReader) Fields() []string {
+	return nil
+}
+
+// Progress returns a snapshot of how far StreamDocument has got, counting
+// documents as records. It is safe to call while StreamDocument is running.
+func (r *NDJSONInputReader) Progress() ReaderProgress {
+	return r.progress(r.Size())
+}
+
+// StreamDocument takes a boolean indicating if the documents should be streamed
+// in read order and a channel on which to stream the documents processed from
+// the underlying reader. Returns a non-nil error if streaming fails.
+func (r *NDJSONInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	return r.streamTo(ordered, docSink(readDocs))
+}
+
+// streamTo is StreamDocument for the documents that sink takes.
+func (r *NDJSONInputReader) streamTo(ordered bool, sink documentSink) error {
+	r.beginStream(r.Size)
+	// cancelling on return stops the read loop if decoding fails first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rawChan := make(chan Converter, r.recordBuffer())
+	// buffered so neither goroutine blocks once the other's error is returned
+	ndjsonErrChan := make(chan error, 2)
+
+	// begin reading from source
+	go func() {
+		for {
+			if r.stopped() {
+				close(rawChan)
+				ndjsonErrChan <- nil
+				return
+			}
+			line, err := readBoundedLine(r.ndjsonReader, maxRecordSize(r.MaxRecordSize))
+			if len(line) != 0 {
+				r.lineNumber++
+				if len(bytes.TrimSpace(line)) != 0 && !r.skipsRecord(r.numRecords) {
+					if err := r.handOver(ctx, rawChan, r.wrapConverter(NDJSONConverter{
+						data: line,
+						line: r.lineNumber,
+					}, len(line))); err != nil {
+						close(rawChan)
+						ndjsonErrChan <- err
+						return
+					}
+				}
+				if len(bytes.TrimSpace(line)) != 0 {
+					r.numRecords++
+				}
+			}
+			if err != nil {
+				close(rawChan)
+				if err == io.EOF {
+					ndjsonErrChan <- nil
+				} else if err == errLineTooLong {
+					ndjsonErrChan <- RecordTooLargeError{r.lineNumber + 1, maxRecordSize(r.MaxRecordSize)}
+				} else {
+					ndjsonErrChan <- fmt.Errorf("read error after line #%v: %v", r.lineNumber, err)
+				}
+				return
+			}
+		}
+	}()
+
+	// begin processing read bytes
+	go func() {
+		ndjsonErrChan <- r.streamConverted(context.Background(), ordered, rawChan, sink)
+	}()
+
+	return channelQuorumError(ndjsonErrChan, 2)
+}
+
+// Convert implements the Converter interface for NDJSON input. It converts a
+// NDJSONConverter struct to a BSON document.
+func (c NDJSONConverter) Convert() (bson.D, error) {
+	document, err := json.UnmarshalBsonD(c.data)
+	if err != nil {
+		return nil, categorizedError{FailureJSON, fmt.Errorf("error unmarshaling line #%v (%s): %v", c.line, truncateLine(c.data), err)}
+	}
+	log.Logvf(log.DebugHigh, "got line: %v", document)
+
+	bsonD, err := bsonutil.GetExtendedBsonD(document)
+	if err != nil {
+		return nil, categorizedError{FailureJSON, fmt.Errorf("error getting extended BSON for line #%v (%s): %v", c.line, truncateLine(c.data), err)}
+	}
+	log.Logvf(log.DebugHigh, "got extended line: %#v", bsonD)
+	return bsonD, nil
+}
+
+// rawRecord returns the line as read, including its line terminator.
+func (c NDJSONConverter) rawRecord() []byte {
+	return c.data
+}
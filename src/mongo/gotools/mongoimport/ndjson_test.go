@@ -0,0 +1,110 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestNDJSONStreamDocument(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a NDJSON input reader", t, func() {
+		Convey("documents should keep their key order", func() {
+			contents := "{\"b\": 1, \"a\": \"x\", \"c\": {\"z\": 2, \"y\": 3}}\n"
+			r := NewNDJSONInputReader(bytes.NewReader([]byte(contents)), 1)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{
+				{"b", int32(1)},
+				{"a", "x"},
+				{"c", bson.D{{"z", int32(2)}, {"y", int32(3)}}},
+			})
+		})
+
+		Convey("extended JSON types should be converted", func() {
+			contents := `{"_id": {"$oid": "5a934e000102030405000000"}, "t": {"$date": "2018-02-26T00:00:00Z"}}`
+			r := NewNDJSONInputReader(bytes.NewReader([]byte(contents)), 1)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			doc := <-docChan
+			So(doc[0].Value, ShouldEqual, bson.ObjectIdHex("5a934e000102030405000000"))
+			So(doc[1].Value.(time.Time).Equal(time.Date(2018, 2, 26, 0, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		})
+
+		Convey("blank lines should be skipped", func() {
+			contents := "\n{\"a\": 1}\r\n   \n\t\n{\"a\": 2}\n\n"
+			r := NewNDJSONInputReader(bytes.NewReader([]byte(contents)), 1)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(2)}})
+			So(r.lineNumber, ShouldEqual, 6)
+		})
+
+		Convey("skipped records should not count blank lines", func() {
+			contents := "{\"a\": 1}\n\n{\"a\": 2}\n{\"a\": 3}\n{\"a\": 4}\n"
+			r := NewNDJSONInputReader(bytes.NewReader([]byte(contents)), 1)
+			r.SkipRecords = 1
+			r.Skip = 1
+			r.Limit = 1
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}})
+		})
+
+		Convey("documents should be streamed in order with several decoders", func() {
+			var buf bytes.Buffer
+			for i := 0; i < 100; i++ {
+				fmt.Fprintf(&buf, "{\"a\": %d}\n", i)
+			}
+			r := NewNDJSONInputReader(&buf, 4)
+			docChan := make(chan bson.D, 100)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			for i := 0; i < 100; i++ {
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(i)}})
+			}
+		})
+
+		Convey("errors should report the line number and the offending line", func() {
+			contents := "{\"a\": 1}\n\n{\"a\": }\n"
+			r := NewNDJSONInputReader(bytes.NewReader([]byte(contents)), 1)
+			err := r.StreamDocument(true, make(chan bson.D, 2))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line #3")
+			So(err.Error(), ShouldContainSubstring, `({"a": })`)
+		})
+
+		Convey("long offending lines should be truncated in errors", func() {
+			contents := "{\"a\": \"" + strings.Repeat("x", 200) + "\""
+			r := NewNDJSONInputReader(bytes.NewReader([]byte(contents)), 1)
+			err := r.StreamDocument(true, make(chan bson.D, 1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "line #1")
+			So(err.Error(), ShouldContainSubstring, strings.Repeat("x", 73)+"...")
+			So(err.Error(), ShouldNotContainSubstring, strings.Repeat("x", 74))
+		})
+
+		Convey("lines longer than the maximum should be an error", func() {
+			contents := "{\"a\": 1}\n\n{\"a\": \"" + strings.Repeat("x", 200) + "\"}\n"
+			r := NewNDJSONInputReader(bytes.NewReader([]byte(contents)), 1)
+			r.MaxRecordSize = 100
+			docChan := make(chan bson.D, 2)
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldResemble, RecordTooLargeError{Line: 3, MaxSize: 100})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}})
+		})
+	})
+}
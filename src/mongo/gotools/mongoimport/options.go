@@ -18,25 +18,174 @@ type InputOptions struct {
 	Fields *string `long:"fields" value-name:"<field>[,<field>]*" short:"f" description:"comma separated list of fields, e.g. -f name,age"`
 
 	// FieldFile is a filename that refers to a list of fields to import, 1 per line.
-	FieldFile *string `long:"fieldFile" value-name:"<filename>" description:"file with field names - 1 per line"`
+	FieldFile *string `long:"fieldFile" value-name:"<filename>" description:"file with field names - 1 per line; blank lines and lines starting with '#' are ignored"`
+
+	// Gives the widths of the columns of fixed-width input.
+	ColumnWidths string `long:"columnWidths" value-name:"<width>[,<width>]*" description:"comma-separated widths in bytes of the columns of fixed-width input, one for each field given by --fields or --fieldFile, which are laid end to end in each line, e.g. 10,3,8 (fixedwidth only)"`
 
 	// Specifies the location and name of a file containing the data to import.
-	File string `long:"file" value-name:"<filename>" description:"file to import from; if not specified, stdin is used"`
+	File string `long:"file" value-name:"<filename>" description:"file to import from, an http or https URL to import from, or a pattern such as 'parts/*.tsv' matching several files to import in name order; if not specified, stdin is used"`
+
+	// Limits how long to wait for a URL's server, when importing from a URL.
+	URLTimeout int `long:"urlTimeout" value-name:"<seconds>" description:"when importing from a URL, the number of seconds to wait to connect, for a response, or for more of the body before failing; 0 waits forever (defaults to 30)" default:"30" default-mask:"-"`
+
+	// Retries reads of the input source that fail, such as on a network filesystem or a URL.
+	ReadRetries int `long:"readRetries" value-name:"<number>" description:"number of times to retry a read of the input source that fails, waiting a second, then twice as long each time up to 30 seconds; files and URLs are reopened from where reading stopped, and stdin is read again"`
 
 	// Treats the input source's first line as field list (csv and tsv only).
 	HeaderLine bool `long:"headerline" description:"use first line in input source as the field list (CSV and TSV only)"`
 
+	// Names the fields after their positions in the first record, which is still imported.
+	GenerateFields bool `long:"generateFields" description:"name the fields field0, field1, and so on, one for each field of the first record, which is still imported; records with more fields are handled by --longRows, which by default names the extra fields in the same way (CSV and TSV only)"`
+
+	// Guesses whether the input source's first line is a header or data.
+	DetectHeader bool `long:"detectHeader" description:"guess from the first records whether the first is a header, as when its fields are all distinct names over columns of numbers; a header names the fields unless --fields or --fieldFile do, and the first record is otherwise imported, with fields named as by --generateFields unless they are given (CSV and TSV only)"`
+
+	// Checks the input source's header line against the given fields, which are still used.
+	VerifyHeader string `long:"verifyHeader" value-name:"<check>" description:"read the first line of the input source as a header and check it against --fields or --fieldFile, failing if it does not match; 'names' requires the same field names in the same order, after any renaming and normalization, and 'count' only the same number of fields (CSV and TSV only)"`
+
+	// Discards leading lines of the input source, such as banners before the header (csv and tsv only).
+	SkipLines int `long:"skipLines" value-name:"<number>" description:"number of lines to discard from the start of the input source, before any header line (CSV and TSV only)"`
+
+	// Discards leading records, after any header line, to resume an import that stopped part way.
+	SkipRecords int `long:"skipRecords" value-name:"<number>" description:"number of records to discard, without converting them, before importing the rest; any header line is not counted, so an import that stopped after N records can be resumed with N"`
+
+	// Imports only a range of the records, after any skipped by --skipRecords.
+	Skip  int `long:"skip" value-name:"<number>" description:"number of records to discard, without converting them, after any skipped by --skipRecords; any header line is not counted"`
+	Limit int `long:"limit" value-name:"<number>" description:"most records to import, after any skipped by --skipRecords and --skip, after which the rest of the input is not read (defaults to 0, for no limit)"`
+
+	// Imports only a sample of the records, after any skipped by --skipRecords.
+	SampleEvery    int     `long:"sampleEvery" value-name:"<number>" description:"import only the first of every N records, after any skipped by --skipRecords, without converting the others; any header line is always read"`
+	SampleFraction float64 `long:"sampleFraction" value-name:"<fraction>" description:"import each record, after any skipped by --skipRecords, with this probability, greater than 0 and at most 1, without converting the others; any header line is always read"`
+	SampleSeed     int64   `long:"sampleSeed" value-name:"<seed>" description:"seed for choosing the records that --sampleFraction imports, so that imports with the same seed import the same records (defaults to 0)"`
+
+	// Converts the input source to UTF-8 from another character encoding.
+	InputEncoding string `long:"inputEncoding" value-name:"<encoding>" description:"character encoding of the input source, which is converted to UTF-8 before it is split into records: utf-8, utf-16 (big-endian unless told otherwise by a byte order mark), utf-16le, utf-16be, or a single-byte encoding such as windows-1252 or latin1; input that starts with a UTF-16 byte order mark is read as UTF-16 (defaults to utf-8)"`
+
 	// Indicates that the underlying input source contains a single JSON array with the documents to import.
 	JSONArray bool `long:"jsonArray" description:"treat input source as a JSON array"`
 
 	// Indicates how to handle type coercion failures
 	ParseGrace string `long:"parseGrace" value-name:"<grace>" default:"stop" description:"controls behavior when type coercion fails - one of: autoCast, skipField, skipRow, stop (defaults to 'stop')"`
 
+	// Indicates how to handle CSV and TSV rows with fewer or more fields than there are columns
+	ShortRows string `long:"shortRows" value-name:"<policy>" description:"controls behavior for CSV and TSV rows with fewer fields than columns - one of: error, padWithNull, which sets the missing fields to null or to their column's default, padWithEmpty, which converts the missing fields as empty ones, or padWithMissing (defaults to 'padWithMissing')"`
+	LongRows  string `long:"longRows" value-name:"<policy>" description:"controls behavior for CSV and TSV rows with more fields than columns - one of: error, truncateExtra, collectExtraIntoField (defaults to 'collectExtraIntoField')"`
+
+	// Drops the empty token that a delimiter at the end of each line leaves.
+	AllowTrailingDelimiter bool `long:"allowTrailingDelimiter" description:"drop an empty last field from CSV and TSV rows that have exactly one field more than there are columns, for input whose lines all end in a delimiter; --shortRows and --longRows apply to the fields that are left"`
+
 	// Specifies the file type to import. The default format is JSON, but it’s possible to import CSV and TSV files.
-	Type string `long:"type" value-name:"<type>" default:"json" default-mask:"-" description:"input format to import: json, csv, or tsv (defaults to 'json')"`
+	Type string `long:"type" value-name:"<type>" description:"input format to import: json, csv, tsv, ndjson, bson, or fixedwidth, or any other registered type (defaults to the type registered for the file's extension, such as .csv, or, for stdin and files without an extension, to the type detected from the start of the input, and otherwise to 'json')"`
+
+	// Specifies the string that separates fields in TSV input; defaults to a tab.
+	Delimiter string `long:"delimiter" value-name:"<delimiter>" description:"string that separates fields in TSV input, e.g. --delimiter '|' (defaults to a tab)"`
+
+	// Separates the fields of TSV input by runs of whitespace, as awk does.
+	WhitespaceDelimited bool `long:"whitespaceDelimited" description:"separate the fields of TSV input, and of its header line, by any run of spaces and tabs, ignoring any at the start and end of a line; a field can then not be empty, so a missing value can not be told from a missing field (TSV only)"`
+
+	// Guesses the delimiter of CSV or TSV input from its first few KB.
+	DetectDelimiter bool `long:"detectDelimiter" description:"guess from the first 4KB of CSV or TSV input whether its fields are separated by tabs, commas, semicolons or pipes, and fail if that can not be told with confidence (CSV and TSV only)"`
+
+	// Indicates that double-quoted TSV cells may contain delimiters, newlines, and doubled quotes.
+	QuotedFields bool `long:"quotedFields" description:"treat TSV cells that begin with a double quote as quoted fields, which may contain delimiters, newlines and doubled quotes (TSV only)"`
+
+	// Decodes backslash escapes of tabs, newlines and backslashes within TSV cells.
+	Unescape bool `long:"unescape" description:"decode the escapes \t, \n, \r and \\ within TSV cells, as written by tools that escape tabs and newlines in cells; other backslashes are kept (TSV only)"`
+
+	// Reads a single large TSV file as several byte ranges at once.
+	ReadRanges int `long:"readRanges" value-name:"<number>" description:"read a TSV file in this many byte ranges at once, to keep up with the decoding workers on very large files; documents are not imported in order, and --quotedFields and --skipRecords can not be used (TSV files only)"`
+
+	// Quoting of CSV fields that don't follow RFC 4180, or holding them to it.
+	CSVQuote      string `long:"csvQuote" value-name:"<char>" description:"character that quoted CSV fields start and end with, e.g. --csvQuote \"'\" (defaults to '\"'; CSV only)"`
+	CSVEscape     string `long:"csvEscape" value-name:"<char>" description:"character that makes the character after it part of a CSV field, e.g. --csvEscape '\\', instead of quotes being doubled within quoted fields (CSV only)"`
+	CSVLazyQuotes bool   `long:"csvLazyQuotes" description:"accept quote characters within unquoted CSV fields and unescaped ones within quoted fields (CSV only)"`
+	CSVStrict     bool   `long:"csvStrict" description:"require CSV input to follow RFC 4180 exactly, with CRLF line endings and doubled quotes, and fail on the first line that does not (CSV only)"`
+
+	// Marks TSV lines that begin with the given prefix as comments to be skipped.
+	CommentPrefix string `long:"commentPrefix" value-name:"<prefix>" description:"skip TSV records that begin with this prefix (TSV only)"`
+
+	// Specifies that records in which the comment prefix follows leading white space are comments too.
+	CommentIndented bool `long:"commentIndented" description:"also skip TSV records in which the --commentPrefix follows spaces or tabs (TSV only)"`
+
+	// Trims surrounding whitespace from each field value before it is parsed.
+	TrimWhitespace bool `long:"trimWhitespace" description:"trim leading and trailing whitespace from each field value before parsing it (CSV and TSV only)"`
+
+	// Field values that are imported as null.
+	NullTokens           string `long:"nullTokens" value-name:"<token>[,<token>]*" description:"comma-separated list of field values, such as NULL or \\N, to import as null whatever the field's type (CSV and TSV only)"`
+	NullTokensIgnoreCase bool   `long:"nullTokensIgnoreCase" description:"match --nullTokens case-insensitively"`
+
+	// Field values that boolean fields accept as true and false.
+	TrueTokens                 string `long:"trueTokens" value-name:"<token>[,<token>]*" description:"comma-separated list of values, such as Y,yes, that boolean fields accept as true; defaults to true,1 (CSV and TSV only)"`
+	FalseTokens                string `long:"falseTokens" value-name:"<token>[,<token>]*" description:"comma-separated list of values, such as N,no, that boolean fields accept as false; defaults to false,0 (CSV and TSV only)"`
+	BooleanTokensCaseSensitive bool   `long:"booleanTokensCaseSensitive" description:"match --trueTokens and --falseTokens case-sensitively"`
+
+	// Separators used by numeric field values, for locales that don't write "1,234.56".
+	DecimalSeparator   string `long:"decimalSeparator" value-name:"<separator>" description:"decimal separator used by int32, int64, double, decimal and percent fields, e.g. ',' for 1.234,56; defaults to '.' (CSV and TSV only)"`
+	ThousandsSeparator string `long:"thousandsSeparator" value-name:"<separator>" description:"thousands separator used by int32, int64, double, decimal and percent fields, e.g. '.' for 1.234,56; by default, thousands separators are not accepted (CSV and TSV only)"`
+	CurrencySymbols    string `long:"currencySymbols" value-name:"<symbol>[,<symbol>]*" description:"comma-separated list of the currency symbols that the values of double(money) and decimal(money) fields may start or end with, tried in order, e.g. 'R$,$'; defaults to $, €, £, ¥ and other common single-character symbols (CSV and TSV only)"`
+
+	// Time zones of the dates that carry no offset of their own.
+	TimeZone        string `long:"timezone" value-name:"<zone>" description:"time zone of the values of date fields that carry no offset of their own: an IANA zone name such as America/Chicago, or an offset such as +05:30; values with an offset keep it (defaults to UTC; CSV and TSV only)"`
+	ColumnTimeZones string `long:"columnTimezones" value-name:"<field>=<zone>[,<field>=<zone>]*" description:"comma-separated list of date fields with the time zone of each, overriding --timezone for those fields (CSV and TSV only)"`
+	DSTGap          string `long:"dstGap" value-name:"<policy>" description:"what to do with a date whose time does not exist in its time zone, as the clocks went forward at that time: error or shift, which moves it forward by the change, e.g. 02:30 to 03:30 (defaults to 'error'); a time that happens twice, as the clocks go back, is the earlier of the two"`
+
+	// Handles values that are not valid UTF-8 (csv and tsv only).
+	InvalidUTF8 string `long:"invalidUTF8" value-name:"<policy>" description:"what to do with a string value that is not valid UTF-8: error, replace, which replaces each run of invalid bytes with U+FFFD, or skip, which drops the record, writing it to --rejectsFile if set (defaults to 'error'; CSV and TSV only)"`
+
+	// Anonymizes the values of string fields as they are imported (csv and tsv only).
+	AnonymizeFields string `long:"anonymizeFields" value-name:"<field>=<anonymizer>[,<field>=<anonymizer>]*" description:"comma-separated list of string fields to anonymize, each with sha256, which replaces values with their hex SHA-256 hash, or sha256:<length> to keep only the first <length> digits of it, redact or redact:<text>, which replaces them with REDACTED or the text, or null, e.g. 'email=sha256:16,name=redact,phone=null'; records are then not quoted in errors and logs, but --rejectsFile still receives them as they were read (CSV and TSV only)"`
+	AnonymizeSalt   string `long:"anonymizeSalt" value-name:"<salt>" description:"string hashed before each value of the fields that --anonymizeFields hashes with sha256, so that the hashes can not be looked up without it (CSV and TSV only)"`
+
+	// Renames of fields from their names in the input.
+	RenameFields string `long:"renameFields" value-name:"<field>=<newName>[,<field>=<newName>]*" description:"comma-separated list of fields to rename, each from its name in the input to the name to import it as, e.g. 'Cust ID=customerId,city=address.city' (CSV and TSV only)"`
+
+	// Prints the fields that documents would be given, without importing anything.
+	DryRun bool `long:"dryRun" description:"validate the fields, print the names that documents would be given, and exit without importing (CSV and TSV only)"`
+
+	// Reads and converts all of the input, without importing anything.
+	Validate bool `long:"validate" description:"read and convert all of the input as an import would, applying --maxErrors, then print the records read, the documents that would be imported, the failures by category, and the sizes of the documents, and exit without connecting to a server"`
+
+	// Writes the documents to a file as extended JSON, rather than importing them.
+	ConvertTo string `long:"convertTo" value-name:"<filename>" description:"write the documents to the file as extended JSON, one per line, instead of importing them, without connecting to a server; '-' writes to stdout"`
+
+	// Writes a summary of the records read and converted as JSON.
+	SummaryFile string `long:"summaryFile" value-name:"<filename>" description:"write a summary of the import as a JSON object to the file once it ends, whether or not it succeeds: the records read, skipped and dropped, the documents converted, the failures by category, the bytes read, the elapsed seconds and the rates; '-' writes to stdout"`
+
+	// The format of the extended JSON written by ConvertTo.
+	ExtJSONFormat string `long:"extJSONFormat" value-name:"<format>" default:"relaxed" default-mask:"-" description:"the format of the extended JSON written by --convertTo: relaxed or canonical (defaults to 'relaxed')"`
+
+	// Fields that are imported, leaving out the others.
+	ProjectFields string `long:"projectFields" value-name:"<field>[,<field>]*" description:"comma-separated list of the fields to import, leaving out all others; a field also selects the fields nested under it, e.g. address selects address.city (CSV and TSV only)"`
+
+	// Fields that are left out of the import.
+	ExcludeFields string `long:"excludeFields" value-name:"<field>[,<field>]*" description:"comma-separated list of fields to leave out of the import; a field also excludes the fields nested under it, e.g. address excludes address.city (CSV and TSV only)"`
+
+	// Fields whose values are combined into the _id of each document.
+	IDFields     string `long:"idFields" value-name:"<field>[,<field>]*" description:"comma-separated list of fields whose values are combined, in order, into the _id of each document; a record with a blank value for any of them is an error (CSV and TSV only)"`
+	IDSeparator  string `long:"idSeparator" value-name:"<separator>" description:"join the --idFields values into a string _id with this separator, e.g. 'EU-1042' for '-', instead of building an embedded document of them"`
+	KeepIDFields bool   `long:"keepIdFields" description:"keep the --idFields as top-level fields, in addition to their values in the _id"`
+
+	// Disables automatic numeric type inference for fields without a type.
+	StringsOnly bool `long:"stringsOnly" description:"store fields without a type annotation as strings rather than inferring numeric types (CSV and TSV only)"`
+
+	// Infers decimal128 rather than double for integers too large for an int64.
+	BigIntegersAsDecimal bool `long:"bigIntegersAsDecimal" description:"store integers beyond the int64 range in fields without a type annotation as decimal128 rather than as doubles, which round them (CSV and TSV only)"`
+
+	// Specifies that field names containing '.' are stored as-is rather than as nested documents.
+	FlatFields bool `long:"flatFields" description:"store field names containing '.' as literal keys instead of creating nested documents (CSV and TSV only)"`
+
+	// Normalize field names before they are validated.
+	TrimFieldNames       bool `long:"trimFieldNames" description:"trim surrounding whitespace from field names (CSV and TSV only)"`
+	LowercaseFieldNames  bool `long:"lowercaseFieldNames" description:"lowercase field names (CSV and TSV only)"`
+	UnderscoreFieldNames bool `long:"underscoreFieldNames" description:"replace each run of whitespace in field names with an underscore (CSV and TSV only)"`
+
+	// Indicates that illegal characters in field names should be replaced rather than rejected.
+	SanitizeFields  bool   `long:"sanitizeFields" description:"replace a leading '$', NUL characters and empty '.'-separated parts in field names instead of failing (CSV and TSV only)"`
+	FieldSubstitute string `long:"fieldSubstitute" value-name:"<string>" description:"replacement used by --sanitizeFields (defaults to '_')"`
 
 	// Indicates that field names include type descriptions
-	ColumnsHaveTypes bool `long:"columnsHaveTypes" description:"indicated that the field list (from --fields, --fieldsFile, or --headerline) specifies types; They must be in the form of '<colName>.<type>(<arg>)'. The type can be one of: auto, binary, bool, date, date_go, date_ms, date_oracle, double, int32, int64, string. For each of the date types, the argument is a datetime layout string. For the binary type, the argument can be one of: base32, base64, hex. All other types take an empty argument. Only valid for CSV and TSV imports. e.g. zipcode.string(), thumbnail.binary(base64)"`
+	ColumnsHaveTypes bool `long:"columnsHaveTypes" description:"indicated that the field list (from --fields, --fieldsFile, or --headerline) specifies types; They must be in the form of '<colName>.<type>(<arg>)', or '<colName>.<type>' for types that take no argument; fields without a '.' are parsed automatically. The type can be one of: array, auto, binary, bool, date, date_epoch_ms, date_epoch_s, date_go, date_ms, date_oracle, decimal, double, int32, int64, json, objectid, percent, string, uuid. For each of the date types, the argument is a datetime layout string, or several separated by '|' to be tried in order; use --parseGrace autoCast to keep unparseable dates as strings. The date_epoch_s and date_epoch_ms types parse the number of seconds, which may have a fraction, or milliseconds since the Unix epoch; their optional argument is the range of years to accept, e.g. created.date_epoch_s(1990:2030), which defaults to 1900 to 2200. For the binary type, the argument is one of base32, base64, or hex, optionally followed by a colon and the binary subtype, e.g. hash.binary(hex:0x05); base64 data may use the standard or URL-safe alphabet. For the array type, the argument is an optional element type and a colon followed by the separator, e.g. tags.array(int32:;); it defaults to strings split on commas. The uuid type parses 32 hex digits, which may be hyphenated and in braces, into binary data of subtype 4; its optional argument is standard, or javaLegacy, csharpLegacy or pythonLegacy to store the bytes in that legacy driver's order as subtype 3, e.g. key.uuid(javaLegacy). The json type parses a JSON object or array, which may use extended JSON such as $date and $oid, into an embedded document or array. The double and decimal types take the optional argument money, to parse amounts such as $1,299.00, € 42 or the negative (350.00), stripping a currency symbol from --currencySymbols and ',' grouping separators, or those of --thousandsSeparator, e.g. price.decimal(money). The percent type parses values ending in '%' into doubles, divided by 100 so that 12.5% is 0.125, or with the argument number kept as 12.5, e.g. rate.percent(number). All other types take an empty argument. A field may end in '.default(<value>)' to give the value, parsed according to its type, that blank values are imported as, e.g. retries.int32().default(0). Only valid for CSV and TSV imports. e.g. zipcode.string(), thumbnail.binary(base64)"`
 }
 
 // Name returns a description of the InputOptions struct.
@@ -56,23 +205,39 @@ type IngestOptions struct {
 	MaintainInsertionOrder bool `long:"maintainInsertionOrder" description:"insert documents in the order of their appearance in the input source"`
 
 	// Sets the number of insertion routines to use
-	NumInsertionWorkers int `short:"j" value-name:"<number>" long:"numInsertionWorkers" description:"number of insert operations to run concurrently (defaults to 1)" default:"1" default-mask:"-"`
+	NumInsertionWorkers int `short:"j" value-name:"<number>" long:"numInsertionWorkers" description:"number of insert operations to run concurrently; with --mode upsert or merge, the documents with the same upsert key are all applied by the same one, in order (defaults to 1)" default:"1" default-mask:"-"`
 
 	// Forces mongoimport to halt the import operation at the first insert or upsert error.
 	StopOnError bool `long:"stopOnError" description:"stop importing at first insert/upsert error"`
 
+	// Writes records that fail to convert to the given file instead of halting the import.
+	RejectsFile string `long:"rejectsFile" value-name:"<filename>" description:"write input records that fail to convert to this file, verbatim, and continue importing; the file holds the raw input, including the values of any --anonymizeFields"`
+
+	// Sets the number of input records that may fail to convert before the import is halted.
+	MaxErrors int `long:"maxErrors" value-name:"<number>" description:"number of input records that may fail to convert before halting the import; a negative number allows any number (defaults to 0, or unlimited with --rejectsFile)" default:"0" default-mask:"-"`
+
+	// Sets the largest document, once marshalled to BSON, that is imported rather than failing to convert.
+	MaxDocumentSize int `long:"maxDocumentSize" value-name:"<bytes>" description:"largest document, in bytes of BSON, to import; a larger one fails to convert, and counts towards --maxErrors, rather than failing to insert (defaults to 16MB, the server's maximum)"`
+
+	// Logs the distribution of the sizes of the documents imported, with -v.
+	DocumentSizes       bool   `long:"documentSizes" description:"record a histogram of the sizes, in bytes of BSON, of the documents imported, with their minimum, mean, median, 95th percentile and maximum, and log it at the end of the import with -v and in the --summaryFile"`
+	DocumentSizeBuckets string `long:"documentSizeBuckets" value-name:"<bytes>[,<bytes>]*" description:"comma-separated, ascending upper bounds of the buckets of the --documentSizes histogram (defaults to 256,1024,4096 and so on, each four times the last, up to 16MB)"`
+
+	// Logs how the decoders spent their time, with the number of them to use.
+	DecoderTiming bool `long:"decoderTiming" description:"time the decoding of the input and the reading of it, and log at the end of the import whether it was bound by the input, the decoders or the inserts, with a recommendation of the number of decoders to use; also written to the --summaryFile"`
+
 	// Modify the import process.
 	// Always insert the documents if they are new (do NOT match --upsertFields).
 	// For existing documents (match --upsertFields) in the database:
 	// "insert": Insert only, skip exisiting documents.
 	// "upsert": Insert new documents or replace existing ones.
 	// "merge": Insert new documents or modify existing ones; Preserve values in the database that are not overwritten.
-	Mode string `long:"mode" choice:"insert" choice:"upsert" choice:"merge" description:"insert: insert only. upsert: insert or replace existing documents. merge: insert or modify existing documents. defaults to insert"`
+	Mode string `long:"mode" choice:"insert" choice:"upsert" choice:"merge" description:"insert: insert only. upsert: insert or replace existing documents. merge: insert or modify existing documents, setting only the fields each row has and skipping rows with only their upsert key. defaults to insert"`
 
 	Upsert bool `long:"upsert" hidden:"true" description:"(deprecated; same as --mode=upsert) insert or update objects that already exist"`
 
 	// Specifies a list of fields for the query portion of the upsert; defaults to _id field.
-	UpsertFields string `long:"upsertFields" value-name:"<field>[,<field>]*" description:"comma-separated fields for the query part when --mode is set to upsert or merge"`
+	UpsertFields string `long:"upsertFields" value-name:"<field>[,<field>]*" description:"comma-separated fields for the query part when --mode is set to upsert or merge; every document must have each of them"`
 
 	// Sets write concern level for write operations.
 	// By default mongoimport uses a write concern of 'majority'.
@@ -82,9 +247,23 @@ type IngestOptions struct {
 	// Indicates that the server should bypass document validation on import.
 	BypassDocumentValidation bool `long:"bypassDocumentValidation" description:"bypass document validation"`
 
+	// Limits the rate of the import.
+	MaxDocsPerSecond  float64 `long:"maxDocsPerSecond" value-name:"<number>" description:"insert at most this many documents a second, on average, allowing a burst of a tenth of a second's worth"`
+	MaxBytesPerSecond float64 `long:"maxBytesPerSecond" value-name:"<number>" description:"read at most this many bytes of input a second, on average, counting the records of the documents inserted"`
+
+	// Drops duplicate documents before they are inserted.
+	Dedup          bool   `long:"dedup" description:"skip each document whose --dedupFields, or whole content if none are given, match those of a document imported before it; with --maintainInsertionOrder the first is kept"`
+	DedupFields    string `long:"dedupFields" value-name:"<field>[,<field>]*" description:"comma-separated fields, in dot notation, that identify duplicates for --dedup"`
+	DedupMaxMemory int64  `long:"dedupMaxMemory" value-name:"<bytes>" description:"cap the memory used to remember the documents imported for --dedup, at about 64 bytes each; 0 means no cap"`
+	DedupOverflow  string `long:"dedupOverflow" choice:"error" choice:"bloom" description:"what to do when --dedupMaxMemory is exceeded: error fails each document beyond it, which halts the import unless --maxErrors allows for it, and bloom switches to a Bloom filter of that size, which wrongly skips about 1% of unique documents once it holds as many as it has bytes (defaults to error)"`
+
 	// Specifies the number of threads to use in processing data read from the input source
 	NumDecodingWorkers int `long:"numDecodingWorkers" default:"0" hidden:"true"`
 
+	// Tunes how records are handed to the decoding threads and reordered after them.
+	DecodingBatchSize int `long:"decodingBatchSize" value-name:"<number>" description:"hand this many consecutive records to the decoding threads at once, which helps when records are short; 0 or 1 hands over each record on its own (CSV and TSV only)"`
+	ReorderWindow     int `long:"reorderWindow" value-name:"<number>" description:"with --maintainInsertionOrder, the number of records, or --decodingBatchSize batches of them, that may be decoded ahead of the next one to be inserted (defaults to 16 for each decoding thread)"`
+
 	BulkBufferSize int `long:"batchSize" default:"1000" hidden:"true"`
 }
 
@@ -0,0 +1,117 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateBurst is the fraction of a second's worth of tokens that a bucket
+// holds, which is the burst it allows after it has been idle.
+const rateBurst = 0.1
+
+// maxThrottleWait is the longest that a decoder sleeps before it checks the
+// buckets again, so that SetRateLimit takes effect promptly.
+const maxThrottleWait = 100 * time.Millisecond
+
+// rateLimiter holds the token buckets that limit the rate of a stream, and
+// the total time that decoders have waited on them.
+type rateLimiter struct {
+	lock      sync.Mutex
+	documents tokenBucket
+	bytes     tokenBucket
+	throttled int64
+}
+
+// tokenBucket is a bucket of tokens that refills at a rate per second, up to
+// the burst. Taking more tokens than the burst puts the bucket into debt,
+// which those that take tokens next wait for.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// wait fills the bucket up to now at rate tokens a second, and returns how
+// long it would take for n tokens, or the whole burst if n is more, to be in
+// it, which is zero if they are. A rate of zero or less is no limit.
+func (b *tokenBucket) wait(rate, n float64, now time.Time) time.Duration {
+	if rate <= 0 {
+		b.last = time.Time{}
+		return 0
+	}
+	burst := rate * rateBurst
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if b.tokens += rate * now.Sub(b.last).Seconds(); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if n > burst {
+		n = burst
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / rate * float64(time.Second))
+}
+
+// take takes n tokens from the bucket, once wait has returned zero.
+func (b *tokenBucket) take(rate, n float64) {
+	if rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// SetRateLimit sets DocumentsPerSecond and BytesPerSecond, which may be done
+// while streaming to change the rate from the next document on.
+func (opts *StreamOptions) SetRateLimit(documentsPerSecond, bytesPerSecond float64) {
+	opts.limiter.lock.Lock()
+	defer opts.limiter.lock.Unlock()
+	opts.DocumentsPerSecond, opts.BytesPerSecond = documentsPerSecond, bytesPerSecond
+}
+
+// throttle waits until a document converted from a record of size bytes may
+// be streamed, under DocumentsPerSecond and BytesPerSecond, returning the
+// time it waited.
+func (opts *StreamOptions) throttle(size int64) (waited time.Duration) {
+	limiter := &opts.limiter
+	for {
+		limiter.lock.Lock()
+		now := time.Now()
+		wait := limiter.documents.wait(opts.DocumentsPerSecond, 1, now)
+		if bytesWait := limiter.bytes.wait(opts.BytesPerSecond, float64(size), now); bytesWait > wait {
+			wait = bytesWait
+		}
+		if wait == 0 {
+			limiter.documents.take(opts.DocumentsPerSecond, 1)
+			limiter.bytes.take(opts.BytesPerSecond, float64(size))
+			limiter.lock.Unlock()
+			return waited
+		}
+		limiter.lock.Unlock()
+		if wait > maxThrottleWait {
+			wait = maxThrottleWait
+		}
+		atomic.AddInt64(&limiter.throttled, int64(wait))
+		// a sleep can take longer than asked for
+		slept := time.Now()
+		time.Sleep(wait)
+		waited += time.Since(slept)
+	}
+}
+
+// Throttled returns the total time that documents have waited to be
+// streamed under DocumentsPerSecond and BytesPerSecond so far. The decoders
+// wait at once, so it can add up to more than the time streaming took.
+func (opts *StreamOptions) Throttled() time.Duration {
+	return time.Duration(atomic.LoadInt64(&opts.limiter.throttled))
+}
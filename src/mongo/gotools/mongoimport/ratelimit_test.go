@@ -0,0 +1,133 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestTokenBucket(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a token bucket", t, func() {
+		var bucket tokenBucket
+		now := time.Unix(1000, 0)
+
+		// take takes n tokens at now if they are there, returning how long
+		// to wait for them otherwise
+		take := func(rate, n float64, now time.Time) time.Duration {
+			wait := bucket.wait(rate, n, now)
+			if wait == 0 {
+				bucket.take(rate, n)
+			}
+			return wait
+		}
+
+		Convey("no rate should never wait", func() {
+			for i := 0; i < 100; i++ {
+				So(take(0, 1e9, now), ShouldEqual, 0)
+			}
+		})
+
+		Convey("a burst of a tenth of a second's worth should not wait", func() {
+			for i := 0; i < 10; i++ {
+				So(take(100, 1, now), ShouldEqual, 0)
+			}
+			Convey("and the next should wait for its token", func() {
+				So(take(100, 1, now), ShouldEqual, 10*time.Millisecond)
+				So(take(100, 1, now.Add(5*time.Millisecond)), ShouldEqual, 5*time.Millisecond)
+				So(take(100, 1, now.Add(10*time.Millisecond)), ShouldEqual, 0)
+			})
+			Convey("and the bucket should refill, but no further than the burst", func() {
+				So(take(100, 5, now.Add(50*time.Millisecond)), ShouldEqual, 0)
+				So(take(100, 10, now.Add(time.Hour)), ShouldEqual, 0)
+				So(take(100, 1, now.Add(time.Hour)), ShouldEqual, 10*time.Millisecond)
+			})
+		})
+
+		Convey("a rate of less than ten a second should still allow a burst of one", func() {
+			So(take(2, 1, now), ShouldEqual, 0)
+			So(take(2, 1, now), ShouldEqual, 500*time.Millisecond)
+		})
+
+		Convey("more than the burst should wait for the burst and go into debt", func() {
+			So(take(1000, 300, now), ShouldEqual, 0)
+			So(take(1000, 300, now), ShouldEqual, 300*time.Millisecond)
+			So(take(1000, 1, now.Add(200*time.Millisecond)), ShouldEqual, time.Millisecond)
+		})
+	})
+}
+
+func TestStreamRateLimit(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader of 50 records", t, func() {
+		colSpecs := []ColumnSpec{{"n", new(FieldAutoParser), pgAutoCast, "auto"}}
+		var lines []string
+		for i := 0; i < 50; i++ {
+			lines = append(lines, fmt.Sprint(i))
+		}
+		input := strings.Join(lines, "\n") + "\n"
+		newReader := func() *TSVInputReader {
+			return NewTSVInputReader(colSpecs, strings.NewReader(input), os.Stdout, 4, false)
+		}
+		stream := func(r *TSVInputReader) time.Duration {
+			docChan := make(chan bson.D, 50)
+			start := time.Now()
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 50)
+			return time.Since(start)
+		}
+
+		Convey("a limit of documents a second should slow streaming to it", func() {
+			r := newReader()
+			r.DocumentsPerSecond = 200
+			// all but the burst of 20 wait their turn
+			So(stream(r), ShouldBeGreaterThanOrEqualTo, 140*time.Millisecond)
+			So(r.Throttled(), ShouldBeGreaterThan, 0)
+			summary := r.Summary()
+			So(summary.Throttled, ShouldEqual, r.Throttled())
+			So(summary.DocumentsPerSecond(), ShouldBeLessThanOrEqualTo, 360)
+		})
+
+		Convey("a limit of bytes a second should count the records' input", func() {
+			r := newReader()
+			// the input is 140 bytes, of which a burst of 10 need not wait
+			r.BytesPerSecond = 100
+			So(stream(r), ShouldBeGreaterThanOrEqualTo, time.Second)
+		})
+
+		Convey("SetRateLimit should loosen the limit while streaming", func() {
+			r := newReader()
+			r.DocumentsPerSecond = 1
+			docChan := make(chan bson.D)
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- r.StreamDocument(true, docChan)
+			}()
+			<-docChan
+			start := time.Now()
+			r.SetRateLimit(0, 0)
+			for range docChan {
+			}
+			So(<-errChan, ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+
+		Convey("no limit should not throttle", func() {
+			r := newReader()
+			stream(r)
+			So(r.Throttled(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// tokensToRaw is tokensToBSON for StreamRaw: it returns the BSON of the
+// record's document, built as its tokens are converted rather than
+// marshalled from a bson.D. The fields of columns that are nested are only
+// known once every token is, so their documents are still built as a bson.D.
+// As with tokensToBSON, a record that is skipped gives a coercionError.
+func tokensToRaw(colSpecs []ColumnSpec, tokens []string, numProcessed uint64, opts *ConvertOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &ConvertOptions{}
+	}
+	if !opts.FlatFields {
+		for _, spec := range colSpecs {
+			if strings.Index(spec.Name, ".") != -1 {
+				document, err := tokensToBSON(colSpecs, tokens, numProcessed, opts)
+				if err != nil {
+					return nil, err
+				}
+				return marshalRecord(document)
+			}
+		}
+	}
+	b := &rawBuilder{buf: make([]byte, 4, 16*len(colSpecs)+5)}
+	if err := convertTokens(colSpecs, tokens, numProcessed, opts, b); err != nil {
+		return nil, err
+	}
+	return b.finish()
+}
+
+// rawBuilder is the documentBuilder of the BSON of a document without nested
+// fields. Its _id, which is only known once the other fields are, is kept
+// apart, and spliced in before them by finish.
+type rawBuilder struct {
+	buf []byte
+	id  []byte
+	err error
+}
+
+func (b *rawBuilder) add(name string, value interface{}) {
+	if b.err == nil {
+		b.buf, b.err = appendElement(b.buf, name, value)
+	}
+}
+
+func (b *rawBuilder) setID(id interface{}) {
+	if b.err == nil {
+		b.id, b.err = appendElement(nil, "_id", id)
+	}
+}
+
+// finish returns the document built, or the first error building it.
+func (b *rawBuilder) finish() ([]byte, error) {
+	if b.err != nil {
+		return nil, fmt.Errorf("error marshalling document: %v", b.err)
+	}
+	raw := b.buf
+	if b.id != nil {
+		raw = make([]byte, 4, len(b.buf)+len(b.id)+1)
+		raw = append(append(raw, b.id...), b.buf[4:]...)
+	}
+	raw = append(raw, 0)
+	binary.LittleEndian.PutUint32(raw, uint32(len(raw)))
+	return raw, nil
+}
+
+// marshalRecord marshals the document of a record, which, like the
+// documents tokensToRaw builds, is checked against the largest size allowed
+// by the stream rather than here.
+func marshalRecord(document bson.D) ([]byte, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling document: %v", err)
+	}
+	return raw, nil
+}
+
+// appendElement appends the BSON element of name and value to buf, as
+// bson.Marshal would encode it. The types that the parsers give most often
+// are encoded directly, and any other is marshalled.
+func appendElement(buf []byte, name string, value interface{}) ([]byte, error) {
+	var scratch [8]byte
+	switch v := value.(type) {
+	case string:
+		buf = appendElementName(buf, 0x02, name)
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(len(v)+1))
+		buf = append(append(append(buf, scratch[:4]...), v...), 0)
+	case int32:
+		buf = appendElementName(buf, 0x10, name)
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(v))
+		buf = append(buf, scratch[:4]...)
+	case int64:
+		buf = appendElementName(buf, 0x12, name)
+		binary.LittleEndian.PutUint64(scratch[:], uint64(v))
+		buf = append(buf, scratch[:]...)
+	case float64:
+		buf = appendElementName(buf, 0x01, name)
+		binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(v))
+		buf = append(buf, scratch[:]...)
+	case bool:
+		buf = appendElementName(buf, 0x08, name)
+		if v {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case nil:
+		buf = appendElementName(buf, 0x0A, name)
+	case time.Time:
+		// MongoDB keeps dates as milliseconds since the epoch
+		buf = appendElementName(buf, 0x09, name)
+		binary.LittleEndian.PutUint64(scratch[:], uint64(v.Unix()*1000+int64(v.Nanosecond()/1e6)))
+		buf = append(buf, scratch[:]...)
+	default:
+		if id, ok := v.(bson.ObjectId); ok && len(id) == 12 {
+			buf = appendElementName(buf, 0x07, name)
+			return append(buf, id...), nil
+		}
+		raw, err := bson.Marshal(bson.D{{Name: name, Value: value}})
+		if err != nil {
+			return nil, err
+		}
+		// the element, without the length and terminator of its document
+		buf = append(buf, raw[4:len(raw)-1]...)
+	}
+	return buf, nil
+}
+
+// appendElementName appends the type and name that start an element.
+func appendElementName(buf []byte, kind byte, name string) []byte {
+	return append(append(append(buf, kind), name...), 0)
+}
@@ -0,0 +1,160 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// sameRaw checks that tokensToRaw gives the document tokensToBSON does for
+// the tokens of the columns of headers, marshalled, and returns it.
+func sameRaw(headers []string, tokens []string, opts *ConvertOptions) []byte {
+	colSpecs, err := ParseTypedHeaders(headers, pgAutoCast)
+	So(err, ShouldBeNil)
+	if opts != nil {
+		So(opts.selectColumns(colSpecs), ShouldBeNil)
+	}
+	document, err := tokensToBSON(colSpecs, tokens, uint64(0), opts)
+	So(err, ShouldBeNil)
+	expected, err := bson.Marshal(document)
+	So(err, ShouldBeNil)
+	raw, err := tokensToRaw(colSpecs, tokens, uint64(0), opts)
+	So(err, ShouldBeNil)
+	So(raw, ShouldResemble, expected)
+	return raw
+}
+
+func TestTokensToRaw(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("The BSON built from the tokens should be the marshalled document", t, func() {
+		Convey("for each type of column", func() {
+			sameRaw([]string{"a.auto()", "b.int32()", "c.int64()", "d.double()", "e.boolean()",
+				"f.date(2006-01-02 15:04:05.000)", "g.string()", "h.decimal()", "i.objectid()", "j.binary(hex)"},
+				[]string{"1.5", "2", "3", "4.25", "true", "2017-03-04 05:06:07.089", "seven", "8.1",
+					"5a934e000102030405000000", "cafe"}, nil)
+			sameRaw([]string{"a", "b", "c"}, []string{"", "text", "false"}, nil)
+		})
+		Convey("for extra tokens and nested fields", func() {
+			sameRaw([]string{"a", "b"}, []string{"1", "2", "3", "4"}, nil)
+			sameRaw([]string{"a.b.auto()", "a.c.auto()", "d"}, []string{"1", "2", "3"}, nil)
+			sameRaw([]string{"a.b.auto()", "d"}, []string{"1", "3"}, &ConvertOptions{FlatFields: true})
+		})
+		Convey("with an _id built before the other fields", func() {
+			raw := sameRaw([]string{"region", "order_no", "qty"}, []string{"eu", "7", "2"},
+				&ConvertOptions{IDFields: []string{"region", "order_no"}, IDSeparator: "-"})
+			var document bson.D
+			So(bson.Unmarshal(raw, &document), ShouldBeNil)
+			So(document, ShouldResemble, bson.D{{"_id", "eu-7"}, {"qty", 2}})
+		})
+		Convey("with an empty document", func() {
+			So(sameRaw(nil, nil, nil), ShouldResemble, []byte{5, 0, 0, 0, 0})
+		})
+	})
+	Convey("A skipped row should give no BSON", t, func() {
+		colSpecs, err := ParseTypedHeaders([]string{"a.int32()"}, pgSkipRow)
+		So(err, ShouldBeNil)
+		raw, err := tokensToRaw(colSpecs, []string{"x"}, uint64(0), nil)
+		So(err, ShouldHaveSameTypeAs, coercionError{})
+		So(raw, ShouldBeNil)
+	})
+	Convey("With a CSV converter streamed raw", t, func() {
+		colSpecs, err := ParseTypedHeaders([]string{"a.int32()", "b"}, pgStop)
+		So(err, ShouldBeNil)
+		c := CSVConverter{colSpecs: colSpecs, data: []string{"1", "two"}, index: 0}
+
+		Convey("the document should be converted without a bson.D", func() {
+			raw, err := c.ConvertRaw()
+			So(err, ShouldBeNil)
+			expected, err := bson.Marshal(bson.D{{"a", int32(1)}, {"b", "two"}})
+			So(err, ShouldBeNil)
+			So(raw, ShouldResemble, expected)
+		})
+		Convey("a wrapped converter should size and count the document it converts", func() {
+			opts := &StreamOptions{MaxDocumentSize: 10}
+			raw, err := opts.wrapConverter(c, 6).(RawConverter).ConvertRaw()
+			So(err, ShouldNotBeNil)
+			So(ErrorCategory(err), ShouldEqual, FailureSize)
+			So(raw, ShouldBeNil)
+			opts.MaxDocumentSize = 0
+			raw, err = opts.wrapConverter(c, 6).(RawConverter).ConvertRaw()
+			So(err, ShouldBeNil)
+			So(len(raw), ShouldEqual, 23)
+			So(atomic.LoadUint64(&opts.numConverted), ShouldEqual, 1)
+		})
+		Convey("a failure should name its record", func() {
+			c.data = []string{"x", "two"}
+			_, err := c.ConvertRaw()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "'a'")
+		})
+	})
+}
+
+// benchmarkTSV is the input of the streaming benchmarks: a typed header and
+// 10,000 records.
+func benchmarkTSV() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("id.int64()\tname.string()\tprice.double()\tin_stock.boolean()\tnote.auto()\n")
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "%d\titem %d\t%d.25\ttrue\tsome text\n", i, i, i)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkStreamMarshalled streams bson.D documents and marshals each, as
+// an insert that takes BSON must.
+func BenchmarkStreamMarshalled(b *testing.B) {
+	input := benchmarkTSV()
+	for i := 0; i < b.N; i++ {
+		r := NewTSVInputReader(nil, bytes.NewReader(input), ioutil.Discard, 4, false)
+		if err := r.ReadAndValidateTypedHeader(pgStop); err != nil {
+			b.Fatal(err)
+		}
+		docs := make(chan bson.D, workerBufferSize)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- r.StreamDocument(true, docs)
+		}()
+		for document := range docs {
+			if _, err := bson.Marshal(document); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := <-errChan; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkStreamRaw streams the same documents built as BSON by StreamRaw.
+func BenchmarkStreamRaw(b *testing.B) {
+	input := benchmarkTSV()
+	for i := 0; i < b.N; i++ {
+		r := NewTSVInputReader(nil, bytes.NewReader(input), ioutil.Discard, 4, false)
+		if err := r.ReadAndValidateTypedHeader(pgStop); err != nil {
+			b.Fatal(err)
+		}
+		raw := make(chan []byte, workerBufferSize)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- StreamRaw(r, true, raw)
+		}()
+		for range raw {
+		}
+		if err := <-errChan; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
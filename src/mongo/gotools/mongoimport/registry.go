@@ -0,0 +1,344 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mongodb/mongo-tools/common/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ReaderConfig is what the constructor of an input type, registered with
+// RegisterInputReader, makes an InputReader from. The settings of the stream
+// that every reader embeds, such as MaxErrors and Transform, are set on the
+// reader it returns, so a constructor need only set those of its own.
+type ReaderConfig struct {
+	// In is the input to read, and File the name it was given by, which is
+	// empty for standard input
+	In   io.Reader
+	File string
+
+	// ColSpecs are the columns given by --fields or --fieldFile, if any, and
+	// ConvertOptions say how records are converted to documents
+	ColSpecs       []ColumnSpec
+	ConvertOptions ConvertOptions
+
+	// NumDecoders is the number of decoding goroutines asked for, IgnoreBlanks
+	// whether to leave out blank fields, and Encoding the encoding of the
+	// input
+	NumDecoders  int
+	IgnoreBlanks bool
+	Encoding     InputEncoding
+
+	// InputOptions are the options of the import, for types that take
+	// settings of their own from them, such as --jsonArray
+	InputOptions *InputOptions
+}
+
+// InputReaderConstructor makes an InputReader of a registered input type.
+type InputReaderConstructor func(cfg ReaderConfig) (InputReader, error)
+
+var inputReaders = struct {
+	sync.RWMutex
+	constructors map[string]InputReaderConstructor
+	extensions   map[string]string
+	options      map[string]map[string]bool
+}{
+	constructors: make(map[string]InputReaderConstructor),
+	extensions:   make(map[string]string),
+	options:      make(map[string]map[string]bool),
+}
+
+// FixedWidth is the input type of fixed-width input, whose columns are given
+// by --fields or --fieldFile and --columnWidths.
+const FixedWidth = "fixedwidth"
+
+// NDJSON is the input type of newline-delimited JSON, one document a line.
+const NDJSON = "ndjson"
+
+// convertingOptions are the options, named as their flags are, that every
+// input type that converts records of fields to documents accepts.
+var convertingOptions = []string{
+	"fields", "fieldFile", "columnsHaveTypes", "ignoreBlanks", "stringsOnly", "bigIntegersAsDecimal",
+	"trimWhitespace", "nullTokens", "trueTokens", "falseTokens", "renameFields", "timezone",
+	"columnTimezones", "anonymizeFields", "anonymizeSalt", "dstGap", "invalidUTF8", "dryRun",
+	"projectFields", "excludeFields", "idFields", "decimalSeparator", "thousandsSeparator",
+	"currencySymbols", "flatFields", "trimFieldNames", "lowercaseFieldNames", "underscoreFieldNames",
+	"sanitizeFields",
+}
+
+// delimitedOptions are the options that both CSV and TSV accept, besides the
+// convertingOptions.
+var delimitedOptions = []string{
+	"headerline", "generateFields", "detectHeader", "verifyHeader", "detectDelimiter",
+	"decodingBatchSize", "shortRows", "longRows", "allowTrailingDelimiter", "skipLines",
+}
+
+func init() {
+	RegisterInputReader(CSV, []string{".csv"}, newCSVReader)
+	AcceptInputOptions(CSV, convertingOptions...)
+	AcceptInputOptions(CSV, delimitedOptions...)
+	AcceptInputOptions(CSV, "csvQuote", "csvEscape", "csvLazyQuotes", "csvStrict")
+	RegisterInputReader(TSV, []string{".tsv", ".tab"}, newTSVReader)
+	AcceptInputOptions(TSV, convertingOptions...)
+	AcceptInputOptions(TSV, delimitedOptions...)
+	AcceptInputOptions(TSV, "delimiter", "whitespaceDelimited", "quotedFields", "unescape", "readRanges",
+		"commentPrefix", "commentIndented")
+	RegisterInputReader(JSON, []string{".json"}, newJSONReader)
+	RegisterInputReader(NDJSON, []string{".ndjson", ".jsonl"}, newNDJSONReader)
+	RegisterInputReader(BSON, []string{".bson"}, newBSONReader)
+	RegisterInputReader(FixedWidth, nil, newFixedWidthReader)
+	AcceptInputOptions(FixedWidth, convertingOptions...)
+	AcceptInputOptions(FixedWidth, "columnWidths")
+}
+
+// RegisterInputReader makes ctor the constructor of the input type of the
+// given name, which --type names, and of the files with any of the given
+// extensions, such as ".csv", when --type is not given, replacing any that
+// was registered for either before. Names and extensions are not case
+// sensitive.
+func RegisterInputReader(name string, extensions []string, ctor func(cfg ReaderConfig) (InputReader, error)) {
+	inputReaders.Lock()
+	defer inputReaders.Unlock()
+	name = strings.ToLower(name)
+	inputReaders.constructors[name] = ctor
+	for _, extension := range extensions {
+		if !strings.HasPrefix(extension, ".") {
+			extension = "." + extension
+		}
+		inputReaders.extensions[strings.ToLower(extension)] = name
+	}
+}
+
+// AcceptInputOptions declares that the input type of the given name accepts
+// the options of the given names, as their flags are named, such as "fields".
+// ValidateSettings refuses the options that only some input types accept,
+// such as --fields, --headerline and --csvQuote, for the types that have not
+// declared them. Names are not case sensitive.
+func AcceptInputOptions(name string, options ...string) {
+	inputReaders.Lock()
+	defer inputReaders.Unlock()
+	name = strings.ToLower(name)
+	accepted := inputReaders.options[name]
+	if accepted == nil {
+		accepted = make(map[string]bool)
+		inputReaders.options[name] = accepted
+	}
+	for _, option := range options {
+		accepted[option] = true
+	}
+}
+
+// InputTypeAccepts reports whether the input type of the given name has
+// declared, with AcceptInputOptions, that it accepts the option of the given
+// name.
+func InputTypeAccepts(name, option string) bool {
+	inputReaders.RLock()
+	defer inputReaders.RUnlock()
+	return inputReaders.options[strings.ToLower(name)][option]
+}
+
+// InputTypes returns the names of the registered input types, in order.
+func InputTypes() []string {
+	inputReaders.RLock()
+	defer inputReaders.RUnlock()
+	return inputTypes()
+}
+
+// LookupInputReader returns the name and the constructor of the input type
+// of the given name, or, if it is empty, of the one registered for the
+// extension of file, which may be a path or a URL.
+func LookupInputReader(name, file string) (string, InputReaderConstructor, error) {
+	inputReaders.RLock()
+	defer inputReaders.RUnlock()
+	name = strings.ToLower(name)
+	if name == "" {
+		extension := strings.ToLower(fileExtension(file))
+		var ok bool
+		if name, ok = inputReaders.extensions[extension]; !ok {
+			if extension == "" {
+				return "", nil, fmt.Errorf("no input type given for input without an extension; "+
+					"the registered types are %v", strings.Join(inputTypes(), ", "))
+			}
+			return "", nil, fmt.Errorf("no input type is registered for the extension '%v'; "+
+				"the registered types are %v", extension, strings.Join(inputTypes(), ", "))
+		}
+	}
+	ctor, ok := inputReaders.constructors[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown type %v; the registered types are %v",
+			name, strings.Join(inputTypes(), ", "))
+	}
+	return name, ctor, nil
+}
+
+// inputTypes is InputTypes for a caller that holds the lock.
+func inputTypes() []string {
+	names := make([]string, 0, len(inputReaders.constructors))
+	for name := range inputReaders.constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileExtension returns the extension of the last element of file, a path
+// or a URL, including its dot, or "" if it has none.
+func fileExtension(file string) string {
+	if _, ok := locationScheme(file); ok {
+		return path.Ext(urlBaseName(file))
+	}
+	return path.Ext(strings.Replace(file, "\\", "/", -1))
+}
+
+// newCSVReader is the constructor of the CSV input type.
+func newCSVReader(cfg ReaderConfig) (InputReader, error) {
+	opts := cfg.InputOptions
+	r := NewCSVInputReader(cfg.ColSpecs, cfg.In, os.Stdout, cfg.NumDecoders, cfg.IgnoreBlanks)
+	r.ConvertOptions = cfg.ConvertOptions
+	r.SkipLines = opts.SkipLines
+	// the characters were validated by ValidateSettings
+	if opts.CSVQuote != "" {
+		r.Quote, _ = utf8.DecodeRuneInString(opts.CSVQuote)
+	}
+	if opts.CSVEscape != "" {
+		r.Escape, _ = utf8.DecodeRuneInString(opts.CSVEscape)
+	}
+	r.LazyQuotes = opts.CSVLazyQuotes
+	r.Strict = opts.CSVStrict
+	r.DetectDelimiter = opts.DetectDelimiter
+	r.Encoding = cfg.Encoding
+	return r, nil
+}
+
+// newTSVReader is the constructor of the TSV input type, which reads a local
+// file in --readRanges byte ranges if there are more than one.
+func newTSVReader(cfg ReaderConfig) (InputReader, error) {
+	opts := cfg.InputOptions
+	var inputReader InputReader
+	var r *TSVInputReader
+	if opts.ReadRanges > 1 {
+		split, err := newSplitInputReader(cfg)
+		if err != nil {
+			log.Logvf(log.Always, "reading the input as one range: %v", err)
+		} else {
+			inputReader, r = split, split.TSVInputReader
+		}
+	}
+	if r == nil {
+		r = NewDelimitedInputReader(cfg.ColSpecs, cfg.In, os.Stdout, cfg.NumDecoders, cfg.IgnoreBlanks, opts.Delimiter)
+		inputReader = r
+	}
+	r.ConvertOptions = cfg.ConvertOptions
+	r.WhitespaceDelimited = opts.WhitespaceDelimited
+	r.Quoted = opts.QuotedFields
+	r.Unescape = opts.Unescape
+	r.CommentPrefix = opts.CommentPrefix
+	r.CommentIndented = opts.CommentIndented
+	r.DetectDelimiter = opts.DetectDelimiter
+	r.Encoding = cfg.Encoding
+	r.SkipLines = opts.SkipLines
+	return inputReader, nil
+}
+
+// newSplitInputReader returns a SplitTSVInputReader that reads the input,
+// which must be a local file, in --readRanges byte ranges.
+func newSplitInputReader(cfg ReaderConfig) (*SplitTSVInputReader, error) {
+	file, ok := cfg.In.(*os.File)
+	if !ok {
+		return nil, fmt.Errorf("the input is not a local file")
+	}
+	fileStat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return NewSplitTSVInputReader(cfg.ColSpecs, file, fileStat.Size(), cfg.InputOptions.ReadRanges, os.Stdout,
+		cfg.NumDecoders, cfg.IgnoreBlanks, cfg.InputOptions.Delimiter)
+}
+
+// newJSONReader is the constructor of the JSON input type.
+func newJSONReader(cfg ReaderConfig) (InputReader, error) {
+	r := NewJSONInputReader(cfg.InputOptions.JSONArray, cfg.In, cfg.NumDecoders)
+	r.Encoding = cfg.Encoding
+	return r, nil
+}
+
+// newNDJSONReader is the constructor of the NDJSON input type.
+func newNDJSONReader(cfg ReaderConfig) (InputReader, error) {
+	r := NewNDJSONInputReader(cfg.In, cfg.NumDecoders)
+	r.Encoding = cfg.Encoding
+	return r, nil
+}
+
+// newBSONReader is the constructor of the BSON input type.
+func newBSONReader(cfg ReaderConfig) (InputReader, error) {
+	return NewBSONInputReader(cfg.In, cfg.NumDecoders), nil
+}
+
+// newFixedWidthReader is the constructor of the fixed-width input type, whose
+// columns are the fields given by --fields or --fieldFile, each as wide as
+// --columnWidths says, laid end to end.
+func newFixedWidthReader(cfg ReaderConfig) (InputReader, error) {
+	// the widths were validated by ValidateSettings
+	widths, _ := ParseColumnWidths(cfg.InputOptions.ColumnWidths)
+	if len(widths) != len(cfg.ColSpecs) {
+		return nil, fmt.Errorf("--columnWidths gives %v widths for %v fields", len(widths), len(cfg.ColSpecs))
+	}
+	columns := make([]FixedWidthColumn, len(widths))
+	start := 0
+	for i, width := range widths {
+		columns[i] = FixedWidthColumn{Name: cfg.ColSpecs[i].Name, Start: start, End: start + width}
+		start += width
+	}
+	r, err := NewFixedWidthInputReader(columns, cfg.In, os.Stdout, cfg.NumDecoders, cfg.IgnoreBlanks)
+	if err != nil {
+		return nil, err
+	}
+	r.colSpecs = cfg.ColSpecs
+	r.ConvertOptions = cfg.ConvertOptions
+	r.Encoding = cfg.Encoding
+	return r, nil
+}
+
+// failedInputReader is the InputReader of an input whose constructor failed,
+// which returns the error as soon as it is read.
+type failedInputReader struct {
+	err error
+}
+
+func (r failedInputReader) StreamDocument(ordered bool, read chan bson.D) error {
+	close(read)
+	return r.err
+}
+
+func (r failedInputReader) ReadAndValidateHeader() error {
+	return r.err
+}
+
+func (r failedInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) error {
+	return r.err
+}
+
+func (r failedInputReader) Fields() []string {
+	return nil
+}
+
+func (r failedInputReader) NumProcessed() uint64 {
+	return 0
+}
+
+func (r failedInputReader) Size() int64 {
+	return 0
+}
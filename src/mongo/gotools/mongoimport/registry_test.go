@@ -0,0 +1,168 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestInputReaderRegistry(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("The built-in input types should be registered", t, func() {
+		So(InputTypes(), ShouldContain, CSV)
+		So(InputTypes(), ShouldContain, TSV)
+		So(InputTypes(), ShouldContain, JSON)
+		So(InputTypes(), ShouldContain, NDJSON)
+		So(InputTypes(), ShouldContain, FixedWidth)
+		So(InputTypes(), ShouldContain, BSON)
+		for file, name := range map[string]string{
+			"a.csv":                   CSV,
+			"dir.json/b.TSV":          TSV,
+			"c.tab":                   TSV,
+			`C:\data\d.json`:          JSON,
+			"https://host/e.csv?x=.y": CSV,
+			"f.ndjson":                NDJSON,
+			"g.jsonl":                 NDJSON,
+			"dump/h.bson":             BSON,
+		} {
+			found, ctor, err := LookupInputReader("", file)
+			So(err, ShouldBeNil)
+			So(found, ShouldEqual, name)
+			So(ctor, ShouldNotBeNil)
+		}
+	})
+	Convey("An explicit type should be looked up before the extension", t, func() {
+		name, _, err := LookupInputReader("TSV", "a.csv")
+		So(err, ShouldBeNil)
+		So(name, ShouldEqual, TSV)
+	})
+	Convey("An error should list the registered types when nothing matches", t, func() {
+		_, _, err := LookupInputReader("xml", "a.csv")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unknown type xml")
+		So(err.Error(), ShouldContainSubstring, "csv, fixedwidth, json")
+		_, _, err = LookupInputReader("", "a.txt")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "'.txt'")
+		_, _, err = LookupInputReader("", "")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("The options of each input type should be those it declares", t, func() {
+		So(InputTypeAccepts(CSV, "csvQuote"), ShouldBeTrue)
+		So(InputTypeAccepts("TSV", "headerline"), ShouldBeTrue)
+		So(InputTypeAccepts(TSV, "csvQuote"), ShouldBeFalse)
+		So(InputTypeAccepts(JSON, "fields"), ShouldBeFalse)
+		So(InputTypeAccepts(FixedWidth, "fields"), ShouldBeTrue)
+		So(InputTypeAccepts(FixedWidth, "headerline"), ShouldBeFalse)
+		So(InputTypeAccepts("unregistered", "fields"), ShouldBeFalse)
+
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.ToolOptions.Collection = "c"
+		imp.InputOptions.Type = NDJSON
+		fields := "a,b"
+		imp.InputOptions.Fields = &fields
+		err = imp.ValidateSettings(nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "can not use --fields when input type is ndjson")
+		AcceptInputOptions("Declared", "fields")
+		RegisterInputReader("declared", nil, func(cfg ReaderConfig) (InputReader, error) {
+			return NewNDJSONInputReader(cfg.In, cfg.NumDecoders), nil
+		})
+		imp.InputOptions.Type = "declared"
+		So(imp.ValidateSettings(nil), ShouldBeNil)
+		imp.InputOptions.Fields = nil
+		err = imp.ValidateSettings(nil)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "must specify --fields or --fieldFile")
+	})
+	Convey("With a fixed-width import", t, func() {
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.ToolOptions.Collection = "c"
+		imp.InputOptions.Type = FixedWidth
+		fields := "name,qty"
+		imp.InputOptions.Fields = &fields
+		imp.InputOptions.ColumnWidths = "5,2"
+
+		Convey("the fields should be sliced from each line by --columnWidths", func() {
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			r, err := imp.getInputReader(bytes.NewReader([]byte("apple12\nplums34\n")))
+			So(err, ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"name", "apple"}, {"qty", int32(12)}})
+			So(<-docChan, ShouldResemble, bson.D{{"name", "plums"}, {"qty", int32(34)}})
+		})
+		Convey("the widths should be checked", func() {
+			imp.InputOptions.ColumnWidths = ""
+			So(imp.ValidateSettings(nil), ShouldNotBeNil)
+			imp.InputOptions.ColumnWidths = "5,0"
+			So(imp.ValidateSettings(nil), ShouldNotBeNil)
+			imp.InputOptions.ColumnWidths = "5"
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			_, err := imp.getInputReader(bytes.NewReader(nil))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "1 widths for 2 fields")
+		})
+		Convey("--columnWidths should be refused for other types", func() {
+			imp.InputOptions.Type = CSV
+			err := imp.ValidateSettings(nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "--columnWidths")
+		})
+	})
+	Convey("With an input type registered by an application", t, func() {
+		var given ReaderConfig
+		RegisterInputReader("Lines", []string{"lines"}, func(cfg ReaderConfig) (InputReader, error) {
+			given = cfg
+			return NewNDJSONInputReader(cfg.In, cfg.NumDecoders), nil
+		})
+		RegisterInputReader("broken", nil, func(cfg ReaderConfig) (InputReader, error) {
+			return nil, fmt.Errorf("broken reader")
+		})
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.ToolOptions.Collection = "c"
+
+		Convey("it should be chosen by name or by extension", func() {
+			imp.InputOptions.File = "input.LINES"
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, "lines")
+			imp.InputOptions.Type = "LINES"
+			imp.InputOptions.File = "input.json"
+			So(imp.ValidateSettings(nil), ShouldBeNil)
+			So(imp.InputOptions.Type, ShouldEqual, "lines")
+		})
+		Convey("its readers should be given the options of the stream", func() {
+			imp.InputOptions.Type = "lines"
+			imp.IngestOptions.MaxErrors = 7
+			imp.InputOptions.Limit = 1
+			r, err := imp.getInputReader(bytes.NewReader([]byte("{\"a\":1}\n{\"a\":2}\n")))
+			So(err, ShouldBeNil)
+			So(given.InputOptions, ShouldEqual, imp.InputOptions)
+			lines := r.(*NDJSONInputReader)
+			So(lines.MaxErrors, ShouldEqual, 7)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			// the limit of the stream stops it after the first document
+			So(len(docChan), ShouldEqual, 1)
+			So((<-docChan)[0].Name, ShouldEqual, "a")
+		})
+		Convey("the error of its constructor should be returned", func() {
+			imp.InputOptions.Type = "broken"
+			_, err := imp.getInputReader(bytes.NewReader(nil))
+			So(err.Error(), ShouldEqual, "broken reader")
+		})
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"io"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// RetryPolicy says which errors reading an input are retried, and how often.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a failed read is retried before its
+	// error is returned. It counts the retries since the last read that
+	// succeeded, so each failure gets as many.
+	MaxRetries int
+
+	// Backoff is the time waited before the first retry of a failure, which
+	// doubles for each retry after it, up to MaxBackoff if that is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable reports whether a read that failed with err is retried. If
+	// it is nil, every error other than io.EOF is.
+	Retryable func(err error) bool
+}
+
+// retryable reports whether the policy retries a read that failed with err.
+func (p RetryPolicy) retryable(err error) bool {
+	if err == io.EOF {
+		return false
+	}
+	if p.Retryable == nil {
+		return true
+	}
+	return p.Retryable(err)
+}
+
+// backoff returns the time to wait before the given retry, counting from one.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// RetryingReader is an io.ReadCloser that retries the reads of its source
+// that fail, as its RetryPolicy says. If it was given a SourceResumer, each
+// retry reopens the source from the offset of the bytes read so far, so a
+// broken connection or file handle is replaced; otherwise, as for a pipe,
+// the failed Read is simply called again. Bytes are only counted once they
+// are returned, so the readers above it count nothing twice.
+type RetryingReader struct {
+	source   io.Reader
+	policy   RetryPolicy
+	location string
+	config   SourceConfig
+	resume   SourceResumer
+	offset   int64
+
+	// sleep waits between retries; tests replace it
+	sleep func(time.Duration)
+}
+
+// NewRetryingReader returns a RetryingReader that reads source, the input at
+// location opened with cfg, retrying failed reads as policy says. Resume, if
+// it is not nil, reopens the input with cfg for each retry, unless source has
+// a Resumable method that returns false.
+func NewRetryingReader(source io.Reader, location string, cfg SourceConfig, policy RetryPolicy, resume SourceResumer) *RetryingReader {
+	if resumable, ok := source.(interface {
+		Resumable() bool
+	}); ok && !resumable.Resumable() {
+		resume = nil
+	}
+	return &RetryingReader{
+		source:   source,
+		policy:   policy,
+		location: location,
+		config:   cfg,
+		resume:   resume,
+		sleep:    time.Sleep,
+	}
+}
+
+// Read reads from the source, retrying a read that fails without returning
+// any bytes. A read that returns bytes along with an error returns only the
+// bytes, so the error is met, and retried, by the next Read.
+func (r *RetryingReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	for retry := 1; n == 0 && err != nil && r.policy.retryable(err) && retry <= r.policy.MaxRetries; retry++ {
+		wait := r.policy.backoff(retry)
+		log.Logvf(log.Always, "error reading input after %v bytes, retrying in %v (%v of %v): %v",
+			r.offset, wait, retry, r.policy.MaxRetries, err)
+		r.sleep(wait)
+		if r.resume != nil {
+			var source io.ReadCloser
+			if source, _, err = r.resume(r.location, r.offset, r.config); err != nil {
+				continue
+			}
+			r.closeSource()
+			r.source = source
+		}
+		n, err = r.source.Read(p)
+	}
+	r.offset += int64(n)
+	if n > 0 && err != nil && err != io.EOF && r.policy.retryable(err) {
+		err = nil
+	}
+	return n, err
+}
+
+// Close closes the source that is being read, if it is an io.Closer.
+func (r *RetryingReader) Close() error {
+	return r.closeSource()
+}
+
+func (r *RetryingReader) closeSource() error {
+	if closer, ok := r.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var errTransient = errors.New("transient read error")
+
+// flakyReader reads data a few bytes at a time, failing every other read
+// until it has failed failures times, or, once it has read failAfter bytes,
+// failing every read.
+type flakyReader struct {
+	data      []byte
+	failures  int
+	failAfter int
+	read      int
+	failed    bool
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if r.failAfter > 0 && r.read >= r.failAfter {
+		return 0, errTransient
+	}
+	if r.failures > 0 && !r.failed {
+		r.failures--
+		r.failed = true
+		return 0, errTransient
+	}
+	r.failed = false
+	if r.read == len(r.data) {
+		return 0, io.EOF
+	}
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	n := copy(p, r.data[r.read:])
+	r.read += n
+	return n, nil
+}
+
+func TestRetryingReader(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a retrying reader", t, func() {
+		data := []byte("a\tb\n1\t2\n3\t4\n5\t6\n")
+		var waits []time.Duration
+		newReader := func(source io.Reader, policy RetryPolicy, resume SourceResumer) *RetryingReader {
+			r := NewRetryingReader(source, "input", SourceConfig{}, policy, resume)
+			r.sleep = func(wait time.Duration) { waits = append(waits, wait) }
+			return r
+		}
+
+		Convey("failed reads of a pipe should be retried by reading again", func() {
+			r := newReader(&flakyReader{data: data, failures: 4}, RetryPolicy{MaxRetries: 1, Backoff: time.Millisecond}, nil)
+			read, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, data)
+			So(len(waits), ShouldEqual, 4)
+		})
+		Convey("the error should be returned once the retries run out", func() {
+			r := newReader(&flakyReader{data: data, failAfter: 5}, RetryPolicy{MaxRetries: 3, Backoff: time.Second}, nil)
+			read, err := ioutil.ReadAll(r)
+			So(err, ShouldEqual, errTransient)
+			So(read, ShouldResemble, data[:6])
+			So(waits, ShouldResemble, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second})
+		})
+		Convey("waits should be no longer than MaxBackoff", func() {
+			policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 3 * time.Second}
+			So(policy.backoff(1), ShouldEqual, time.Second)
+			So(policy.backoff(2), ShouldEqual, 2*time.Second)
+			So(policy.backoff(5), ShouldEqual, 3*time.Second)
+		})
+		Convey("errors that are not retryable should be returned at once", func() {
+			policy := RetryPolicy{MaxRetries: 3, Retryable: func(err error) bool { return err != errTransient }}
+			_, err := ioutil.ReadAll(newReader(&flakyReader{data: data, failures: 1}, policy, nil))
+			So(err, ShouldEqual, errTransient)
+			So(waits, ShouldBeEmpty)
+		})
+		Convey("a resumable source should be reopened from the bytes read so far", func() {
+			var offsets []int64
+			resume := func(location string, offset int64, cfg SourceConfig) (io.ReadCloser, int64, error) {
+				So(location, ShouldEqual, "input")
+				offsets = append(offsets, offset)
+				return ioutil.NopCloser(&flakyReader{data: data[offset:], failAfter: 7}), -1, nil
+			}
+			r := newReader(&flakyReader{data: data, failAfter: 7}, RetryPolicy{MaxRetries: 1}, resume)
+			read, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, data)
+			So(offsets, ShouldResemble, []int64{9, 16})
+		})
+		Convey("records should not be counted twice", func() {
+			r := newReader(&flakyReader{data: data, failures: 6}, RetryPolicy{MaxRetries: 1}, nil)
+			tsv := NewTSVInputReader(nil, r, os.Stdout, 1, false)
+			So(tsv.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 3)
+			So(tsv.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 3)
+			So(tsv.Size(), ShouldEqual, len(data))
+			So(tsv.Progress().RecordsRead, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestResumeURL(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a server of a file", t, func() {
+		contents := "a\n1\n2\n3\n"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/norange" {
+				w.Write([]byte(contents))
+				return
+			}
+			http.ServeContent(w, req, "data.tsv", time.Time{}, strings.NewReader(contents))
+		}))
+		defer server.Close()
+
+		Convey("a URL should be resumed from an offset", func() {
+			body, size, err := ResumeURL(server.URL+"/data.tsv", 4, time.Second)
+			So(err, ShouldBeNil)
+			defer body.Close()
+			So(size, ShouldEqual, len(contents)-4)
+			read, err := ioutil.ReadAll(body)
+			So(err, ShouldBeNil)
+			So(string(read), ShouldEqual, contents[4:])
+			So(body.(*urlBody).Resumable(), ShouldBeTrue)
+		})
+		Convey("a server that ignores the range should fail", func() {
+			_, _, err := ResumeURL(server.URL+"/norange", 4, time.Second)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "200")
+		})
+	})
+	Convey("A local file should be resumed from an offset", t, func() {
+		file, err := ioutil.TempFile("", "resume")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		file.Write([]byte("0123456789"))
+		file.Close()
+		source, size, err := resumeFile(file.Name(), 6, SourceConfig{})
+		So(err, ShouldBeNil)
+		defer source.Close()
+		So(size, ShouldEqual, 4)
+		read, err := ioutil.ReadAll(source)
+		So(err, ShouldBeNil)
+		So(read, ShouldResemble, []byte("6789"))
+	})
+}
@@ -0,0 +1,100 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RoutedDocument is a document streamed by StreamRouted, with the route that
+// the reader's Route gave it.
+type RoutedDocument struct {
+	Route string
+	Doc   bson.D
+}
+
+// StreamRouted is like r.StreamDocument, but streams each document to read
+// along with its route, as given by the Route of the reader's StreamOptions,
+// or "" if Route is not set. Routes are given on the reader's decoding
+// goroutines, and a document whose Route fails is handled as any other
+// conversion failure. If ordered, the documents are streamed in the order
+// they were read, and so are those of each route. read is closed once
+// streaming is done. It returns an error, and closes read, for a reader
+// without decoding goroutines of its own, such as a MultiFileInputReader,
+// as there is no Route to route its documents by.
+func StreamRouted(r InputReader, ordered bool, read chan RoutedDocument) error {
+	streamer, ok := r.(sinkStreamer)
+	if !ok {
+		close(read)
+		return fmt.Errorf("can not route the documents of a %T", r)
+	}
+	return streamer.streamTo(ordered, routedSink(read))
+}
+
+// routedSink is the documentSink of StreamRouted.
+type routedSink chan RoutedDocument
+
+func (s routedSink) convert(c Converter) (interface{}, error) {
+	document, err := convertRouted(c)
+	if err != nil || document.Doc == nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (s routedSink) send(document interface{}, dying, done <-chan struct{}) bool {
+	select {
+	case s <- document.(RoutedDocument):
+		return true
+	default:
+	}
+	select {
+	case s <- document.(RoutedDocument):
+		return true
+	case <-dying:
+	case <-done:
+	}
+	return false
+}
+
+func (s routedSink) close() {
+	close(s)
+}
+
+// countRoute counts a document being streamed on route.
+func (opts *StreamOptions) countRoute(route string) {
+	opts.routeLock.Lock()
+	defer opts.routeLock.Unlock()
+	if opts.routeCounts == nil {
+		opts.routeCounts = make(map[string]uint64)
+	}
+	opts.routeCounts[route]++
+}
+
+// Routes returns the number of documents streamed so far on each route, if
+// Route is set.
+func (opts *StreamOptions) Routes() map[string]uint64 {
+	opts.routeLock.Lock()
+	defer opts.routeLock.Unlock()
+	routes := make(map[string]uint64, len(opts.routeCounts))
+	for route, count := range opts.routeCounts {
+		routes[route] = count
+	}
+	return routes
+}
+
+// convertRouted converts c with its route if it is one of a stream's
+// Converters, and otherwise with no route.
+func convertRouted(c Converter) (RoutedDocument, error) {
+	if rc, ok := c.(rejectingConverter); ok {
+		return rc.convertRouted()
+	}
+	document, err := c.Convert()
+	return RoutedDocument{Doc: document}, err
+}
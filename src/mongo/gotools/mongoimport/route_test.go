@@ -0,0 +1,142 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestStreamRouted(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that routes documents by their type", t, func() {
+		colSpecs := []ColumnSpec{
+			{"type", new(FieldStringParser), pgAutoCast, "string"},
+			{"n", new(FieldInt32Parser), pgStop, "int32"},
+		}
+		types := []string{"order", "refund", "adjustment"}
+		var lines []string
+		for i := 0; i < 90; i++ {
+			lines = append(lines, fmt.Sprintf("%v\t%v", types[i%3], i))
+		}
+		contents := strings.Join(lines, "\n") + "\n"
+		newReader := func(contents string) *TSVInputReader {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), nil, 4, false)
+			r.Route = func(doc bson.D) (string, error) {
+				route, _ := doc[0].Value.(string)
+				if route == "" {
+					return "", fmt.Errorf("no type")
+				}
+				// route the earlier records slower, so that they would be
+				// streamed last if nothing kept them in order
+				if n := doc[1].Value.(int32); n < 30 && n%4 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				return route, nil
+			}
+			return r
+		}
+		stream := func(r InputReader, ordered bool) ([]RoutedDocument, error) {
+			read := make(chan RoutedDocument, 100)
+			err := StreamRouted(r, ordered, read)
+			var docs []RoutedDocument
+			for doc := range read {
+				docs = append(docs, doc)
+			}
+			return docs, err
+		}
+
+		Convey("streaming in order should tag each document and keep the order of each route", func() {
+			for _, batchSize := range []int{0, 7} {
+				r := newReader(contents)
+				r.BatchSize = batchSize
+				docs, err := stream(r, true)
+				So(err, ShouldBeNil)
+				So(len(docs), ShouldEqual, 90)
+				last := map[string]int32{}
+				for _, doc := range docs {
+					So(doc.Route, ShouldEqual, doc.Doc[0].Value)
+					n, ok := last[doc.Route]
+					So(!ok || doc.Doc[1].Value.(int32) > n, ShouldBeTrue)
+					last[doc.Route] = doc.Doc[1].Value.(int32)
+				}
+				So(r.Summary().Routes, ShouldResemble, map[string]uint64{"order": 30, "refund": 30, "adjustment": 30})
+			}
+		})
+
+		Convey("streaming out of order should tag each document", func() {
+			for _, batchSize := range []int{0, 7} {
+				r := newReader(contents)
+				r.BatchSize = batchSize
+				docs, err := stream(r, false)
+				So(err, ShouldBeNil)
+				So(len(docs), ShouldEqual, 90)
+				for _, doc := range docs {
+					So(doc.Route, ShouldEqual, doc.Doc[0].Value)
+				}
+			}
+		})
+
+		Convey("a document that fails to route should be handled like a conversion failure", func() {
+			r := newReader("order\t1\n\t2\nrefund\t3\n")
+			_, err := stream(r, true)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "route failed: no type")
+
+			r = newReader("order\t1\n\t2\nrefund\t3\n")
+			r.MaxErrors = 1
+			docs, err := stream(r, true)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []RoutedDocument{
+				{"order", bson.D{{"type", "order"}, {"n", int32(1)}}},
+				{"refund", bson.D{{"type", "refund"}, {"n", int32(3)}}},
+			})
+			summary := r.Summary()
+			So(summary.Failures, ShouldResemble, map[FailureCategory]uint64{FailureRoute: 1})
+			So(summary.Routes, ShouldResemble, map[string]uint64{"order": 1, "refund": 1})
+		})
+
+		Convey("documents that Transform drops should not be routed", func() {
+			r := newReader("order\t1\nrefund\t2\n")
+			r.Transform = func(doc bson.D) (bson.D, error) {
+				if doc[0].Value == "refund" {
+					return nil, nil
+				}
+				return doc, nil
+			}
+			docs, err := stream(r, false)
+			So(err, ShouldBeNil)
+			So(len(docs), ShouldEqual, 1)
+			So(r.Summary().Routes, ShouldResemble, map[string]uint64{"order": 1})
+		})
+
+		Convey("without a Route, documents should be streamed with no route", func() {
+			r := newReader("order\t1\n")
+			r.Route = nil
+			docs, err := stream(r, true)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, []RoutedDocument{{"", bson.D{{"type", "order"}, {"n", int32(1)}}}})
+			So(r.Summary().Routes, ShouldBeEmpty)
+		})
+
+		Convey("a reader without stream options of its own should fail", func() {
+			r, err := NewMultiFileInputReader([]string{"a.tsv"}, []io.Reader{bytes.NewReader([]byte(contents))},
+				func(in io.Reader) InputReader { return NewTSVInputReader(colSpecs, in, nil, 1, false) })
+			So(err, ShouldBeNil)
+			_, err = stream(r, true)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,28 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"math/rand"
+)
+
+// samples reports whether sampling selects the record at index, counting
+// from zero after the SkipRecords and Skip. It is only called from the goroutine
+// reading the input, in the order of the records, so that the records a
+// SampleSeed selects are the same on every run.
+func (opts *StreamOptions) samples(index uint64) bool {
+	switch {
+	case opts.SampleEvery > 1:
+		return index%opts.SampleEvery == 0
+	case opts.SampleFraction > 0 && opts.SampleFraction < 1:
+		if opts.sampler == nil {
+			opts.sampler = rand.New(rand.NewSource(opts.SampleSeed))
+		}
+		return opts.sampler.Float64() < opts.SampleFraction
+	}
+	return true
+}
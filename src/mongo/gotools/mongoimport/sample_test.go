@@ -0,0 +1,80 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSampling(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that samples its records", t, func() {
+		var buf bytes.Buffer
+		buf.WriteString("a.int32()\n")
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(&buf, "%d\n", i)
+		}
+		newReader := func() *TSVInputReader {
+			r := NewTSVInputReader(nil, bytes.NewReader(buf.Bytes()), os.Stdout, 2, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			return r
+		}
+		sampled := func(r *TSVInputReader) []int32 {
+			docChan := make(chan bson.D, 100)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			var values []int32
+			for doc := range docChan {
+				values = append(values, doc[0].Value.(int32))
+			}
+			return values
+		}
+
+		Convey("every Nth record after the skipped ones should be selected", func() {
+			r := newReader()
+			r.SkipRecords = 5
+			r.SampleEvery = 30
+			So(sampled(r), ShouldResemble, []int32{5, 35, 65, 95})
+			So(r.NumProcessed(), ShouldEqual, 100)
+			summary := r.Summary()
+			So(summary.RecordsSkipped, ShouldEqual, 5)
+			So(summary.RecordsSeen, ShouldEqual, 95)
+			So(summary.RecordsSelected, ShouldEqual, 4)
+			So(summary.Documents, ShouldEqual, 4)
+		})
+		Convey("the same seed should select the same fraction of records", func() {
+			first, second, other := newReader(), newReader(), newReader()
+			first.SampleFraction, first.SampleSeed = 0.2, 7
+			second.SampleFraction, second.SampleSeed = 0.2, 7
+			other.SampleFraction, other.SampleSeed = 0.2, 8
+			values := sampled(first)
+			So(len(values), ShouldBeBetween, 5, 40)
+			So(sampled(second), ShouldResemble, values)
+			So(sampled(other), ShouldNotResemble, values)
+			So(first.Summary().RecordsSeen, ShouldEqual, 100)
+			So(first.Summary().RecordsSelected, ShouldEqual, len(values))
+		})
+		Convey("records left out should not be converted", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte("a.int32()\n1\nx\n3\ny\n")), os.Stdout, 1, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			r.SampleEvery = 2
+			So(sampled(r), ShouldResemble, []int32{1, 3})
+		})
+		Convey("without sampling every record should be both seen and selected", func() {
+			r := newReader()
+			So(len(sampled(r)), ShouldEqual, 100)
+			So(r.Summary().RecordsSeen, ShouldEqual, 100)
+			So(r.Summary().RecordsSelected, ShouldEqual, 100)
+		})
+	})
+}
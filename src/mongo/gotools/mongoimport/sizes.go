@@ -0,0 +1,195 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// DefaultSizeBuckets are the upper bounds, in bytes, of the buckets of a
+// SizeHistogram if StreamOptions.SizeBuckets is not set: from 256 bytes to
+// the 16MB that the server accepts, each four times the last.
+var DefaultSizeBuckets = []int{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// sizeShards is the number of shares that a sizeRecorder splits its counts
+// into, so that decoding goroutines seldom count into the same one at once.
+const sizeShards = 16
+
+// SizeHistogram is the distribution of the sizes, marshalled to BSON, of the
+// documents streamed. Counts[i] is the number of documents of more than
+// Buckets[i-1] bytes, but no more than Buckets[i]; the last of the Counts,
+// of which there is one more than there are Buckets, is of those larger than
+// every bucket. P50 and P95, the median and 95th percentile, are estimated
+// as the upper bound of the bucket they fall in, but no more than Max.
+type SizeHistogram struct {
+	Buckets []int    `json:"buckets"`
+	Counts  []uint64 `json:"counts"`
+
+	Documents uint64  `json:"documents"`
+	Min       int     `json:"min"`
+	Mean      float64 `json:"mean"`
+	P50       int     `json:"p50"`
+	P95       int     `json:"p95"`
+	Max       int     `json:"max"`
+}
+
+// ValidateSizeBuckets checks that buckets, the upper bounds of the buckets of
+// a SizeHistogram, are positive and ascending.
+func ValidateSizeBuckets(buckets []int) error {
+	for i, bound := range buckets {
+		if bound <= 0 {
+			return fmt.Errorf("size bucket %v is not positive", bound)
+		}
+		if i > 0 && bound <= buckets[i-1] {
+			return fmt.Errorf("size buckets must be ascending, but %v follows %v", bound, buckets[i-1])
+		}
+	}
+	return nil
+}
+
+// sizeShard is one share of the counts of a sizeRecorder, padded so that no
+// two shards share a cache line. Every count is kept atomically.
+type sizeShard struct {
+	documents uint64
+	total     uint64
+	min       int64
+	max       int64
+	counts    []uint64
+	_         [64]byte
+}
+
+// sizeRecorder counts the sizes of documents into a SizeHistogram, across
+// sizeShards shards, which are only merged when it is read.
+type sizeRecorder struct {
+	buckets []int
+	shards  [sizeShards]sizeShard
+}
+
+// newSizeRecorder returns a sizeRecorder with the given bucket bounds, or
+// the DefaultSizeBuckets if there are none.
+func newSizeRecorder(buckets []int) *sizeRecorder {
+	if len(buckets) == 0 {
+		buckets = DefaultSizeBuckets
+	}
+	r := &sizeRecorder{buckets: append([]int(nil), buckets...)}
+	for i := range r.shards {
+		r.shards[i].counts = make([]uint64, len(buckets)+1)
+		r.shards[i].min = math.MaxInt64
+	}
+	return r
+}
+
+// record counts a document of size bytes, the index-th record read, into
+// the shard of its index.
+func (r *sizeRecorder) record(index uint64, size int) {
+	shard := &r.shards[index%sizeShards]
+	atomic.AddUint64(&shard.counts[sort.SearchInts(r.buckets, size)], 1)
+	atomic.AddUint64(&shard.documents, 1)
+	atomic.AddUint64(&shard.total, uint64(size))
+	for min := atomic.LoadInt64(&shard.min); int64(size) < min; min = atomic.LoadInt64(&shard.min) {
+		if atomic.CompareAndSwapInt64(&shard.min, min, int64(size)) {
+			break
+		}
+	}
+	for max := atomic.LoadInt64(&shard.max); int64(size) > max; max = atomic.LoadInt64(&shard.max) {
+		if atomic.CompareAndSwapInt64(&shard.max, max, int64(size)) {
+			break
+		}
+	}
+}
+
+// histogram merges the shards into a SizeHistogram of the sizes recorded so
+// far.
+func (r *sizeRecorder) histogram() *SizeHistogram {
+	h := &SizeHistogram{
+		Buckets: append([]int(nil), r.buckets...),
+		Counts:  make([]uint64, len(r.buckets)+1),
+	}
+	var total uint64
+	min := int64(math.MaxInt64)
+	for i := range r.shards {
+		shard := &r.shards[i]
+		for bucket := range h.Counts {
+			h.Counts[bucket] += atomic.LoadUint64(&shard.counts[bucket])
+		}
+		h.Documents += atomic.LoadUint64(&shard.documents)
+		total += atomic.LoadUint64(&shard.total)
+		if shardMin := atomic.LoadInt64(&shard.min); shardMin < min {
+			min = shardMin
+		}
+		if shardMax := int(atomic.LoadInt64(&shard.max)); shardMax > h.Max {
+			h.Max = shardMax
+		}
+	}
+	if h.Documents == 0 {
+		return h
+	}
+	h.Min = int(min)
+	h.Mean = float64(total) / float64(h.Documents)
+	h.P50 = h.percentile(0.5)
+	h.P95 = h.percentile(0.95)
+	return h
+}
+
+// percentile estimates the size that q of the documents are no larger than,
+// as the upper bound of the bucket it falls in, but no more than Max.
+func (h *SizeHistogram) percentile(q float64) int {
+	target := uint64(math.Ceil(q * float64(h.Documents)))
+	var seen uint64
+	for bucket, count := range h.Counts {
+		if seen += count; seen >= target && bucket < len(h.Buckets) {
+			if bound := h.Buckets[bucket]; bound < h.Max {
+				return bound
+			}
+			break
+		}
+	}
+	return h.Max
+}
+
+// sizeRecorder returns the recorder of the sizes of the documents streamed,
+// making it the first time it is called, or nil if DocumentSizes is not set.
+func (opts *StreamOptions) sizeRecorder() *sizeRecorder {
+	if !opts.DocumentSizes {
+		return nil
+	}
+	opts.sizesOnce.Do(func() { opts.sizes = newSizeRecorder(opts.SizeBuckets) })
+	return opts.sizes
+}
+
+// documentSizes returns the histogram of the sizes of the documents streamed
+// so far, or nil if DocumentSizes is not set.
+func (opts *StreamOptions) documentSizes() *SizeHistogram {
+	if sizes := opts.sizeRecorder(); sizes != nil {
+		return sizes.histogram()
+	}
+	return nil
+}
+
+// logDocumentSizes logs a summary of sizes, and the count in each of its
+// buckets, at the Info verbosity.
+func logDocumentSizes(sizes *SizeHistogram) {
+	if sizes == nil || !log.IsInVerbosity(log.Info) {
+		return
+	}
+	log.Logvf(log.Info, "document sizes: %v document(s), min %v, mean %.0f, p50 %v, p95 %v, max %v bytes",
+		sizes.Documents, sizes.Min, sizes.Mean, sizes.P50, sizes.P95, sizes.Max)
+	low := 0
+	for bucket, count := range sizes.Counts {
+		if bucket < len(sizes.Buckets) {
+			log.Logvf(log.Info, "\t%v-%v bytes: %v", low, sizes.Buckets[bucket], count)
+			low = sizes.Buckets[bucket] + 1
+		} else {
+			log.Logvf(log.Info, "\t%v bytes or more: %v", low, count)
+		}
+	}
+}
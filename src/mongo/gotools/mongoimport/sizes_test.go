@@ -0,0 +1,92 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDocumentSizes(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that records the sizes of its documents", t, func() {
+		var buf bytes.Buffer
+		buf.WriteString("s.string()\n")
+		for i := 0; i < 100; i++ {
+			// 90 documents of 14 bytes, and 10 larger ones
+			if i%10 == 9 {
+				buf.WriteString(strings.Repeat("x", 100) + "\n")
+			} else {
+				buf.WriteString("x\n")
+			}
+		}
+		small, _ := bson.Marshal(bson.D{{"s", "x"}})
+		large, _ := bson.Marshal(bson.D{{"s", strings.Repeat("x", 100)}})
+		newReader := func() *TSVInputReader {
+			r := NewTSVInputReader(nil, bytes.NewReader(buf.Bytes()), os.Stdout, 4, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			r.DocumentSizes = true
+			return r
+		}
+		stream := func(r *TSVInputReader) {
+			docChan := make(chan bson.D, 100)
+			So(r.StreamDocument(false, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 100)
+		}
+
+		Convey("the summary should have the histogram and the statistics of the sizes", func() {
+			r := newReader()
+			r.SizeBuckets = []int{16, 64, 128}
+			stream(r)
+			sizes := r.Summary().DocumentSizes
+			So(sizes, ShouldNotBeNil)
+			So(sizes.Buckets, ShouldResemble, []int{16, 64, 128})
+			So(sizes.Counts, ShouldResemble, []uint64{90, 0, 10, 0})
+			So(sizes.Documents, ShouldEqual, 100)
+			So(sizes.Min, ShouldEqual, len(small))
+			So(sizes.Max, ShouldEqual, len(large))
+			So(sizes.Mean, ShouldAlmostEqual, float64(90*len(small)+10*len(large))/100, 1e-9)
+			So(sizes.P50, ShouldEqual, 16)
+			So(sizes.P95, ShouldEqual, len(large))
+			So(r.Progress().DocumentSizes, ShouldResemble, sizes)
+		})
+		Convey("the default buckets should be used if none are given", func() {
+			r := newReader()
+			stream(r)
+			sizes := r.Summary().DocumentSizes
+			So(sizes.Buckets, ShouldResemble, DefaultSizeBuckets)
+			So(sizes.Counts[0], ShouldEqual, 100)
+			So(sizes.P95, ShouldEqual, len(large))
+		})
+		Convey("sizes should also be recorded when they are not checked", func() {
+			r := newReader()
+			r.MaxDocumentSize = -1
+			stream(r)
+			So(r.Summary().DocumentSizes.Documents, ShouldEqual, 100)
+		})
+		Convey("nothing should be recorded unless they are asked for", func() {
+			r := newReader()
+			r.DocumentSizes = false
+			stream(r)
+			So(r.Summary().DocumentSizes, ShouldBeNil)
+			So(r.Progress().DocumentSizes, ShouldBeNil)
+		})
+	})
+	Convey("Size buckets must be positive and ascending", t, func() {
+		So(ValidateSizeBuckets([]int{1, 10, 100}), ShouldBeNil)
+		So(ValidateSizeBuckets(nil), ShouldBeNil)
+		So(ValidateSizeBuckets([]int{0, 10}), ShouldNotBeNil)
+		So(ValidateSizeBuckets([]int{10, 10}), ShouldNotBeNil)
+		So(ValidateSizeBuckets([]int{100, 10}), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// sniffCandidates are the delimiters that SniffDelimiter chooses between.
+var sniffCandidates = []string{"\t", ",", ";", "|"}
+
+// sniffSampleSize is the most input that SniffDelimiter looks at.
+const sniffSampleSize = 4096
+
+// minSniffConfidence is the least confidence in a sniffed delimiter that the
+// readers accept rather than asking for the delimiter to be given.
+const minSniffConfidence = 0.75
+
+// DelimiterGuess is the delimiter that SniffDelimiter takes an input to have.
+// Confidence, from 0 to 1, is the share of the sampled lines that have the
+// same number of Delimiter as most of them, or zero if another delimiter fits
+// as many lines as well, or if none appears at all.
+type DelimiterGuess struct {
+	Delimiter  string
+	Confidence float64
+}
+
+// SniffDelimiter guesses the delimiter of the input in from the lines in up to
+// its first 4KB, counting each of a tab, comma, semicolon and pipe on every
+// line, outside double-quoted cells, and picking the one whose count is the
+// same on the most of them. The input is only peeked at, so the sample is
+// still read from in afterwards.
+func SniffDelimiter(in *bufio.Reader) (DelimiterGuess, error) {
+	return sniffSample(peekSample(in))
+}
+
+// peekSample peeks at up to the first sniffSampleSize bytes of in, or as many
+// as its buffer holds if that is fewer, returning io.EOF if they are all of
+// the input.
+func peekSample(in *bufio.Reader) ([]byte, error) {
+	sample, err := in.Peek(sniffSampleSize)
+	if err == bufio.ErrBufferFull {
+		// the buffer is smaller than the sample; peeking one byte past what
+		// it holds tells whether the input ends there
+		if _, err = in.Peek(len(sample) + 1); err == bufio.ErrBufferFull {
+			err = nil
+		}
+	}
+	return sample, err
+}
+
+// sniffSample is SniffDelimiter for a sample of the input and the error
+// peeking at it returned, which is io.EOF if the input is shorter.
+func sniffSample(sample []byte, err error) (DelimiterGuess, error) {
+	if err != nil && err != io.EOF {
+		return DelimiterGuess{}, err
+	}
+	lines := strings.Split(string(sample), "\n")
+	// the last line is cut short by the end of the sample, unless it is the
+	// end of the input
+	if err == nil && len(lines) > 1 {
+		lines = lines[:len(lines)-1]
+	}
+	var nonEmpty []string
+	for _, line := range lines {
+		if line = strings.TrimSuffix(line, "\r"); line != "" {
+			nonEmpty = append(nonEmpty, line)
+		}
+	}
+	var best, runnerUp DelimiterGuess
+	for _, candidate := range sniffCandidates {
+		guess := DelimiterGuess{candidate, delimiterConsistency(nonEmpty, candidate[0])}
+		if guess.Confidence > best.Confidence {
+			best, runnerUp = guess, best
+		} else if guess.Confidence > runnerUp.Confidence {
+			runnerUp = guess
+		}
+	}
+	if runnerUp.Confidence == best.Confidence {
+		best.Confidence = 0
+	}
+	return best, nil
+}
+
+// delimiterConsistency returns the share of lines that have the number of
+// delimiter, outside double-quoted cells, that most of them have, or zero if
+// most have none.
+func delimiterConsistency(lines []string, delimiter byte) float64 {
+	if len(lines) == 0 {
+		return 0
+	}
+	frequencies := make(map[int]int)
+	for _, line := range lines {
+		count, quoted := 0, false
+		for i := 0; i < len(line); i++ {
+			switch line[i] {
+			case '"':
+				quoted = !quoted
+			case delimiter:
+				if !quoted {
+					count++
+				}
+			}
+		}
+		frequencies[count]++
+	}
+	mode, frequency := 0, 0
+	for count, n := range frequencies {
+		if n > frequency || n == frequency && count > mode {
+			mode, frequency = count, n
+		}
+	}
+	if mode == 0 {
+		return 0
+	}
+	return float64(frequency) / float64(len(lines))
+}
+
+// confidentDelimiter returns the delimiter of guess, or an error asking for
+// one to be given if the guess is not confident enough.
+func confidentDelimiter(guess DelimiterGuess) (string, error) {
+	if guess.Confidence < minSniffConfidence {
+		if guess.Delimiter == "" {
+			return "", fmt.Errorf("can not tell the delimiter of the input; give it explicitly")
+		}
+		return "", fmt.Errorf("can not tell the delimiter of the input: %q is the best guess, "+
+			"but with a confidence of only %.2f; give it explicitly", guess.Delimiter, guess.Confidence)
+	}
+	return guess.Delimiter, nil
+}
+
+// headerSampleRecords is the number of records, from the first, that the
+// readers look at to detect a header.
+const headerSampleRecords = 10
+
+// minHeaderConfidence is the least confidence in a header that the readers
+// take the first record for one at, rather than for data.
+const minHeaderConfidence = 0.5
+
+// DetectHeader guesses whether the first of records, the first few records
+// of an input split into their fields, is a header. A header's fields must
+// all be non-empty, distinct and not numbers; the confidence that it is one
+// is then the share of its columns whose values in most of the other records
+// are numbers. Any other first record is data, with a confidence of 1 that it
+// is not a header. If there are no other records, or none of them have
+// numbers, it can not be told, and the first record is taken for data with a
+// confidence of 0.
+func DetectHeader(records [][]string) (isHeader bool, confidence float64) {
+	if len(records) == 0 {
+		return false, 0
+	}
+	header := records[0]
+	seen := make(map[string]bool, len(header))
+	for _, field := range header {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] || isNumber(field) {
+			return false, 1
+		}
+		seen[field] = true
+	}
+	if len(records) == 1 {
+		return false, 0
+	}
+	numeric := 0
+	for column := range header {
+		numbers := 0
+		for _, record := range records[1:] {
+			if column < len(record) && isNumber(strings.TrimSpace(record[column])) {
+				numbers++
+			}
+		}
+		if numbers*2 > len(records)-1 {
+			numeric++
+		}
+	}
+	if numeric == 0 {
+		return false, 0
+	}
+	return true, float64(numeric) / float64(len(header))
+}
+
+// isNumber reports whether value is a decimal number.
+func isNumber(value string) bool {
+	_, err := strconv.ParseFloat(value, 64)
+	return err == nil
+}
+
+// detectsHeader reports whether the readers take the first of records for a
+// header, as DetectHeader is confident that it is one, logging the decision.
+func detectsHeader(records [][]string) bool {
+	isHeader, confidence := DetectHeader(records)
+	if isHeader && confidence >= minHeaderConfidence {
+		log.Logvf(log.Always, "detected a header in the first record, with a confidence of %.2f", confidence)
+		return true
+	}
+	if isHeader || confidence == 0 {
+		log.Logvf(log.Always, "can not tell whether the first record is a header, so importing it as data")
+	} else {
+		log.Logvf(log.Always, "detected no header: importing the first record as data")
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSniffDelimiter(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Sniffing the delimiter of an input", t, func() {
+		sniff := func(contents string) DelimiterGuess {
+			guess, err := SniffDelimiter(bufio.NewReader(strings.NewReader(contents)))
+			So(err, ShouldBeNil)
+			return guess
+		}
+
+		Convey("should pick the delimiter whose count is the same on every line", func() {
+			for contents, delimiter := range map[string]string{
+				"a,b,c\n1,2,3\n4,5,6\n":                  ",",
+				"a;b;c\r\n1,5;2;3\r\n4;5,5;6\r\n":        ";",
+				"a\tb\n1\t2\n\n3\t4":                     "\t",
+				"a|b|c\n1|x;y|3\n4|5|6\n7|8;z|9\n":       "|",
+				"name,note\nx,\"a, b, c\"\ny,\"d; e\"\n": ",",
+			} {
+				guess := sniff(contents)
+				So(guess.Delimiter, ShouldEqual, delimiter)
+				So(guess.Confidence, ShouldEqual, 1)
+			}
+		})
+		Convey("should not be confident when no delimiter, or more than one, fits", func() {
+			So(sniff("a\nb\nc\n").Confidence, ShouldEqual, 0)
+			So(sniff("a,b;c\n1,2;3\n").Confidence, ShouldEqual, 0)
+			guess := sniff("a,b\n1,2,3\n4\n5,6\n")
+			So(guess.Delimiter, ShouldEqual, ",")
+			So(guess.Confidence, ShouldEqual, 0.5)
+		})
+		Convey("should only look at the lines within the sample, leaving them to be read", func() {
+			line := strings.Repeat("x", 100) + ";y\n"
+			contents := strings.Repeat(line, 100) + strings.Repeat("z,", 100) + "\n"
+			in := bufio.NewReader(strings.NewReader(contents))
+			guess, err := SniffDelimiter(in)
+			So(err, ShouldBeNil)
+			So(guess, ShouldResemble, DelimiterGuess{";", 1})
+			read, err := ioutil.ReadAll(in)
+			So(err, ShouldBeNil)
+			So(string(read), ShouldEqual, contents)
+		})
+		Convey("should sample no more than a smaller buffer holds", func() {
+			in := bufio.NewReaderSize(strings.NewReader("a;b\n1;2\n3;4\n5,6\n7,8\n"), 16)
+			sample, err := peekSample(in)
+			So(err, ShouldBeNil)
+			So(string(sample), ShouldEqual, "a;b\n1;2\n3;4\n5,6\n")
+			guess, err := SniffDelimiter(in)
+			So(err, ShouldBeNil)
+			So(guess, ShouldResemble, DelimiterGuess{";", 0.75})
+			in = bufio.NewReaderSize(strings.NewReader("a;b\n1;2\n"), 16)
+			sample, err = peekSample(in)
+			So(err, ShouldEqual, io.EOF)
+			So(string(sample), ShouldEqual, "a;b\n1;2\n")
+		})
+	})
+
+	Convey("With input readers that detect the delimiter", t, func() {
+		stream := func(r InputReader) ([]bson.D, error) {
+			if err := r.ReadAndValidateHeader(); err != nil {
+				return nil, err
+			}
+			read := make(chan bson.D, 10)
+			err := r.StreamDocument(true, read)
+			var docs []bson.D
+			for doc := range read {
+				docs = append(docs, doc)
+			}
+			return docs, err
+		}
+		expected := []bson.D{
+			{{"a", int32(1)}, {"b", "x, y"}},
+			{{"a", int32(2)}, {"b", "z"}},
+		}
+
+		Convey("a TSV reader should split records on the delimiter detected", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte("skip me\na|b\n1|x, y\n2|z\n")), nil, 1, false)
+			r.SkipLines = 1
+			r.DetectDelimiter = true
+			docs, err := stream(r)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, expected)
+		})
+		Convey("a CSV reader should split records on the delimiter detected, honoring quotes", func() {
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte("a;b\n1;\"x, y\"\n2;z\n")), nil, 1, false)
+			r.DetectDelimiter = true
+			docs, err := stream(r)
+			So(err, ShouldBeNil)
+			So(docs, ShouldResemble, expected)
+		})
+		Convey("reading should fail when the guess is not confident", func() {
+			r := NewCSVInputReader(nil, bytes.NewReader([]byte("a\n1\n2\n")), nil, 1, false)
+			r.DetectDelimiter = true
+			_, err := stream(r)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "can not tell the delimiter of the input; give it explicitly")
+
+			t := NewTSVInputReader(nil, bytes.NewReader([]byte("a,b;c\n1,2;3\n")), nil, 1, false)
+			t.DetectDelimiter = true
+			_, err = stream(t)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "with a confidence of only 0.00")
+		})
+	})
+}
+
+func TestDetectHeader(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Detecting a header", t, func() {
+		Convey("should take distinct names over columns of numbers for a header", func() {
+			isHeader, confidence := DetectHeader([][]string{{"id", "name", "price"}, {"1", "pen", "2.5"}, {"2", "ink", "3"}})
+			So(isHeader, ShouldBeTrue)
+			So(confidence, ShouldAlmostEqual, 2.0/3)
+		})
+		Convey("should take a first record with an empty, repeated or numeric field for data", func() {
+			for _, first := range [][]string{{"id", ""}, {"id", "id"}, {"id", "7"}} {
+				isHeader, confidence := DetectHeader([][]string{first, {"1", "2"}})
+				So(isHeader, ShouldBeFalse)
+				So(confidence, ShouldEqual, 1)
+			}
+		})
+		Convey("should not be able to tell without numbers after the first record", func() {
+			for _, records := range [][][]string{{{"a", "b"}}, {{"a", "b"}, {"c", "d"}, {"1", "e"}}} {
+				isHeader, confidence := DetectHeader(records)
+				So(isHeader, ShouldBeFalse)
+				So(confidence, ShouldEqual, 0)
+			}
+		})
+	})
+
+	Convey("With input readers that detect a header", t, func() {
+		var lines []string
+		for i := 1; i <= 15; i++ {
+			lines = append(lines, fmt.Sprintf("%v,x%v", i, i))
+		}
+		data := strings.Join(lines, "\n") + "\n"
+		readers := map[string]func(contents string) InputReader{
+			"CSV": func(contents string) InputReader {
+				return NewCSVInputReader(nil, bytes.NewReader([]byte(contents)), nil, 1, false)
+			},
+			"TSV": func(contents string) InputReader {
+				return NewDelimitedInputReader(nil, bytes.NewReader([]byte(contents)), nil, 1, false, ",")
+			},
+		}
+		stream := func(r InputReader) ([]bson.D, error) {
+			if err := r.(interface {
+				ReadAndDetectHeader() error
+			}).ReadAndDetectHeader(); err != nil {
+				return nil, err
+			}
+			read := make(chan bson.D, 20)
+			err := r.StreamDocument(true, read)
+			var docs []bson.D
+			for doc := range read {
+				docs = append(docs, doc)
+			}
+			return docs, err
+		}
+
+		for name, newReader := range readers {
+			Convey("a "+name+" reader should name the fields after a header, and stream every record after it", func() {
+				r := newReader("n,s\n" + data)
+				docs, err := stream(r)
+				So(err, ShouldBeNil)
+				So(r.Fields(), ShouldResemble, []string{"n", "s"})
+				So(len(docs), ShouldEqual, 15)
+				So(docs[0], ShouldResemble, bson.D{{"n", int32(1)}, {"s", "x1"}})
+				So(docs[14], ShouldResemble, bson.D{{"n", int32(15)}, {"s", "x15"}})
+			})
+			Convey("a "+name+" reader should import a first record that is data, generating the fields", func() {
+				r := newReader(data)
+				docs, err := stream(r)
+				So(err, ShouldBeNil)
+				So(r.Fields(), ShouldResemble, []string{"field0", "field1"})
+				So(len(docs), ShouldEqual, 15)
+				So(docs[0], ShouldResemble, bson.D{{"field0", int32(1)}, {"field1", "x1"}})
+			})
+			Convey("a "+name+" reader should import a first record that can not be told apart as data", func() {
+				docs, err := stream(newReader("a,b\nc,d\n"))
+				So(err, ShouldBeNil)
+				So(len(docs), ShouldEqual, 2)
+			})
+		}
+		Convey("a header should not replace the fields given", func() {
+			r := NewCSVInputReader(ParseAutoHeaders([]string{"a", "b"}), bytes.NewReader([]byte("n,s\n"+data)), nil, 1, false)
+			docs, err := stream(r)
+			So(err, ShouldBeNil)
+			So(len(docs), ShouldEqual, 15)
+			So(docs[0], ShouldResemble, bson.D{{"a", int32(1)}, {"b", "x1"}})
+		})
+		Convey("the records read to detect a header should keep their line numbers in errors", func() {
+			colSpecs := []ColumnSpec{
+				{"n", new(FieldInt32Parser), pgStop, "int32"},
+				{"s", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			r := NewCSVInputReader(colSpecs, bytes.NewReader([]byte("n,s\n1,a\n\n2,b\nx,c\n")), nil, 1, false)
+			_, err := stream(r)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "(line 5)")
+		})
+	})
+}
@@ -0,0 +1,178 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// SourceConfig holds the settings of an import that its input source is
+// opened with.
+type SourceConfig struct {
+	// Timeout is how long the http and https sources wait to connect, for a
+	// response, or for more of the body, as given by --urlTimeout. Zero
+	// means no limit.
+	Timeout time.Duration
+}
+
+// SourceResumer reopens the input at location for reading from offset on,
+// after a read from it failed, returning it along with the number of bytes
+// from offset to its end, or -1 if that is not known. The caller closes what
+// it returns.
+type SourceResumer func(location string, offset int64, cfg SourceConfig) (io.ReadCloser, int64, error)
+
+// SourceOpener opens the input at location for reading, returning it along
+// with its size in bytes, or -1 if that is not known. The caller closes what
+// it returns.
+type SourceOpener func(location string, cfg SourceConfig) (io.ReadCloser, int64, error)
+
+// Schemes of the input sources that are registered by default.
+const (
+	// FileScheme is the scheme of local files, which is assumed for any
+	// location without a scheme.
+	FileScheme = "file"
+
+	// StdinScheme is the scheme of standard input, which is read when the
+	// location is empty.
+	StdinScheme = "stdin"
+)
+
+var sources = struct {
+	sync.RWMutex
+	openers  map[string]SourceOpener
+	resumers map[string]SourceResumer
+}{openers: make(map[string]SourceOpener), resumers: make(map[string]SourceResumer)}
+
+func init() {
+	RegisterSource(FileScheme, openFile)
+	RegisterSource(StdinScheme, openStdin)
+	RegisterSource("http", openURL)
+	RegisterSource("https", openURL)
+	RegisterSourceResumer(FileScheme, resumeFile)
+	RegisterSourceResumer("http", resumeURL)
+	RegisterSourceResumer("https", resumeURL)
+}
+
+// RegisterSource makes opener the way of opening locations with the given
+// scheme, such as "s3" for "s3://bucket/key", replacing any opener that
+// was registered for it before. Schemes are not case sensitive.
+func RegisterSource(scheme string, opener SourceOpener) {
+	sources.Lock()
+	defer sources.Unlock()
+	sources.openers[strings.ToLower(scheme)] = opener
+}
+
+// RegisterSourceResumer makes resumer the way of reopening locations with the
+// given scheme part way through, as a RetryingReader does after a read
+// error. Sources whose scheme has no resumer, such as standard input, can
+// only have the failed read retried.
+func RegisterSourceResumer(scheme string, resumer SourceResumer) {
+	sources.Lock()
+	defer sources.Unlock()
+	sources.resumers[strings.ToLower(scheme)] = resumer
+}
+
+// sourceResumer returns the resumer registered for the scheme of location,
+// or nil if there is none.
+func sourceResumer(location string) SourceResumer {
+	sources.RLock()
+	defer sources.RUnlock()
+	return sources.resumers[sourceScheme(location)]
+}
+
+// OpenSource opens location with the opener registered for its scheme, and
+// the settings of cfg. An empty location, or "-", is standard input, and a
+// location without a scheme is a local file.
+func OpenSource(location string, cfg SourceConfig) (io.ReadCloser, int64, error) {
+	scheme := sourceScheme(location)
+	sources.RLock()
+	opener, ok := sources.openers[scheme]
+	sources.RUnlock()
+	if !ok {
+		return nil, -1, fmt.Errorf("no input source is registered for the scheme '%v'", scheme)
+	}
+	return opener(location, cfg)
+}
+
+// sourceScheme returns the scheme of location, in lower case.
+func sourceScheme(location string) string {
+	if location == "" || location == "-" {
+		return StdinScheme
+	}
+	if scheme, ok := locationScheme(location); ok {
+		return strings.ToLower(scheme)
+	}
+	return FileScheme
+}
+
+// locationScheme returns the scheme that location begins with, if it begins
+// with a URL scheme followed by "://".
+func locationScheme(location string) (string, bool) {
+	i := strings.Index(location, "://")
+	if i <= 0 {
+		return "", false
+	}
+	for j, c := range location[:i] {
+		letter := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if !letter && (j == 0 || !('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return "", false
+		}
+	}
+	return location[:i], true
+}
+
+// openFile opens a local file, named with or without the file scheme.
+func openFile(location string, cfg SourceConfig) (io.ReadCloser, int64, error) {
+	if scheme, ok := locationScheme(location); ok {
+		location = location[len(scheme)+len("://"):]
+	}
+	file, err := os.Open(util.ToUniversalPath(location))
+	if err != nil {
+		return nil, -1, err
+	}
+	fileStat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, -1, err
+	}
+	return file, fileStat.Size(), nil
+}
+
+// resumeFile opens a local file and seeks to offset.
+func resumeFile(location string, offset int64, cfg SourceConfig) (io.ReadCloser, int64, error) {
+	file, size, err := openFile(location, cfg)
+	if err != nil {
+		return nil, -1, err
+	}
+	if _, err = file.(*os.File).Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, -1, err
+	}
+	return file, size - offset, nil
+}
+
+// openStdin returns standard input, whose size is not known.
+func openStdin(string, SourceConfig) (io.ReadCloser, int64, error) {
+	return os.Stdin, -1, nil
+}
+
+// openURL opens an http or https URL with the Timeout of cfg.
+func openURL(location string, cfg SourceConfig) (io.ReadCloser, int64, error) {
+	return OpenURL(location, cfg.Timeout)
+}
+
+// resumeURL reopens an http or https URL from offset with the Timeout of cfg.
+func resumeURL(location string, offset int64, cfg SourceConfig) (io.ReadCloser, int64, error) {
+	return ResumeURL(location, offset, cfg.Timeout)
+}
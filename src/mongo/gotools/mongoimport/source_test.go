@@ -0,0 +1,97 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// closeTrackingReader records whether it has been closed.
+type closeTrackingReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeTrackingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestOpenSource(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a source registered for a scheme", t, func() {
+		var opened []string
+		var config SourceConfig
+		var source *closeTrackingReader
+		RegisterSource("MEM", func(location string, cfg SourceConfig) (io.ReadCloser, int64, error) {
+			opened = append(opened, location)
+			config = cfg
+			source = &closeTrackingReader{Reader: bytes.NewReader([]byte("a\ta\n1\t2\n"))}
+			return source, 8, nil
+		})
+
+		Convey("locations with the scheme should be opened by it", func() {
+			in, size, err := OpenSource("mem://bucket/data.tsv", SourceConfig{Timeout: time.Second})
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 8)
+			So(opened, ShouldResemble, []string{"mem://bucket/data.tsv"})
+			So(config.Timeout, ShouldEqual, time.Second)
+			So(in.Close(), ShouldBeNil)
+			So(source.closed, ShouldBeTrue)
+		})
+		Convey("a scheme with no source should be an error", func() {
+			_, _, err := OpenSource("hdfs://cluster/data.tsv", SourceConfig{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "no input source is registered for the scheme 'hdfs'")
+		})
+		Convey("the source should be closed when the import stops early", func() {
+			imp, err := NewMongoImport()
+			So(err, ShouldBeNil)
+			imp.InputOptions.File = "mem://bucket/data.tsv"
+			imp.InputOptions.Type = TSV
+			imp.InputOptions.HeaderLine = true
+			imp.InputOptions.URLTimeout = 5
+			_, err = imp.ImportDocuments()
+			So(err, ShouldNotBeNil)
+			So(source.closed, ShouldBeTrue)
+			So(config.Timeout, ShouldEqual, 5*time.Second)
+		})
+	})
+
+	Convey("Locations without a scheme should be local files or stdin", t, func() {
+		So(sourceScheme(""), ShouldEqual, StdinScheme)
+		So(sourceScheme("data.tsv"), ShouldEqual, FileScheme)
+		So(sourceScheme(`C:\exports\data.tsv`), ShouldEqual, FileScheme)
+		So(sourceScheme("HTTPS://example.com/data.tsv"), ShouldEqual, "https")
+		So(sourceScheme("./a://b"), ShouldEqual, FileScheme)
+
+		dir, err := ioutil.TempDir("", "mongoimport")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "data.tsv")
+		So(ioutil.WriteFile(path, []byte("a\n1\n"), 0644), ShouldBeNil)
+		for _, location := range []string{path, "file://" + path} {
+			in, size, err := OpenSource(location, SourceConfig{})
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 4)
+			So(in.Close(), ShouldBeNil)
+		}
+		in, size, err := OpenSource("", SourceConfig{})
+		So(err, ShouldBeNil)
+		So(in, ShouldEqual, os.Stdin)
+		So(size, ShouldEqual, -1)
+	})
+}
@@ -0,0 +1,270 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RangeError is returned for a failure in one of the later byte ranges of a
+// SplitTSVInputReader, whose record and line numbers count from the start of
+// the range rather than of the input.
+type RangeError struct {
+	// Start is the offset in bytes of the start of the range
+	Start int64
+
+	// Err is the error that the range's reader returned
+	Err error
+}
+
+func (e RangeError) Error() string {
+	return fmt.Sprintf("in the range from byte %v: %v", e.Start, e.Err)
+}
+
+func (e RangeError) Unwrap() error {
+	return e.Err
+}
+
+// SplitTSVInputReader is an InputReader that reads a single seekable TSV input
+// as several byte ranges at once, so that reading keeps up with the decoding
+// goroutines when the input is very large. Each range is read from the first
+// record that starts within it, up to and including the last; a record
+// belongs to the range its first byte is in, so every record is read exactly
+// once.
+//
+// The embedded TSVInputReader reads the first range, and so any header and
+// leading lines, and its exported fields, which may be changed as for any
+// TSVInputReader, apply to every range. Documents can only be streamed
+// unordered. Ranges are split after a "\n", so input with only "\r" line
+// endings is read as one range, and quoted cells, which may span lines, and
+// SkipRecords, which counts records from the start of the input, are not
+// supported. Errors in the later ranges are RangeErrors.
+type SplitTSVInputReader struct {
+	*TSVInputReader
+
+	// ranges are the readers of the ranges after the first
+	ranges []*TSVInputReader
+}
+
+// NewSplitTSVInputReader returns a SplitTSVInputReader that reads the size
+// bytes of in as numRanges byte ranges of about the same length, or fewer if
+// the input is short, splitting records on delimiter as a reader returned by
+// NewDelimitedInputReader would. The input can not be gzip-compressed, as a
+// compressed stream can only be read from its start, nor UTF-16, as a range
+// may start in the middle of a character.
+func NewSplitTSVInputReader(colSpecs []ColumnSpec, in io.ReaderAt, size int64, numRanges int, rejects io.Writer, numDecoders int, ignoreBlanks bool, delimiter string) (*SplitTSVInputReader, error) {
+	magic := make([]byte, len(gzipMagic))
+	if n, _ := in.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, gzipMagic) {
+		return nil, fmt.Errorf("can not read gzip-compressed input in several ranges")
+	} else if n == len(magic) && (magic[0] == 0xFF && magic[1] == 0xFE || magic[0] == 0xFE && magic[1] == 0xFF) {
+		return nil, fmt.Errorf("can not read UTF-16 input in several ranges")
+	}
+	ranges := splitRanges(size, numRanges)
+	r := &SplitTSVInputReader{
+		TSVInputReader: NewDelimitedInputReader(colSpecs, newRangeReader(in, 0, ranges[0], size),
+			rejects, numDecoders, ignoreBlanks, delimiter),
+	}
+	for i := 1; i < len(ranges); i++ {
+		start := ranges[i-1]
+		szCount := newSizeTrackingReader(newRangeReader(in, start, ranges[i], size))
+		r.ranges = append(r.ranges, &TSVInputReader{
+			tsvReader:   bufio.NewReaderSize(newDecodingReader(szCount, &r.Encoding), defaultReadBufferSize),
+			sizeTracker: szCount,
+			rangeStart:  start,
+		})
+	}
+	return r, nil
+}
+
+// splitRanges returns the ends of numRanges byte ranges of about the same
+// length that cover size bytes, fewer if that would make them shorter than
+// minRangeSize, and at least one.
+func splitRanges(size int64, numRanges int) []int64 {
+	if most := size / minRangeSize; int64(numRanges) > most {
+		numRanges = int(most)
+	}
+	if numRanges < 1 {
+		numRanges = 1
+	}
+	ends := make([]int64, numRanges)
+	for i := range ends {
+		ends[i] = size * int64(i+1) / int64(numRanges)
+	}
+	return ends
+}
+
+// minRangeSize is the length of the shortest byte range that the input of a
+// SplitTSVInputReader is split into.
+const minRangeSize = 64 * 1024
+
+// Size returns the number of bytes read from all of the ranges so far.
+func (r *SplitTSVInputReader) Size() int64 {
+	size := r.TSVInputReader.Size()
+	for _, ranged := range r.ranges {
+		size += ranged.Size()
+	}
+	return size
+}
+
+// VerifyHeader reads the header line from the start of the first range and
+// checks it against the fields that the reader was given, as
+// TSVInputReader.VerifyHeader does. The later ranges have no header.
+func (r *SplitTSVInputReader) VerifyHeader(check HeaderCheck) error {
+	return r.TSVInputReader.VerifyHeader(check)
+}
+
+// Progress returns a snapshot of the progress of StreamDocument across all of
+// the ranges. It may be called while StreamDocument runs.
+func (r *SplitTSVInputReader) Progress() ReaderProgress {
+	return r.progress(r.Size())
+}
+
+// StreamDocument streams the documents of every range, reading the ranges
+// at once and converting their records on the reader's decoding goroutines.
+// It returns an error if ordered is set, as the ranges are read at once.
+func (r *SplitTSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) error {
+	return r.streamTo(ordered, docSink(readDocs))
+}
+
+// streamTo is StreamDocument for the documents that sink takes.
+func (r *SplitTSVInputReader) streamTo(ordered bool, sink documentSink) error {
+	var err error
+	switch {
+	case ordered:
+		err = fmt.Errorf("can not stream documents in order when reading the input in several ranges")
+	case r.Quoted:
+		err = fmt.Errorf("can not read quoted fields when reading the input in several ranges")
+	case r.SkipRecords != 0 || r.Skip != 0:
+		err = fmt.Errorf("can not skip records when reading the input in several ranges")
+	case r.Limit != 0:
+		err = fmt.Errorf("can not limit the records read when reading the input in several ranges")
+	case r.SampleEvery > 1 || r.SampleFraction > 0 && r.SampleFraction < 1:
+		err = fmt.Errorf("can not sample records when reading the input in several ranges")
+	case r.Encoding.utf16:
+		err = fmt.Errorf("can not read UTF-16 input in several ranges")
+	case r.DetectDelimiter && !r.delimiterDetected:
+		err = fmt.Errorf("can not detect the delimiter when reading the input in several ranges, unless the header is read first")
+	}
+	if err != nil {
+		sink.close()
+		return err
+	}
+	r.beginStream(r.Size)
+	r.utf8Replacements = &r.numReplaced
+	// cancelling on return stops every range if one of them fails first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	records := make(chan Converter, r.recordBuffer())
+	// buffered so no goroutine blocks once another's error is returned
+	errChan := make(chan error, len(r.ranges)+2)
+
+	wg := new(sync.WaitGroup)
+	read := func(ranged *TSVInputReader) {
+		defer wg.Done()
+		err := ranged.readConverters(ctx, &r.StreamOptions, records)
+		if err != nil && ranged.rangeStart != 0 {
+			err = RangeError{ranged.rangeStart, err}
+		}
+		errChan <- err
+	}
+	wg.Add(1)
+	go read(r.TSVInputReader)
+	for _, ranged := range r.ranges {
+		// each range is read with the settings of the first
+		ranged.colSpecs = r.colSpecs
+		ranged.tsvRejectWriter = r.tsvRejectWriter
+		ranged.delimiter = r.delimiter
+		ranged.WhitespaceDelimited = r.WhitespaceDelimited
+		ranged.Unescape = r.Unescape
+		ranged.CommentPrefix = r.CommentPrefix
+		ranged.CommentIndented = r.CommentIndented
+		ranged.MaxRecordSize = r.MaxRecordSize
+		ranged.ConvertOptions = r.ConvertOptions
+		wg.Add(1)
+		go read(ranged)
+	}
+	go func() {
+		wg.Wait()
+		close(records)
+	}()
+
+	go func() {
+		errChan <- r.streamConverted(ctx, false, records, sink)
+	}()
+
+	return channelQuorumError(errChan, len(r.ranges)+2)
+}
+
+// rangeReader reads the part of an input that a byte range covers: from the
+// first line that starts at or after start up to the end of the last line
+// that starts before end. A line that starts exactly at start is the range's
+// own, as the line before it ends at start-1.
+type rangeReader struct {
+	in  *bufio.Reader
+	pos int64
+	end int64
+
+	// skipped is set once the line that started before the range has been
+	// skipped, and atLineStart while the next byte starts a line
+	skipped     bool
+	atLineStart bool
+}
+
+// newRangeReader returns a rangeReader for the range of in from start to end,
+// of an input of size bytes.
+func newRangeReader(in io.ReaderAt, start, end, size int64) *rangeReader {
+	from := start
+	if start > 0 {
+		// the byte before the range tells if a line starts at start
+		from = start - 1
+	}
+	return &rangeReader{
+		in:          bufio.NewReader(io.NewSectionReader(in, from, size-from)),
+		pos:         from,
+		end:         end,
+		skipped:     start == 0,
+		atLineStart: true,
+	}
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	for !r.skipped {
+		line, err := r.in.ReadSlice('\n')
+		r.pos += int64(len(line))
+		if err == nil {
+			r.skipped = true
+		} else if err != bufio.ErrBufferFull {
+			return 0, err
+		}
+	}
+	if r.atLineStart && r.pos >= r.end {
+		return 0, io.EOF
+	}
+	if _, err := r.in.Peek(1); err != nil {
+		return 0, err
+	}
+	buf, _ := r.in.Peek(r.in.Buffered())
+	if len(buf) > len(p) {
+		buf = buf[:len(p)]
+	}
+	r.atLineStart = false
+	if i := bytes.IndexByte(buf, '\n'); i != -1 {
+		buf = buf[:i+1]
+		r.atLineStart = true
+	}
+	n := copy(p, buf)
+	r.in.Discard(n)
+	r.pos += int64(n)
+	return n, nil
+}
@@ -0,0 +1,155 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRangeReader(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Reading an input as byte ranges", t, func() {
+		contents := "a\n\nbc\r\ndef\nghij"
+		in := bytes.NewReader([]byte(contents))
+		size := int64(len(contents))
+		readRange := func(start, end int64) string {
+			data, err := ioutil.ReadAll(newRangeReader(in, start, end, size))
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+
+		Convey("should read every line exactly once, wherever the ranges split", func() {
+			for i := int64(0); i <= size; i++ {
+				for j := i; j <= size; j++ {
+					So(readRange(0, i)+readRange(i, j)+readRange(j, size), ShouldEqual, contents)
+				}
+			}
+		})
+		Convey("should give a range its lines from the first that starts in it", func() {
+			So(readRange(0, 1), ShouldEqual, "a\n")
+			So(readRange(2, 3), ShouldEqual, "\n")
+			So(readRange(3, 11), ShouldEqual, "bc\r\ndef\n")
+			So(readRange(4, 11), ShouldEqual, "def\n")
+			So(readRange(12, size), ShouldEqual, "")
+		})
+	})
+}
+
+func TestSplitTSVInputReader(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a split TSV input reader of a large file", t, func() {
+		const numRecords = 30000
+		var contents bytes.Buffer
+		contents.WriteString("n\ts\n")
+		for i := 0; i < numRecords; i++ {
+			fmt.Fprintf(&contents, "%v\trecord %v\n", i, i)
+		}
+		newReader := func(data []byte) *SplitTSVInputReader {
+			r, err := NewSplitTSVInputReader(nil, bytes.NewReader(data), int64(len(data)), 4, os.Stdout, 3, false, "")
+			So(err, ShouldBeNil)
+			So(len(r.ranges), ShouldEqual, 3)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			return r
+		}
+
+		Convey("every record should be streamed exactly once, and the header not at all", func() {
+			r := newReader(contents.Bytes())
+			docChan := make(chan bson.D, numRecords)
+			So(r.StreamDocument(false, docChan), ShouldBeNil)
+			seen := make([]bool, numRecords)
+			count := 0
+			for document := range docChan {
+				n := document[0].Value.(int32)
+				So(seen[n], ShouldBeFalse)
+				So(document[1].Value, ShouldEqual, fmt.Sprintf("record %v", n))
+				seen[n] = true
+				count++
+			}
+			So(count, ShouldEqual, numRecords)
+			So(r.Size(), ShouldEqual, contents.Len())
+			So(r.Progress().RecordsConverted, ShouldEqual, numRecords)
+		})
+		Convey("a failure in a later range should be a RangeError", func() {
+			data := strings.Replace(contents.String(), "29000\t", "x\t", 1)
+			r := newReader([]byte(data))
+			r.colSpecs[0] = ColumnSpec{"n", new(FieldInt32Parser), pgStop, "int32"}
+			err := r.StreamDocument(false, make(chan bson.D, numRecords))
+			So(err, ShouldNotBeNil)
+			_, ok := err.(RangeError)
+			So(ok, ShouldBeTrue)
+			So(ErrorCategory(err), ShouldEqual, FailureType)
+		})
+		Convey("generated fields and a detected header should apply to every range", func() {
+			data := contents.Bytes()
+			r, err := NewSplitTSVInputReader(nil, bytes.NewReader(data[4:]), int64(len(data)-4), 4, os.Stdout, 3, false, "")
+			So(err, ShouldBeNil)
+			So(r.GenerateFields(), ShouldBeNil)
+			docChan := make(chan bson.D, numRecords)
+			So(r.StreamDocument(false, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, numRecords)
+			for document := range docChan {
+				So(document[0].Name, ShouldEqual, "field0")
+			}
+
+			r, err = NewSplitTSVInputReader(nil, bytes.NewReader(data), int64(len(data)), 4, os.Stdout, 3, false, "")
+			So(err, ShouldBeNil)
+			So(r.ReadAndDetectHeader(), ShouldBeNil)
+			docChan = make(chan bson.D, numRecords)
+			So(r.StreamDocument(false, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, numRecords)
+			for document := range docChan {
+				So(document[0].Name, ShouldEqual, "n")
+			}
+		})
+		Convey("a header should be verified against the fields given in the first range", func() {
+			for header, matches := range map[string]bool{"n\ts": true, "s\tn": false} {
+				data := []byte(header + contents.String()[len(header):])
+				r, err := NewSplitTSVInputReader(ParseAutoHeaders([]string{"n", "s"}), bytes.NewReader(data),
+					int64(len(data)), 4, os.Stdout, 3, false, "")
+				So(err, ShouldBeNil)
+				err = r.VerifyHeader(HeaderCheckNames)
+				if !matches {
+					So(err, ShouldNotBeNil)
+					continue
+				}
+				So(err, ShouldBeNil)
+				docChan := make(chan bson.D, numRecords)
+				So(r.StreamDocument(false, docChan), ShouldBeNil)
+				So(len(docChan), ShouldEqual, numRecords)
+			}
+		})
+		Convey("streaming in order should fail", func() {
+			docChan := make(chan bson.D)
+			So(newReader(contents.Bytes()).StreamDocument(true, docChan), ShouldNotBeNil)
+			_, open := <-docChan
+			So(open, ShouldBeFalse)
+		})
+		Convey("a short file should be read as one range", func() {
+			r, err := NewSplitTSVInputReader(nil, bytes.NewReader([]byte("n\n1\n")), 4, 4, os.Stdout, 1, false, "")
+			So(err, ShouldBeNil)
+			So(r.ranges, ShouldBeEmpty)
+		})
+		Convey("gzip-compressed input should fail", func() {
+			var compressed bytes.Buffer
+			gz := gzip.NewWriter(&compressed)
+			gz.Write(contents.Bytes())
+			gz.Close()
+			_, err := NewSplitTSVInputReader(nil, bytes.NewReader(compressed.Bytes()), int64(compressed.Len()), 4, os.Stdout, 1, false, "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
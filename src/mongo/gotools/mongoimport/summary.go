@@ -0,0 +1,179 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/util"
+)
+
+// StreamSummary is the outcome of a stream, as returned by the Summary method
+// of StreamOptions, which every input reader embeds. It marshals to JSON with
+// the elapsed time in seconds, for tools that run imports to parse.
+type StreamSummary struct {
+	// RecordsRead is the number of records read from the input and handed
+	// over to be converted; RecordsSkipped, the SkipRecords and Skip
+	// discarded before them, are not among them
+	RecordsRead    uint64 `json:"recordsRead"`
+	RecordsSkipped uint64 `json:"recordsSkipped"`
+
+	// RecordsSeen is the number of records after the SkipRecords that
+	// sampling chose from, and RecordsSelected the number it selected, which
+	// are those read; without sampling, every record seen is selected
+	RecordsSeen     uint64 `json:"recordsSeen"`
+	RecordsSelected uint64 `json:"recordsSelected"`
+
+	// Documents is the number of documents streamed, of which EmptyDocuments
+	// have no fields, as when IgnoreBlanks leaves out every field of a
+	// blank record
+	Documents      uint64 `json:"documents"`
+	EmptyDocuments uint64 `json:"emptyDocuments"`
+
+	// Dropped is the number of records whose documents Transform dropped,
+	// and Duplicates the number of documents that Dedup dropped
+	Dropped    uint64 `json:"dropped"`
+	Duplicates uint64 `json:"duplicates"`
+
+	// Routes is the number of documents streamed on each route, if Route
+	// is set
+	Routes map[string]uint64 `json:"routes"`
+
+	// Failed is the number of records that failed to convert, Failures the
+	// number in each FailureCategory, and Rejected the number written to
+	// Rejects or dropped by InvalidUTF8Skip
+	Failed   uint64                     `json:"failed"`
+	Failures map[FailureCategory]uint64 `json:"failures"`
+	Rejected uint64                     `json:"rejected"`
+
+	// InvalidUTF8Replaced is the number of runs of invalid UTF-8 that
+	// InvalidUTF8Replace replaced
+	InvalidUTF8Replaced uint64 `json:"invalidUTF8Replaced"`
+
+	// BytesRead is the number of bytes read from the input
+	BytesRead int64 `json:"bytesRead"`
+
+	// DocumentSizes is the histogram of the sizes of the documents
+	// streamed, if StreamOptions.DocumentSizes is set
+	DocumentSizes *SizeHistogram `json:"documentSizes,omitempty"`
+
+	// DecoderTiming is how the decoders and the reader spent the time
+	// streaming, if StreamOptions.DecoderTiming is set
+	DecoderTiming *DecoderTiming `json:"decoderTiming,omitempty"`
+
+	// Elapsed is the time from the start of StreamDocument until the last
+	// document was streamed, or until now if streaming has not ended
+	Elapsed time.Duration `json:"-"`
+
+	// Throttled is the total time that documents waited under the rate
+	// limit, across all the decoders
+	Throttled time.Duration `json:"-"`
+}
+
+// RecordsPerSecond returns the rate at which records were read, or zero if
+// no time has elapsed.
+func (s StreamSummary) RecordsPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.RecordsRead) / s.Elapsed.Seconds()
+}
+
+// DocumentsPerSecond returns the rate at which documents were streamed, or
+// zero if no time has elapsed.
+func (s StreamSummary) DocumentsPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Documents) / s.Elapsed.Seconds()
+}
+
+// BytesPerSecond returns the rate at which the input was read, or zero if no
+// time has elapsed.
+func (s StreamSummary) BytesPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.BytesRead) / s.Elapsed.Seconds()
+}
+
+// MarshalJSON marshals the summary as an object of its counts, with the
+// elapsed and throttled times and the rates in seconds.
+func (s StreamSummary) MarshalJSON() ([]byte, error) {
+	// the alias has the fields and tags, but not this method
+	type summary StreamSummary
+	if s.Failures == nil {
+		s.Failures = map[FailureCategory]uint64{}
+	}
+	return json.Marshal(struct {
+		summary
+		ElapsedSeconds     float64 `json:"elapsedSeconds"`
+		RecordsPerSecond   float64 `json:"recordsPerSecond"`
+		DocumentsPerSecond float64 `json:"documentsPerSecond"`
+		BytesPerSecond     float64 `json:"bytesPerSecond"`
+		ThrottledSeconds   float64 `json:"throttledSeconds"`
+	}{summary(s), s.Elapsed.Seconds(), s.RecordsPerSecond(), s.DocumentsPerSecond(), s.BytesPerSecond(), s.Throttled.Seconds()})
+}
+
+// Summary returns the summary of the stream so far, which is final once
+// StreamDocument has returned. The counts are kept atomically, so it may be
+// called while StreamDocument runs, with any number of decoders, and in
+// either order of streaming.
+func (opts *StreamOptions) Summary() StreamSummary {
+	bytesRead := opts.readerBytes()
+	progress := opts.progress(bytesRead)
+	if finished := atomic.LoadInt64(&opts.finished); finished != 0 {
+		progress.Elapsed = time.Duration(finished - atomic.LoadInt64(&opts.started))
+	}
+	return StreamSummary{
+		RecordsRead:         progress.RecordsRead,
+		RecordsSkipped:      atomic.LoadUint64(&opts.numSkipped),
+		RecordsSeen:         progress.RecordsRead + atomic.LoadUint64(&opts.numUnsampled),
+		RecordsSelected:     progress.RecordsRead,
+		Documents:           progress.RecordsConverted,
+		EmptyDocuments:      atomic.LoadUint64(&opts.numEmpty),
+		Dropped:             opts.Dropped(),
+		Duplicates:          opts.Duplicates(),
+		Routes:              opts.Routes(),
+		Failed:              progress.RecordsFailed,
+		Failures:            opts.Failures(),
+		Rejected:            opts.Rejected(),
+		BytesRead:           bytesRead,
+		DocumentSizes:       progress.DocumentSizes,
+		DecoderTiming:       opts.decoderTiming(progress.Elapsed),
+		InvalidUTF8Replaced: atomic.LoadUint64(&opts.numReplaced),
+		Elapsed:             progress.Elapsed,
+		Throttled:           opts.Throttled(),
+	}
+}
+
+// writeSummaryFile writes summary as JSON, followed by a newline, to the
+// named file, or to stdout if the name is "-".
+func writeSummaryFile(name string, summary StreamSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("error marshalling the summary: %v", err)
+	}
+	out := io.Writer(os.Stdout)
+	if name != "-" {
+		file, err := os.Create(util.ToUniversalPath(name))
+		if err != nil {
+			return fmt.Errorf("error creating summary file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err = out.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing summary file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestStreamSummary(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader on several decoders", t, func() {
+		colSpecs := []ColumnSpec{
+			{"n", new(FieldInt32Parser), pgStop, "int32"},
+			{"s", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+		var lines []string
+		for i := 0; i < 1000; i++ {
+			switch {
+			case i%100 == 0:
+				lines = append(lines, "x\ta")
+			case i%50 == 0:
+				lines = append(lines, "\t")
+			default:
+				lines = append(lines, "1\ta")
+			}
+		}
+		contents := strings.Join(lines, "\n") + "\n"
+		newReader := func() *TSVInputReader {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), nil, 4, true)
+			r.MaxErrors = -1
+			r.SkipRecords = 10
+			r.Transform = func(doc bson.D) (bson.D, error) {
+				if len(doc) == 2 && doc[1].Value == "drop" {
+					return nil, nil
+				}
+				return doc, nil
+			}
+			return r
+		}
+
+		for _, ordered := range []bool{true, false} {
+			Convey("the summary should count every record when ordered is "+map[bool]string{true: "set", false: "unset"}[ordered], func() {
+				r := newReader()
+				readDocs := make(chan bson.D, 1000)
+				So(r.StreamDocument(ordered, readDocs), ShouldBeNil)
+				summary := r.Summary()
+				So(summary.RecordsSkipped, ShouldEqual, 10)
+				So(summary.RecordsRead, ShouldEqual, 990)
+				So(summary.Failed, ShouldEqual, 9)
+				So(summary.Failures, ShouldResemble, map[FailureCategory]uint64{FailureType: 9})
+				So(summary.Documents, ShouldEqual, 981)
+				So(summary.Documents, ShouldEqual, len(readDocs))
+				So(summary.EmptyDocuments, ShouldEqual, 10)
+				So(summary.Dropped, ShouldEqual, 0)
+				So(summary.BytesRead, ShouldEqual, len(contents))
+				So(summary.Elapsed, ShouldBeGreaterThan, 0)
+				So(r.Summary().Elapsed, ShouldEqual, summary.Elapsed)
+			})
+		}
+		Convey("documents dropped by the transform should be counted", func() {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte("1\tdrop\n2\tkeep\n")), nil, 2, false)
+			r.Transform = newReader().Transform
+			So(r.StreamDocument(false, make(chan bson.D, 2)), ShouldBeNil)
+			summary := r.Summary()
+			So(summary.Dropped, ShouldEqual, 1)
+			So(summary.Documents, ShouldEqual, 1)
+		})
+	})
+	Convey("A stream summary", t, func() {
+		summary := StreamSummary{
+			RecordsRead: 10,
+			Documents:   8,
+			Duplicates:  3,
+			Routes:      map[string]uint64{"order": 8},
+			Failed:      2,
+			Failures:    map[FailureCategory]uint64{FailureType: 2},
+			BytesRead:   100,
+			Elapsed:     2 * time.Second,
+			Throttled:   time.Second / 2,
+		}
+		Convey("should marshal to JSON with the elapsed time and rates in seconds", func() {
+			data, err := json.Marshal(summary)
+			So(err, ShouldBeNil)
+			var decoded map[string]interface{}
+			So(json.Unmarshal(data, &decoded), ShouldBeNil)
+			So(decoded["recordsRead"], ShouldEqual, 10)
+			So(decoded["documents"], ShouldEqual, 8)
+			So(decoded["duplicates"], ShouldEqual, 3)
+			So(decoded["routes"], ShouldResemble, map[string]interface{}{"order": 8.0})
+			So(decoded["failures"], ShouldResemble, map[string]interface{}{"type": 2.0})
+			So(decoded["elapsedSeconds"], ShouldEqual, 2)
+			So(decoded["recordsPerSecond"], ShouldEqual, 5)
+			So(decoded["documentsPerSecond"], ShouldEqual, 4)
+			So(decoded["bytesPerSecond"], ShouldEqual, 50)
+			So(decoded["throttledSeconds"], ShouldEqual, 0.5)
+			So(decoded, ShouldNotContainKey, "Elapsed")
+		})
+		Convey("should marshal an empty object of failures if there are none", func() {
+			data, err := json.Marshal(StreamSummary{})
+			So(err, ShouldBeNil)
+			So(string(data), ShouldContainSubstring, `"failures":{}`)
+			So(string(data), ShouldContainSubstring, `"recordsPerSecond":0`)
+		})
+		Convey("should be written to a file as a line of JSON", func() {
+			dir, err := ioutil.TempDir("", "summary")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			name := filepath.Join(dir, "summary.json")
+			So(writeSummaryFile(name, summary), ShouldBeNil)
+			data, err := ioutil.ReadFile(name)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEndWith, "}\n")
+			var decoded StreamSummary
+			So(json.Unmarshal(data, &decoded), ShouldBeNil)
+			So(decoded.RecordsRead, ShouldEqual, 10)
+		})
+	})
+}
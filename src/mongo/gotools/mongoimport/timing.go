@@ -0,0 +1,193 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/log"
+)
+
+// The bounds of the shares of time by which DecoderTiming tells what a
+// stream was bound by: decoders idle for at least idleBound of the time
+// mostly wait, and for less than busyBound mostly convert, and a reader
+// blocked for at least blockedBound of the time waits on the decoders.
+const (
+	idleBound    = 0.5
+	busyBound    = 0.25
+	blockedBound = 0.25
+)
+
+// Bounds of a stream, as told by DecoderTiming.
+const (
+	BoundByInput   = "input"
+	BoundByDecoder = "decoder"
+	BoundByOutput  = "output"
+	Balanced       = "balanced"
+)
+
+// DecoderTiming is how the time of a stream was spent, for tuning the number
+// of decoders: the time they spent converting records, and were otherwise
+// idle, waiting for records to be read or for their documents to be taken,
+// and the time that the reader was blocked handing records to them. Time
+// that documents waited under the rate limit counts as idle. Bound tells
+// which of these held the stream back, and Recommendation what, if
+// anything, to change.
+type DecoderTiming struct {
+	Decoders      int           `json:"decoders"`
+	Converting    time.Duration `json:"-"`
+	Idle          time.Duration `json:"-"`
+	ReaderBlocked time.Duration `json:"-"`
+
+	// IdleShare is the share of the decoders' time that they were idle, and
+	// ReaderBlockedShare the share of the stream's time that the reader was
+	// blocked
+	IdleShare          float64 `json:"idleShare"`
+	ReaderBlockedShare float64 `json:"readerBlockedShare"`
+
+	Bound          string `json:"bound"`
+	Recommendation string `json:"recommendation"`
+}
+
+// MarshalJSON marshals the timing with its times in seconds.
+func (t DecoderTiming) MarshalJSON() ([]byte, error) {
+	// the alias has the fields and tags, but not this method
+	type timing DecoderTiming
+	return json.Marshal(struct {
+		timing
+		ConvertingSeconds    float64 `json:"convertingSeconds"`
+		IdleSeconds          float64 `json:"idleSeconds"`
+		ReaderBlockedSeconds float64 `json:"readerBlockedSeconds"`
+	}{timing(t), t.Converting.Seconds(), t.Idle.Seconds(), t.ReaderBlocked.Seconds()})
+}
+
+// String returns the share of time that the decoders were idle and what
+// bound the stream, such as "decoders idle 72% — input-bound".
+func (t DecoderTiming) String() string {
+	return fmt.Sprintf("decoders idle %.0f%%, reader blocked %.0f%% — %v-bound",
+		100*t.IdleShare, 100*t.ReaderBlockedShare, t.Bound)
+}
+
+// newDecoderTiming works out the timing of a stream of elapsed time, whose
+// decoders spent converting on it, and whose reader was blocked for blocked.
+func newDecoderTiming(decoders int, elapsed, converting, blocked time.Duration) *DecoderTiming {
+	if decoders <= 0 {
+		decoders = 1
+	}
+	t := &DecoderTiming{Decoders: decoders, Converting: converting, ReaderBlocked: blocked, Bound: Balanced}
+	if total := time.Duration(decoders) * elapsed; total > converting {
+		t.Idle = total - converting
+	}
+	if elapsed > 0 && t.Idle+converting > 0 {
+		t.IdleShare = float64(t.Idle) / float64(t.Idle+converting)
+		t.ReaderBlockedShare = math.Min(float64(blocked)/float64(elapsed), 1)
+	}
+	switch {
+	case t.ReaderBlockedShare >= blockedBound && t.IdleShare < busyBound:
+		t.Bound = BoundByDecoder
+		if decoders < MaxNumDecoders {
+			more := 2 * decoders
+			if more > MaxNumDecoders {
+				more = MaxNumDecoders
+			}
+			t.Recommendation = fmt.Sprintf("the decoders are mostly converting and the reader waits on them; "+
+				"more of them, such as --numDecodingWorkers=%v, may import faster", more)
+		} else {
+			t.Recommendation = "the decoders are mostly converting, and there are as many as there may be"
+		}
+	case t.ReaderBlockedShare >= blockedBound && t.IdleShare >= idleBound:
+		t.Bound = BoundByOutput
+		t.Recommendation = "the documents are converted faster than they are inserted; " +
+			"more decoders would not import faster"
+	case t.ReaderBlockedShare < blockedBound && t.IdleShare >= idleBound:
+		t.Bound = BoundByInput
+		if needed := int(math.Ceil(float64(decoders) * (1 - t.IdleShare))); needed < decoders {
+			if needed < 1 {
+				needed = 1
+			}
+			t.Recommendation = fmt.Sprintf("the decoders are mostly waiting for the input to be read; "+
+				"fewer of them, such as --numDecodingWorkers=%v, would likely import as fast", needed)
+		} else {
+			t.Recommendation = "the input is read no faster than one decoder converts it"
+		}
+	default:
+		t.Recommendation = "no change to the number of decoders is suggested"
+	}
+	return t
+}
+
+// decoderTimer keeps the times of a DecoderTiming atomically, the time
+// converting across sizeShards shards, as the decoders add to it at once.
+type decoderTimer struct {
+	decoders   int64
+	blocked    int64
+	converting [sizeShards]struct {
+		nanos int64
+		_     [56]byte
+	}
+}
+
+// converted adds the time that the index-th record read took to convert.
+func (t *decoderTimer) converted(index uint64, took time.Duration) {
+	atomic.AddInt64(&t.converting[index%sizeShards].nanos, int64(took))
+}
+
+// blockedSince adds the time since start that the reader has been blocked.
+func (t *decoderTimer) blockedSince(start time.Time) {
+	atomic.AddInt64(&t.blocked, int64(time.Since(start)))
+}
+
+// convertingTime returns the time that the decoders have spent converting.
+func (t *decoderTimer) convertingTime() (converting time.Duration) {
+	for i := range t.converting {
+		converting += time.Duration(atomic.LoadInt64(&t.converting[i].nanos))
+	}
+	return converting
+}
+
+// decoderTimer returns the timer of the decoders of the stream, making it
+// the first time it is called, or nil if DecoderTiming is not set, when
+// nothing is timed.
+func (opts *StreamOptions) decoderTimer() *decoderTimer {
+	if !opts.DecoderTiming {
+		return nil
+	}
+	opts.timingOnce.Do(func() { opts.timing = new(decoderTimer) })
+	return opts.timing
+}
+
+// startTiming records the number of decoders that convert the stream, if
+// DecoderTiming is set.
+func (opts *StreamOptions) startTiming(numDecoders int) {
+	if timer := opts.decoderTimer(); timer != nil {
+		atomic.StoreInt64(&timer.decoders, int64(numDecoders))
+	}
+}
+
+// decoderTiming returns the timing of the decoders of a stream of elapsed
+// time so far, or nil if DecoderTiming is not set.
+func (opts *StreamOptions) decoderTiming(elapsed time.Duration) *DecoderTiming {
+	timer := opts.decoderTimer()
+	if timer == nil {
+		return nil
+	}
+	return newDecoderTiming(int(atomic.LoadInt64(&timer.decoders)), elapsed,
+		timer.convertingTime(), time.Duration(atomic.LoadInt64(&timer.blocked)))
+}
+
+// logDecoderTiming logs timing and its recommendation, if it is set.
+func logDecoderTiming(timing *DecoderTiming) {
+	if timing == nil {
+		return
+	}
+	log.Logvf(log.Always, "%v decoder(s): %v", timing.Decoders, timing)
+	log.Logvf(log.Always, "%v", timing.Recommendation)
+}
@@ -0,0 +1,110 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDecoderTiming(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("The bound of a stream should be told from its times", t, func() {
+		second := time.Second
+		Convey("decoders mostly idle while the reader is not blocked are bound by the input", func() {
+			timing := newDecoderTiming(8, 10*second, 16*second, second/2)
+			So(timing.Idle, ShouldEqual, 64*second)
+			So(timing.IdleShare, ShouldAlmostEqual, 0.8)
+			So(timing.ReaderBlockedShare, ShouldAlmostEqual, 0.05)
+			So(timing.Bound, ShouldEqual, BoundByInput)
+			So(timing.Recommendation, ShouldContainSubstring, "--numDecodingWorkers=2")
+			So(timing.String(), ShouldEqual, "decoders idle 80%, reader blocked 5% — input-bound")
+		})
+		Convey("busy decoders that the reader waits on are bound by the decoders", func() {
+			timing := newDecoderTiming(4, 10*second, 38*second, 6*second)
+			So(timing.Bound, ShouldEqual, BoundByDecoder)
+			So(timing.Recommendation, ShouldContainSubstring, "--numDecodingWorkers=8")
+			timing = newDecoderTiming(MaxNumDecoders, second, MaxNumDecoders*second, second)
+			So(timing.Bound, ShouldEqual, BoundByDecoder)
+			So(timing.Recommendation, ShouldNotContainSubstring, "--numDecodingWorkers")
+		})
+		Convey("idle decoders that the reader waits on are bound by the output", func() {
+			timing := newDecoderTiming(4, 10*second, 4*second, 9*second)
+			So(timing.Bound, ShouldEqual, BoundByOutput)
+		})
+		Convey("anything else is balanced", func() {
+			timing := newDecoderTiming(4, 10*second, 25*second, second)
+			So(timing.Bound, ShouldEqual, Balanced)
+			So(newDecoderTiming(0, 0, 0, 0).Bound, ShouldEqual, Balanced)
+		})
+		Convey("the times should be marshalled in seconds", func() {
+			data, err := json.Marshal(newDecoderTiming(2, second, second, second/4))
+			So(err, ShouldBeNil)
+			var fields map[string]interface{}
+			So(json.Unmarshal(data, &fields), ShouldBeNil)
+			So(fields["decoders"], ShouldEqual, 2)
+			So(fields["convertingSeconds"], ShouldEqual, 1)
+			So(fields["idleSeconds"], ShouldEqual, 1)
+			So(fields["readerBlockedSeconds"], ShouldEqual, 0.25)
+			So(fields["idleShare"], ShouldEqual, 0.5)
+		})
+	})
+	Convey("With a TSV input reader that times its decoders", t, func() {
+		var buf bytes.Buffer
+		buf.WriteString("n.int32()\n")
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(&buf, "%d\n", i)
+		}
+		newReader := func() *TSVInputReader {
+			r := NewTSVInputReader(nil, bytes.NewReader(buf.Bytes()), os.Stdout, 3, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			r.DecoderTiming = true
+			return r
+		}
+		stream := func(r *TSVInputReader, ordered bool) {
+			docChan := make(chan bson.D, 1000)
+			So(r.StreamDocument(ordered, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1000)
+		}
+
+		Convey("the summary should have the time spent converting", func() {
+			for _, ordered := range []bool{false, true} {
+				r := newReader()
+				stream(r, ordered)
+				timing := r.Summary().DecoderTiming
+				So(timing, ShouldNotBeNil)
+				So(timing.Decoders, ShouldEqual, 3)
+				So(timing.Converting, ShouldBeGreaterThan, 0)
+				So(timing.IdleShare, ShouldBeBetweenOrEqual, 0, 1)
+				So(timing.ReaderBlockedShare, ShouldBeBetweenOrEqual, 0, 1)
+				So(timing.Recommendation, ShouldNotBeEmpty)
+			}
+		})
+		Convey("time waited under the rate limit should not count as converting", func() {
+			r := newReader()
+			r.SetRateLimit(2000, 0)
+			stream(r, false)
+			So(r.Throttled(), ShouldBeGreaterThan, 0)
+			So(r.Summary().DecoderTiming.IdleShare, ShouldBeGreaterThan, 0.5)
+		})
+		Convey("nothing should be timed unless it is asked for", func() {
+			r := newReader()
+			r.DecoderTiming = false
+			stream(r, false)
+			So(r.Summary().DecoderTiming, ShouldBeNil)
+			So(r.timing, ShouldBeNil)
+		})
+	})
+}
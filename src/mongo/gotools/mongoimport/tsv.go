@@ -8,21 +8,83 @@ package mongoimport
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/mongodb/mongo-tools/common/log"
 	"gopkg.in/mgo.v2/bson"
 )
 
 const (
-	entryDelimiter = '\n'
 	tokenSeparator = "\t"
+	quoteCharacter = '"'
 )
 
+// ErrUnterminatedQuote is returned when the input source ends inside a quoted
+// TSV cell.
+var ErrUnterminatedQuote = errors.New("unterminated quoted field at end of input")
+
 // TSVInputReader is a struct that implements the InputReader interface for a
 // TSV input source.
+//
+// The exported fields can be changed to customize the details before the
+// first call to ReadAndValidateHeader or StreamDocument.
 type TSVInputReader struct {
+	// Quoted enables quoted cells. A cell that begins with a double quote
+	// runs until the matching closing quote and may contain delimiters and
+	// newlines; a doubled quote within it stands for a single quote. Records
+	// are read until all of their quotes are balanced.
+	Quoted bool
+
+	// WhitespaceDelimited splits records, and the header, on every run of
+	// spaces and tabs, as awk does by default, rather than on the delimiter.
+	// Whitespace at the start and end of a record does not make a token, and
+	// neither can whitespace between tokens, so a record can have no empty
+	// tokens: a blank cell can not be told from no cell at all, and
+	// IgnoreBlanks has no blanks to ignore. It can not be used with Quoted.
+	WhitespaceDelimited bool
+
+	// Unescape decodes the backslash escapes \t, \n, \r and \\ within cells,
+	// as written by tools that can not otherwise put tabs and newlines in a
+	// cell. Any other backslash, including one that ends a cell, is kept.
+	Unescape bool
+
+	// SkipLines is the number of leading lines to discard before the header
+	// or first record. They still count towards the line numbers in errors.
+	SkipLines int
+
+	// DetectDelimiter replaces the delimiter given to the constructor with
+	// the one that SniffDelimiter guesses from the input after SkipLines,
+	// once the header or first record is read. Reading fails if the guess is
+	// not confident enough.
+	DetectDelimiter bool
+
+	// CommentPrefix, if non-empty, marks comment records: a record that
+	// begins with it, including the header, is discarded without being
+	// converted. Comments still count towards the line numbers in errors.
+	// Only the first line of a record is checked, so quoted cells spanning
+	// several lines are never mistaken for comments.
+	CommentPrefix string
+
+	// CommentIndented also treats records in which CommentPrefix follows
+	// leading spaces or tabs as comments.
+	CommentIndented bool
+
+	// MaxRecordSize is the length in bytes, including line terminators, of
+	// the longest record that is read; a longer one is a RecordTooLargeError.
+	// Zero means 16MB, the largest document the server accepts.
+	MaxRecordSize int
+
+	// Encoding is the character encoding of the input, which is converted
+	// to UTF-8 as it is read, and so must be set before anything is.
+	Encoding InputEncoding
+
 	// colSpecs is a list of column specifications in the BSON documents to be imported
 	colSpecs []ColumnSpec
 
@@ -36,17 +98,51 @@ type TSVInputReader struct {
 	// tsvRecord stores each line of input we read from the underlying reader
 	tsvRecord string
 
-	// numProcessed tracks the number of TSV records processed by the underlying reader
+	// numProcessed is the number of TSV records read in full, whether handed
+	// over to be converted or skipped by SkipRecords, but not one that fails
+	// to be read, so the one that does is number numProcessed+1; it is
+	// updated atomically, so it can be read while StreamDocument runs
 	numProcessed uint64
 
-	// numDecoders is the number of concurrent goroutines to use for decoding
-	numDecoders int
+	// lineNumber is the number of lines read so far, including the header line
+	lineNumber uint64
+
+	// recordLine is the line on which the most recently read record started
+	recordLine uint64
 
 	// embedded sizeTracker exposes the Size() method to check the number of bytes read so far
 	sizeTracker
 
-	// ignoreBlanks is whether empty fields should be ignored
-	ignoreBlanks bool
+	// embedded ConvertOptions controls how each record's tokens are converted
+	ConvertOptions
+
+	// embedded StreamOptions controls how records that fail to convert are handled
+	StreamOptions
+
+	// delimiter is the string used to separate tokens within each record,
+	// and delimiterDetected is set once DetectDelimiter has replaced it
+	delimiter         string
+	delimiterDetected bool
+
+	// originalHeader is the column names read from the header, before normalization
+	originalHeader []string
+
+	// skippedLines is the number of leading lines discarded so far
+	skippedLines int
+
+	// peeked are the records read by GenerateFields, which are streamed
+	// before any more are read
+	peeked []peekedRecord
+
+	// rangeStart is the offset of the byte range that the reader reads, if it
+	// reads one of the later ranges of a SplitTSVInputReader, for errors
+	rangeStart int64
+}
+
+// peekedRecord is a record read ahead of the stream, and the line it starts on.
+type peekedRecord struct {
+	record string
+	line   uint64
 }
 
 // TSVConverter implements the Converter interface for TSV input.
@@ -54,116 +150,693 @@ type TSVConverter struct {
 	colSpecs     []ColumnSpec
 	data         string
 	index        uint64
-	ignoreBlanks bool
+	line         uint64
 	rejectWriter io.Writer
+	delimiter    string
+	whitespace   bool
+	quoted       bool
+	unescape     bool
+	rangeStart   int64
+	options      *ConvertOptions
 }
 
 // NewTSVInputReader returns a TSVInputReader configured to read input from the
 // given io.Reader, extracting the specified columns only.
 func NewTSVInputReader(colSpecs []ColumnSpec, in io.Reader, rejects io.Writer, numDecoders int, ignoreBlanks bool) *TSVInputReader {
-	szCount := newSizeTrackingReader(newBomDiscardingReader(in))
-	return &TSVInputReader{
+	return NewDelimitedInputReader(colSpecs, in, rejects, numDecoders, ignoreBlanks, tokenSeparator)
+}
+
+// NewDelimitedInputReader returns a TSVInputReader that splits each record on
+// the given delimiter rather than on a tab. The delimiter may be any non-empty
+// string. Quote characters have no special meaning to this reader, so a
+// delimiter that appears inside a quoted cell still separates tokens; use the
+// CSV reader for input that relies on quoting.
+func NewDelimitedInputReader(colSpecs []ColumnSpec, in io.Reader, rejects io.Writer, numDecoders int, ignoreBlanks bool, delimiter string) *TSVInputReader {
+	return NewDelimitedInputReaderSize(colSpecs, in, rejects, numDecoders, ignoreBlanks, delimiter, defaultReadBufferSize)
+}
+
+// NewDelimitedInputReaderSize is like NewDelimitedInputReader, but reads the
+// input through a buffer of bufferSize bytes. Records of any length up to
+// MaxRecordSize are read whatever the buffer size, but reading records much
+// longer than the buffer is slower.
+func NewDelimitedInputReaderSize(colSpecs []ColumnSpec, in io.Reader, rejects io.Writer, numDecoders int, ignoreBlanks bool, delimiter string, bufferSize int) *TSVInputReader {
+	if delimiter == "" {
+		delimiter = tokenSeparator
+	}
+	szCount := newSizeTrackingReader(in)
+	r := &TSVInputReader{
 		colSpecs:        colSpecs,
-		tsvReader:       bufio.NewReader(szCount),
 		tsvRejectWriter: rejects,
 		numProcessed:    uint64(0),
-		numDecoders:     numDecoders,
 		sizeTracker:     szCount,
-		ignoreBlanks:    ignoreBlanks,
+		ConvertOptions:  ConvertOptions{IgnoreBlanks: ignoreBlanks},
+		delimiter:       delimiter,
+		StreamOptions:   StreamOptions{numDecoders: effectiveNumDecoders(numDecoders)},
 	}
+	r.tsvReader = bufio.NewReaderSize(newTextReader(szCount, &r.Encoding), bufferSize)
+	return r
 }
 
 // ReadAndValidateHeader reads the header from the underlying reader and validates
 // the header fields. It sets err if the read/validation fails.
 func (r *TSVInputReader) ReadAndValidateHeader() (err error) {
-	header, err := r.tsvReader.ReadString(entryDelimiter)
+	if err = r.skipLeadingLines(); err != nil {
+		return err
+	}
+	header, err := r.readRecord()
 	if err != nil {
 		return err
 	}
-	for _, field := range strings.Split(header, tokenSeparator) {
+	return r.setHeader(header)
+}
+
+// setHeader names the fields of the reader after those of header.
+func (r *TSVInputReader) setHeader(header string) error {
+	for _, field := range r.splitRecord(header) {
 		r.colSpecs = append(r.colSpecs, ColumnSpec{
-			Name:   strings.TrimRight(field, "\r\n"),
+			Name:   field,
 			Parser: new(FieldAutoParser),
 		})
 	}
-	return validateReaderFields(ColumnNames(r.colSpecs))
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	return r.validateColumns(r.colSpecs)
 }
 
 // ReadAndValidateTypedHeader reads the header from the underlying reader and validates
 // the header fields. It sets err if the read/validation fails.
 func (r *TSVInputReader) ReadAndValidateTypedHeader(parseGrace ParseGrace) (err error) {
-	header, err := r.tsvReader.ReadString(entryDelimiter)
-	if err != nil {
+	if err = r.skipLeadingLines(); err != nil {
 		return err
 	}
-	var headerFields []string
-	for _, field := range strings.Split(header, tokenSeparator) {
-		headerFields = append(headerFields, strings.TrimRight(field, "\r\n"))
+	header, err := r.readRecord()
+	if err != nil {
+		return err
 	}
+	headerFields := r.splitRecord(header)
 	r.colSpecs, err = ParseTypedHeaders(headerFields, parseGrace)
 	if err != nil {
 		return err
 	}
-	return validateReaderFields(ColumnNames(r.colSpecs))
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	return r.validateColumns(r.colSpecs)
+}
+
+// VerifyHeader reads the header line and checks it against the fields that
+// the reader was given, as check says, rather than taking the fields from
+// it. The header line is not imported.
+func (r *TSVInputReader) VerifyHeader(check HeaderCheck) error {
+	if err := r.skipLeadingLines(); err != nil {
+		return err
+	}
+	header, err := r.readRecord()
+	if err == io.EOF {
+		return fmt.Errorf("the input has no header line to verify")
+	}
+	if err != nil {
+		return err
+	}
+	r.originalHeader = r.splitRecord(header)
+	return r.verifyHeader(r.originalHeader, r.colSpecs, check)
+}
+
+// GenerateFields names the fields field0, field1, and so on, one for each
+// token of the first record that is not blank, for input that has no header.
+// The generated names are logged. The records read to count the tokens are
+// not consumed, so they are still streamed. A later record with more tokens
+// is handled by LongRows, which by default names its extra tokens in the same
+// way, and one with fewer by ShortRows.
+func (r *TSVInputReader) GenerateFields() error {
+	if err := r.skipLeadingLines(); err != nil {
+		return err
+	}
+	for {
+		record, err := r.readRecord()
+		if err == io.EOF {
+			log.Logvf(log.Always, "no records to generate field names from")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r.peeked = append(r.peeked, peekedRecord{record, r.recordLine})
+		if strings.TrimRight(record, "\r\n") != "" {
+			return r.generateFields(record)
+		}
+	}
+}
+
+// generateFields names the fields of the reader after those of record, the
+// first, as GenerateFields does.
+func (r *TSVInputReader) generateFields(record string) error {
+	r.colSpecs = generatedColumns(r.generatedFieldCount(r.splitRecord(record)))
+	r.originalHeader = r.prepareColumnNames(r.colSpecs)
+	log.Logvf(log.Always, "generated fields from the first record: %v", strings.Join(r.originalHeader, ","))
+	return r.validateColumns(r.colSpecs)
+}
+
+// ReadAndDetectHeader reads the first few records that are not blank, up to
+// ten, and takes the first for a header, as ReadAndValidateHeader does, if
+// DetectHeader is confident that it is one, or else for data, logging which.
+// Any header is not imported, but the other records read are still
+// streamed. A header names the fields unless the reader was given them; if
+// the first record is data, and the reader was not, they are generated from
+// it as by GenerateFields.
+func (r *TSVInputReader) ReadAndDetectHeader() error {
+	if err := r.skipLeadingLines(); err != nil {
+		return err
+	}
+	var records [][]string
+	first := -1
+	for len(records) < headerSampleRecords {
+		record, err := r.readRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		r.peeked = append(r.peeked, peekedRecord{record, r.recordLine})
+		if trimmed := strings.TrimRight(record, "\r\n"); trimmed != "" {
+			if first < 0 {
+				first = len(r.peeked) - 1
+			}
+			records = append(records, r.splitRecord(trimmed))
+		}
+	}
+	if len(records) == 0 {
+		log.Logvf(log.Always, "no records to detect a header in")
+		return nil
+	}
+	header := r.peeked[first].record
+	if detectsHeader(records) {
+		r.peeked = append(r.peeked[:first:first], r.peeked[first+1:]...)
+		if len(r.colSpecs) != 0 {
+			return nil
+		}
+		return r.setHeader(header)
+	}
+	if len(r.colSpecs) != 0 {
+		return nil
+	}
+	return r.generateFields(header)
+}
+
+// OriginalHeader returns the column names as they were before normalization
+// and sanitization, or nil if the header has not been read and validated.
+func (r *TSVInputReader) OriginalHeader() []string {
+	return r.originalHeader
+}
+
+// Fields returns the names of the fields that documents are given, once the
+// header has been read and validated. The slice is the caller's to change.
+func (r *TSVInputReader) Fields() []string {
+	return r.fieldNames(r.colSpecs)
+}
+
+// EstimateRecordCount estimates the number of records, including any header,
+// from the current offset of source to its end, by splitting up to sampleSize
+// bytes of it as this reader would. Source should be a separate handle on the
+// input, such as a second *os.File; it is sought back to where it was. For
+// input that can not seek, such as standard input, it returns
+// UnknownRecordCount.
+func (r *TSVInputReader) EstimateRecordCount(source io.Reader, sampleSize int) (RecordCountEstimate, error) {
+	return estimateRecordCount(source, sampleSize, func(sample io.Reader) (lengths []int) {
+		sampler := &TSVInputReader{
+			tsvReader:           bufio.NewReader(newBomDiscardingReader(sample)),
+			delimiter:           r.delimiter,
+			WhitespaceDelimited: r.WhitespaceDelimited,
+			Quoted:              r.Quoted,
+			CommentPrefix:       r.CommentPrefix,
+			CommentIndented:     r.CommentIndented,
+		}
+		for {
+			record, err := sampler.readRecord()
+			if err != nil {
+				// an unterminated quote is a record cut off by the sample
+				return
+			}
+			lengths = append(lengths, len(record))
+		}
+	})
+}
+
+// Progress returns a snapshot of how far StreamDocument has got. It is safe
+// to call while StreamDocument is running.
+func (r *TSVInputReader) Progress() ReaderProgress {
+	return r.progress(r.Size())
 }
 
 // StreamDocument takes a boolean indicating if the documents should be streamed
 // in read order and a channel on which to stream the documents processed from
 // the underlying reader. Returns a non-nil error if streaming fails.
 func (r *TSVInputReader) StreamDocument(ordered bool, readDocs chan bson.D) (retErr error) {
-	tsvRecordChan := make(chan Converter, r.numDecoders)
-	tsvErrChan := make(chan error)
+	return r.StreamDocumentContext(context.Background(), ordered, readDocs)
+}
+
+// StreamDocumentContext is like StreamDocument, but stops reading and
+// converting records as soon as ctx is done, returning ctx.Err(). Its
+// goroutines exit after at most the record each is working on, closing
+// readDocs, even if nothing is receiving from readDocs any more.
+func (r *TSVInputReader) StreamDocumentContext(ctx context.Context, ordered bool, readDocs chan bson.D) (retErr error) {
+	return r.streamContext(ctx, ordered, docSink(readDocs))
+}
+
+// streamTo is StreamDocument for the documents that sink takes.
+func (r *TSVInputReader) streamTo(ordered bool, sink documentSink) error {
+	return r.streamContext(context.Background(), ordered, sink)
+}
+
+// streamContext is StreamDocumentContext for the documents that sink takes.
+func (r *TSVInputReader) streamContext(ctx context.Context, ordered bool, sink documentSink) error {
+	r.beginStream(r.Size)
+	r.utf8Replacements = &r.numReplaced
+	// cancelling on return stops the read loop if decoding fails first
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tsvRecordChan := make(chan Converter, r.recordBuffer())
+	// buffered so neither goroutine blocks once the other's error is returned
+	tsvErrChan := make(chan error, 2)
 
 	// begin reading from source
 	go func() {
-		var err error
-		for {
-			r.tsvRecord, err = r.tsvReader.ReadString(entryDelimiter)
-			if err != nil {
-				close(tsvRecordChan)
-				if err == io.EOF {
-					tsvErrChan <- nil
-				} else {
-					r.numProcessed++
-					tsvErrChan <- fmt.Errorf("read error on entry #%v: %v", r.numProcessed, err)
-				}
-				return
-			}
-			tsvRecordChan <- TSVConverter{
-				colSpecs:     r.colSpecs,
-				data:         r.tsvRecord,
-				index:        r.numProcessed,
-				ignoreBlanks: r.ignoreBlanks,
-				rejectWriter: r.tsvRejectWriter,
-			}
-			r.numProcessed++
-		}
+		defer close(tsvRecordChan)
+		tsvErrChan <- r.readConverters(readCtx, &r.StreamOptions, tsvRecordChan)
 	}()
 
 	// begin processing read bytes
 	go func() {
-		tsvErrChan <- streamDocuments(ordered, r.numDecoders, tsvRecordChan, readDocs)
+		tsvErrChan <- r.streamConverted(ctx, ordered, tsvRecordChan, sink)
 	}()
 
 	return channelQuorumError(tsvErrChan, 2)
 }
 
+// readConverters reads the records of the input, after any leading lines,
+// handing a Converter for each to records, wrapped and counted by opts, until
+// the input ends, opts is stopped, or ctx is done. It returns nil at the end
+// of the input or once stopped.
+func (r *TSVInputReader) readConverters(ctx context.Context, opts *StreamOptions, records chan<- Converter) error {
+	if err := r.skipLeadingLines(); err != nil {
+		return err
+	}
+	batcher := opts.newRecordBatcher()
+	var err error
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if opts.stopped() {
+			r.tsvRecord, err = "", io.EOF
+		} else {
+			r.tsvRecord, err = r.nextRecord()
+		}
+		if err != nil {
+			// the records read before the end or the failure are still
+			// converted
+			if batch, ok := batcher.flush(); ok {
+				if sendErr := opts.handOver(ctx, records, batch); sendErr != nil {
+					return sendErr
+				}
+			}
+			if err == io.EOF {
+				return nil
+			} else if _, ok := err.(RecordTooLargeError); ok {
+				return err
+			}
+			return fmt.Errorf("read error on entry #%v (line %v): %v", r.numProcessed+1, r.recordLine, err)
+		}
+		if opts.skipsRecord(r.numProcessed) {
+			atomic.AddUint64(&r.numProcessed, 1)
+			continue
+		}
+		converter, ready := batcher.add(opts.wrapConverter(TSVConverter{
+			colSpecs:     r.colSpecs,
+			data:         r.tsvRecord,
+			index:        r.numProcessed,
+			line:         r.recordLine,
+			rejectWriter: r.tsvRejectWriter,
+			delimiter:    r.delimiter,
+			whitespace:   r.WhitespaceDelimited,
+			quoted:       r.Quoted,
+			unescape:     r.Unescape,
+			rangeStart:   r.rangeStart,
+			options:      &r.ConvertOptions,
+		}, len(r.tsvRecord)), r.numProcessed)
+		if ready {
+			if err = opts.handOver(ctx, records, converter); err != nil {
+				return err
+			}
+		}
+		atomic.AddUint64(&r.numProcessed, 1)
+	}
+}
+
+// skipLeadingLines discards the first SkipLines lines of input, if they have
+// not been discarded already, and then detects the delimiter if
+// DetectDelimiter is set and it has not been detected already.
+func (r *TSVInputReader) skipLeadingLines() error {
+	for ; r.skippedLines < r.SkipLines; r.skippedLines++ {
+		line, err := r.readLine(maxRecordSize(r.MaxRecordSize))
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		if err == errLineTooLong {
+			err = RecordTooLargeError{r.lineNumber + 1, maxRecordSize(r.MaxRecordSize)}
+		}
+		if err != nil {
+			return skipLinesError(r.skippedLines, r.SkipLines, err)
+		}
+	}
+	if r.DetectDelimiter && !r.delimiterDetected {
+		guess, err := SniffDelimiter(r.tsvReader)
+		if err != nil {
+			return err
+		}
+		delimiter, err := confidentDelimiter(guess)
+		if err != nil {
+			return err
+		}
+		r.delimiter = delimiter
+		log.Logvf(log.Info, "detected the delimiter %q, with a confidence of %.2f", guess.Delimiter, guess.Confidence)
+		r.delimiterDetected = true
+	}
+	return nil
+}
+
+// readRecord reads the next record from the underlying reader, including its
+// line terminator, skipping comments and setting recordLine. A final record
+// that is not followed by a line terminator is returned without error; the
+// next call then returns io.EOF. In quoted mode, lines are accumulated until
+// every quoted cell in the record is closed. A record longer than
+// MaxRecordSize is a RecordTooLargeError.
+func (r *TSVInputReader) readRecord() (string, error) {
+	if r.Quoted && r.WhitespaceDelimited {
+		return "", fmt.Errorf("can not read quoted fields when splitting records on whitespace")
+	}
+	maxSize := maxRecordSize(r.MaxRecordSize)
+	r.recordLine = r.lineNumber + 1
+	record, err := r.readLine(maxSize)
+	for record != "" && r.isComment(record) {
+		if err != nil {
+			return "", err
+		}
+		r.recordLine = r.lineNumber + 1
+		record, err = r.readLine(maxSize)
+	}
+	for err == nil && r.Quoted {
+		if _, complete := splitQuotedRecord(record, r.delimiter); complete {
+			break
+		}
+		var line string
+		line, err = r.readLine(maxSize - len(record))
+		record += line
+	}
+	if err == errLineTooLong {
+		return "", RecordTooLargeError{r.recordLine, maxSize}
+	}
+	if err == io.EOF && record != "" {
+		if r.Quoted {
+			if _, complete := splitQuotedRecord(record, r.delimiter); !complete {
+				return "", ErrUnterminatedQuote
+			}
+		}
+		return record, nil
+	}
+	return record, err
+}
+
+// nextRecord returns the next record to stream: the first of the peeked
+// records if there are any, or the next one read.
+func (r *TSVInputReader) nextRecord() (string, error) {
+	if len(r.peeked) == 0 {
+		return r.readRecord()
+	}
+	peeked := r.peeked[0]
+	r.peeked = r.peeked[1:]
+	r.recordLine = peeked.line
+	return peeked.record, nil
+}
+
+// isComment reports whether the record beginning with line is a comment.
+func (r *TSVInputReader) isComment(line string) bool {
+	if r.CommentPrefix == "" {
+		return false
+	}
+	if r.CommentIndented {
+		line = strings.TrimLeft(line, " \t")
+	}
+	return strings.HasPrefix(line, r.CommentPrefix)
+}
+
+// readLine reads a single line from the underlying reader, including its
+// terminator. A line may be terminated by "\n", "\r\n" or a lone "\r", so
+// files with classic Mac or mixed line endings are split correctly. Once the
+// line is longer than maxSize, it stops reading and returns errLineTooLong.
+func (r *TSVInputReader) readLine(maxSize int) (string, error) {
+	var line []byte
+	for {
+		if _, err := r.tsvReader.Peek(1); err != nil {
+			if len(line) != 0 {
+				r.lineNumber++
+			}
+			return string(line), err
+		}
+		buf, _ := r.tsvReader.Peek(r.tsvReader.Buffered())
+		i := bytes.IndexAny(buf, "\r\n")
+		end := i + 1
+		if i == -1 {
+			end = len(buf)
+		}
+		if len(line)+end > maxSize {
+			return "", errLineTooLong
+		}
+		if i == -1 {
+			line = append(line, buf...)
+			r.tsvReader.Discard(len(buf))
+			continue
+		}
+		terminator := buf[i]
+		line = append(line, buf[:i+1]...)
+		r.tsvReader.Discard(i + 1)
+		if terminator == '\r' {
+			if next, err := r.tsvReader.Peek(1); err == nil && next[0] == '\n' {
+				line = append(line, '\n')
+				r.tsvReader.Discard(1)
+			}
+		}
+		r.lineNumber++
+		return string(line), nil
+	}
+}
+
+// splitRecord splits record into tokens as the reader is set to.
+func (r *TSVInputReader) splitRecord(record string) []string {
+	if r.WhitespaceDelimited {
+		return appendWhitespaceTokens(nil, strings.TrimRight(record, "\r\n"))
+	}
+	return splitTSVRecord(record, r.delimiter, r.Quoted)
+}
+
+// splitTSVRecord strips the line terminator from record and splits it into
+// tokens on delimiter, honoring quoted cells if quoted is set.
+func splitTSVRecord(record, delimiter string, quoted bool) []string {
+	return appendTSVTokens(nil, record, delimiter, quoted)
+}
+
+// appendTSVTokens is like splitTSVRecord, but appends the tokens to tokens,
+// so that a slice can be reused from record to record. Unquoted tokens are
+// substrings of record, so it allocates nothing if tokens has the room.
+func appendTSVTokens(tokens []string, record, delimiter string, quoted bool) []string {
+	record = strings.TrimRight(record, "\r\n")
+	if quoted {
+		quotedTokens, _ := splitQuotedRecord(record, delimiter)
+		return append(tokens, quotedTokens...)
+	}
+	for {
+		i := strings.Index(record, delimiter)
+		if i == -1 {
+			return append(tokens, record)
+		}
+		tokens = append(tokens, record[:i])
+		record = record[i+len(delimiter):]
+	}
+}
+
+// appendWhitespaceTokens appends the tokens of record, without its line
+// terminator, split on runs of spaces and tabs, to tokens, as for
+// WhitespaceDelimited.
+func appendWhitespaceTokens(tokens []string, record string) []string {
+	start := -1
+	for i := 0; i < len(record); i++ {
+		if record[i] == ' ' || record[i] == '\t' {
+			if start != -1 {
+				tokens = append(tokens, record[start:i])
+				start = -1
+			}
+		} else if start == -1 {
+			start = i
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, record[start:])
+	}
+	return tokens
+}
+
+// unescapeTSVToken decodes the backslash escapes \t, \n, \r and \\ in
+// token. Escapes are read from left to right, so "\\t" is a backslash
+// followed by a t. A backslash followed by anything else, or by nothing, is
+// kept as is. Token is returned unchanged if it has no backslash.
+func unescapeTSVToken(token string) string {
+	i := strings.IndexByte(token, '\\')
+	if i == -1 {
+		return token
+	}
+	buf := make([]byte, 0, len(token))
+	for ; i != -1; i = strings.IndexByte(token, '\\') {
+		buf = append(buf, token[:i]...)
+		if i+1 == len(token) {
+			token = token[i:]
+			break
+		}
+		switch token[i+1] {
+		case 't':
+			buf = append(buf, '\t')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case '\\':
+			buf = append(buf, '\\')
+		default:
+			buf = append(buf, token[i:i+2]...)
+		}
+		token = token[i+2:]
+	}
+	return string(append(buf, token...))
+}
+
+// tsvTokenPool holds the token slices that the decoding goroutines split
+// records into, as pointers so that putting them back does not allocate.
+var tsvTokenPool = sync.Pool{
+	New: func() interface{} {
+		return new([]string)
+	},
+}
+
+// splitQuotedRecord splits record into tokens on delimiter. A token that
+// begins with a double quote extends to the matching closing quote, so it can
+// contain the delimiter and newlines, and a doubled quote inside it is read as
+// a literal quote. Any text between a closing quote and the next delimiter is
+// kept as is. Quotes that do not begin a token have no special meaning. The
+// returned bool is false if record ends inside a quoted token.
+func splitQuotedRecord(record, delimiter string) (tokens []string, complete bool) {
+	var token bytes.Buffer
+	for {
+		if len(record) > 0 && record[0] == quoteCharacter {
+			record = record[1:]
+			for {
+				i := strings.IndexByte(record, quoteCharacter)
+				if i == -1 {
+					token.WriteString(record)
+					return append(tokens, token.String()), false
+				}
+				token.WriteString(record[:i])
+				record = record[i+1:]
+				if len(record) == 0 || record[0] != quoteCharacter {
+					break
+				}
+				// doubled quote
+				token.WriteByte(quoteCharacter)
+				record = record[1:]
+			}
+		}
+		i := strings.Index(record, delimiter)
+		if i == -1 {
+			token.WriteString(record)
+			return append(tokens, token.String()), true
+		}
+		token.WriteString(record[:i])
+		tokens = append(tokens, token.String())
+		token.Reset()
+		record = record[i+len(delimiter):]
+	}
+}
+
 // Convert implements the Converter interface for TSV input. It converts a
 // TSVConverter struct to a BSON document.
-func (c TSVConverter) Convert() (b bson.D, err error) {
-	b, err = tokensToBSON(
-		c.colSpecs,
-		strings.Split(strings.TrimRight(c.data, "\r\n"), tokenSeparator),
-		c.index,
-		c.ignoreBlanks,
-	)
+func (c TSVConverter) Convert() (bson.D, error) {
+	tokens := c.tokens()
+	b, err := tokensToBSON(c.colSpecs, *tokens, c.index, c.options)
+	tsvTokenPool.Put(tokens)
+	return b, c.conversionError(err)
+}
+
+// ConvertRaw implements the RawConverter interface for TSV input, building
+// the BSON of the document without a bson.D.
+func (c TSVConverter) ConvertRaw() ([]byte, error) {
+	tokens := c.tokens()
+	raw, err := tokensToRaw(c.colSpecs, *tokens, c.index, c.options)
+	tsvTokenPool.Put(tokens)
+	return raw, c.conversionError(err)
+}
+
+// tokens splits the record into its tokens. The tokens are not kept once the
+// document is built, so their slice, which goes back to tsvTokenPool, is
+// reused; the strings, which the document may keep, are not.
+func (c TSVConverter) tokens() *[]string {
+	tokens := tsvTokenPool.Get().(*[]string)
+	if c.whitespace {
+		*tokens = appendWhitespaceTokens((*tokens)[:0], strings.TrimRight(c.data, "\r\n"))
+	} else {
+		*tokens = appendTSVTokens((*tokens)[:0], c.data, c.tokenDelimiter(), c.quoted)
+	}
+	if c.unescape {
+		for i, token := range *tokens {
+			(*tokens)[i] = unescapeTSVToken(token)
+		}
+	}
+	return tokens
+}
+
+// conversionError returns nil for a row that was skipped, once it is
+// printed, and otherwise err with the position of the record.
+func (c TSVConverter) conversionError(err error) error {
 	if _, ok := err.(coercionError); ok {
-		c.Print()
-		err = nil
+		// a skipped row is printed unless its columns are anonymized
+		if c.options.quotesRecords() {
+			c.Print()
+		}
+		return nil
+	} else if err != nil {
+		err = c.options.recordError(c.index+1, c.line, c.data, err)
+		if c.rangeStart != 0 {
+			err = RangeError{c.rangeStart, err}
+		}
+		return err
 	}
-	return
+	return nil
+}
+
+// tokenDelimiter returns the delimiter the converter splits records on,
+// falling back to a tab for converters constructed without one.
+func (c TSVConverter) tokenDelimiter() string {
+	if c.delimiter == "" {
+		return tokenSeparator
+	}
+	return c.delimiter
+}
+
+// rawRecord returns the record as read, including its line terminator.
+func (c TSVConverter) rawRecord() []byte {
+	return []byte(c.data)
 }
 
 func (c TSVConverter) Print() {
-	c.rejectWriter.Write([]byte(c.data + "\n"))
+	// the record keeps its line terminator, which only a last line lacks
+	record := c.rawRecord()
+	if !strings.HasSuffix(c.data, "\n") {
+		record = append(record, '\n')
+	}
+	c.rejectWriter.Write(record)
 }
@@ -8,9 +8,18 @@ package mongoimport
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/mongodb/mongo-tools/common/log"
+	"github.com/mongodb/mongo-tools/common/options"
 	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/mgo.v2/bson"
@@ -57,6 +66,63 @@ func TestTSVStreamDocument(t *testing.T) {
 			So(<-docChan, ShouldResemble, expectedRead)
 		})
 
+		Convey("a UTF-8 BOM should be stripped from the header line and "+
+			"counted towards the bytes read", func() {
+			fileHandle, err := os.Open("testdata/test_bom_header.tsv")
+			So(err, ShouldBeNil)
+			r := NewTSVInputReader(nil, fileHandle, os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"a", "b", "c"})
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{
+				{"a", int32(1)},
+				{"b", int32(2)},
+				{"c", int32(3)},
+			})
+			So(r.Size(), ShouldEqual, 15)
+		})
+
+		Convey("BOM bytes that are not at the start of the input should be "+
+			"preserved", func() {
+			contents := "x\xEF\xBB\xBFy\t2\n\xEF\xBB\xBF3\t4\n"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", "x\ufeffy"}, {"b", int32(2)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", "\ufeff3"}, {"b", int32(4)}})
+		})
+
+		Convey("gzip-compressed input should be decompressed and its "+
+			"compressed size tracked", func() {
+			compressed := gzipBytes([]byte("a\tb\n1\t2\n3\t4\n"))
+			r := NewTSVInputReader(nil, bytes.NewReader(compressed), os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"a", "b"})
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", int32(2)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}, {"b", int32(4)}})
+			So(r.Size(), ShouldEqual, len(compressed))
+		})
+
+		Convey("truncated gzip-compressed input should be reported", func() {
+			compressed := gzipBytes([]byte("1\t2\n3\t4\n5\t6\n"))
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader(compressed[:len(compressed)-6]), os.Stdout, 1, false)
+			docChan := make(chan bson.D, 3)
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, ErrTruncatedGzip.Error())
+		})
+
 		Convey("integer valued strings should be converted tsv2", func() {
 			contents := "a\tb\t\"cccc,cccc\"\td\n"
 			colSpecs := []ColumnSpec{
@@ -170,6 +236,152 @@ func TestTSVStreamDocument(t *testing.T) {
 			So(<-docChan, ShouldResemble, expectedReadTwo)
 		})
 
+		Convey("a custom delimiter should be used to split records", func() {
+			contents := "1|2\t3|x y\n"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"c", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			expectedRead := bson.D{
+				{"a", int32(1)},
+				{"b", "2\t3"},
+				{"c", "x y"},
+			}
+			r := NewDelimitedInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false, "|")
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, expectedRead)
+		})
+
+		Convey("a custom delimiter inside quotes should still separate tokens", func() {
+			contents := "1;\"a;b\"\n"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			expectedRead := bson.D{
+				{"a", int32(1)},
+				{"b", `"a`},
+				{"field2", `b"`},
+			}
+			r := NewDelimitedInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false, ";")
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, expectedRead)
+		})
+
+		Convey("quoted cells with embedded newlines should be read as one "+
+			"record in quoted mode", func() {
+			fileHandle, err := os.Open("testdata/test_multiline.tsv")
+			So(err, ShouldBeNil)
+			r := NewTSVInputReader(nil, fileHandle, os.Stdout, 1, false)
+			r.Quoted = true
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"name", "address", "zip"})
+			docChan := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{
+				{"name", "Acme"},
+				{"address", "1 Main St\r\nSuite 200"},
+				{"zip", int32(2134)},
+			})
+			So(<-docChan, ShouldResemble, bson.D{
+				{"name", `Bob "The Builder"`},
+				{"address", "12 Elm\tRd"},
+				{"zip", int32(10001)},
+			})
+			So(<-docChan, ShouldResemble, bson.D{
+				{"name", "Carol"},
+				{"address", "PO Box 7\r\n\r\nAttn: \"Mail\""},
+				{"zip", int32(94105)},
+			})
+		})
+
+		Convey("a quoted cell that is never closed should error in quoted mode", func() {
+			contents := "1\t\"abc\n2\t3\n"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.Quoted = true
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldNotBeNil)
+		})
+
+		Convey("a final record without a trailing newline should be imported", func() {
+			contents := "1\t2\n3\t4"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", int32(2)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}, {"b", int32(4)}})
+		})
+
+		Convey("CR-only and mixed line endings should all terminate records", func() {
+			contents := "a\tb\r\n1\t2\r3\t4\n5\t6\r\n\r7\t8"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"a", "b"})
+			docChan := make(chan bson.D, 5)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", int32(2)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(3)}, {"b", int32(4)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(5)}, {"b", int32(6)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", ""}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(7)}, {"b", int32(8)}})
+			So(r.numProcessed, ShouldEqual, 5)
+		})
+
+		Convey("numeric inference should be disabled per reader with StringsOnly", func() {
+			contents := "007\t1e5\n"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.StringsOnly = true
+			other := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", "007"}, {"b", "1e5"}})
+			otherDocChan := make(chan bson.D, 1)
+			So(other.StreamDocument(true, otherDocChan), ShouldBeNil)
+			So(<-otherDocChan, ShouldResemble, bson.D{{"a", int32(7)}, {"b", float64(100000)}})
+		})
+
+		Convey("with ignoreBlanks set, empty cells should be omitted while "+
+			"whitespace-only cells are kept", func() {
+			contents := "1\t\t \t\n"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"c", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"d", new(FieldInt32Parser), pgStop, "int32"},
+			}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, true)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"c", " "}})
+		})
+
+		Convey("without ignoreBlanks set, an empty cell in a typed column "+
+			"should fail to parse rather than become a zero", func() {
+			contents := "1\t\n"
+			colSpecs := []ColumnSpec{
+				{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+				{"b", new(FieldInt32Parser), pgStop, "int32"},
+			}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldNotBeNil)
+		})
+
 		Convey("plain TSV input file sources should be parsed correctly and "+
 			"subsequent imports should parse correctly",
 			func() {
@@ -209,6 +421,378 @@ func TestTSVReadAndValidateHeader(t *testing.T) {
 			So(r.ReadAndValidateHeader(), ShouldBeNil)
 			So(len(r.colSpecs), ShouldEqual, 3)
 		})
+		Convey("setting a typed header should parse each field's type", func() {
+			contents := "zip.string\tage.int32\tname\n01234\t42\tx\n01234\tN/A\tx\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"zip", "age", "name"})
+			docChan := make(chan bson.D, 2)
+			err := r.StreamDocument(true, docChan)
+			So(<-docChan, ShouldResemble, bson.D{
+				{"zip", "01234"},
+				{"age", int32(42)},
+				{"name", "x"},
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "'age'")
+		})
+		Convey("setting a header with duplicate fields should fail", func() {
+			contents := "id\tname\tid\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			err := r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "duplicate field names: 'id' (columns 1, 3)")
+		})
+		Convey("setting a header with illegal field names should sanitize them if requested", func() {
+			contents := "$id\ta..b\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			err := r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "column 1: ")
+			r = NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.SanitizeFields = true
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"_id", "a._.b"})
+		})
+		Convey("setting the header should normalize field names before checking for duplicates", func() {
+			contents := " Order  ID \tORDER_ID\tid\tID \n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.TrimFieldNames = true
+			r.UnderscoreFieldNames = true
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"Order_ID", "ORDER_ID", "id", "ID"})
+			So(r.OriginalHeader(), ShouldResemble, []string{" Order  ID ", "ORDER_ID", "id", "ID "})
+
+			r = NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.TrimFieldNames = true
+			r.LowercaseFieldNames = true
+			r.UnderscoreFieldNames = true
+			err := r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "duplicate field names: 'order_id' (columns 1, 2); 'id' (columns 3, 4)")
+		})
+		Convey("each normalization should be applied independently", func() {
+			contents := " Order ID \n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.UnderscoreFieldNames = true
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"_Order_ID_"})
+			r = NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.LowercaseFieldNames = true
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{" order id "})
+		})
+		Convey("leading lines should be skipped before the header", func() {
+			banner := strings.Repeat("=", 8192)
+			contents := "Report generated 2024-01-03\r\n" + banner + "\n\nname\tprice.double()\nwidget\tN/A\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.SkipLines = 3
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"name", "price"})
+			err := r.StreamDocument(true, make(chan bson.D, 1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #1 (line 5): ")
+			So(r.Size(), ShouldEqual, len(contents))
+		})
+		Convey("leading lines should be skipped before the first record without a header", func() {
+			colSpecs := []ColumnSpec{{"a", new(FieldAutoParser), pgAutoCast, "auto"}}
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte("banner\n1\n")), os.Stdout, 1, false)
+			r.SkipLines = 1
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}})
+			So(len(docChan), ShouldEqual, 0)
+		})
+		Convey("an input with fewer lines than are to be skipped should fail", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte("banner\nno newline")), os.Stdout, 1, false)
+			r.SkipLines = 3
+			err := r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "input ended after 2 of the 3 lines to skip")
+		})
+		Convey("comment records should be skipped but still counted as lines", func() {
+			contents := "# exported 2024-01-03\nname\tprice.double()\n#note\n  # indented\nwidget\tN/A\n# trailing"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.CommentPrefix = "#"
+			r.CommentIndented = true
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"name", "price"})
+			err := r.StreamDocument(true, make(chan bson.D, 1))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #1 (line 5): ")
+
+			Convey("unless they are indented and CommentIndented is not set", func() {
+				r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+				r.CommentPrefix = "#"
+				So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+				docChan := make(chan bson.D, 2)
+				err := r.StreamDocument(true, docChan)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "record #2 (line 5): ")
+				So(<-docChan, ShouldResemble, bson.D{{"name", "  # indented"}})
+			})
+		})
+		Convey("comment prefixes inside quoted multi-line cells should be ignored", func() {
+			contents := "a\tb\n\"first\n# not a comment\"\t1\n#comment\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.Quoted = true
+			r.CommentPrefix = "#"
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", "first\n# not a comment"}, {"b", int32(1)}})
+			So(len(docChan), ShouldEqual, 0)
+		})
+		Convey("an empty comment prefix should disable comments", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte("a\n#1\n")), os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", "#1"}})
+		})
+		Convey("setting the header should honor a custom delimiter", func() {
+			contents := "a||b||c\n"
+			r := NewDelimitedInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false, "||")
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(ColumnNames(r.colSpecs), ShouldResemble, []string{"a", "b", "c"})
+		})
+		Convey("a multi-character delimiter should split records only where it appears in full", func() {
+			// the delimiter's characters on their own, or overlapping the
+			// end of it, are data, and a record that ends in the delimiter
+			// has an empty last field
+			contents := "a~|~b~|~c\n1~|~x|y~~|~\n~~|~|~|~~\n"
+			for _, quoted := range []bool{false, true} {
+				r := NewDelimitedInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false, "~|~")
+				r.Quoted = quoted
+				So(r.ReadAndValidateHeader(), ShouldBeNil)
+				So(ColumnNames(r.colSpecs), ShouldResemble, []string{"a", "b", "c"})
+				docChan := make(chan bson.D, 2)
+				So(r.StreamDocument(true, docChan), ShouldBeNil)
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", "x|y~"}, {"c", ""}})
+				So(<-docChan, ShouldResemble, bson.D{{"a", "~"}, {"b", "|"}, {"c", "~"}})
+			}
+		})
+		Convey("a quoted cell should hold a multi-character delimiter", func() {
+			r := NewDelimitedInputReader(nil, bytes.NewReader([]byte("a||b\n\"1||2\"||3\n")), os.Stdout, 1, false, "||")
+			r.Quoted = true
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", "1||2"}, {"b", int32(3)}})
+		})
+		Convey("conversion errors should name the 1-based line, counting the header, "+
+			"and quote the record", func() {
+			contents := "name\tprice.double()\nwidget\t2.5\ngadget\tN/A\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			err := r.StreamDocument(true, make(chan bson.D, 2))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #2 (line 3): field 'price' (column 2): cannot parse 'N/A' as double: ")
+			So(err.Error(), ShouldEndWith, ": gadget\tN/A")
+			conversionErr, ok := AsFieldConversionError(err)
+			So(ok, ShouldBeTrue)
+			So(conversionErr.Field, ShouldEqual, "price")
+			So(conversionErr.Column, ShouldEqual, 2)
+			So(conversionErr.Value, ShouldEqual, "N/A")
+			So(conversionErr.Type, ShouldEqual, "double")
+			So(ErrorCategory(err), ShouldEqual, FailureType)
+		})
+		Convey("conversion errors should count every line of a quoted record", func() {
+			contents := "name\tprice.double()\n\"multi\nline\"\t1\ngadget\tN/A\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.Quoted = true
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			err := r.StreamDocument(true, make(chan bson.D, 2))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #2 (line 4): ")
+		})
+		Convey("setting colliding nested headers should raise an error", func() {
+			contents := "a\ta.b\tc\n1\t2\t3\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldNotBeNil)
+		})
+		Convey("dotted headers should produce nested documents", func() {
+			contents := "address.city\taddress.zip\nDublin\t02134\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			doc := <-docChan
+			So(len(doc), ShouldEqual, 1)
+			So(doc[0].Name, ShouldEqual, "address")
+			So(*doc[0].Value.(*bson.D), ShouldResemble, bson.D{{"city", "Dublin"}, {"zip", int32(2134)}})
+		})
+		Convey("projected fields should be the only ones converted", func() {
+			contents := "id.int32()\tname\taddress.city.auto()\taddress.zip.string()\tsecret.int32()\n" +
+				"1\tAnn\tDublin\t02134\tnot a number\textra\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.ProjectFields = []string{"address", "id"}
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			So(r.DroppedColumns(), ShouldEqual, 2)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			doc := <-docChan
+			So(doc[0], ShouldResemble, bson.DocElem{"id", int32(1)})
+			So(doc[1].Name, ShouldEqual, "address")
+			So(*doc[1].Value.(*bson.D), ShouldResemble, bson.D{{"city", "Dublin"}, {"zip", "02134"}})
+			So(len(doc), ShouldEqual, 2)
+		})
+		Convey("excluded fields should be left out, with the columns nested under them", func() {
+			contents := "id\tssn\taddress.city\taddress.zip\tname\n1\t123-45-6789\tDublin\t02134\tAnn\textra\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.ExcludeFields = []string{"ssn", "address"}
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(r.DroppedColumns(), ShouldEqual, 3)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"id", int32(1)}, {"name", "Ann"}, {"field5", "extra"}})
+		})
+		Convey("excluded fields should be checked against the header and the projection", func() {
+			contents := "id\tssn\taddress.city\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.ExcludeFields = []string{"snn"}
+			err := r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "excluded field 'snn' is not in the header")
+
+			r = NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.ProjectFields = []string{"id", "address.city"}
+			r.ExcludeFields = []string{"address"}
+			err = r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "field 'address.city' is projected but 'address' is excluded")
+		})
+		Convey("renamed fields should be given their new names before nesting", func() {
+			contents := "Cust ID\tcity\tzip\tother name\n7\tDublin\t02134\tx\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.RenameFields = map[string]string{"Cust ID": "customerId", "city": "address.city", "zip": "address.zip"}
+			r.UnderscoreFieldNames = true
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"customerId", "address.city", "address.zip", "other_name"})
+			So(r.OriginalHeader(), ShouldResemble, []string{"Cust ID", "city", "zip", "other name"})
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			doc := <-docChan
+			So(doc[0], ShouldResemble, bson.DocElem{"customerId", int32(7)})
+			So(*doc[1].Value.(*bson.D), ShouldResemble, bson.D{{"city", "Dublin"}, {"zip", int32(2134)}})
+		})
+		Convey("renamed fields should be validated", func() {
+			contents := "a\tb\tc\n"
+			for renames, expected := range map[string]string{
+				"a=x,b=x": "fields 'a' and 'b' are both renamed to 'x'",
+				"a=c":     "duplicate field names: 'c' (columns 1, 3)",
+				"d=x":     "renamed field 'd' is not in the header",
+				"a=$x":    "column 1: field '$x' cannot start with a '$'",
+			} {
+				imp := &MongoImport{InputOptions: &InputOptions{RenameFields: renames}, IngestOptions: &IngestOptions{}}
+				r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+				r.RenameFields = imp.convertOptions().RenameFields
+				err := r.ReadAndValidateHeader()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, expected)
+			}
+		})
+		Convey("the _id should be built from the id fields", func() {
+			contents := "region\torder_no\tqty\nEU\t007\t3\n"
+
+			Convey("as an embedded document, leaving out the fields", func() {
+				r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+				r.IDFields = []string{"region", "order_no"}
+				So(r.ReadAndValidateHeader(), ShouldBeNil)
+				So(r.Fields(), ShouldResemble, []string{"_id", "qty"})
+				docChan := make(chan bson.D, 1)
+				So(r.StreamDocument(true, docChan), ShouldBeNil)
+				So(<-docChan, ShouldResemble, bson.D{
+					{"_id", bson.D{{"region", "EU"}, {"order_no", int32(7)}}},
+					{"qty", int32(3)},
+				})
+			})
+			Convey("as a string of the tokens, keeping the fields", func() {
+				r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+				r.IDFields = []string{"region", "order_no"}
+				r.IDSeparator = "-"
+				r.KeepIDFields = true
+				So(r.ReadAndValidateHeader(), ShouldBeNil)
+				So(r.Fields(), ShouldResemble, []string{"_id", "region", "order_no", "qty"})
+				docChan := make(chan bson.D, 1)
+				So(r.StreamDocument(true, docChan), ShouldBeNil)
+				So(<-docChan, ShouldResemble, bson.D{
+					{"_id", "EU-007"},
+					{"region", "EU"},
+					{"order_no", int32(7)},
+					{"qty", int32(3)},
+				})
+			})
+		})
+		Convey("blank id fields should be an error even with ignoreBlanks", func() {
+			for contents, expected := range map[string]string{
+				"region\torder_no\n\t7\n":  "_id field 'region' is missing",
+				"region\torder_no\nEU\n":   "_id field 'order_no' is blank",
+				"region\torder_no\nEU\t\n": "_id field 'order_no' is missing",
+			} {
+				r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, true)
+				r.IDFields = []string{"region", "order_no"}
+				r.ShortRows = RaggedPadWithNull
+				So(r.ReadAndValidateHeader(), ShouldBeNil)
+				err := r.StreamDocument(true, make(chan bson.D, 1))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, expected)
+			}
+		})
+		Convey("id fields should be checked against the header", func() {
+			contents := "region\torder_no\t_id\n"
+			for fields, expected := range map[string]string{
+				"region,order":    "_id field 'order' is not in the header",
+				"region,region":   "_id field 'region' is given more than once",
+				"region,order_no": "can not build the _id from fields when field '_id' is imported",
+			} {
+				r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+				r.IDFields = strings.Split(fields, ",")
+				if fields != "region,order_no" {
+					r.ExcludeFields = []string{"_id"}
+				}
+				err := r.ReadAndValidateHeader()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, expected)
+			}
+
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.IDFields = []string{"region", "order_no"}
+			r.ExcludeFields = []string{"_id", "order_no"}
+			err := r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "_id field 'order_no' is not imported")
+		})
+		Convey("projected fields should be checked against the header", func() {
+			contents := "id\tname\taddress.city\n"
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.ProjectFields = []string{"id", "addr"}
+			err := r.ReadAndValidateHeader()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "projected field 'addr' is not in the header")
+		})
+	})
+}
+
+func TestSplitQuotedRecord(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Splitting a quoted TSV record", t, func() {
+		Convey("should treat quotes that do not begin a token literally", func() {
+			tokens, complete := splitQuotedRecord("a\"b\t\"c\"d", "\t")
+			So(complete, ShouldBeTrue)
+			So(tokens, ShouldResemble, []string{`a"b`, "cd"})
+		})
+		Convey("should unescape doubled quotes", func() {
+			tokens, complete := splitQuotedRecord(`"""x"""`+"\t"+`""`, "\t")
+			So(complete, ShouldBeTrue)
+			So(tokens, ShouldResemble, []string{`"x"`, ""})
+		})
+		Convey("should report records that end inside a quoted token", func() {
+			_, complete := splitQuotedRecord("a\t\"b\n", "\t")
+			So(complete, ShouldBeFalse)
+			_, complete = splitQuotedRecord("a\t\"b\"\"", "\t")
+			So(complete, ShouldBeFalse)
+		})
 	})
 }
 
@@ -236,3 +820,919 @@ func TestTSVConvert(t *testing.T) {
 		})
 	})
 }
+
+// gzipBytes returns data compressed with gzip.
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// endlessTSVReader is an io.Reader that yields the same TSV record forever.
+type endlessTSVReader struct{}
+
+func (endlessTSVReader) Read(p []byte) (int, error) {
+	const record = "1\t2\t3\n"
+	for i := range p {
+		p[i] = record[i%len(record)]
+	}
+	return len(p) - len(p)%len(record), nil
+}
+
+func TestTSVStreamDocumentContext(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader over an endless input source", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+			{"c", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+
+		for _, ordered := range []bool{true, false} {
+			ordered := ordered
+			Convey(fmt.Sprintf("cancelling the context should stop streaming promptly (ordered: %v)", ordered), func() {
+				r := NewTSVInputReader(colSpecs, endlessTSVReader{}, os.Stdout, 4, false)
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				start := time.Now()
+				// nothing receives from docChan, so the decoders block once it is full
+				err := r.StreamDocumentContext(ctx, ordered, make(chan bson.D, 1))
+				So(err, ShouldEqual, context.Canceled)
+				So(time.Since(start), ShouldBeLessThan, time.Second)
+			})
+
+			Convey(fmt.Sprintf("cancelling mid-stream should close the document channel (ordered: %v)", ordered), func() {
+				r := NewTSVInputReader(colSpecs, endlessTSVReader{}, os.Stdout, 4, false)
+				ctx, cancel := context.WithCancel(context.Background())
+				docChan := make(chan bson.D)
+				errChan := make(chan error)
+				go func() {
+					errChan <- r.StreamDocumentContext(ctx, ordered, docChan)
+				}()
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", int32(2)}, {"c", int32(3)}})
+				cancel()
+				select {
+				case err := <-errChan:
+					So(err, ShouldEqual, context.Canceled)
+				case <-time.After(5 * time.Second):
+					So("StreamDocumentContext did not return", ShouldBeEmpty)
+				}
+				for range docChan {
+				}
+			})
+		}
+	})
+}
+
+func TestTSVNumProcessed(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+
+		Convey("the records processed should be readable while streaming", func() {
+			var lines []string
+			for i := 0; i < 1000; i++ {
+				lines = append(lines, fmt.Sprint(i))
+			}
+			r := NewTSVInputReader(colSpecs, strings.NewReader(strings.Join(lines, "\n")+"\n"), os.Stdout, 4, false)
+			r.SkipRecords = 10
+			readDocs := make(chan bson.D)
+			errChan := make(chan error)
+			go func() {
+				errChan <- r.StreamDocument(false, readDocs)
+			}()
+			var last uint64
+			for range readDocs {
+				numProcessed := atomic.LoadUint64(&r.numProcessed)
+				So(numProcessed, ShouldBeGreaterThanOrEqualTo, last)
+				last = numProcessed
+			}
+			So(<-errChan, ShouldBeNil)
+			So(atomic.LoadUint64(&r.numProcessed), ShouldEqual, 1000)
+		})
+
+		Convey("a read error should give the number and line of the record that failed", func() {
+			data := []byte("1\n2\n3\n")
+			in := &flakyReader{data: data, failAfter: len(data)}
+			r := NewTSVInputReader(colSpecs, in, os.Stdout, 1, false)
+			err := r.StreamDocument(true, make(chan bson.D, 3))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "read error on entry #4 (line 4): ")
+			So(r.numProcessed, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestTSVAccessors(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader with a header", t, func() {
+		r := NewTSVInputReader(nil, strings.NewReader("a\tb\n1\t2\n3\t4\n5\t6\n"), os.Stdout, 2, false)
+		So(r.Fields(), ShouldBeEmpty)
+		So(r.ReadAndValidateHeader(), ShouldBeNil)
+
+		Convey("Fields should return a copy of the fields parsed from the header", func() {
+			fields := r.Fields()
+			So(fields, ShouldResemble, []string{"a", "b"})
+			fields[0] = "changed"
+			So(r.Fields(), ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("NumProcessed should count the records read after the header, including those skipped", func() {
+			var inputReader InputReader = r
+			r.SkipRecords = 1
+			So(inputReader.NumProcessed(), ShouldEqual, 0)
+			docChan := make(chan bson.D, 3)
+			So(inputReader.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 2)
+			So(inputReader.NumProcessed(), ShouldEqual, 3)
+		})
+	})
+
+	Convey("A JSON input reader should have no fields, but count its documents", t, func() {
+		var r InputReader = NewJSONInputReader(false, strings.NewReader(`{"a": 1}{"a": 2}`), 1)
+		So(r.ReadAndValidateHeader(), ShouldBeNil)
+		So(r.Fields(), ShouldBeNil)
+		So(r.StreamDocument(true, make(chan bson.D, 2)), ShouldBeNil)
+		So(r.NumProcessed(), ShouldEqual, 2)
+	})
+}
+
+func TestTSVStop(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader", t, func() {
+		colSpecs := []ColumnSpec{{"n", new(FieldAutoParser), pgAutoCast, "auto"}}
+		var buf bytes.Buffer
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(&buf, "%d\n", i)
+		}
+		input := buf.String()
+
+		// stream streams the input, skipping skip records, and stops once
+		// stopAfter documents have been received, returning the documents
+		// and what Stop returned
+		stream := func(skip uint64, stopAfter int, ordered bool) ([]bson.D, uint64) {
+			r := NewTSVInputReader(colSpecs, strings.NewReader(input), os.Stdout, 4, false)
+			r.SkipRecords = skip
+			docChan := make(chan bson.D)
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- r.StreamDocument(ordered, docChan)
+			}()
+			var docs []bson.D
+			stopped := make(chan uint64, 1)
+			for doc := range docChan {
+				docs = append(docs, doc)
+				if len(docs) == stopAfter {
+					go func() { stopped <- r.Stop() }()
+				}
+			}
+			So(<-errChan, ShouldBeNil)
+			if stopAfter == 0 || len(docs) < stopAfter {
+				return docs, r.Stop()
+			}
+			return docs, <-stopped
+		}
+
+		for _, ordered := range []bool{true, false} {
+			ordered := ordered
+			Convey(fmt.Sprintf("Stop should flush what was read and say where to resume (ordered: %v)", ordered), func() {
+				docs, resume := stream(0, 10, ordered)
+				So(len(docs), ShouldBeGreaterThanOrEqualTo, 10)
+				So(len(docs), ShouldBeLessThan, 1000)
+				So(uint64(len(docs)), ShouldEqual, resume)
+				rest, end := stream(resume, 0, ordered)
+				So(end, ShouldEqual, 1000)
+
+				seen := make(map[int32]bool)
+				for _, doc := range append(docs, rest...) {
+					n := doc[0].Value.(int32)
+					So(seen[n], ShouldBeFalse)
+					seen[n] = true
+				}
+				So(len(seen), ShouldEqual, 1000)
+				if ordered {
+					So(docs[len(docs)-1][0].Value, ShouldEqual, int32(resume-1))
+					So(rest[0][0].Value, ShouldEqual, int32(resume))
+				}
+			})
+		}
+
+		Convey("Stop should be a no-op once called, or after the input ends", func() {
+			r := NewTSVInputReader(colSpecs, strings.NewReader(input), os.Stdout, 4, false)
+			docChan := make(chan bson.D, 1000)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 1000)
+			So(r.Stop(), ShouldEqual, 1000)
+			So(r.Stop(), ShouldEqual, 1000)
+		})
+
+		Convey("Stop before streaming should leave nothing to read", func() {
+			r := NewTSVInputReader(colSpecs, strings.NewReader(input), os.Stdout, 4, false)
+			So(r.Stop(), ShouldEqual, 0)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			_, open := <-docChan
+			So(open, ShouldBeFalse)
+			So(r.Stop(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestTSVStreamDocumentAbandonedOutput(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader whose document channel is abandoned", t, func() {
+		// the failing record is close enough to the start to be decoded while
+		// the first documents wait to be received
+		var buf bytes.Buffer
+		for i := 0; i < 1000; i++ {
+			if i == 2 {
+				buf.WriteString("N/A\tx\n")
+			}
+			fmt.Fprintf(&buf, "%d\tx\n", i)
+		}
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgStop, "int32"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+
+		for _, ordered := range []bool{true, false} {
+			ordered := ordered
+			Convey(fmt.Sprintf("a later conversion failure should still be returned (ordered: %v)", ordered), func() {
+				r := NewTSVInputReader(colSpecs, bytes.NewReader(buf.Bytes()), os.Stdout, 4, false)
+				docChan := make(chan bson.D)
+				errChan := make(chan error, 1)
+				go func() {
+					errChan <- r.StreamDocument(ordered, docChan)
+				}()
+				<-docChan // read one document, then stop receiving
+				select {
+				case err := <-errChan:
+					So(err, ShouldNotBeNil)
+					So(err.Error(), ShouldContainSubstring, "N/A")
+				case <-time.After(5 * time.Second):
+					So("StreamDocument did not return", ShouldBeEmpty)
+				}
+			})
+		}
+	})
+}
+
+func TestTSVStreamDocumentRejects(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader writing records that fail to convert to a rejects file", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgStop, "int32"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+		var buf bytes.Buffer
+		for i := 0; i < 100; i++ {
+			if i%10 == 5 {
+				fmt.Fprintf(&buf, "N/A\t%d\r\n", i)
+			} else {
+				fmt.Fprintf(&buf, "%d\tx\r\n", i)
+			}
+		}
+
+		for _, ordered := range []bool{true, false} {
+			ordered := ordered
+			Convey(fmt.Sprintf("bad records should be written verbatim and the rest streamed (ordered: %v)", ordered), func() {
+				var rejects bytes.Buffer
+				r := NewTSVInputReader(colSpecs, bytes.NewReader(buf.Bytes()), os.Stdout, 4, false)
+				r.Rejects = &rejects
+				docChan := make(chan bson.D, 100)
+				So(r.StreamDocument(ordered, docChan), ShouldBeNil)
+				numDocs := 0
+				for range docChan {
+					numDocs++
+				}
+				So(numDocs, ShouldEqual, 90)
+				So(r.Rejected(), ShouldEqual, 10)
+
+				// concurrent writes must not interleave within a record
+				rejected := strings.SplitAfter(rejects.String(), "\r\n")
+				So(rejected[len(rejected)-1], ShouldEqual, "")
+				rejected = rejected[:len(rejected)-1]
+				So(len(rejected), ShouldEqual, 10)
+				for _, record := range rejected {
+					So(record, ShouldStartWith, "N/A\t")
+					So(record, ShouldEndWith, "5\r\n")
+				}
+				if ordered {
+					So(rejected[0], ShouldEqual, "N/A\t5\r\n")
+				}
+			})
+		}
+	})
+	Convey("With a TSV input reader skipping rows that fail to convert", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgSkipRow, "int32"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+		Convey("each skipped row should be written as it was read", func() {
+			var skipped bytes.Buffer
+			contents := "1\tx\nN/A\ty\n2\tz\r\nN/A\tw\r\nN/A\tv"
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), &skipped, 1, false)
+			docChan := make(chan bson.D, 5)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 2)
+			So(skipped.String(), ShouldEqual, "N/A\ty\nN/A\tw\r\nN/A\tv\n")
+		})
+	})
+}
+
+func TestTSVStreamDocumentMaxErrors(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that tolerates conversion failures", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgStop, "int32"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+		var buf bytes.Buffer
+		for i := 0; i < 100; i++ {
+			if i%10 == 5 {
+				buf.WriteString("N/A\tx\n")
+			} else {
+				fmt.Fprintf(&buf, "%d\tx\n", i)
+			}
+		}
+		stream := func(ordered bool, maxErrors int) (int, error) {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader(buf.Bytes()), os.Stdout, 8, false)
+			r.MaxErrors = maxErrors
+			docChan := make(chan bson.D, 100)
+			err := r.StreamDocument(ordered, docChan)
+			return len(docChan), err
+		}
+
+		for _, ordered := range []bool{true, false} {
+			ordered := ordered
+			Convey(fmt.Sprintf("failures within the limit should be skipped (ordered: %v)", ordered), func() {
+				numDocs, err := stream(ordered, 10)
+				So(err, ShouldBeNil)
+				So(numDocs, ShouldEqual, 90)
+				numDocs, err = stream(ordered, -1)
+				So(err, ShouldBeNil)
+				So(numDocs, ShouldEqual, 90)
+			})
+			Convey(fmt.Sprintf("exceeding the limit should report the exact count (ordered: %v)", ordered), func() {
+				_, err := stream(ordered, 3)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "4 records failed to convert, more than the maximum of 3; last 4 failures: record #")
+				So(strings.Count(err.Error(), "cannot parse 'N/A' as int32"), ShouldEqual, 4)
+			})
+			Convey(fmt.Sprintf("a limit of zero should stop at the first failure (ordered: %v)", ordered), func() {
+				_, err := stream(ordered, 0)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "record #")
+			})
+		}
+
+		Convey("only the last few failures should be quoted", func() {
+			_, err := stream(true, 7)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "8 records failed to convert, more than the maximum of 7; last 5 failures: ")
+			So(strings.Count(err.Error(), "cannot parse 'N/A' as int32"), ShouldEqual, 5)
+		})
+	})
+}
+
+func TestTSVStreamDocumentTransform(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that transforms its documents", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgStop, "int32"},
+		}
+		var buf bytes.Buffer
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(&buf, "%d\n", i)
+		}
+		var numCalls int64
+		transform := func(doc bson.D) (bson.D, error) {
+			atomic.AddInt64(&numCalls, 1)
+			a := doc[0].Value.(int32)
+			switch a % 10 {
+			case 0:
+				return nil, nil
+			case 7:
+				return nil, fmt.Errorf("unlucky %v", a)
+			}
+			return append(doc, bson.DocElem{Name: "double", Value: a * 2}), nil
+		}
+		stream := func(ordered bool, maxErrors int) (*TSVInputReader, []bson.D, error) {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader(buf.Bytes()), os.Stdout, 8, false)
+			r.MaxErrors = maxErrors
+			r.Transform = transform
+			docChan := make(chan bson.D, 100)
+			err := r.StreamDocument(ordered, docChan)
+			var docs []bson.D
+			for doc := range docChan {
+				docs = append(docs, doc)
+			}
+			return r, docs, err
+		}
+
+		Convey("transformed documents should be streamed in order, without dropped ones", func() {
+			r, docs, err := stream(true, -1)
+			So(err, ShouldBeNil)
+			So(atomic.LoadInt64(&numCalls), ShouldEqual, 100)
+			So(len(docs), ShouldEqual, 80)
+			So(r.Dropped(), ShouldEqual, 10)
+			So(docs[0], ShouldResemble, bson.D{{"a", int32(1)}, {"double", int32(2)}})
+			So(docs[79], ShouldResemble, bson.D{{"a", int32(99)}, {"double", int32(198)}})
+		})
+		Convey("transform errors should follow the MaxErrors limit", func() {
+			r, docs, err := stream(false, 10)
+			So(err, ShouldBeNil)
+			So(len(docs), ShouldEqual, 80)
+			So(r.Dropped(), ShouldEqual, 10)
+			_, _, err = stream(false, 9)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "10 records failed to convert, more than the maximum of 9")
+			_, _, err = stream(true, 0)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "transform failed: unlucky ")
+		})
+	})
+}
+
+func TestTSVProgress(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader of a known size", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgStop, "int32"},
+		}
+		var buf bytes.Buffer
+		for i := 0; i < 1000; i++ {
+			if i%100 == 99 {
+				buf.WriteString("x\n")
+			} else {
+				fmt.Fprintf(&buf, "%d\n", i)
+			}
+		}
+		r := NewTSVInputReader(colSpecs, bytes.NewReader(buf.Bytes()), os.Stdout, 4, false)
+		r.MaxErrors = -1
+		r.TotalBytes = int64(buf.Len())
+		So(r.Progress(), ShouldResemble, ReaderProgress{TotalBytes: int64(buf.Len())})
+
+		Convey("progress should be coherent while streaming, and complete afterwards", func() {
+			done := make(chan struct{})
+			polled := make(chan bool)
+			go func() {
+				coherent := true
+				for {
+					progress := r.Progress()
+					if progress.RecordsConverted+progress.RecordsFailed > progress.RecordsRead ||
+						progress.BytesRead > progress.TotalBytes {
+						coherent = false
+					}
+					select {
+					case <-done:
+						polled <- coherent
+						return
+					default:
+					}
+				}
+			}()
+			docChan := make(chan bson.D, 1000)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			close(done)
+			So(<-polled, ShouldBeTrue)
+
+			progress := r.Progress()
+			So(progress.BytesRead, ShouldEqual, buf.Len())
+			So(progress.RecordsRead, ShouldEqual, 1000)
+			So(progress.RecordsConverted, ShouldEqual, 990)
+			So(progress.RecordsFailed, ShouldEqual, 10)
+			So(progress.Elapsed, ShouldBeGreaterThan, 0)
+			// the failed records, each "x\n", were not emitted
+			So(progress.BytesEmitted, ShouldEqual, buf.Len()-20)
+		})
+
+		Convey("the size and the bytes emitted should advance once a record is read", func() {
+			docChan := make(chan bson.D)
+			errChan := make(chan error)
+			go func() {
+				errChan <- r.StreamDocument(true, docChan)
+			}()
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(0)}})
+			So(r.Size(), ShouldBeGreaterThan, 0)
+			So(r.Progress().BytesEmitted, ShouldBeGreaterThanOrEqualTo, 2)
+			for range docChan {
+			}
+			So(<-errChan, ShouldBeNil)
+		})
+	})
+}
+
+func TestTSVEstimateRecordCount(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader", t, func() {
+		r := NewTSVInputReader(nil, bytes.NewReader(nil), os.Stdout, 1, false)
+		var buf bytes.Buffer
+		buf.WriteString("a\tb\n")
+		for i := 0; i < 10000; i++ {
+			fmt.Fprintf(&buf, "%05d\t%s\n", i, strings.Repeat("x", i%20))
+		}
+
+		Convey("the estimate should be close, and leave the source where it was", func() {
+			source := bytes.NewReader(buf.Bytes())
+			estimate, err := r.EstimateRecordCount(source, 4096)
+			So(err, ShouldBeNil)
+			So(estimate.Records, ShouldAlmostEqual, 10001, 1000)
+			So(estimate.Low, ShouldBeLessThanOrEqualTo, estimate.Records)
+			So(estimate.High, ShouldBeGreaterThanOrEqualTo, estimate.Records)
+			So(estimate.Low, ShouldBeLessThan, estimate.High)
+			So(source.Len(), ShouldEqual, buf.Len())
+		})
+		Convey("the count should be exact if the sample is the whole input", func() {
+			estimate, err := r.EstimateRecordCount(bytes.NewReader(buf.Bytes()), buf.Len()+1)
+			So(err, ShouldBeNil)
+			So(estimate, ShouldResemble, RecordCountEstimate{10001, 10001, 10001})
+		})
+		Convey("the count should be unknown for input that can not seek", func() {
+			estimate, err := r.EstimateRecordCount(io.MultiReader(bytes.NewReader(buf.Bytes())), 4096)
+			So(err, ShouldBeNil)
+			So(estimate, ShouldResemble, UnknownRecordCount)
+
+			pipeReader, pipeWriter, err := os.Pipe()
+			So(err, ShouldBeNil)
+			defer pipeReader.Close()
+			defer pipeWriter.Close()
+			estimate, err = r.EstimateRecordCount(pipeReader, 4096)
+			So(err, ShouldBeNil)
+			So(estimate, ShouldResemble, UnknownRecordCount)
+		})
+	})
+}
+
+func TestTSVStreamDocumentSkipRecords(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that skips records after its header", t, func() {
+		contents := "a.int32()\n1\n2\nx\n4\ny\n"
+		r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+		r.SkipRecords = 3
+		So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+
+		Convey("skipped records should not be converted, but still be numbered", func() {
+			docChan := make(chan bson.D, 5)
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #5 (line 6):")
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(4)}})
+			So(r.Progress().RecordsRead, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestTSVStreamDocumentSkipAndLimit(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that imports a range of its records", t, func() {
+		newReader := func(contents string) *TSVInputReader {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 2, false)
+			So(r.ReadAndValidateTypedHeader(pgStop), ShouldBeNil)
+			r.SkipRecords = 1
+			r.Skip = 2
+			r.Limit = 2
+			return r
+		}
+
+		Convey("only the records in the range should be converted, and nothing after it read", func() {
+			docChan := make(chan bson.D, 5)
+			So(newReader("a.int32()\nx\n2\n3\n4\n5\ny\n").StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(4)}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(5)}})
+			_, open := <-docChan
+			So(open, ShouldBeFalse)
+		})
+		Convey("the records skipped should still be numbered", func() {
+			r := newReader("a.int32()\n1\n2\n3\nx\n5\n6\n")
+			err := r.StreamDocument(true, make(chan bson.D, 5))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #4 (line 5):")
+			So(r.NumProcessed(), ShouldEqual, 5)
+			So(r.Summary().RecordsSkipped, ShouldEqual, 3)
+		})
+		Convey("a limit beyond the end of the input should read all of it", func() {
+			r := newReader("a.int32()\n1\n2\n3\n4\n")
+			r.Limit = 10
+			docChan := make(chan bson.D, 5)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(4)}})
+			So(r.NumProcessed(), ShouldEqual, 4)
+		})
+	})
+}
+
+func TestTSVWhitespaceDelimiter(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that splits records on runs of whitespace", t, func() {
+		newReader := func(contents string) *TSVInputReader {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.WhitespaceDelimited = true
+			return r
+		}
+
+		Convey("the header and records should be split on every run of spaces and tabs", func() {
+			r := newReader("  level \t  code   message\r\nINFO   200\tok\n\t WARN 404  missing  \n")
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"level", "code", "message"})
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"level", "INFO"}, {"code", int32(200)}, {"message", "ok"}})
+			So(<-docChan, ShouldResemble, bson.D{{"level", "WARN"}, {"code", int32(404)}, {"message", "missing"}})
+		})
+		Convey("a record should have no empty tokens", func() {
+			r := newReader("")
+			So(r.splitRecord("\t a  b\t\tc \n"), ShouldResemble, []string{"a", "b", "c"})
+			So(r.splitRecord(" \t \n"), ShouldBeEmpty)
+			So(r.splitRecord("a,b c"), ShouldResemble, []string{"a,b", "c"})
+		})
+		Convey("quoted fields should not be read", func() {
+			r := newReader("a b\n1 2\n")
+			r.Quoted = true
+			So(r.ReadAndValidateHeader(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestTSVStreamDocumentBatches(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader that batches its records", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgStop, "int32"},
+		}
+		var buf bytes.Buffer
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(&buf, "%d\n", i)
+		}
+		newReader := func(contents []byte) *TSVInputReader {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader(contents), os.Stdout, 3, false)
+			r.BatchSize = 7
+			return r
+		}
+
+		Convey("documents should be streamed in order across batches, including the partial last one", func() {
+			docChan := make(chan bson.D, 100)
+			So(newReader(buf.Bytes()).StreamDocument(true, docChan), ShouldBeNil)
+			So(len(docChan), ShouldEqual, 100)
+			for i := 0; i < 100; i++ {
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(i)}})
+			}
+		})
+		Convey("every document should be streamed if unordered", func() {
+			docChan := make(chan bson.D, 100)
+			So(newReader(buf.Bytes()).StreamDocument(false, docChan), ShouldBeNil)
+			seen := make(map[int32]bool)
+			for i := 0; i < 100; i++ {
+				seen[(<-docChan)[0].Value.(int32)] = true
+			}
+			So(len(seen), ShouldEqual, 100)
+		})
+		Convey("a failure should identify the record within its batch", func() {
+			contents := bytes.Replace(buf.Bytes(), []byte("\n9\n"), []byte("\nN/A\n"), 1)
+			docChan := make(chan bson.D, 100)
+			err := newReader(contents).StreamDocument(true, docChan)
+			So(err, ShouldHaveSameTypeAs, BatchRecordError{})
+			So(err.(BatchRecordError).Index, ShouldEqual, 9)
+			So(err.Error(), ShouldStartWith, "record #10 (line 10):")
+			// the documents before the failure that are streamed are still in order
+			numDocs := len(docChan)
+			So(numDocs, ShouldBeLessThanOrEqualTo, 9)
+			for i := 0; i < numDocs; i++ {
+				So(<-docChan, ShouldResemble, bson.D{{"a", int32(i)}})
+			}
+		})
+	})
+}
+
+func TestTSVStreamDocumentMaxBufferedBytes(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader whose consumer is slow", t, func() {
+		record := strings.Repeat("x", 99) + "\n"
+		contents := strings.Repeat(record, 200)
+		r := NewTSVInputReader([]ColumnSpec{{"a", new(FieldAutoParser), pgAutoCast, "auto"}},
+			bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+		r.RecordBuffer = 100
+		r.MaxBufferedBytes = 3 * int64(len(record))
+
+		Convey("reading should pause once the unconverted records reach the limit", func() {
+			docChan := make(chan bson.D)
+			errChan := make(chan error)
+			go func() {
+				errChan <- r.StreamDocument(false, docChan)
+			}()
+			time.Sleep(100 * time.Millisecond)
+			// one held by the decoder, three buffered and one waiting for room
+			So(r.Progress().RecordsRead, ShouldBeLessThanOrEqualTo, 5)
+
+			numDocs := 0
+			for range docChan {
+				numDocs++
+			}
+			So(<-errChan, ShouldBeNil)
+			So(numDocs, ShouldEqual, 200)
+		})
+	})
+}
+
+func TestTSVLongRecords(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader with a small buffer", t, func() {
+		long := strings.Repeat("x", 1<<20)
+		contents := "a\tb\n1\t" + long + "\r\n2\t\"" + long + "\n" + long + "\"\n"
+		newReader := func() *TSVInputReader {
+			r := NewDelimitedInputReaderSize(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false, tokenSeparator, 16)
+			r.Quoted = true
+			return r
+		}
+
+		Convey("records much longer than the buffer should be read whole", func() {
+			r := newReader()
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", long}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(2)}, {"b", long + "\n" + long}})
+		})
+		Convey("records longer than the maximum should be an error", func() {
+			r := newReader()
+			r.MaxRecordSize = 1<<20 + 100
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			err := r.StreamDocument(true, docChan)
+			So(err, ShouldResemble, RecordTooLargeError{Line: 3, MaxSize: 1<<20 + 100})
+			So(err.Error(), ShouldEqual, "record too large at line 3: longer than the maximum of 1048676 bytes")
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", long}})
+		})
+	})
+}
+
+func BenchmarkTSVConvert(b *testing.B) {
+	benchmarkTSVConvert(b, tokenSeparator)
+}
+
+// BenchmarkTSVConvertMultiCharDelimiter is BenchmarkTSVConvert for a record
+// split on a delimiter of several characters, whose first appears in the data.
+func BenchmarkTSVConvertMultiCharDelimiter(b *testing.B) {
+	benchmarkTSVConvert(b, "~|~")
+}
+
+func benchmarkTSVConvert(b *testing.B, delimiter string) {
+	colSpecs := []ColumnSpec{
+		{"id", new(FieldInt32Parser), pgStop, "int32"},
+		{"name", new(FieldStringParser), pgStop, "string"},
+		{"city", new(FieldStringParser), pgStop, "string"},
+		{"zip", new(FieldStringParser), pgStop, "string"},
+		{"score", new(FieldDoubleParser), pgStop, "double"},
+		{"note", new(FieldAutoParser), pgStop, "auto"},
+	}
+	c := TSVConverter{
+		colSpecs:  colSpecs,
+		data:      strings.Replace("12345\tAnn Smith\tDublin\t02134\t98.6\tsome~free text\n", "\t", delimiter, -1),
+		delimiter: delimiter,
+		options:   &ConvertOptions{},
+	}
+	// the tests log every record
+	log.SetVerbosity(&options.Verbosity{})
+	defer log.SetVerbosity(&options.Verbosity{VLevel: 4})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Convert(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTSVGenerateFields(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader of a file without a header", t, func() {
+		contents := "# exported\n1\tx\n2\ty\tz\n3\n"
+		r := NewTSVInputReader(nil, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+		r.CommentPrefix = "#"
+
+		Convey("the fields should be named after the first record, which is still imported", func() {
+			So(r.GenerateFields(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"field0", "field1"})
+			docChan := make(chan bson.D, 3)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(1)}, {"field1", "x"}})
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(2)}, {"field1", "y"}, {"field2", "z"}})
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(3)}})
+		})
+		Convey("longer records should follow the long row policy", func() {
+			r.LongRows = RaggedError
+			So(r.GenerateFields(), ShouldBeNil)
+			err := r.StreamDocument(true, make(chan bson.D, 3))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "record #2 (line 3): row has 3 fields, more than the 2 columns")
+		})
+		Convey("blank records should be passed over, but still streamed", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte("\n1\tx\n")), os.Stdout, 1, true)
+			So(r.GenerateFields(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"field0", "field1"})
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{})
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(1)}, {"field1", "x"}})
+		})
+		Convey("a trailing delimiter should not name a field if AllowTrailingDelimiter is set", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader([]byte("1\tx\t\n2\ty\t\n")), os.Stdout, 1, false)
+			r.AllowTrailingDelimiter = true
+			r.LongRows = RaggedError
+			So(r.GenerateFields(), ShouldBeNil)
+			So(r.Fields(), ShouldResemble, []string{"field0", "field1"})
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(1)}, {"field1", "x"}})
+			So(<-docChan, ShouldResemble, bson.D{{"field0", int32(2)}, {"field1", "y"}})
+		})
+		Convey("empty input should have no fields", func() {
+			r := NewTSVInputReader(nil, bytes.NewReader(nil), os.Stdout, 1, false)
+			So(r.GenerateFields(), ShouldBeNil)
+			So(r.Fields(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestTSVVerifyHeader(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV input reader given fields for a file with a header", t, func() {
+		colSpecs := ParseAutoHeaders([]string{"a", "b", "c"})
+		newReader := func(contents string) *TSVInputReader {
+			return NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+		}
+
+		Convey("a matching header should be consumed rather than imported", func() {
+			r := newReader("a\tb\tc\n1\t2\t3\n")
+			So(r.VerifyHeader(HeaderCheckNames), ShouldBeNil)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", int32(2)}, {"c", int32(3)}})
+		})
+		Convey("header names should be renamed as the fields are", func() {
+			r := newReader("x\tb\tc\n")
+			r.RenameFields = map[string]string{"x": "a"}
+			So(r.VerifyHeader(HeaderCheckNames), ShouldBeNil)
+		})
+		Convey("a column inserted in the header should be reported only as extra", func() {
+			err := newReader("a\tz\tb\tc\n").VerifyHeader(HeaderCheckNames)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "the header does not match the fields given: extra 'z' (column 2)")
+		})
+		Convey("missing, extra and reordered columns should all be reported", func() {
+			err := newReader("c\ta\td\n").VerifyHeader(HeaderCheckNames)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "the header does not match the fields given: "+
+				"missing 'b' (field 2); extra 'd' (column 3); "+
+				"reordered: the header has 'c,a' where the fields are 'a,c'")
+		})
+		Convey("counting should only compare the number of columns", func() {
+			So(newReader("x\ty\tz\n").VerifyHeader(HeaderCheckCount), ShouldBeNil)
+			err := newReader("a\tb\n").VerifyHeader(HeaderCheckCount)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "the header has 2 columns, but 3 fields are given")
+		})
+		Convey("an input without a header should fail", func() {
+			So(newReader("").VerifyHeader(HeaderCheckCount), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestUnescapeTSVToken(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Unescaping a TSV token", t, func() {
+		Convey("should decode tabs, newlines, carriage returns and backslashes", func() {
+			So(unescapeTSVToken(`a\tb\nc\rd\\e`), ShouldEqual, "a\tb\nc\rd\\e")
+		})
+		Convey("should read an escaped backslash before a t as a literal backslash-t", func() {
+			So(unescapeTSVToken(`\\t`), ShouldEqual, `\t`)
+			So(unescapeTSVToken(`\\\t`), ShouldEqual, "\\\t")
+		})
+		Convey("should keep other backslashes, including a trailing one", func() {
+			So(unescapeTSVToken(`C:\x\`), ShouldEqual, `C:\x\`)
+			So(unescapeTSVToken(`\`), ShouldEqual, `\`)
+			So(unescapeTSVToken("plain"), ShouldEqual, "plain")
+		})
+	})
+	Convey("With a TSV input reader of escaped cells", t, func() {
+		contents := `a\tb` + "\t" + `x\ny\` + "\n"
+		colSpecs := ParseAutoHeaders([]string{"a", "b"})
+
+		Convey("the escapes should be kept literally by default", func() {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", `a\tb`}, {"b", `x\ny\`}})
+		})
+		Convey("the escapes should be decoded if Unescape is set", func() {
+			r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 1, false)
+			r.Unescape = true
+			docChan := make(chan bson.D, 1)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", "a\tb"}, {"b", "x\ny\\"}})
+		})
+	})
+}
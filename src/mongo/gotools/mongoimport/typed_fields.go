@@ -7,16 +7,22 @@
 package mongoimport
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/base32"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/mongodb/mongo-tools/common/bsonutil"
+	"github.com/mongodb/mongo-tools/common/json"
 	"github.com/mongodb/mongo-tools/mongoimport/dateconv"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -32,28 +38,46 @@ const (
 	ctDateGo
 	ctDateMS
 	ctDateOracle
+	ctDateEpochS
+	ctDateEpochMS
 	ctDouble
 	ctInt32
 	ctInt64
 	ctDecimal
 	ctString
+	ctArray
+	ctObjectID
+	ctUUID
+	ctJSON
+	ctPercent
 )
 
 var (
 	columnTypeRE      = regexp.MustCompile(`(?s)^(.*)\.(\w+)\((.*)\)$`)
+	columnBareTypeRE  = regexp.MustCompile(`(?s)^(.*)\.(\w+)$`)
+	columnDefaultRE   = regexp.MustCompile(`(?s)^(.*)\.default\((.*)\)$`)
+	decimalRE         = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
 	columnTypeNameMap = map[string]columnType{
-		"auto":        ctAuto,
-		"binary":      ctBinary,
-		"boolean":     ctBoolean,
-		"date":        ctDate,
-		"decimal":     ctDecimal,
-		"date_go":     ctDateGo,
-		"date_ms":     ctDateMS,
-		"date_oracle": ctDateOracle,
-		"double":      ctDouble,
-		"int32":       ctInt32,
-		"int64":       ctInt64,
-		"string":      ctString,
+		"array":         ctArray,
+		"auto":          ctAuto,
+		"binary":        ctBinary,
+		"bool":          ctBoolean,
+		"boolean":       ctBoolean,
+		"date":          ctDate,
+		"decimal":       ctDecimal,
+		"date_go":       ctDateGo,
+		"date_ms":       ctDateMS,
+		"date_oracle":   ctDateOracle,
+		"date_epoch_s":  ctDateEpochS,
+		"date_epoch_ms": ctDateEpochMS,
+		"double":        ctDouble,
+		"int32":         ctInt32,
+		"int64":         ctInt64,
+		"json":          ctJSON,
+		"objectid":      ctObjectID,
+		"percent":       ctPercent,
+		"string":        ctString,
+		"uuid":          ctUUID,
 	}
 )
 
@@ -89,11 +113,38 @@ func ColumnNames(fs []ColumnSpec) (s []string) {
 
 // ParseTypedHeader produces a ColumnSpec from a header item, extracting type
 // information from the it. The parseGrace is passed along to the new ColumnSpec.
+//
+// The type may be given as '<name>.<type>(<arg>)' or, for types that take no
+// argument, as '<name>.<type>'. A header without any '.' carries no type
+// annotation and is parsed automatically.
+//
+// Either form may be followed by '.default(<value>)', giving the token to use
+// for blank ones; it is parsed as the tokens of the column are, with the
+// ConvertOptions of the reader, which validates it with the columns.
 func ParseTypedHeader(header string, parseGrace ParseGrace) (f ColumnSpec, err error) {
+	if match := columnDefaultRE.FindStringSubmatch(header); match != nil {
+		f, err = ParseTypedHeader(match[1], parseGrace)
+		if err != nil {
+			return
+		}
+		if _, ok := f.Parser.(*FieldDefaultParser); ok {
+			err = fmt.Errorf("more than one default in header %s", header)
+			return
+		}
+		f.Parser = &FieldDefaultParser{f.Parser, match[2]}
+		return
+	}
 	match := columnTypeRE.FindStringSubmatch(header)
 	if len(match) != 4 {
-		err = fmt.Errorf("could not parse type from header %s", header)
-		return
+		match = columnBareTypeRE.FindStringSubmatch(header)
+		if len(match) != 3 {
+			if !strings.Contains(header, ".") {
+				return ColumnSpec{header, new(FieldAutoParser), parseGrace, "auto"}, nil
+			}
+			err = fmt.Errorf("could not parse type from header %s", header)
+			return
+		}
+		match = append(match, "")
 	}
 	t, ok := columnTypeNameMap[match[2]]
 	if !ok {
@@ -120,6 +171,46 @@ func ParseTypedHeaders(headers []string, parseGrace ParseGrace) (fs []ColumnSpec
 	return
 }
 
+// ReadFieldFile reads the column specs of an input reader from in, one field
+// per line, and validates them as validateReaderFields does. Lines may end in
+// "\n" or "\r\n"; blank lines and lines starting with '#' are skipped. If
+// typed is set, each line may carry a type annotation as accepted by
+// ParseTypedHeader, and lines without one are parsed automatically. If opts
+// is non-nil, the field names are normalized and sanitized as it specifies
+// before they are validated.
+func ReadFieldFile(in io.Reader, typed bool, parseGrace ParseGrace, opts *ConvertOptions) ([]ColumnSpec, error) {
+	var colSpecs []ColumnSpec
+	scanner := bufio.NewScanner(in)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		colSpec := ColumnSpec{line, new(FieldAutoParser), pgAutoCast, "auto"}
+		if typed {
+			var err error
+			if colSpec, err = ParseTypedHeader(line, parseGrace); err != nil {
+				return nil, fmt.Errorf("field file line %v: %v", lineNumber, err)
+			}
+		}
+		colSpecs = append(colSpecs, colSpec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading field file after line %v: %v", lineNumber, err)
+	}
+	flat := false
+	if opts != nil {
+		opts.prepareColumnNames(colSpecs)
+		flat = opts.FlatFields
+	}
+	if err := validateReaderFields(ColumnNames(colSpecs), flat); err != nil {
+		return nil, err
+	}
+	return colSpecs, nil
+}
+
 // ParseAutoHeaders converts a list of header items to ColumnSpec objects, with
 // automatic parsers.
 func ParseAutoHeaders(headers []string) (fs []ColumnSpec) {
@@ -149,15 +240,37 @@ var (
 // arg is passed along to the specific type's parser, if it permits an
 // argument. An error will be raised if arg is not valid for the type's
 // parser.
+//
+// The date types accept several alternative layouts separated by '|'; each is
+// tried in turn. A literal '|' can be escaped as '\|'.
+//
+// The array type's argument is '[<type>:]<separator>', where the optional
+// element type is one that takes no argument; it defaults to a string
+// array split on ','.
+//
+// The double and decimal types accept the argument 'money', to parse amounts
+// of money as FieldMoneyParser does, and the percent type 'fraction' or
+// 'number', as FieldPercentParser describes.
 func NewFieldParser(t columnType, arg string) (parser FieldParser, err error) {
+	rawArg := arg
 	arg = escapeReplacer.Replace(arg)
 
 	switch t { // validate argument
+	case ctArray:
 	case ctBinary:
 	case ctDate:
 	case ctDateGo:
 	case ctDateMS:
 	case ctDateOracle:
+	case ctDateEpochS:
+	case ctDateEpochMS:
+	case ctPercent:
+	case ctDouble, ctDecimal:
+		if arg != "" && arg != moneyArg {
+			err = fmt.Errorf("invalid argument '%v' for type %v: the only argument is '%v'", arg, t, moneyArg)
+			return
+		}
+	case ctUUID:
 	default:
 		if arg != "" {
 			err = fmt.Errorf("type %v does not support arguments", t)
@@ -166,6 +279,8 @@ func NewFieldParser(t columnType, arg string) (parser FieldParser, err error) {
 	}
 
 	switch t {
+	case ctArray:
+		parser, err = NewFieldArrayParser(arg)
 	case ctBinary:
 		parser, err = NewFieldBinaryParser(arg)
 	case ctBoolean:
@@ -173,28 +288,59 @@ func NewFieldParser(t columnType, arg string) (parser FieldParser, err error) {
 	case ctDate:
 		fallthrough
 	case ctDateGo:
-		parser = &FieldDateParser{arg}
+		parser = newDateParser(rawArg, nil)
 	case ctDateMS:
-		parser = &FieldDateParser{dateconv.FromMS(arg)}
+		parser = newDateParser(rawArg, dateconv.FromMS)
 	case ctDateOracle:
-		parser = &FieldDateParser{dateconv.FromOracle(arg)}
+		parser = newDateParser(rawArg, dateconv.FromOracle)
+	case ctDateEpochS:
+		parser, err = NewFieldEpochDateParser(time.Second, arg)
+	case ctDateEpochMS:
+		parser, err = NewFieldEpochDateParser(time.Millisecond, arg)
 	case ctDouble:
 		parser = new(FieldDoubleParser)
+		if arg == moneyArg {
+			parser = &FieldMoneyParser{parser}
+		}
 	case ctInt32:
 		parser = new(FieldInt32Parser)
 	case ctInt64:
 		parser = new(FieldInt64Parser)
 	case ctDecimal:
 		parser = new(FieldDecimalParser)
+		if arg == moneyArg {
+			parser = &FieldMoneyParser{parser}
+		}
+	case ctObjectID:
+		parser = new(FieldObjectIDParser)
 	case ctString:
 		parser = new(FieldStringParser)
+	case ctUUID:
+		parser, err = NewFieldUUIDParser(arg)
+	case ctJSON:
+		parser = new(FieldJSONParser)
+	case ctPercent:
+		parser, err = NewFieldPercentParser(arg)
 	default: // ctAuto
 		parser = new(FieldAutoParser)
 	}
 	return
 }
 
-func autoParse(in string) interface{} {
+// isNumericParser reports whether p parses one of the numeric types.
+func isNumericParser(p FieldParser) bool {
+	switch p.(type) {
+	case *FieldInt32Parser, *FieldInt64Parser, *FieldDoubleParser, *FieldDecimalParser:
+		return true
+	}
+	return false
+}
+
+// autoParse picks the smallest type that represents a number: an int32 or an
+// int64 for integers, and a double for other numbers, and for integers beyond
+// the int64 range, which a double rounds, a decimal128 instead if
+// bigIntegersAsDecimal is set. Everything else is left as a string.
+func autoParse(in string, bigIntegersAsDecimal bool) interface{} {
 	parsedInt, err := strconv.ParseInt(in, 10, 64)
 	if err == nil {
 		if math.MinInt32 <= parsedInt && parsedInt <= math.MaxInt32 {
@@ -202,6 +348,13 @@ func autoParse(in string) interface{} {
 		}
 		return parsedInt
 	}
+	if bigIntegersAsDecimal && err.(*strconv.NumError).Err == strconv.ErrRange {
+		// a double would silently round such an integer
+		if parsedDecimal, err := bson.ParseDecimal128(in); err == nil {
+			return parsedDecimal
+		}
+		return in
+	}
 	parsedFloat, err := strconv.ParseFloat(in, 64)
 	if err == nil {
 		return parsedFloat
@@ -209,45 +362,162 @@ func autoParse(in string) interface{} {
 	return in
 }
 
-type FieldAutoParser struct{}
+// FieldAutoParser parses the numbers of columns without a type, as autoParse
+// does; BigIntegersAsDecimal is as for ConvertOptions.
+type FieldAutoParser struct {
+	BigIntegersAsDecimal bool
+}
 
 func (ap *FieldAutoParser) Parse(in string) (interface{}, error) {
-	return autoParse(in), nil
+	return autoParse(in, ap.BigIntegersAsDecimal), nil
+}
+
+// FieldDefaultParser wraps the parser of a column that has a default, the
+// token that tokensToBSON parses in place of blank tokens, and of the missing
+// fields of short rows padded with null.
+type FieldDefaultParser struct {
+	parser FieldParser
+	token  string
 }
 
+func (dp *FieldDefaultParser) Parse(in string) (interface{}, error) {
+	return dp.parser.Parse(in)
+}
+
+// FieldArrayParser splits a token on a separator, parsing each element
+// with an element parser. A separator at the very end of the token
+// terminates the last element rather than starting an empty one, so
+// "a,b," yields two elements; write "a,b,," to keep a trailing empty one.
+type FieldArrayParser struct {
+	separator string
+	element   FieldParser
+}
+
+func (ap *FieldArrayParser) Parse(in string) (interface{}, error) {
+	array := []interface{}{}
+	if in == "" {
+		return array, nil
+	}
+	elements := strings.Split(strings.TrimSuffix(in, ap.separator), ap.separator)
+	for i, element := range elements {
+		value, err := ap.element.Parse(element)
+		if err != nil {
+			return nil, fmt.Errorf("array element #%d: %v", i, err)
+		}
+		array = append(array, value)
+	}
+	return array, nil
+}
+
+// NewFieldArrayParser returns a FieldArrayParser for an argument of the
+// form '[<type>:]<separator>'.
+func NewFieldArrayParser(arg string) (*FieldArrayParser, error) {
+	elementType := ctString
+	if i := strings.Index(arg, ":"); i >= 0 {
+		if t, ok := columnTypeNameMap[arg[:i]]; ok {
+			switch t {
+			case ctArray, ctBinary, ctDate, ctDateGo, ctDateMS, ctDateOracle:
+				return nil, fmt.Errorf("array elements cannot be of type %s", arg[:i])
+			}
+			elementType = t
+			arg = arg[i+1:]
+		}
+	}
+	if arg == "" {
+		arg = ","
+	}
+	element, err := NewFieldParser(elementType, "")
+	if err != nil {
+		return nil, err
+	}
+	return &FieldArrayParser{arg, element}, nil
+}
+
+// FieldBinaryParser decodes binary data. Base64 data may use either the
+// standard or the URL-safe alphabet, but must be correctly padded. Data of
+// the generic subtype is parsed as a []byte, and data of any other subtype
+// as a bson.Binary.
 type FieldBinaryParser struct {
-	enc binaryEncoding
+	enc     binaryEncoding
+	subtype byte
 }
 
 func (bp *FieldBinaryParser) Parse(in string) (interface{}, error) {
+	var data []byte
+	var err error
 	switch bp.enc {
 	case beBase32:
-		return base32.StdEncoding.DecodeString(in)
+		data, err = base32.StdEncoding.DecodeString(in)
 	case beBase64:
-		return base64.StdEncoding.DecodeString(in)
+		encoding := base64.StdEncoding
+		if strings.ContainsAny(in, "-_") {
+			encoding = base64.URLEncoding
+		}
+		data, err = encoding.DecodeString(in)
 	default: // beHex
-		return hex.DecodeString(in)
+		data, err = hex.DecodeString(in)
+	}
+	if err != nil {
+		return nil, err
 	}
+	if bp.subtype != 0x00 {
+		return bson.Binary{Kind: bp.subtype, Data: data}, nil
+	}
+	return data, nil
 }
 
+// NewFieldBinaryParser returns a FieldBinaryParser for an argument of the form
+// '<encoding>[:<subtype>]'. The subtype may be written in decimal or, with a
+// '0x' prefix, hex, and defaults to the generic subtype 0x00.
 func NewFieldBinaryParser(arg string) (*FieldBinaryParser, error) {
+	var subtype uint64
+	if i := strings.Index(arg, ":"); i != -1 {
+		var err error
+		subtype, err = strconv.ParseUint(arg[i+1:], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary subtype: %s", arg[i+1:])
+		}
+		arg = arg[:i]
+	}
 	enc, ok := binaryEncodingNameMap[arg]
 	if !ok {
 		return nil, fmt.Errorf("invalid binary encoding: %s", arg)
 	}
-	return &FieldBinaryParser{enc}, nil
+	return &FieldBinaryParser{enc, byte(subtype)}, nil
 }
 
+// defaultTrueTokens and defaultFalseTokens are the values accepted by boolean
+// columns unless ConvertOptions specifies others.
+var (
+	defaultTrueTokens  = []string{"true", "1"}
+	defaultFalseTokens = []string{"false", "0"}
+)
+
 type FieldBooleanParser struct{}
 
 func (bp *FieldBooleanParser) Parse(in string) (interface{}, error) {
-	if strings.ToLower(in) == "true" || in == "1" {
+	return parseBoolean(in, defaultTrueTokens, defaultFalseTokens, false)
+}
+
+// parseBoolean parses in as true if it is one of trueTokens, or as false if it
+// is one of falseTokens. Case is ignored unless caseSensitive is set.
+func parseBoolean(in string, trueTokens, falseTokens []string, caseSensitive bool) (interface{}, error) {
+	matches := func(tokens []string) bool {
+		for _, token := range tokens {
+			if in == token || !caseSensitive && strings.EqualFold(in, token) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(trueTokens) {
 		return true, nil
 	}
-	if strings.ToLower(in) == "false" || in == "0" {
+	if matches(falseTokens) {
 		return false, nil
 	}
-	return nil, fmt.Errorf("failed to parse boolean: %s", in)
+	return nil, fmt.Errorf("failed to parse boolean: %s (true values: %v; false values: %v)",
+		in, strings.Join(trueTokens, ", "), strings.Join(falseTokens, ", "))
 }
 
 type FieldDateParser struct {
@@ -255,7 +525,282 @@ type FieldDateParser struct {
 }
 
 func (dp *FieldDateParser) Parse(in string) (interface{}, error) {
-	return time.Parse(dp.layout, in)
+	return dp.ParseInLocation(in, time.UTC)
+}
+
+// ParseInLocation parses a date that carries no offset of its own as a time
+// in loc.
+func (dp *FieldDateParser) ParseInLocation(in string, loc *time.Location) (interface{}, error) {
+	return time.ParseInLocation(dp.layout, in, loc)
+}
+
+// FieldMultiDateParser parses dates that may be in any one of several
+// layouts, trying each in order.
+type FieldMultiDateParser struct {
+	layouts []string
+}
+
+func (dp *FieldMultiDateParser) Parse(in string) (interface{}, error) {
+	return dp.ParseInLocation(in, time.UTC)
+}
+
+// ParseInLocation parses a date that carries no offset of its own as a time
+// in loc.
+func (dp *FieldMultiDateParser) ParseInLocation(in string, loc *time.Location) (interface{}, error) {
+	for _, layout := range dp.layouts {
+		if t, err := time.ParseInLocation(layout, in, loc); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("could not parse '%s' using any of the layouts %q", in, dp.layouts)
+}
+
+// newDateParser returns a parser for the '|'-separated layouts in the raw,
+// still escaped, type argument arg. If convert is non-nil, it translates each
+// layout into go's parse format.
+func newDateParser(arg string, convert func(string) string) FieldParser {
+	var layouts []string
+	for _, layout := range splitUnescaped(arg, '|') {
+		layout = escapeReplacer.Replace(layout)
+		if convert != nil {
+			layout = convert(layout)
+		}
+		layouts = append(layouts, layout)
+	}
+	if len(layouts) == 1 {
+		return &FieldDateParser{layouts[0]}
+	}
+	return &FieldMultiDateParser{layouts}
+}
+
+// locationParser is implemented by the parsers of dates written as a wall
+// time, which may be in a zone other than UTC.
+type locationParser interface {
+	ParseInLocation(in string, loc *time.Location) (interface{}, error)
+}
+
+// DSTGapPolicy controls dates whose wall time does not exist in their zone,
+// as it is skipped when the clocks go forward.
+type DSTGapPolicy int
+
+const (
+	// DSTGapError fails to parse a date in the gap.
+	DSTGapError DSTGapPolicy = iota
+
+	// DSTGapShift moves a date in the gap forward by the length of the gap,
+	// so 02:30 on a day the clocks go from 02:00 to 03:00 is 03:30.
+	DSTGapShift
+)
+
+// ValidateDSTGapPolicy ensures the user-provided DST gap policy is one of
+// the allowed values.
+func ValidateDSTGapPolicy(policy string) (DSTGapPolicy, error) {
+	switch policy {
+	case "", "error":
+		return DSTGapError, nil
+	case "shift":
+		return DSTGapShift, nil
+	default:
+		return DSTGapError, fmt.Errorf("invalid DST gap policy: %s", policy)
+	}
+}
+
+// fixedOffsetRE matches a fixed offset from UTC, such as "+05:30", "-0800"
+// or "+01".
+var fixedOffsetRE = regexp.MustCompile(`^([+-])(\d\d):?(\d\d)?$`)
+
+// ParseTimeZone returns the location named by zone: "UTC", an IANA zone
+// name such as "America/Chicago", or a fixed offset from UTC such as
+// "+05:30", "-0800" or "+01".
+func ParseTimeZone(zone string) (*time.Location, error) {
+	if match := fixedOffsetRE.FindStringSubmatch(zone); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes := 0
+		if match[3] != "" {
+			minutes, _ = strconv.Atoi(match[3])
+		}
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("invalid offset from UTC '%v'", zone)
+		}
+		offset := hours*60*60 + minutes*60
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(zone, offset), nil
+	}
+	if zone == "" || zone == "Local" {
+		// LoadLocation would return UTC or the zone of the machine
+		return nil, fmt.Errorf("invalid time zone '%v'", zone)
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone '%v': %v", zone, err)
+	}
+	return loc, nil
+}
+
+// parseInLocation parses in with p as a time in loc, unless it carries an
+// offset of its own, resolving a wall time in a DST gap as policy says. A
+// wall time that happens twice, as the clocks go back, is the earlier of
+// the two.
+func parseInLocation(p locationParser, in string, loc *time.Location, policy DSTGapPolicy) (interface{}, error) {
+	value, err := p.ParseInLocation(in, loc)
+	if err != nil || loc == time.UTC {
+		return value, err
+	}
+	t := value.(time.Time)
+	utcValue, err := p.ParseInLocation(in, time.UTC)
+	if err != nil {
+		return value, nil
+	}
+	wall := utcValue.(time.Time)
+	if t.Equal(wall) || sameWallTime(t.In(loc), wall) {
+		// either the date has an offset, or its wall time exists in loc
+		return t, nil
+	}
+	if policy != DSTGapShift {
+		return nil, fmt.Errorf("%v does not exist in %v, as the clocks went forward", wall.Format("2006-01-02 15:04:05"), loc)
+	}
+	// the two offsets either side of the gap each give an instant, and the
+	// later one, with the offset from before the gap, shifts the wall time
+	// forward
+	_, before := t.Zone()
+	first := wall.Add(-time.Duration(before) * time.Second)
+	_, after := first.In(loc).Zone()
+	second := wall.Add(-time.Duration(after) * time.Second)
+	if second.After(first) {
+		first = second
+	}
+	return first.In(loc), nil
+}
+
+// sameWallTime reports whether a and b show the same date and time of day,
+// whatever their zones.
+func sameWallTime(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd &&
+		a.Hour() == b.Hour() && a.Minute() == b.Minute() && a.Second() == b.Second() &&
+		a.Nanosecond() == b.Nanosecond()
+}
+
+// splitUnescaped splits s on each occurrence of sep that is not preceded by
+// a backslash escape. The escapes themselves are left in place.
+func splitUnescaped(s string, sep byte) (parts []string) {
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // skip the escaped character
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Years to which the epoch date types limit their dates unless given others,
+// so that a column of milliseconds mistaken for seconds fails to parse.
+const (
+	defaultEpochMinYear = 1900
+	defaultEpochMaxYear = 2200
+)
+
+// FieldEpochDateParser parses dates written as the number of seconds or
+// milliseconds since the Unix epoch, which may be negative for dates before
+// 1970. Seconds may have a fractional part. A date outside the years from
+// minYear to maxYear fails to parse.
+type FieldEpochDateParser struct {
+	unit    time.Duration
+	minYear int
+	maxYear int
+}
+
+func (dp *FieldEpochDateParser) Parse(in string) (interface{}, error) {
+	var t time.Time
+	if dp.unit == time.Millisecond {
+		ms, err := strconv.ParseInt(in, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a whole number of milliseconds since the epoch", in)
+		}
+		t = time.Unix(ms/1000, ms%1000*int64(time.Millisecond))
+	} else {
+		seconds, nanoseconds, ok := parseEpochSeconds(in)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a number of seconds since the epoch", in)
+		}
+		t = time.Unix(seconds, nanoseconds)
+	}
+	t = t.UTC()
+	if year := t.Year(); year < dp.minYear || year > dp.maxYear {
+		unit := "seconds"
+		if dp.unit == time.Millisecond {
+			unit = "milliseconds"
+		}
+		return nil, fmt.Errorf("%v %v since the epoch is in the year %v, outside the years %v to %v",
+			in, unit, year, dp.minYear, dp.maxYear)
+	}
+	return t, nil
+}
+
+// parseEpochSeconds parses a number of seconds, with an optional sign and
+// fractional part, into whole seconds and nanoseconds, both negative for a
+// negative number. Digits beyond nanoseconds are dropped.
+func parseEpochSeconds(in string) (seconds, nanoseconds int64, ok bool) {
+	whole, fraction := in, ""
+	if i := strings.IndexByte(in, '.'); i != -1 {
+		whole, fraction = in[:i], in[i+1:]
+		if fraction == "" || strings.TrimLeft(fraction, "0123456789") != "" {
+			return 0, 0, false
+		}
+	}
+	negative := strings.HasPrefix(whole, "-")
+	if whole == "" || whole == "-" || whole == "+" {
+		if fraction == "" {
+			return 0, 0, false
+		}
+		whole += "0"
+	}
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if len(fraction) > 9 {
+		fraction = fraction[:9]
+	}
+	if fraction != "" {
+		nanoseconds, _ = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+	}
+	if negative {
+		nanoseconds = -nanoseconds
+	}
+	return seconds, nanoseconds, true
+}
+
+// NewFieldEpochDateParser returns a FieldEpochDateParser of dates in the
+// given unit, time.Second or time.Millisecond, for an argument of the form
+// '<min year>:<max year>', or an empty one for the default years.
+func NewFieldEpochDateParser(unit time.Duration, arg string) (*FieldEpochDateParser, error) {
+	dp := &FieldEpochDateParser{unit, defaultEpochMinYear, defaultEpochMaxYear}
+	if arg == "" {
+		return dp, nil
+	}
+	i := strings.Index(arg, ":")
+	if i == -1 {
+		return nil, fmt.Errorf("invalid year range '%s': must be '<min year>:<max year>'", arg)
+	}
+	var err error
+	if dp.minYear, err = strconv.Atoi(arg[:i]); err != nil {
+		return nil, fmt.Errorf("invalid minimum year: %s", arg[:i])
+	}
+	if dp.maxYear, err = strconv.Atoi(arg[i+1:]); err != nil {
+		return nil, fmt.Errorf("invalid maximum year: %s", arg[i+1:])
+	}
+	if dp.minYear > dp.maxYear {
+		return nil, fmt.Errorf("invalid year range '%s': the minimum is after the maximum", arg)
+	}
+	return dp, nil
 }
 
 type FieldDoubleParser struct{}
@@ -268,7 +813,11 @@ type FieldInt32Parser struct{}
 
 func (ip *FieldInt32Parser) Parse(in string) (interface{}, error) {
 	value, err := strconv.ParseInt(in, 10, 32)
-	return int32(value), err
+	if err != nil {
+		// don't return the value ParseInt clamped to the int32 range
+		return nil, err
+	}
+	return int32(value), nil
 }
 
 type FieldInt64Parser struct{}
@@ -277,10 +826,229 @@ func (ip *FieldInt64Parser) Parse(in string) (interface{}, error) {
 	return strconv.ParseInt(in, 10, 64)
 }
 
+// FieldDecimalParser parses decimal numbers, in plain or scientific notation,
+// into decimal128 values with up to 34 significant digits.
 type FieldDecimalParser struct{}
 
 func (ip *FieldDecimalParser) Parse(in string) (interface{}, error) {
-	return bson.ParseDecimal128(in)
+	value, err := bson.ParseDecimal128(in)
+	if err != nil && !decimalRE.MatchString(in) {
+		return nil, fmt.Errorf("%q is not a decimal number", in)
+	}
+	// values just past the largest exponent are accepted by ParseDecimal128
+	// with a coefficient of 35 digits, which is not a valid decimal128
+	if err != nil || significantDigits(value.String()) > 34 {
+		return nil, fmt.Errorf("%v is out of range for decimal128, which holds up to "+
+			"34 significant digits and exponents from -6176 to 6111", in)
+	}
+	return value, nil
+}
+
+// significantDigits returns the number of digits in the coefficient of a
+// decimal number as formatted by bson.Decimal128, excluding leading zeros.
+func significantDigits(decimal string) (n int) {
+	if e := strings.IndexAny(decimal, "Ee"); e != -1 {
+		decimal = decimal[:e]
+	}
+	for _, c := range strings.TrimLeft(strings.TrimLeft(decimal, "+-"), "0.") {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+	return
+}
+
+// FieldObjectIDParser parses ObjectIds written as 24 hex characters, or
+// wrapped as ObjectId("<hex>").
+type FieldObjectIDParser struct{}
+
+func (op *FieldObjectIDParser) Parse(in string) (interface{}, error) {
+	hexString := in
+	if len(in) >= len(`ObjectId("")`) && strings.HasPrefix(in, `ObjectId("`) && strings.HasSuffix(in, `")`) {
+		hexString = in[len(`ObjectId("`) : len(in)-len(`")`)]
+	}
+	if len(hexString) != 24 {
+		return nil, fmt.Errorf("an ObjectId must be 24 hex characters, not %v", len(hexString))
+	}
+	if i := strings.IndexFunc(hexString, func(c rune) bool {
+		return !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F')
+	}); i != -1 {
+		return nil, fmt.Errorf("an ObjectId must be 24 hex characters, but character %v is %q", i+1, hexString[i])
+	}
+	return bson.ObjectIdHex(hexString), nil
+}
+
+// uuidByteOrder is the order in which the bytes of a UUID are stored.
+type uuidByteOrder int
+
+const (
+	uuidStandard uuidByteOrder = iota
+	uuidJavaLegacy
+	uuidCSharpLegacy
+	uuidPythonLegacy
+)
+
+var uuidByteOrderNameMap = map[string]uuidByteOrder{
+	"standard":     uuidStandard,
+	"javaLegacy":   uuidJavaLegacy,
+	"csharpLegacy": uuidCSharpLegacy,
+	"pythonLegacy": uuidPythonLegacy,
+}
+
+// FieldUUIDParser parses UUIDs written as 32 hex digits, which may be
+// hyphenated as 8-4-4-4-12 digits and wrapped in braces, into BSON binary
+// data: of the UUID subtype 0x04, or, for the legacy byte orders that drivers
+// once used, of the old UUID subtype 0x03.
+type FieldUUIDParser struct {
+	order uuidByteOrder
+}
+
+func (up *FieldUUIDParser) Parse(in string) (interface{}, error) {
+	digits := in
+	if strings.HasPrefix(digits, "{") && strings.HasSuffix(digits, "}") && len(digits) >= 2 {
+		digits = digits[1 : len(digits)-1]
+	}
+	if len(digits) == 36 {
+		for _, i := range []int{8, 13, 18, 23} {
+			if digits[i] != '-' {
+				return nil, fmt.Errorf("a hyphenated UUID must be grouped as 8-4-4-4-12 hex digits, but character %v is %q", i+1, digits[i])
+			}
+		}
+		digits = digits[:8] + digits[9:13] + digits[14:18] + digits[19:23] + digits[24:]
+	} else if len(digits) != 32 {
+		return nil, fmt.Errorf("a UUID must be 32 hex digits, or 36 characters hyphenated, not %v", len(digits))
+	}
+	data := make([]byte, 16)
+	if _, err := hex.Decode(data, []byte(digits)); err != nil {
+		if invalid, ok := err.(hex.InvalidByteError); ok {
+			return nil, fmt.Errorf("a UUID must be hex digits, but it contains %q", byte(invalid))
+		}
+		return nil, fmt.Errorf("invalid UUID: %v", err)
+	}
+	switch up.order {
+	case uuidStandard:
+		return bson.Binary{Kind: 0x04, Data: data}, nil
+	case uuidJavaLegacy:
+		reverseBytes(data[:8])
+		reverseBytes(data[8:])
+	case uuidCSharpLegacy:
+		reverseBytes(data[:4])
+		reverseBytes(data[4:6])
+		reverseBytes(data[6:8])
+	}
+	return bson.Binary{Kind: 0x03, Data: data}, nil
+}
+
+// reverseBytes reverses the order of b in place.
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+// NewFieldUUIDParser returns a FieldUUIDParser for an argument naming the
+// byte order of the UUIDs: standard, the default, or one of the legacy
+// orders javaLegacy, csharpLegacy and pythonLegacy.
+func NewFieldUUIDParser(arg string) (*FieldUUIDParser, error) {
+	if arg == "" {
+		return &FieldUUIDParser{uuidStandard}, nil
+	}
+	order, ok := uuidByteOrderNameMap[arg]
+	if !ok {
+		return nil, fmt.Errorf("invalid UUID byte order: %s", arg)
+	}
+	return &FieldUUIDParser{order}, nil
+}
+
+// FieldJSONParser parses cells that hold a JSON object or array, which may
+// use extended JSON such as {"$date": ...} and {"$oid": ...}, into an
+// embedded document or array. The keys of objects keep their order.
+type FieldJSONParser struct{}
+
+func (jp *FieldJSONParser) Parse(in string) (interface{}, error) {
+	trimmed := strings.TrimSpace(in)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return nil, fmt.Errorf("a json value must be an object or an array")
+	}
+	// the value is scanned on its own, so that content after it is not
+	// taken for more fields of the document it is wrapped in below
+	cell := strings.NewReader(trimmed)
+	decoder := json.NewDecoder(cell)
+	value, err := decoder.ScanObject()
+	if err != nil {
+		return nil, err
+	}
+	rest, err := ioutil.ReadAll(io.MultiReader(decoder.Buffered(), cell))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, fmt.Errorf("unexpected content after the json value: %q", bytes.TrimSpace(rest))
+	}
+	// wrapped in a document, as only documents are decoded in order
+	wrapped, err := json.UnmarshalBsonD(append(append([]byte(`{"value":`), value...), '}'))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := bsonutil.GetExtendedBsonD(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return doc[0].Value, nil
+}
+
+// FieldPercentParser parses percentages, such as "12.5%" or "-3 %", into
+// doubles: the fraction they are, 0.125 and -0.03, if DivideBy100 is set,
+// and otherwise the number of percent, 12.5 and -3. A value without a '%'
+// sign fails to parse.
+type FieldPercentParser struct {
+	DivideBy100 bool
+}
+
+func (pp *FieldPercentParser) Parse(in string) (interface{}, error) {
+	number, err := trimPercent(in)
+	if err != nil {
+		return nil, err
+	}
+	return pp.parseNumber(number)
+}
+
+// parseNumber parses number, a percentage without its '%' sign.
+func (pp *FieldPercentParser) parseNumber(number string) (interface{}, error) {
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return nil, fmt.Errorf("%q is not a finite number", number)
+	}
+	if pp.DivideBy100 {
+		value /= 100
+	}
+	return value, nil
+}
+
+// trimPercent returns the number of a percentage, without its '%' sign and
+// any whitespace before it, or an error if it has none.
+func trimPercent(in string) (string, error) {
+	trimmed := strings.TrimSpace(in)
+	if !strings.HasSuffix(trimmed, "%") {
+		return "", fmt.Errorf("%q is not a percentage: it does not end in '%%'", in)
+	}
+	return strings.TrimSpace(trimmed[:len(trimmed)-1]), nil
+}
+
+// NewFieldPercentParser returns a FieldPercentParser for an argument of
+// fraction, the default, which divides percentages by 100, or number, which
+// does not.
+func NewFieldPercentParser(arg string) (*FieldPercentParser, error) {
+	switch arg {
+	case "", "fraction":
+		return &FieldPercentParser{DivideBy100: true}, nil
+	case "number":
+		return &FieldPercentParser{}, nil
+	}
+	return nil, fmt.Errorf("invalid percent argument '%s': must be fraction or number", arg)
 }
 
 type FieldStringParser struct{}
@@ -12,6 +12,8 @@ import (
 	"github.com/mongodb/mongo-tools/common/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/mgo.v2/bson"
+	"math"
+	"strings"
 	"testing"
 	"time"
 )
@@ -52,6 +54,57 @@ func TestTypedHeaderParser(t *testing.T) {
 		})
 	})
 
+	Convey("Using 'age.int32,price.decimal,created.date_ms(yyyy-MM-dd),name'", t, func() {
+		var headers = []string{"age.int32", "price.decimal", "created.date_ms(yyyy-MM-dd)", "name"}
+		colSpecs, err := ParseTypedHeaders(headers, pgStop)
+		So(err, ShouldBeNil)
+		So(colSpecs, ShouldResemble, []ColumnSpec{
+			{"age", new(FieldInt32Parser), pgStop, "int32"},
+			{"price", new(FieldDecimalParser), pgStop, "decimal"},
+			{"created", &FieldDateParser{"2006-01-02"}, pgStop, "date_ms"},
+			{"name", new(FieldAutoParser), pgStop, "auto"},
+		})
+	})
+
+	Convey("Using '_id.objectid(),refs.array(objectid:;)'", t, func() {
+		var headers = []string{"_id.objectid()", "refs.array(objectid:;)"}
+		colSpecs, err := ParseTypedHeaders(headers, pgStop)
+		So(err, ShouldBeNil)
+		So(colSpecs, ShouldResemble, []ColumnSpec{
+			{"_id", new(FieldObjectIDParser), pgStop, "objectid"},
+			{"refs", &FieldArrayParser{";", new(FieldObjectIDParser)}, pgStop, "array"},
+		})
+	})
+
+	Convey("Using 'status.default(pending),retries.int32().default(0),tags.array(;).default(a;b)'", t, func() {
+		var headers = []string{"status.default(pending)", "retries.int32().default(0)", "tags.array(;).default(a;b)"}
+		colSpecs, err := ParseTypedHeaders(headers, pgStop)
+		So(err, ShouldBeNil)
+		So(colSpecs, ShouldResemble, []ColumnSpec{
+			{"status", &FieldDefaultParser{new(FieldAutoParser), "pending"}, pgStop, "auto"},
+			{"retries", &FieldDefaultParser{new(FieldInt32Parser), "0"}, pgStop, "int32"},
+			{"tags", &FieldDefaultParser{&FieldArrayParser{";", new(FieldStringParser)}, "a;b"}, pgStop, "array"},
+		})
+
+		Convey("with defaults that contain parentheses or equal the empty string", func() {
+			colSpec, err := ParseTypedHeader("note.string().default((none))", pgStop)
+			So(err, ShouldBeNil)
+			So(colSpec.Parser, ShouldResemble, &FieldDefaultParser{new(FieldStringParser), "(none)"})
+			colSpec, err = ParseTypedHeader("note.string.default()", pgStop)
+			So(err, ShouldBeNil)
+			So(colSpec.Parser, ShouldResemble, &FieldDefaultParser{new(FieldStringParser), ""})
+		})
+		Convey("with defaults that are invalid for the type", func() {
+			colSpec, err := ParseTypedHeader("retries.int32().default(none)", pgAutoCast)
+			So(err, ShouldBeNil)
+			err = (&ConvertOptions{}).validateColumns([]ColumnSpec{colSpec})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "invalid default 'none' for int32 field retries: ")
+			_, err = ParseTypedHeader("retries.int32().default(1).default(2)", pgAutoCast)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
 	Convey("Using various bad headers", t, func() {
 		var err error
 
@@ -69,6 +122,16 @@ func TestTypedHeaderParser(t *testing.T) {
 			_, err = ParseTypedHeader("zip.auto(0)", pgAutoCast)
 			So(err, ShouldNotBeNil)
 		})
+		Convey("with unknown types without parentheses", func() {
+			_, err = ParseTypedHeader("age.int23", pgAutoCast)
+			So(err, ShouldNotBeNil)
+			_, err = ParseTypedHeader("address.city", pgAutoCast)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("with types that require arguments but have none", func() {
+			_, err = ParseTypedHeader("thumbnail.binary", pgAutoCast)
+			So(err, ShouldNotBeNil)
+		})
 		Convey("with bad arguments for the binary type", func() {
 			_, err = ParseTypedHeader("zip.binary(blah)", pgAutoCast)
 			So(err, ShouldNotBeNil)
@@ -76,6 +139,12 @@ func TestTypedHeaderParser(t *testing.T) {
 			So(err, ShouldNotBeNil)
 			_, err = ParseTypedHeader("zip.binary(decimal)", pgAutoCast)
 			So(err, ShouldNotBeNil)
+			_, err = ParseTypedHeader("zip.binary(hex:)", pgAutoCast)
+			So(err, ShouldNotBeNil)
+			_, err = ParseTypedHeader("zip.binary(hex:0x100)", pgAutoCast)
+			So(err, ShouldNotBeNil)
+			_, err = ParseTypedHeader("zip.binary(:5)", pgAutoCast)
+			So(err, ShouldNotBeNil)
 		})
 	})
 }
@@ -92,6 +161,42 @@ func TestAutoHeaderParser(t *testing.T) {
 	})
 }
 
+func TestReadFieldFile(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Reading a field file", t, func() {
+		contents := "# order export fields\r\nid.int64()\r\n\r\n  \nname\n#comment.int32()\nzip.string()"
+
+		Convey("should skip comments and blank lines and tolerate CRLF line endings", func() {
+			colSpecs, err := ReadFieldFile(strings.NewReader(contents), false, pgStop, nil)
+			So(err, ShouldBeNil)
+			So(ColumnNames(colSpecs), ShouldResemble, []string{"id.int64()", "name", "zip.string()"})
+		})
+		Convey("should parse type annotations on the lines that have one if typed", func() {
+			colSpecs, err := ReadFieldFile(strings.NewReader(contents), true, pgStop, nil)
+			So(err, ShouldBeNil)
+			So(colSpecs, ShouldResemble, []ColumnSpec{
+				{"id", new(FieldInt64Parser), pgStop, "int64"},
+				{"name", new(FieldAutoParser), pgStop, "auto"},
+				{"zip", new(FieldStringParser), pgStop, "string"},
+			})
+		})
+		Convey("should report the line of an invalid type annotation", func() {
+			_, err := ReadFieldFile(strings.NewReader("a\r\nb.nope()\r\n"), true, pgStop, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "field file line 2: ")
+		})
+		Convey("should validate the fields", func() {
+			_, err := ReadFieldFile(strings.NewReader("a\nb\na\n"), false, pgStop, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "'a' (columns 1, 3)")
+			colSpecs, err := ReadFieldFile(strings.NewReader("a\nA \n"), false, pgStop,
+				&ConvertOptions{TrimFieldNames: true, LowercaseFieldNames: true})
+			So(err, ShouldNotBeNil)
+			So(colSpecs, ShouldBeNil)
+		})
+	})
+}
+
 func TestFieldParsers(t *testing.T) {
 	testutil.VerifyTestType(t, testutil.UnitTestType)
 
@@ -110,6 +215,33 @@ func TestFieldParsers(t *testing.T) {
 			value, err = p.Parse("-2147483649")
 			So(value.(int64), ShouldEqual, int64(-2147483649))
 		})
+		Convey("picks the smallest lossless type at the 2^31, 2^53 and 2^63 boundaries", func() {
+			for ts, expected := range map[string]interface{}{
+				"2147483647":           int32(math.MaxInt32),
+				"-2147483648":          int32(math.MinInt32),
+				"2147483648":           int64(1 << 31),
+				"9007199254740992":     int64(1 << 53),
+				"9007199254740993":     int64(1<<53 + 1),
+				"-9007199254740993":    -int64(1<<53 + 1),
+				"9223372036854775807":  int64(math.MaxInt64),
+				"-9223372036854775808": int64(math.MinInt64),
+			} {
+				value, err = p.Parse(ts)
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, expected)
+			}
+			value, err = p.Parse("9223372036854775808")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, float64(1<<63))
+			decimals := &FieldAutoParser{BigIntegersAsDecimal: true}
+			for _, ts := range []string{"9223372036854775808", "-9223372036854775809", "123456789012345678901234567890"} {
+				value, err = decimals.Parse(ts)
+				So(err, ShouldBeNil)
+				So(value.(bson.Decimal128).String(), ShouldEqual, ts)
+			}
+			value, err = decimals.Parse("1234567890123456789012345678901234567")
+			So(value, ShouldEqual, "1234567890123456789012345678901234567")
+		})
 		Convey("parses decimals when it can", func() {
 			value, err = p.Parse("3.14159265")
 			So(value.(float64), ShouldEqual, 3.14159265)
@@ -176,6 +308,71 @@ func TestFieldParsers(t *testing.T) {
 			_, err = p.Parse("no")
 			So(err, ShouldNotBeNil)
 		})
+		Convey("lists the accepted values when it fails", func() {
+			_, err = p.Parse("yes")
+			So(err.Error(), ShouldEqual, "failed to parse boolean: yes (true values: true, 1; false values: false, 0)")
+		})
+		Convey("is also available as the bool type", func() {
+			colSpec, err := ParseTypedHeader("active.bool()", pgStop)
+			So(err, ShouldBeNil)
+			So(colSpec, ShouldResemble, ColumnSpec{"active", new(FieldBooleanParser), pgStop, "bool"})
+		})
+	})
+
+	Convey("Using FieldArrayParser", t, func() {
+		var value interface{}
+		var err error
+
+		Convey("with no argument, splits strings on commas", func() {
+			var p, _ = NewFieldParser(ctArray, "")
+			value, err = p.Parse("red,blue,green")
+			So(value, ShouldResemble, []interface{}{"red", "blue", "green"})
+			So(err, ShouldBeNil)
+			value, err = p.Parse("")
+			So(value, ShouldResemble, []interface{}{})
+			So(err, ShouldBeNil)
+		})
+		Convey("with a separator, does not produce an element for a trailing separator", func() {
+			var p, _ = NewFieldParser(ctArray, ";")
+			value, err = p.Parse("a;b;")
+			So(value, ShouldResemble, []interface{}{"a", "b"})
+			So(err, ShouldBeNil)
+			value, err = p.Parse("a;b;;")
+			So(value, ShouldResemble, []interface{}{"a", "b", ""})
+			So(err, ShouldBeNil)
+			value, err = p.Parse("a;;b")
+			So(value, ShouldResemble, []interface{}{"a", "", "b"})
+			So(err, ShouldBeNil)
+		})
+		Convey("with an element type, parses each element", func() {
+			var p, _ = NewFieldParser(ctArray, "int32:|")
+			value, err = p.Parse("1|2|3")
+			So(value, ShouldResemble, []interface{}{int32(1), int32(2), int32(3)})
+			So(err, ShouldBeNil)
+			_, err = p.Parse("1|x|3")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "#1")
+		})
+		Convey("with a ':' separator and no element type", func() {
+			var p, _ = NewFieldParser(ctArray, ":")
+			value, err = p.Parse("a:b")
+			So(value, ShouldResemble, []interface{}{"a", "b"})
+			So(err, ShouldBeNil)
+		})
+		Convey("rejects element types that need an argument", func() {
+			_, err = NewFieldParser(ctArray, "date:,")
+			So(err, ShouldNotBeNil)
+			_, err = NewFieldParser(ctArray, "binary:,")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("from a typed header", func() {
+			spec, err := ParseTypedHeader("tags.array(int64:,)", pgStop)
+			So(err, ShouldBeNil)
+			So(spec.Name, ShouldEqual, "tags")
+			value, err = spec.Parser.Parse("4,5")
+			So(value, ShouldResemble, []interface{}{int64(4), int64(5)})
+			So(err, ShouldBeNil)
+		})
 	})
 
 	Convey("Using FieldBinaryParser", t, func() {
@@ -220,6 +417,48 @@ func TestFieldParsers(t *testing.T) {
 				So(value.([]uint8), ShouldResemble, []uint8{102, 111, 111, 98, 97, 114})
 				So(err, ShouldBeNil)
 			})
+			Convey("parses both the standard and URL-safe alphabets", func() {
+				value, err = p.Parse("+/+/")
+				So(err, ShouldBeNil)
+				So(value.([]uint8), ShouldResemble, []uint8{251, 255, 191})
+				value, err = p.Parse("-_-_")
+				So(err, ShouldBeNil)
+				So(value.([]uint8), ShouldResemble, []uint8{251, 255, 191})
+				_, err = p.Parse("+_-/")
+				So(err, ShouldNotBeNil)
+			})
+			Convey("does not parse invalid padding", func() {
+				for _, ts := range []string{"Zm9vYg", "Zm9vYg=", "Zm9vYg===", "Zm=9vYg="} {
+					_, err = p.Parse(ts)
+					So(err, ShouldNotBeNil)
+				}
+				value, err = p.Parse("Zm9vYg==")
+				So(err, ShouldBeNil)
+				So(value.([]uint8), ShouldResemble, []uint8{102, 111, 111, 98})
+			})
+		})
+		Convey("using a subtype", func() {
+			var p, err = NewFieldParser(ctBinary, "base64:0x80")
+			So(err, ShouldBeNil)
+			Convey("parses values as binary of that subtype", func() {
+				value, err = p.Parse("Zm9vYmFy")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, bson.Binary{Kind: 0x80, Data: []byte("foobar")})
+			})
+			Convey("accepts decimal subtypes", func() {
+				p, err = NewFieldParser(ctBinary, "hex:4")
+				So(err, ShouldBeNil)
+				value, err = p.Parse("0b")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, bson.Binary{Kind: 0x04, Data: []byte{11}})
+			})
+			Convey("parses the generic subtype as plain bytes", func() {
+				p, err = NewFieldParser(ctBinary, "hex:0x00")
+				So(err, ShouldBeNil)
+				value, err = p.Parse("0b")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, []byte{11})
+			})
 		})
 	})
 
@@ -279,6 +518,45 @@ func TestFieldParsers(t *testing.T) {
 				So(err, ShouldNotBeNil)
 			})
 		})
+		Convey("with multiple layouts", func() {
+			var p, err = NewFieldParser(ctDateGo, "2006-01-02|01/02/2006|2006-01-02 15:04:05.000 -0700")
+			So(err, ShouldBeNil)
+			Convey("tries each layout in order", func() {
+				value, err = p.Parse("2000-01-04")
+				So(value.(time.Time), ShouldResemble, time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC))
+				So(err, ShouldBeNil)
+				value, err = p.Parse("01/04/2000")
+				So(value.(time.Time), ShouldResemble, time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC))
+				So(err, ShouldBeNil)
+			})
+			Convey("parses fractional seconds and numeric offsets", func() {
+				value, err = p.Parse("2000-01-04 17:38:10.250 +0200")
+				So(err, ShouldBeNil)
+				So(value.(time.Time).Equal(time.Date(2000, 1, 4, 15, 38, 10, 250e6, time.UTC)), ShouldBeTrue)
+			})
+			Convey("reports every layout tried when none match", func() {
+				_, err = p.Parse("January 4, 2000")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "January 4, 2000")
+				So(err.Error(), ShouldContainSubstring, "01/02/2006")
+			})
+		})
+		Convey("with an escaped layout separator", func() {
+			var p, _ = NewFieldParser(ctDateGo, `2006\|01\|02`)
+			value, err = p.Parse("2000|01|04")
+			So(value.(time.Time), ShouldResemble, time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC))
+			So(err, ShouldBeNil)
+		})
+		Convey("with MS's fractional seconds and Oracle's FF", func() {
+			var p, _ = NewFieldParser(ctDateMS, "yyyy-MM-dd HH:mm:ss.fff|yyyy-MM-dd")
+			value, err = p.Parse("2000-01-04 17:38:10.250")
+			So(value.(time.Time), ShouldResemble, time.Date(2000, 1, 4, 17, 38, 10, 250e6, time.UTC))
+			So(err, ShouldBeNil)
+			p, _ = NewFieldParser(ctDateOracle, "YYYY-MM-DD HH24:MI:SS.FF3")
+			value, err = p.Parse("2000-01-04 17:38:10.250")
+			So(value.(time.Time), ShouldResemble, time.Date(2000, 1, 4, 17, 38, 10, 250e6, time.UTC))
+			So(err, ShouldBeNil)
+		})
 	})
 
 	Convey("Using FieldDoubleParser", t, func() {
@@ -300,9 +578,22 @@ func TestFieldParsers(t *testing.T) {
 			So(value.(float64), ShouldEqual, -1.0)
 			So(err, ShouldBeNil)
 		})
+		Convey("parses scientific notation", func() {
+			value, err = p.Parse("6.02214076e23")
+			So(value.(float64), ShouldEqual, 6.02214076e23)
+			So(err, ShouldBeNil)
+			value, err = p.Parse("-1.5E-3")
+			So(value.(float64), ShouldEqual, -0.0015)
+			So(err, ShouldBeNil)
+			value, err = p.Parse("9007199254740993")
+			So(value.(float64), ShouldEqual, float64(1<<53))
+			So(err, ShouldBeNil)
+		})
 		Convey("does not parse invalid numbers", func() {
 			_, err = p.Parse("")
 			So(err, ShouldNotBeNil)
+			_, err = p.Parse("1e400")
+			So(err, ShouldNotBeNil)
 			_, err = p.Parse("1.1.1")
 			So(err, ShouldNotBeNil)
 			_, err = p.Parse("1-2.0")
@@ -338,8 +629,10 @@ func TestFieldParsers(t *testing.T) {
 			So(err, ShouldNotBeNil)
 			value, err = p.Parse("2147483648")
 			So(err, ShouldNotBeNil)
+			So(value, ShouldBeNil)
 			value, err = p.Parse("-2147483649")
 			So(err, ShouldNotBeNil)
+			So(value, ShouldBeNil)
 		})
 	})
 
@@ -358,6 +651,16 @@ func TestFieldParsers(t *testing.T) {
 			value, err = p.Parse("-2147483649")
 			So(value.(int64), ShouldEqual, int64(-2147483649))
 		})
+		Convey("parses the full 64-bit range", func() {
+			value, err = p.Parse("9223372036854775807")
+			So(value.(int64), ShouldEqual, int64(math.MaxInt64))
+			So(err, ShouldBeNil)
+			value, err = p.Parse("-9223372036854775808")
+			So(value.(int64), ShouldEqual, int64(math.MinInt64))
+			So(err, ShouldBeNil)
+			_, err = p.Parse("9223372036854775808")
+			So(err, ShouldNotBeNil)
+		})
 		Convey("does not parse invalid numbers", func() {
 			_, err = p.Parse("")
 			So(err, ShouldNotBeNil)
@@ -384,12 +687,179 @@ func TestFieldParsers(t *testing.T) {
 				So(testVal, ShouldResemble, parsedValue.(bson.Decimal128))
 			}
 		})
+		Convey("parses decimal values with full precision", func() {
+			value, err := p.Parse("1234567890123456789012345678901234")
+			So(err, ShouldBeNil)
+			So(value.(bson.Decimal128).String(), ShouldEqual, "1234567890123456789012345678901234")
+			value, err = p.Parse("19999.99")
+			So(err, ShouldBeNil)
+			So(value.(bson.Decimal128).String(), ShouldEqual, "19999.99")
+		})
+		Convey("round-trips scientific notation and negative zero", func() {
+			for ts, expected := range map[string]string{
+				"1.5E+3":  "1.5E+3",
+				"1.5e3":   "1.5E+3",
+				"-2.5E-9": "-2.5E-9",
+				"-0":      "-0",
+				"-0.00":   "-0.00",
+				"9.999999999999999999999999999999999E+6144": "9.999999999999999999999999999999999E+6144",
+			} {
+				value, err := p.Parse(ts)
+				So(err, ShouldBeNil)
+				So(value.(bson.Decimal128).String(), ShouldEqual, expected)
+			}
+		})
 		Convey("does not parse invalid decimal values", func() {
-			for _, ts := range []string{"", "1-2", "abcd"} {
+			for _, ts := range []string{"", "1-2", "abcd", "1,5", " 1", "1e", "$5"} {
 				_, err = p.Parse(ts)
 				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "is not a decimal number")
 			}
 		})
+		Convey("does not parse values out of decimal128 range", func() {
+			for _, ts := range []string{"1E+6145", "1E+7000", "1E-7000", "12345678901234567890123456789012345"} {
+				_, err = p.Parse(ts)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "out of range for decimal128")
+			}
+		})
+	})
+
+	Convey("Using FieldPercentParser", t, func() {
+		fraction, err := NewFieldParser(ctPercent, "")
+		So(err, ShouldBeNil)
+		number, err := NewFieldParser(ctPercent, "number")
+		So(err, ShouldBeNil)
+
+		Convey("parses percentages as fractions or as numbers of percent", func() {
+			tests := []struct {
+				in               string
+				fraction, number float64
+			}{
+				{"12.5%", 0.125, 12.5},
+				{"0%", 0, 0},
+				{"100%", 1, 100},
+				{"250%", 2.5, 250},
+				{"1e3%", 10, 1000},
+				{"-5%", -0.05, -5},
+				{"-0.5 %", -0.005, -0.5},
+				{" 12.5\t% ", 0.125, 12.5},
+			}
+			for _, test := range tests {
+				value, err := fraction.Parse(test.in)
+				So(err, ShouldBeNil)
+				So(value, ShouldAlmostEqual, test.fraction, 1e-12)
+				value, err = number.Parse(test.in)
+				So(err, ShouldBeNil)
+				So(value, ShouldEqual, test.number)
+			}
+		})
+		Convey("does not parse values without a '%' sign, or no number before it", func() {
+			for _, in := range []string{"12.5", "", "%", " % ", "%12", "12%%", "abc%", "Inf%", "NaN%", "1,5%"} {
+				_, err := fraction.Parse(in)
+				So(err, ShouldNotBeNil)
+			}
+			_, err := fraction.Parse("12.5")
+			So(err.Error(), ShouldContainSubstring, "does not end in '%'")
+		})
+		Convey("parses localized numbers with the separators of the options", func() {
+			opts := &ConvertOptions{DecimalSeparator: ",", ThousandsSeparator: "."}
+			value, err := opts.parsePercent(fraction.(*FieldPercentParser), "1.250,5 %")
+			So(err, ShouldBeNil)
+			So(value, ShouldAlmostEqual, 12.505, 1e-12)
+			colSpecs, err := ParseTypedHeaders([]string{"rate.percent(number)"}, pgStop)
+			So(err, ShouldBeNil)
+			bsonD, err := tokensToBSON(colSpecs, []string{"-12,5%"}, uint64(0), opts)
+			So(err, ShouldBeNil)
+			So(bsonD, ShouldResemble, bson.D{{"rate", -12.5}})
+		})
+		Convey("rejects other arguments", func() {
+			_, err := NewFieldParser(ctPercent, "percent")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Using FieldUUIDParser", t, func() {
+		var p, _ = NewFieldParser(ctUUID, "")
+		standard := bson.Binary{Kind: 0x04, Data: []byte{
+			0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}}
+
+		Convey("parses hyphenated, plain and braced UUIDs as subtype 4", func() {
+			for _, in := range []string{
+				"00112233-4455-6677-8899-aabbccddeeff",
+				"00112233445566778899AABBCCDDEEFF",
+				"{00112233-4455-6677-8899-AABBCCDDEEFF}",
+				"{00112233445566778899aabbccddeeff}",
+			} {
+				value, err := p.Parse(in)
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, standard)
+			}
+		})
+		Convey("does not parse values of the wrong length", func() {
+			for _, in := range []string{"", "{}", "0011223344556677", "00112233-4455-6677-8899-aabbccddeeff0", "{00112233445566778899aabbccddeeff"} {
+				_, err := p.Parse(in)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "must be 32 hex digits")
+			}
+		})
+		Convey("does not parse misplaced hyphens or other characters", func() {
+			_, err := p.Parse("0011223-34455-6677-8899-aabbccddeeff")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "character 9 is '3'")
+			_, err = p.Parse("00112233-4455-6677-8899-aabbccddeegg")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "contains 'g'")
+		})
+		Convey("stores legacy byte orders as subtype 3", func() {
+			for order, data := range map[string][]byte{
+				"javaLegacy":   {0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11, 0x00, 0xff, 0xee, 0xdd, 0xcc, 0xbb, 0xaa, 0x99, 0x88},
+				"csharpLegacy": {0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+				"pythonLegacy": standard.Data,
+			} {
+				p, err := NewFieldParser(ctUUID, order)
+				So(err, ShouldBeNil)
+				value, err := p.Parse("00112233-4455-6677-8899-aabbccddeeff")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, bson.Binary{Kind: 0x03, Data: data})
+			}
+			_, err := NewFieldParser(ctUUID, "goLegacy")
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Using FieldObjectIDParser", t, func() {
+		var p, _ = NewFieldParser(ctObjectID, "")
+		var value interface{}
+		var err error
+
+		Convey("parses hex strings as ObjectIds", func() {
+			value, err = p.Parse("5a934e000102030405000000")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, bson.ObjectIdHex("5a934e000102030405000000"))
+			value, err = p.Parse("5A934E0001020304050000FF")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, bson.ObjectIdHex("5a934e0001020304050000ff"))
+		})
+		Convey("parses ObjectIds wrapped as ObjectId(\"...\")", func() {
+			value, err = p.Parse(`ObjectId("5a934e000102030405000000")`)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, bson.ObjectIdHex("5a934e000102030405000000"))
+		})
+		Convey("does not parse values of the wrong length", func() {
+			for _, ts := range []string{"", "5a934e", "5a934e0001020304050000000", `ObjectId("")`, `ObjectId(")`} {
+				_, err = p.Parse(ts)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "must be 24 hex characters, not")
+			}
+		})
+		Convey("does not parse values with non-hex characters", func() {
+			_, err = p.Parse("5a934e00010203040500000g")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEndWith, "character 24 is 'g'")
+			_, err = p.Parse(`ObjectId('5a934e000102030405000000')`)
+			So(err, ShouldNotBeNil)
+		})
 	})
 
 	Convey("Using FieldStringParser", t, func() {
@@ -411,3 +881,175 @@ func TestFieldParsers(t *testing.T) {
 	})
 
 }
+
+func TestFieldEpochDateParser(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Using FieldEpochDateParser", t, func() {
+		Convey("with seconds", func() {
+			p, err := NewFieldParser(ctDateEpochS, "")
+			So(err, ShouldBeNil)
+			Convey("parses whole, fractional and negative seconds", func() {
+				value, err := p.Parse("1719923456")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, time.Date(2024, 7, 2, 12, 30, 56, 0, time.UTC))
+				value, err = p.Parse("1719923456.789")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, time.Date(2024, 7, 2, 12, 30, 56, 789000000, time.UTC))
+				value, err = p.Parse("-86400.5")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, time.Date(1969, 12, 30, 23, 59, 59, 500000000, time.UTC))
+			})
+			Convey("does not parse other content", func() {
+				for _, in := range []string{"", "x", "1.", "1.2.3", "1e9", "12:00"} {
+					_, err := p.Parse(in)
+					So(err, ShouldNotBeNil)
+				}
+			})
+			Convey("does not parse milliseconds, which are out of range as seconds", func() {
+				_, err := p.Parse("1719923456123")
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "outside the years 1900 to 2200")
+			})
+		})
+		Convey("with milliseconds", func() {
+			p, err := NewFieldParser(ctDateEpochMS, "1960:2100")
+			So(err, ShouldBeNil)
+			Convey("parses whole and negative milliseconds", func() {
+				value, err := p.Parse("1719923456123")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, time.Date(2024, 7, 2, 12, 30, 56, 123000000, time.UTC))
+				value, err = p.Parse("-1")
+				So(err, ShouldBeNil)
+				So(value, ShouldResemble, time.Date(1969, 12, 31, 23, 59, 59, 999000000, time.UTC))
+			})
+			Convey("does not parse fractions, or dates outside the years given", func() {
+				_, err := p.Parse("1.5")
+				So(err, ShouldNotBeNil)
+				_, err = p.Parse("-400000000000")
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("with an invalid year range", func() {
+			for _, arg := range []string{"1990", "x:2000", "1990:y", "2000:1990"} {
+				_, err := NewFieldParser(ctDateEpochMS, arg)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("in a typed header", func() {
+			colSpec, err := ParseTypedHeader("created.date_epoch_s", pgStop)
+			So(err, ShouldBeNil)
+			So(colSpec.Parser, ShouldResemble, &FieldEpochDateParser{time.Second, 1900, 2200})
+		})
+	})
+}
+
+func TestParseInLocation(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a time zone", t, func() {
+		Convey("ParseTimeZone accepts zone names and fixed offsets", func() {
+			for zone, offset := range map[string]int{"+05:30": 19800, "-0800": -28800, "+01": 3600, "UTC": 0} {
+				loc, err := ParseTimeZone(zone)
+				So(err, ShouldBeNil)
+				_, got := time.Date(2024, 1, 1, 0, 0, 0, 0, loc).Zone()
+				So(got, ShouldEqual, offset)
+			}
+			for _, zone := range []string{"", "Local", "Mars/Olympus_Mons", "+5", "+25:00", "+01:75"} {
+				_, err := ParseTimeZone(zone)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		chicago, err := ParseTimeZone("America/Chicago")
+		So(err, ShouldBeNil)
+		parser := &FieldDateParser{"2006-01-02 15:04"}
+		parse := func(in string, policy DSTGapPolicy) (time.Time, error) {
+			value, err := parseInLocation(parser, in, chicago, policy)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return value.(time.Time).UTC(), nil
+		}
+
+		Convey("wall times are resolved in the zone", func() {
+			value, err := parse("2024-01-15 09:00", DSTGapError)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, time.Date(2024, 1, 15, 15, 0, 0, 0, time.UTC))
+			value, err = parse("2024-07-15 09:00", DSTGapError)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, time.Date(2024, 7, 15, 14, 0, 0, 0, time.UTC))
+		})
+		Convey("a wall time that happens twice is the earlier of the two", func() {
+			value, err := parse("2024-11-03 01:30", DSTGapError)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC))
+		})
+		Convey("a wall time in a DST gap follows the policy", func() {
+			_, err := parse("2024-03-10 02:30", DSTGapError)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "does not exist in America/Chicago")
+			value, err := parse("2024-03-10 02:30", DSTGapShift)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, time.Date(2024, 3, 10, 8, 30, 0, 0, time.UTC))
+			So(value.In(chicago).Hour(), ShouldEqual, 3)
+		})
+		Convey("dates with an offset keep it", func() {
+			multi := &FieldMultiDateParser{[]string{time.RFC3339, "2006-01-02 15:04"}}
+			for _, in := range []string{"2024-03-10T02:30:00Z", "2024-03-10T03:30:00+01:00"} {
+				value, err := parseInLocation(multi, in, chicago, DSTGapError)
+				So(err, ShouldBeNil)
+				So(value.(time.Time).UTC(), ShouldResemble, time.Date(2024, 3, 10, 2, 30, 0, 0, time.UTC))
+			}
+		})
+		Convey("the DST gap policy must be valid", func() {
+			policy, err := ValidateDSTGapPolicy("shift")
+			So(err, ShouldBeNil)
+			So(policy, ShouldEqual, DSTGapShift)
+			_, err = ValidateDSTGapPolicy("later")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFieldJSONParser(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Using FieldJSONParser", t, func() {
+		p, err := NewFieldParser(ctJSON, "")
+		So(err, ShouldBeNil)
+
+		Convey("parses objects into documents that keep their key order", func() {
+			value, err := p.Parse(`{"color":"red","dims":[2,3],"a":{"z":1,"y":2}}`)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, bson.D{
+				{"color", "red"},
+				{"dims", []interface{}{int32(2), int32(3)}},
+				{"a", bson.D{{"z", int32(1)}, {"y", int32(2)}}},
+			})
+		})
+		Convey("parses arrays", func() {
+			value, err := p.Parse(` [1, "two", {"three": 3}] `)
+			So(err, ShouldBeNil)
+			So(value, ShouldResemble, []interface{}{int32(1), "two", bson.D{{"three", int32(3)}}})
+		})
+		Convey("parses extended JSON", func() {
+			value, err := p.Parse(`{"_id":{"$oid":"5a934e000102030405000000"},"at":{"$date":"2024-07-02T12:30:56Z"},"tags":[{"$oid":"5a934e0001020304050000ff"}]}`)
+			So(err, ShouldBeNil)
+			doc := value.(bson.D)
+			So(doc[0].Value, ShouldEqual, bson.ObjectIdHex("5a934e000102030405000000"))
+			So(doc[1].Value.(time.Time).Equal(time.Date(2024, 7, 2, 12, 30, 56, 0, time.UTC)), ShouldBeTrue)
+			So(doc[2].Value, ShouldResemble, []interface{}{bson.ObjectIdHex("5a934e0001020304050000ff")})
+		})
+		Convey("does not parse malformed JSON or other values", func() {
+			for _, in := range []string{"", "red", "3", `"red"`, `{"color":}`, `[1,2`, `{"a":1}x`,
+				`{"a":1}, "b":2`, `[1] [2]`, `{"a":1}}`} {
+				_, err := p.Parse(in)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("names the column of malformed JSON", func() {
+			colSpecs := []ColumnSpec{{"attributes", p, pgStop, "json"}}
+			_, err := tokensToBSON(colSpecs, []string{`{"color":}`}, uint64(0), nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "field 'attributes'")
+		})
+	})
+}
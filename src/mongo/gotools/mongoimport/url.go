@@ -0,0 +1,176 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// URLReadError is returned when the body of a URL can not be read in full,
+// either because the server did not return it or because the connection
+// failed part way through it.
+type URLReadError struct {
+	// URL is the URL being read
+	URL string
+
+	// Received is the number of bytes of the body read before the error
+	Received int64
+
+	// Err is the reason the body could not be read
+	Err error
+}
+
+func (e URLReadError) Error() string {
+	return fmt.Sprintf("error reading %v after receiving %v bytes: %v", e.URL, e.Received, e.Err)
+}
+
+// urlBaseName returns the last element of the path of rawURL, without any
+// query, or "" if it has none.
+func urlBaseName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	base := path.Base(u.Path)
+	if base == "/" || base == "." {
+		return ""
+	}
+	return base
+}
+
+// OpenURL starts a GET of the http or https URL and returns its body, along
+// with its length from Content-Length, or -1 if that is not known. Redirects
+// are followed, and a body sent with gzip Content-Encoding is decoded, in
+// which case its length is not known.
+//
+// A zero timeout means no limit; otherwise, it limits each of connecting,
+// waiting for the response, and waiting for more of the body, but not the
+// time taken to read the whole body. A status other than 200, or a failure
+// part way through the body, is a URLReadError.
+func OpenURL(rawURL string, timeout time.Duration) (io.ReadCloser, int64, error) {
+	return openURLFrom(rawURL, 0, timeout)
+}
+
+// ResumeURL is like OpenURL, but asks the server for the body from offset
+// on, with a Range request, which the server must honor. The request does
+// not ask for gzip Content-Encoding, so offset counts the bytes of the body
+// as sent, and the returned length is that of the rest of the body.
+func ResumeURL(rawURL string, offset int64, timeout time.Duration) (io.ReadCloser, int64, error) {
+	return openURLFrom(rawURL, offset, timeout)
+}
+
+// openURLFrom is OpenURL for the body from offset on.
+func openURLFrom(rawURL string, offset int64, timeout time.Duration) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	status := http.StatusOK
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+		status = http.StatusPartialContent
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, -1, err
+	}
+	if resp.StatusCode != status {
+		resp.Body.Close()
+		cancel()
+		return nil, -1, URLReadError{rawURL, offset, fmt.Errorf("server returned %v", resp.Status)}
+	}
+	body := &urlBody{
+		url:      rawURL,
+		body:     resp.Body,
+		cancel:   cancel,
+		timeout:  timeout,
+		received: offset,
+		decoded:  resp.Uncompressed,
+	}
+	if timeout > 0 {
+		body.timer = time.AfterFunc(timeout, body.expire)
+		body.timer.Stop()
+	}
+	return body, resp.ContentLength, nil
+}
+
+// urlBody is the body of a URL, which reports failures as URLReadErrors and
+// gives up if more of the body does not arrive within the timeout.
+type urlBody struct {
+	url      string
+	body     io.ReadCloser
+	cancel   context.CancelFunc
+	timeout  time.Duration
+	timer    *time.Timer
+	received int64
+
+	// decoded is set if the transport decoded a gzip Content-Encoding, in
+	// which case the bytes read are not those sent, so reading can not be
+	// resumed from an offset
+	decoded bool
+
+	// timedOut is set, atomically, if the timer cancelled the request
+	timedOut int32
+}
+
+// expire cancels the request once the timeout passes without a read
+// returning.
+func (b *urlBody) expire() {
+	atomic.StoreInt32(&b.timedOut, 1)
+	b.cancel()
+}
+
+func (b *urlBody) Read(p []byte) (int, error) {
+	if b.timer != nil {
+		b.timer.Reset(b.timeout)
+	}
+	n, err := b.body.Read(p)
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.received += int64(n)
+	if err != nil && err != io.EOF {
+		if atomic.LoadInt32(&b.timedOut) != 0 {
+			err = fmt.Errorf("no data received for %v", b.timeout)
+		}
+		err = URLReadError{b.url, b.received, err}
+	}
+	return n, err
+}
+
+// Resumable reports whether reading the body can be resumed with ResumeURL
+// from the number of bytes read so far.
+func (b *urlBody) Resumable() bool {
+	return !b.decoded
+}
+
+func (b *urlBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
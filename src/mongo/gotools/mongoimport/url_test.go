@@ -0,0 +1,108 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestOpenURL(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a server of TSV data", t, func() {
+		release := make(chan struct{})
+		mux := http.NewServeMux()
+		mux.HandleFunc("/data.tsv", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("a\tb\n1\tx\n2\ty\n"))
+		})
+		mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/data.tsv", http.StatusFound)
+		})
+		mux.HandleFunc("/encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte("a\tb\n1\tx\n"))
+			gz.Close()
+		})
+		mux.HandleFunc("/dropped", func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\na\tb\n1\tx\n")
+			buf.Flush()
+			conn.Close()
+		})
+		mux.HandleFunc("/stalled", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("a\tb\n"))
+			w.(http.Flusher).Flush()
+			<-release
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		defer close(release)
+
+		Convey("redirects should be followed, and the size taken from Content-Length", func() {
+			body, size, err := OpenURL(server.URL+"/moved", time.Second)
+			So(err, ShouldBeNil)
+			defer body.Close()
+			So(size, ShouldEqual, 12)
+			r := NewTSVInputReader(nil, body, os.Stdout, 1, false)
+			So(r.ReadAndValidateHeader(), ShouldBeNil)
+			docChan := make(chan bson.D, 2)
+			So(r.StreamDocument(true, docChan), ShouldBeNil)
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(1)}, {"b", "x"}})
+			So(<-docChan, ShouldResemble, bson.D{{"a", int32(2)}, {"b", "y"}})
+			So(r.Size(), ShouldEqual, size)
+		})
+		Convey("a body with gzip Content-Encoding should be decoded", func() {
+			body, size, err := OpenURL(server.URL+"/encoded", time.Second)
+			So(err, ShouldBeNil)
+			defer body.Close()
+			So(size, ShouldEqual, -1)
+			data, err := ioutil.ReadAll(body)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "a\tb\n1\tx\n")
+		})
+		Convey("a status other than 200 should be an error", func() {
+			_, _, err := OpenURL(server.URL+"/missing.tsv", time.Second)
+			So(err, ShouldHaveSameTypeAs, URLReadError{})
+			So(err.Error(), ShouldContainSubstring, "404 Not Found")
+		})
+		Convey("a connection dropped part way through the body should be a read error", func() {
+			body, size, err := OpenURL(server.URL+"/dropped", time.Second)
+			So(err, ShouldBeNil)
+			defer body.Close()
+			So(size, ShouldEqual, 100)
+			data, err := ioutil.ReadAll(body)
+			So(data, ShouldResemble, []byte("a\tb\n1\tx\n"))
+			So(err, ShouldHaveSameTypeAs, URLReadError{})
+			So(err.(URLReadError).Received, ShouldEqual, 8)
+		})
+		Convey("a body that stops arriving should time out", func() {
+			body, _, err := OpenURL(server.URL+"/stalled", 50*time.Millisecond)
+			So(err, ShouldBeNil)
+			defer body.Close()
+			data, err := ioutil.ReadAll(body)
+			So(data, ShouldResemble, []byte("a\tb\n"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "error reading "+server.URL+"/stalled after receiving 4 bytes: no data received for 50ms")
+		})
+	})
+
+	Convey("The collection name should come from the path of a URL", t, func() {
+		So(urlBaseName("https://example.com/exports/orders.tsv?token=x"), ShouldEqual, "orders.tsv")
+		So(urlBaseName("https://example.com/"), ShouldEqual, "")
+		So(isInputPattern("https://example.com/orders.tsv?page=[1]"), ShouldBeFalse)
+	})
+}
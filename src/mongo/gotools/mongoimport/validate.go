@@ -0,0 +1,154 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-tools/common/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ValidationSummary describes what importing an input would do, as found by
+// ValidateInput.
+type ValidationSummary struct {
+	// RecordsRead is the number of records read from the input, or zero if
+	// the reader does not report its Progress
+	RecordsRead uint64
+
+	// Documents is the number of documents that would be imported, of which
+	// TooLarge are larger than the server accepts, as only those of a reader
+	// with a negative MaxDocumentSize, or without one, can be
+	Documents uint64
+	TooLarge  uint64
+
+	// Failures is the number of records that failed to convert in each
+	// FailureCategory, or nil if the reader does not count them
+	Failures map[FailureCategory]uint64
+
+	// MinDocumentSize and MaxDocumentSize are the sizes in bytes of the
+	// smallest and largest documents, and TotalDocumentSize the size of all
+	// of them
+	MinDocumentSize   int
+	MaxDocumentSize   int
+	TotalDocumentSize int64
+}
+
+// AverageDocumentSize returns the mean size in bytes of the documents, or
+// zero if there are none.
+func (s ValidationSummary) AverageDocumentSize() float64 {
+	if s.Documents == 0 {
+		return 0
+	}
+	return float64(s.TotalDocumentSize) / float64(s.Documents)
+}
+
+// String describes the summary in a few lines.
+func (s ValidationSummary) String() string {
+	lines := []string{
+		fmt.Sprintf("%v record(s) read, %v document(s) would be imported", s.RecordsRead, s.Documents),
+	}
+	if s.Documents != 0 {
+		lines = append(lines, fmt.Sprintf("document sizes: min %v, avg %.0f, max %v bytes",
+			s.MinDocumentSize, s.AverageDocumentSize(), s.MaxDocumentSize))
+	}
+	if s.TooLarge != 0 {
+		lines = append(lines, fmt.Sprintf("%v document(s) larger than the maximum of %v bytes", s.TooLarge, db.MaxBSONSize))
+	}
+	categories := make([]string, 0, len(s.Failures))
+	for category := range s.Failures {
+		categories = append(categories, string(category))
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		lines = append(lines, fmt.Sprintf("%v record(s) failed to convert: %v", s.Failures[FailureCategory(category)], category))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateInput streams r's documents with StreamDocument, applying the
+// reader's limits on conversion failures as an import would, but discards
+// them rather than importing them, and returns a summary of what it found.
+// If streaming fails, the summary covers the input up to the failure.
+func ValidateInput(r InputReader) (ValidationSummary, error) {
+	readDocs := make(chan bson.D, workerBufferSize)
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- r.StreamDocument(false, readDocs)
+	}()
+
+	// the documents are sized on as many goroutines as convert them
+	numWorkers := runtime.NumCPU()
+	summaries := make([]ValidationSummary, numWorkers)
+	wg := &sync.WaitGroup{}
+	for i := range summaries {
+		wg.Add(1)
+		go func(summary *ValidationSummary) {
+			defer wg.Done()
+			for document := range readDocs {
+				summary.add(document)
+			}
+		}(&summaries[i])
+	}
+	wg.Wait()
+	err := <-streamErr
+
+	var summary ValidationSummary
+	for _, partial := range summaries {
+		summary.merge(partial)
+	}
+	if progresser, ok := r.(interface {
+		Progress() ReaderProgress
+	}); ok {
+		summary.RecordsRead = progresser.Progress().RecordsRead
+	}
+	if failer, ok := r.(interface {
+		Failures() map[FailureCategory]uint64
+	}); ok {
+		summary.Failures = failer.Failures()
+	}
+	return summary, err
+}
+
+// add counts a document in the summary.
+func (s *ValidationSummary) add(document bson.D) {
+	size := 0
+	if raw, err := bson.Marshal(document); err == nil {
+		size = len(raw)
+	}
+	if size > db.MaxBSONSize {
+		s.TooLarge++
+	}
+	if s.Documents == 0 || size < s.MinDocumentSize {
+		s.MinDocumentSize = size
+	}
+	if size > s.MaxDocumentSize {
+		s.MaxDocumentSize = size
+	}
+	s.Documents++
+	s.TotalDocumentSize += int64(size)
+}
+
+// merge adds the documents counted in other to the summary.
+func (s *ValidationSummary) merge(other ValidationSummary) {
+	if other.Documents == 0 {
+		return
+	}
+	if s.Documents == 0 || other.MinDocumentSize < s.MinDocumentSize {
+		s.MinDocumentSize = other.MinDocumentSize
+	}
+	if other.MaxDocumentSize > s.MaxDocumentSize {
+		s.MaxDocumentSize = other.MaxDocumentSize
+	}
+	s.Documents += other.Documents
+	s.TooLarge += other.TooLarge
+	s.TotalDocumentSize += other.TotalDocumentSize
+}
@@ -0,0 +1,78 @@
+// Copyright (C) MongoDB, Inc. 2014-present.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at http://www.apache.org/licenses/LICENSE-2.0
+
+package mongoimport
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mongodb/mongo-tools/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateInput(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("With a TSV reader of records, some of which fail to convert", t, func() {
+		colSpecs := []ColumnSpec{
+			{"a", new(FieldInt32Parser), pgStop, "int32"},
+			{"b", new(FieldAutoParser), pgAutoCast, "auto"},
+		}
+		contents := "1\tx\n2\tyy\nz\tw\n3\n"
+		r := NewTSVInputReader(colSpecs, bytes.NewReader([]byte(contents)), os.Stdout, 2, false)
+		r.ShortRows = RaggedError
+
+		Convey("the documents should be summarized and the failures counted by category", func() {
+			r.MaxErrors = -1
+			summary, err := ValidateInput(r)
+			So(err, ShouldBeNil)
+			So(summary.RecordsRead, ShouldEqual, 4)
+			So(summary.Documents, ShouldEqual, 2)
+			So(summary.Failures, ShouldResemble, map[FailureCategory]uint64{
+				FailureType:      1,
+				FailureRaggedRow: 1,
+			})
+			So(summary.MinDocumentSize, ShouldEqual, 21)
+			So(summary.MaxDocumentSize, ShouldEqual, 22)
+			So(summary.AverageDocumentSize(), ShouldEqual, 21.5)
+			So(summary.String(), ShouldEqual, "4 record(s) read, 2 document(s) would be imported\n"+
+				"document sizes: min 21, avg 22, max 22 bytes\n"+
+				"1 record(s) failed to convert: ragged row\n"+
+				"1 record(s) failed to convert: type")
+		})
+		Convey("a failure beyond the maximum should stop validation", func() {
+			summary, err := ValidateInput(r)
+			So(err, ShouldNotBeNil)
+			So(summary.Failures[FailureType], ShouldEqual, 1)
+		})
+	})
+
+	Convey("The category of a failure should survive the errors that wrap it", t, func() {
+		err := recordError(3, 4, "x", categorizedError{FailureID, fmt.Errorf("_id field 'a' is blank")})
+		So(err.Error(), ShouldEqual, "record #3 (line 4): _id field 'a' is blank: x")
+		So(ErrorCategory(FileError{"a.tsv", BatchRecordError{2, err}}), ShouldEqual, FailureID)
+		So(ErrorCategory(fmt.Errorf("read error")), ShouldEqual, FailureOther)
+	})
+}
+
+func TestImportDocumentsValidate(t *testing.T) {
+	testutil.VerifyTestType(t, testutil.UnitTestType)
+	Convey("Validating the input of a mongoimport instance should not need a server", t, func() {
+		imp, err := NewMongoImport()
+		So(err, ShouldBeNil)
+		imp.InputOptions.File = "testdata/test.csv"
+		imp.InputOptions.Type = CSV
+		fields := "a,b,c"
+		imp.InputOptions.Fields = &fields
+		imp.InputOptions.Validate = true
+		So(imp.ValidateSettings([]string{}), ShouldBeNil)
+		numImported, err := imp.ImportDocuments()
+		So(err, ShouldBeNil)
+		So(numImported, ShouldEqual, 0)
+	})
+}